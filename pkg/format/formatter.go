@@ -1770,6 +1770,15 @@ func (f *formatter) writeSpecialFloatLiteral(specialFloatLiteralNode *ast.Specia
 // the quote style used in the original source.
 func (f *formatter) writeStringLiteral(stringLiteralNode *ast.StringLiteralNode) {
 	info := f.fileNode.NodeInfo(stringLiteralNode)
+	if !info.IsValid() {
+		// the literal was created manually, not from the parser
+		if len(stringLiteralNode.Raw) > 0 {
+			f.WriteString(string(stringLiteralNode.Raw))
+		} else {
+			f.WriteString(strconv.Quote(stringLiteralNode.Val))
+		}
+		return
+	}
 	rawText := info.RawText()
 	if len(rawText) > 1 && rawText[0] == '\'' && rawText[len(rawText)-1] == '\'' {
 		// convert single quotes to double quotes
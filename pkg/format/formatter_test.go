@@ -1,13 +1,20 @@
 package format_test
 
 import (
+	"context"
 	"strings"
 	"testing"
 
+	"github.com/kralicky/protocompile"
+	"github.com/kralicky/protocompile/ast"
 	"github.com/kralicky/protocompile/parser"
 	"github.com/kralicky/protocompile/reporter"
 	"github.com/kralicky/protols/pkg/format"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
 )
 
 func TestFormat(t *testing.T) {
@@ -281,6 +288,38 @@ message Foo {
   optional string bbbbbbbb = 1;
   optional string bbbbb    = 2;
   optional string bbbbbbb  = 3;
+}`[1:],
+		},
+		16: {
+			input: `
+edition   =   "2023" ;
+package   foo;
+option features.field_presence=IMPLICIT;
+message Foo {
+  option features = {message_encoding: DELIMITED};
+  string name = 1 [features.field_presence = EXPLICIT];
+  repeated int32 ids = 2 [features.repeated_field_encoding=EXPANDED];
+  Foo child = 3;
+  enum E {
+    option features.enum_type = CLOSED;
+    A = 1;
+  }
+}`[1:],
+			want: `
+edition = "2023";
+package foo;
+
+option features.field_presence = IMPLICIT;
+
+message Foo {
+  option features = {message_encoding: DELIMITED};
+  string         name  = 1 [features.field_presence = EXPLICIT];
+  repeated int32 ids   = 2 [features.repeated_field_encoding = EXPANDED];
+  Foo            child = 3;
+  enum E {
+    option features.enum_type = CLOSED;
+    A = 1;
+  }
 }`[1:],
 		},
 	}
@@ -306,3 +345,79 @@ message Foo {
 		})
 	}
 }
+
+func TestFormatSynthesizedStringLiteral(t *testing.T) {
+	cases := []struct {
+		lit  *ast.StringLiteralNode
+		want string
+	}{
+		{
+			lit:  &ast.StringLiteralNode{Val: "foo"},
+			want: `option go_package = "foo";`,
+		},
+		{
+			// the value is quoted and escaped when there is no raw text
+			lit:  &ast.StringLiteralNode{Val: "a\"b\n"},
+			want: `option go_package = "a\"b\n";`,
+		},
+		{
+			// raw text is written as-is, keeping its quote style
+			lit:  &ast.StringLiteralNode{Val: "foo", Raw: []byte(`'foo'`)},
+			want: `option go_package = 'foo';`,
+		},
+	}
+	for i, c := range cases {
+		root, err := parser.Parse("", strings.NewReader(`option go_package = "bar";`), reporter.NewHandler(nil), 0)
+		require.NoError(t, err)
+		option := root.GetDecls()[0].GetOption()
+		option.Val = c.lit.AsValueNode()
+
+		got, err := format.PrintNode(format.NodeInfoOverlay(root, map[ast.Node]ast.NodeInfo{c.lit: {}}), option)
+		require.NoError(t, err)
+		require.Equal(t, c.want, got, "case %d", i)
+	}
+}
+
+func TestPrintEditionsFileDescriptor(t *testing.T) {
+	const src = `
+edition = "2023";
+
+package foo;
+
+option features.field_presence = IMPLICIT;
+
+message Foo {
+  string name = 1 [features.field_presence = EXPLICIT];
+  int32 required = 2 [features.field_presence = LEGACY_REQUIRED];
+  repeated int32 ids = 3 [features.repeated_field_encoding = EXPANDED];
+  Foo child = 4 [features.message_encoding = DELIMITED];
+  map<string, Foo> children = 5;
+  int32 def = 6 [default = 5, features.field_presence = EXPLICIT];
+}
+`
+	compile := func(src string) *descriptorpb.FileDescriptorProto {
+		t.Helper()
+		compiler := protocompile.Compiler{
+			Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{
+				Accessor: protocompile.SourceAccessorFromMap(map[string]string{"test.proto": src}),
+			}),
+		}
+		res, err := compiler.Compile(context.Background(), "test.proto")
+		require.NoError(t, err)
+		return protodesc.ToFileDescriptorProto(res.Files[0])
+	}
+	fdp := compile(src)
+	fd, err := protodesc.NewFile(fdp, protoregistry.GlobalFiles)
+	require.NoError(t, err)
+
+	var out strings.Builder
+	require.NoError(t, format.PrintAndFormatFileDescriptor(fd, &out))
+	printed := out.String()
+	require.True(t, strings.HasPrefix(printed, `edition = "2023";`), printed)
+	require.Contains(t, printed, "Foo              child    = 4 [features = {message_encoding: DELIMITED}];")
+	require.NotContains(t, printed, "optional")
+	require.NotContains(t, printed, "required int32")
+	require.NotContains(t, printed, "group")
+
+	require.True(t, proto.Equal(fdp, compile(printed)), printed)
+}
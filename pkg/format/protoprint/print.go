@@ -563,6 +563,10 @@ func (p *Printer) printFile(fdp protoreflect.FileDescriptor, reg *protoregistry.
 	path[0] = internal.File_syntaxTag
 	si := sourceInfo.Get(path)
 	p.printElement(false, si, w, 0, func(w *writer) {
+		if fdp.Syntax() == protoreflect.Editions {
+			_, _ = fmt.Fprintf(w, "edition = %q;", editionString(fdp))
+			return
+		}
 		syn := fdp.Syntax()
 		_, _ = fmt.Fprintf(w, "syntax = %q;", syn)
 	})
@@ -589,7 +593,7 @@ func (p *Printer) printFile(fdp protoreflect.FileDescriptor, reg *protoregistry.
 	}
 	exts := p.computeExtensions(sourceInfo, collect(fdp.Extensions()), []int32{internal.File_extensionsTag})
 	for i, extd := range collect(fdp.Extensions()) {
-		if extd.Kind() == protoreflect.GroupKind {
+		if isGroup(extd) {
 			// we don't emit nested messages for groups since
 			// they get special treatment
 			skip[extd.Message()] = true
@@ -860,6 +864,9 @@ func (p *Printer) typeString(fld protoreflect.FieldDescriptor, scope string) str
 	case protoreflect.MessageKind:
 		return p.qualifyName(string(fld.ParentFile().Package()), scope, string(fld.Message().FullName()))
 	case protoreflect.GroupKind:
+		if !isGroup(fld) {
+			return p.qualifyName(string(fld.ParentFile().Package()), scope, string(fld.Message().FullName()))
+		}
 		return string(fld.Message().Name())
 	}
 	panic(fmt.Sprintf("invalid type: %v", fld.Kind()))
@@ -906,7 +913,7 @@ func (p *Printer) printMessageBody(md protoreflect.MessageDescriptor, reg *proto
 		elements.addrs = append(elements.addrs, elementAddr{elementType: internal.Message_extensionRangeTag, elementIndex: i})
 	}
 	for i, fld := range collect(md.Fields()) {
-		if fld.IsMap() || fld.Kind() == protoreflect.GroupKind {
+		if fld.IsMap() || isGroup(fld) {
 			// we don't emit nested messages for map types or groups since
 			// they get special treatment
 			skip[fld.Message()] = true
@@ -921,7 +928,7 @@ func (p *Printer) printMessageBody(md protoreflect.MessageDescriptor, reg *proto
 	}
 	exts := p.computeExtensions(sourceInfo, collect(md.Extensions()), append(path, internal.Message_extensionsTag))
 	for i, extd := range collect(md.Extensions()) {
-		if extd.Kind() == protoreflect.GroupKind {
+		if isGroup(extd) {
 			// we don't emit nested messages for groups since
 			// they get special treatment
 			skip[extd.Message()] = true
@@ -1151,6 +1158,11 @@ func (p *Printer) printField(fld protoreflect.FieldDescriptor, reg *protoregistr
 }
 
 func shouldEmitLabel(fld protoreflect.FieldDescriptor) bool {
+	if fld.ParentFile().Syntax() == protoreflect.Editions {
+		// editions only allow the 'repeated' label; presence is expressed
+		// using features instead
+		return fld.Cardinality() == protoreflect.Repeated && !fld.IsMap()
+	}
 	return fld.HasOptionalKeyword() ||
 		(!fld.IsMap() && fld.ContainingOneof() == nil &&
 			(fld.Cardinality() != protoreflect.Optional || fld.Syntax() != protoreflect.Proto3))
@@ -1175,8 +1187,23 @@ func labelString(field protoreflect.FieldDescriptor) string {
 	panic(fmt.Sprintf("invalid label: %v", field.Cardinality()))
 }
 
+// isGroup returns true if the field is declared using the group syntax.
+// Delimited message fields in editions files have kind GroupKind, but are
+// declared as regular message fields.
 func isGroup(fld protoreflect.FieldDescriptor) bool {
-	return fld.Kind() == protoreflect.GroupKind
+	return fld.Kind() == protoreflect.GroupKind && fld.ParentFile().Syntax() != protoreflect.Editions
+}
+
+// editionString returns the edition of the file as written in its edition
+// declaration, e.g. "2023".
+func editionString(fdp protoreflect.FileDescriptor) string {
+	var edition descriptorpb.Edition
+	if withEdition, ok := fdp.(interface{ Edition() int32 }); ok {
+		edition = descriptorpb.Edition(withEdition.Edition())
+	} else {
+		edition = protodesc.ToFileDescriptorProto(fdp).GetEdition()
+	}
+	return strings.TrimPrefix(edition.String(), "EDITION_")
 }
 
 func (p *Printer) printOneOf(ood protoreflect.OneofDescriptor, parentElements elementAddrs, startFieldIndex int, reg *protoregistry.Types, w *writer, sourceInfo internal.SourceInfoMap, parentPath []int32, indent int, ooIndex int32) {
@@ -44,8 +44,11 @@ type Cache struct {
 	inflightTasksInvalidate gsync.Map[protocompile.ResolvedPath, time.Time]
 	inflightTasksCompile    gsync.Map[protocompile.ResolvedPath, time.Time]
 	pragmas                 gsync.Map[protocompile.ResolvedPath, *pragmaMap]
+	savedVersions           gsync.Map[protocol.DocumentURI, *savedVersion]
+	pendingDeletes          gsync.Map[protocol.DocumentURI, *pendingDelete]
 
 	documentVersions *documentVersionQueue
+	semanticTokens   *semanticTokensResults
 }
 
 type CacheOptions struct{}
@@ -88,6 +91,7 @@ func NewCache(workspace protocol.WorkspaceFolder, opts ...CacheOption) *Cache {
 		unlinkedResults:        make(map[protocompile.ResolvedPath]parser.Result),
 		partiallyLinkedResults: make(map[protocompile.ResolvedPath]linker.Result),
 		documentVersions:       newDocumentVersionQueue(),
+		semanticTokens:         newSemanticTokensResults(),
 	}
 	cache.DidChangeConfiguration(context.TODO(), Settings{}) // load default settings
 
@@ -0,0 +1,726 @@
+package lsp
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"buf.build/gen/go/bufbuild/protovalidate/protocolbuffers/go/buf/validate"
+	"github.com/bufbuild/protovalidate-go/celext"
+	"github.com/google/cel-go/cel"
+	celcommon "github.com/google/cel-go/common"
+	celast "github.com/google/cel-go/common/ast"
+	"github.com/google/cel-go/common/decls"
+	"github.com/google/cel-go/common/operators"
+	"github.com/google/cel-go/common/types"
+	"github.com/kralicky/protocompile/ast"
+	"github.com/kralicky/protocompile/linker"
+	"github.com/kralicky/protocompile/protoutil"
+	"github.com/kralicky/protocompile/reporter"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+var celEnv *cel.Env
+
+func init() {
+	celEnv, _ = celext.DefaultEnv(false)
+	celEnv, _ = celEnv.Extend(cel.EnableMacroCallTracking())
+}
+
+var (
+	validateConstraintDesc = (*validate.Constraint)(nil).ProtoReflect().Descriptor()
+	validateFieldExt       = validate.E_Field.TypeDescriptor().FullName()
+	validateMessageExt     = validate.E_Message.TypeDescriptor().FullName()
+	validatePredefinedExt  = validate.E_Predefined.TypeDescriptor().FullName()
+)
+
+// predefinedRuleTypes maps each of the standard rules messages to the generic
+// type of 'this' in predefined constraints extending that message.
+var predefinedRuleTypes = map[protoreflect.FullName]*cel.Type{
+	"buf.validate.FloatRules":     cel.DoubleType,
+	"buf.validate.DoubleRules":    cel.DoubleType,
+	"buf.validate.Int32Rules":     cel.IntType,
+	"buf.validate.Int64Rules":     cel.IntType,
+	"buf.validate.UInt32Rules":    cel.UintType,
+	"buf.validate.UInt64Rules":    cel.UintType,
+	"buf.validate.SInt32Rules":    cel.IntType,
+	"buf.validate.SInt64Rules":    cel.IntType,
+	"buf.validate.Fixed32Rules":   cel.UintType,
+	"buf.validate.Fixed64Rules":   cel.UintType,
+	"buf.validate.SFixed32Rules":  cel.IntType,
+	"buf.validate.SFixed64Rules":  cel.IntType,
+	"buf.validate.BoolRules":      cel.BoolType,
+	"buf.validate.StringRules":    cel.StringType,
+	"buf.validate.BytesRules":     cel.BytesType,
+	"buf.validate.EnumRules":      cel.IntType,
+	"buf.validate.RepeatedRules":  cel.ListType(cel.DynType),
+	"buf.validate.MapRules":       cel.MapType(cel.DynType, cel.DynType),
+	"buf.validate.AnyRules":       cel.AnyType,
+	"buf.validate.DurationRules":  cel.DurationType,
+	"buf.validate.TimestampRules": cel.TimestampType,
+}
+
+type celConstraintScope int
+
+const (
+	// (buf.validate.message).cel
+	celScopeMessage celConstraintScope = iota
+	// (buf.validate.field).cel
+	celScopeField
+	// (buf.validate.field).repeated.items.cel
+	celScopeRepeatedItems
+	// (buf.validate.field).map.keys.cel
+	celScopeMapKeys
+	// (buf.validate.field).map.values.cel
+	celScopeMapValues
+	// (buf.validate.predefined).cel
+	celScopePredefined
+)
+
+// celConstraint is a protovalidate CEL expression declared in an option.
+type celConstraint struct {
+	scope celConstraintScope
+	// The message or field the option is declared on
+	target protoreflect.Descriptor
+	// The value of the constraint's 'expression' field
+	expression *ast.ValueNode
+}
+
+// findCelConstraints returns all the CEL expressions declared in the
+// buf.validate options of messages and fields in the given file.
+func findCelConstraints(res linker.Result) []celConstraint {
+	var constraints []celConstraint
+
+	var walkValue func(target protoreflect.Descriptor, path []protoreflect.FieldDescriptor, val *ast.ValueNode)
+	walkValue = func(target protoreflect.Descriptor, path []protoreflect.FieldDescriptor, val *ast.ValueNode) {
+		switch node := val.Unwrap().(type) {
+		case *ast.MessageLiteralNode:
+			for _, elem := range node.Elements {
+				fd := res.FindFieldDescriptorByMessageFieldNode(elem)
+				if fd == nil {
+					continue
+				}
+				walkValue(target, append(path[:len(path):len(path)], fd), elem.Val)
+			}
+		case *ast.ArrayLiteralNode:
+			for _, elem := range node.Elements {
+				if v := elem.GetValue(); v != nil {
+					walkValue(target, path, v)
+				}
+			}
+		case *ast.StringLiteralNode, *ast.CompoundStringLiteralNode:
+			if scope, ok := celScopeForOptionPath(target, path); ok {
+				constraints = append(constraints, celConstraint{
+					scope:      scope,
+					target:     target,
+					expression: val,
+				})
+			}
+		}
+	}
+	visitOptions := func(target protoreflect.Descriptor, opts []*ast.OptionNode) {
+		for _, opt := range opts {
+			uo := res.OptionDescriptor(opt)
+			if uo == nil || opt.Val == nil {
+				continue
+			}
+			var path []protoreflect.FieldDescriptor
+			for _, part := range uo.GetName() {
+				fd := res.FindOptionNameFieldDescriptor(part)
+				if fd == nil {
+					path = nil
+					break
+				}
+				path = append(path, fd)
+			}
+			if len(path) > 0 {
+				walkValue(target, path, opt.Val)
+			}
+		}
+	}
+	visitField := func(fd protoreflect.FieldDescriptor) {
+		node := res.FieldNode(fd.(protoutil.DescriptorProtoWrapper).AsProto().(*descriptorpb.FieldDescriptorProto))
+		if node == nil || node.Unwrap() == nil {
+			return
+		}
+		visitOptions(fd, node.Unwrap().GetOptions().GetOptions())
+	}
+	var visitMessage func(md protoreflect.MessageDescriptor)
+	visitMessage = func(md protoreflect.MessageDescriptor) {
+		if md.IsMapEntry() {
+			return
+		}
+		var decls []*ast.MessageElement
+		switch node := res.MessageNode(md.(protoutil.DescriptorProtoWrapper).AsProto().(*descriptorpb.DescriptorProto)).Unwrap().(type) {
+		case *ast.MessageNode:
+			decls = node.Decls
+		case *ast.GroupNode:
+			decls = node.Decls
+		}
+		var opts []*ast.OptionNode
+		for _, decl := range decls {
+			if opt := decl.GetOption(); opt != nil {
+				opts = append(opts, opt)
+			}
+		}
+		visitOptions(md, opts)
+		for i := 0; i < md.Fields().Len(); i++ {
+			visitField(md.Fields().Get(i))
+		}
+		for i := 0; i < md.Extensions().Len(); i++ {
+			visitField(md.Extensions().Get(i))
+		}
+		for i := 0; i < md.Messages().Len(); i++ {
+			visitMessage(md.Messages().Get(i))
+		}
+	}
+	for i := 0; i < res.Messages().Len(); i++ {
+		visitMessage(res.Messages().Get(i))
+	}
+	for i := 0; i < res.Extensions().Len(); i++ {
+		visitField(res.Extensions().Get(i))
+	}
+	return constraints
+}
+
+// celScopeForOptionPath determines the kind of constraint an expression
+// belongs to, given the path of fields from the option name (starting at the
+// buf.validate extension) to the constraint's 'expression' field.
+func celScopeForOptionPath(target protoreflect.Descriptor, path []protoreflect.FieldDescriptor) (celConstraintScope, bool) {
+	if len(path) < 3 {
+		return 0, false
+	}
+	expr, cel := path[len(path)-1], path[len(path)-2]
+	if expr.ContainingMessage().FullName() != validateConstraintDesc.FullName() || expr.Name() != "expression" {
+		return 0, false
+	}
+	if cel.Message() == nil || cel.Message().FullName() != validateConstraintDesc.FullName() {
+		return 0, false
+	}
+	var names []string
+	for _, fd := range path[1 : len(path)-2] {
+		names = append(names, string(fd.Name()))
+	}
+	switch path[0].FullName() {
+	case validateMessageExt:
+		if _, ok := target.(protoreflect.MessageDescriptor); ok && len(names) == 0 {
+			return celScopeMessage, true
+		}
+	case validateFieldExt:
+		fd, ok := target.(protoreflect.FieldDescriptor)
+		if !ok {
+			break
+		}
+		switch strings.Join(names, ".") {
+		case "":
+			return celScopeField, true
+		case "repeated.items":
+			return celScopeRepeatedItems, fd.IsList()
+		case "map.keys":
+			return celScopeMapKeys, fd.IsMap()
+		case "map.values":
+			return celScopeMapValues, fd.IsMap()
+		}
+	case validatePredefinedExt:
+		if fd, ok := target.(protoreflect.FieldDescriptor); ok && fd.IsExtension() && len(names) == 0 {
+			return celScopePredefined, true
+		}
+	}
+	return 0, false
+}
+
+type celVariable struct {
+	name string
+	typ  *cel.Type
+}
+
+// variables returns the variables bound in the constraint's environment:
+// 'this', and also 'rules' and 'rule' for predefined constraints.
+func (c celConstraint) variables() []celVariable {
+	switch c.scope {
+	case celScopeMessage:
+		md := c.target.(protoreflect.MessageDescriptor)
+		return []celVariable{{"this", cel.ObjectType(string(md.FullName()))}}
+	case celScopePredefined:
+		fd := c.target.(protoreflect.FieldDescriptor)
+		rules := fd.ContainingMessage()
+		thisType, ok := predefinedRuleTypes[rules.FullName()]
+		if !ok {
+			thisType = cel.DynType
+		}
+		return []celVariable{
+			{"this", thisType},
+			{"rules", cel.ObjectType(string(rules.FullName()))},
+			{"rule", celext.ProtoFieldToCELType(fd, true, false)},
+		}
+	}
+	fd, forItems := c.fieldForThis()
+	return []celVariable{{"this", celext.ProtoFieldToCELType(fd, false, forItems)}}
+}
+
+// fieldForThis returns the field (or map key/value) that 'this' refers to in
+// field constraints, and whether it refers to an individual item of the field.
+func (c celConstraint) fieldForThis() (protoreflect.FieldDescriptor, bool) {
+	fd := c.target.(protoreflect.FieldDescriptor)
+	switch c.scope {
+	case celScopeRepeatedItems:
+		return fd, true
+	case celScopeMapKeys:
+		return fd.MapKey(), true
+	case celScopeMapValues:
+		return fd.MapValue(), true
+	}
+	return fd, false
+}
+
+// env returns the CEL environment in which protovalidate evaluates the
+// constraint, with the constraint's variables bound to the appropriate types.
+func (c celConstraint) env() (*cel.Env, error) {
+	var opts []cel.EnvOption
+	switch c.scope {
+	case celScopeMessage:
+		opts = append(opts, cel.Types(dynamicpb.NewMessage(c.target.(protoreflect.MessageDescriptor))))
+	case celScopePredefined:
+		opts = append(opts, cel.Types(dynamicpb.NewMessage(c.target.(protoreflect.FieldDescriptor).ContainingMessage())))
+	default:
+		fd, _ := c.fieldForThis()
+		opts = append(opts, celext.RequiredCELEnvOptions(fd)...)
+	}
+	for _, v := range c.variables() {
+		opts = append(opts, cel.Variable(v.name, v.typ))
+	}
+	return celEnv.Extend(opts...)
+}
+
+// check parses and type-checks the constraint's expression, returning any
+// errors positioned within the expression's string literal(s).
+func (c celConstraint) check(file *ast.FileNode) []reporter.ErrorWithPos {
+	expr, ok := newCelExpression(file, c.expression)
+	if !ok {
+		return nil
+	}
+	env, err := c.env()
+	if err != nil {
+		slog.Debug("failed to create CEL environment", "target", c.target.FullName(), "error", err)
+		return nil
+	}
+	var errs []reporter.ErrorWithPos
+	parsed, issues := env.Parse(expr.text)
+	if issues.Err() != nil {
+		for _, e := range issues.Errors() {
+			offset := expr.locationOffset(e.Location)
+			errs = append(errs, reporter.Error(expr.span(offset, offset+1), errors.New(e.Message)))
+		}
+		return errs
+	}
+	checked, issues := env.Check(parsed)
+	if issues.Err() != nil {
+		for _, e := range issues.Errors() {
+			errs = append(errs, reporter.Error(expr.exprSpan(parsed.NativeRep(), e), errors.New(e.Message)))
+		}
+		return errs
+	}
+	if outType := checked.OutputType(); !outType.IsAssignableType(cel.BoolType) && !outType.IsAssignableType(cel.StringType) {
+		errs = append(errs, reporter.Error(file.NodeInfo(c.expression),
+			fmt.Errorf("expression outputs %s, wanted either bool or string", outType)))
+	}
+	return errs
+}
+
+// checkCelExpressions type-checks the protovalidate CEL expressions declared
+// in the given file, returning any parse or type errors.
+func checkCelExpressions(res linker.Result) []reporter.ErrorWithPos {
+	file := res.AST()
+	if file == nil {
+		return nil
+	}
+	var errs []reporter.ErrorWithPos
+	for _, c := range findCelConstraints(res) {
+		errs = append(errs, c.check(file)...)
+	}
+	return errs
+}
+
+// findCelExpressionAt returns the protovalidate CEL constraint whose
+// expression contains the given file offset, along with the offset of the
+// corresponding rune in the expression text.
+func findCelExpressionAt(res linker.Result, offset int) (celConstraint, *celExpression, int, bool) {
+	file := res.AST()
+	if file == nil {
+		return celConstraint{}, nil, 0, false
+	}
+	for _, c := range findCelConstraints(res) {
+		info := file.NodeInfo(c.expression)
+		if offset <= info.Start().Offset || offset > info.End().Offset {
+			continue
+		}
+		expr, ok := newCelExpression(file, c.expression)
+		if !ok {
+			continue
+		}
+		if pos, ok := expr.runeOffset(offset); ok {
+			return c, expr, pos, true
+		}
+	}
+	return celConstraint{}, nil, 0, false
+}
+
+// celExpression is the text of a CEL expression contained in a string literal
+// or compound string literal, along with the information needed to map
+// positions in the expression back to the source file.
+type celExpression struct {
+	text   string
+	runes  []rune
+	file   *ast.FileNode
+	source celcommon.Source
+	parts  []celExpressionPart
+}
+
+type celExpressionPart struct {
+	// Rune offset of the start of this part in the expression text
+	offset int
+	// File offsets of each rune in the literal's value, followed by the offset
+	// of the closing quote. Escape sequences count as a single rune.
+	fileOffsets []int
+}
+
+func newCelExpression(file *ast.FileNode, val *ast.ValueNode) (*celExpression, bool) {
+	var literals []*ast.StringLiteralNode
+	switch val := val.Unwrap().(type) {
+	case *ast.StringLiteralNode:
+		literals = append(literals, val)
+	case *ast.CompoundStringLiteralNode:
+		for _, part := range val.Elements {
+			if str := part.GetStringLiteral(); str != nil {
+				literals = append(literals, str)
+			}
+		}
+	}
+	if len(literals) == 0 {
+		return nil, false
+	}
+	expr := &celExpression{file: file}
+	var text strings.Builder
+	var offset int
+	for _, lit := range literals {
+		info := file.NodeInfo(lit)
+		if !info.IsValid() {
+			return nil, false
+		}
+		raw := info.RawText()
+		if len(raw) < 2 {
+			return nil, false
+		}
+		start := info.Start().Offset
+		part := celExpressionPart{offset: offset}
+		escapes := escapeCharRegex.FindAllStringIndex(raw, -1)
+		for i := 1; i < len(raw)-1; {
+			part.fileOffsets = append(part.fileOffsets, start+i)
+			if len(escapes) > 0 && escapes[0][0] == i {
+				i = escapes[0][1]
+				escapes = escapes[1:]
+				continue
+			}
+			_, size := utf8.DecodeRuneInString(raw[i:])
+			i += size
+		}
+		part.fileOffsets = append(part.fileOffsets, start+len(raw)-1)
+		offset += len(part.fileOffsets) - 1
+		text.WriteString(lit.AsString())
+		expr.parts = append(expr.parts, part)
+	}
+	expr.text = text.String()
+	expr.runes = []rune(expr.text)
+	expr.source = celcommon.NewTextSource(expr.text)
+	return expr, true
+}
+
+// sourcePos returns the position in the file of the rune at the given offset
+// in the expression. If end is true and the offset falls on the boundary
+// between two string literals, the position at the end of the first literal
+// is returned instead of the start of the second.
+func (e *celExpression) sourcePos(offset int, end bool) ast.SourcePos {
+	for i, part := range e.parts {
+		rel := max(offset-part.offset, 0)
+		n := len(part.fileOffsets) - 1
+		if rel < n || (rel == n && (end || i == len(e.parts)-1)) {
+			return e.file.SourcePos(part.fileOffsets[rel])
+		}
+	}
+	last := e.parts[len(e.parts)-1]
+	return e.file.SourcePos(last.fileOffsets[len(last.fileOffsets)-1])
+}
+
+// span returns the source span of the runes in the expression in the range
+// [start, end).
+func (e *celExpression) span(start, end int) ast.SourceSpan {
+	if end <= start {
+		end = start + 1
+	}
+	return ast.NewSourceSpan(e.sourcePos(start, false), e.sourcePos(end, true))
+}
+
+func (e *celExpression) locationOffset(loc celcommon.Location) int {
+	offset, ok := e.source.LocationOffset(loc)
+	if !ok {
+		return 0
+	}
+	return int(offset)
+}
+
+// exprSpan returns the source span of the expression referenced by a type
+// checker error.
+func (e *celExpression) exprSpan(parsed *celast.AST, err *celcommon.Error) ast.SourceSpan {
+	start, stop, ok := e.tokenRange(parsed, err.ExprID)
+	if !ok {
+		offset := e.locationOffset(err.Location)
+		return e.span(offset, offset+1)
+	}
+	return e.span(start, stop)
+}
+
+// tokenRange returns the range of runes in the expression text covering the
+// token that represents the given sub-expression. Call expressions (including
+// macros) are positioned at their opening parenthesis, field selections at
+// the '.', struct literals at the '{' and operators at their symbol, so the
+// range is adjusted to cover the function name, field name, type name, or
+// operator.
+func (e *celExpression) tokenRange(parsed *celast.AST, id int64) (int, int, bool) {
+	expr := findCelExpr(parsed.Expr(), id)
+	if call, ok := parsed.SourceInfo().GetMacroCall(id); ok {
+		expr = call
+	}
+	if expr == nil {
+		return e.offsetRange(parsed, id)
+	}
+	return e.exprTokenRange(parsed, id, expr)
+}
+
+// exprTokenRange is like tokenRange, but for an expression that may not be
+// part of the parsed expression tree, such as a macro call argument.
+func (e *celExpression) exprTokenRange(parsed *celast.AST, id int64, expr celast.Expr) (int, int, bool) {
+	start, stop, ok := e.offsetRange(parsed, id)
+	if !ok || start >= len(e.runes) {
+		return start, stop, ok
+	}
+	switch expr.Kind() {
+	case celast.CallKind:
+		fn := expr.AsCall().FunctionName()
+		if displayName, ok := operators.FindReverse(fn); ok && len(displayName) > 0 {
+			stop = start + utf8.RuneCountInString(displayName)
+		} else if e.runes[start] == '(' {
+			start, stop = max(start-utf8.RuneCountInString(fn), 0), start
+		}
+	case celast.SelectKind:
+		if e.runes[start] == '.' {
+			start++
+			stop = start + utf8.RuneCountInString(expr.AsSelect().FieldName())
+		}
+	case celast.StructKind:
+		if e.runes[start] == '{' {
+			stop = e.skipSpaceBefore(start)
+			start = max(stop-utf8.RuneCountInString(expr.AsStruct().TypeName()), 0)
+		}
+	}
+	return start, stop, true
+}
+
+// structFieldRange returns the range of runes covering the name of a field in
+// a struct literal, which is positioned at the ':' following the name.
+func (e *celExpression) structFieldRange(parsed *celast.AST, field celast.EntryExpr) (int, int, bool) {
+	start, _, ok := e.offsetRange(parsed, field.ID())
+	if !ok || start >= len(e.runes) || e.runes[start] != ':' {
+		return 0, 0, false
+	}
+	stop := e.skipSpaceBefore(start)
+	return max(stop-utf8.RuneCountInString(field.AsStructField().Name()), 0), stop, true
+}
+
+func (e *celExpression) offsetRange(parsed *celast.AST, id int64) (int, int, bool) {
+	r, ok := parsed.SourceInfo().GetOffsetRange(id)
+	if !ok {
+		return 0, 0, false
+	}
+	return int(r.Start), int(r.Stop), true
+}
+
+func (e *celExpression) skipSpaceBefore(offset int) int {
+	for offset > 0 && unicode.IsSpace(e.runes[offset-1]) {
+		offset--
+	}
+	return offset
+}
+
+// celSelectedField returns the message field selected from a value of the
+// given type, if the type is a message type.
+func celSelectedField(
+	operandType *types.Type,
+	fieldName string,
+	findDescriptor func(protoreflect.FullName) (protoreflect.Descriptor, error),
+) protoreflect.FieldDescriptor {
+	if operandType == nil || operandType.Kind() != types.StructKind {
+		return nil
+	}
+	desc, err := findDescriptor(protoreflect.FullName(operandType.TypeName()))
+	if err != nil {
+		return nil
+	}
+	md, ok := desc.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil
+	}
+	return md.Fields().ByName(protoreflect.Name(fieldName))
+}
+
+// runeOffset returns the offset in the expression text of the rune at the
+// given file offset, if the offset is within one of the expression's string
+// literals.
+func (e *celExpression) runeOffset(fileOffset int) (int, bool) {
+	for _, part := range e.parts {
+		if fileOffset < part.fileOffsets[0] || fileOffset > part.fileOffsets[len(part.fileOffsets)-1] {
+			continue
+		}
+		i, _ := slices.BinarySearch(part.fileOffsets, fileOffset)
+		return part.offset + i, true
+	}
+	return 0, false
+}
+
+func findCelExpr(root celast.Expr, id int64) celast.Expr {
+	var found celast.Expr
+	celast.PreOrderVisit(root, celast.NewExprVisitor(func(e celast.Expr) {
+		if found == nil && e.ID() == id {
+			found = e
+		}
+	}))
+	return found
+}
+
+// celMacros lists the standard CEL macros, which are expanded by the parser
+// and do not appear in the environment's function declarations.
+var celMacros = []struct {
+	name      string
+	signature string
+	member    bool
+}{
+	{"has", "has(e.f) -> bool", false},
+	{"all", "list(A).all(x, predicate) -> bool", true},
+	{"exists", "list(A).exists(x, predicate) -> bool", true},
+	{"exists_one", "list(A).exists_one(x, predicate) -> bool", true},
+	{"map", "list(A).map(x, transform) -> list(B)", true},
+	{"filter", "list(A).filter(x, predicate) -> list(A)", true},
+}
+
+var celFunctionDocs = map[string]string{
+	// macros
+	"has":        "Tests whether a field is set (or whether a map contains a key).",
+	"all":        "Tests whether a predicate holds for all elements of a list or keys of a map.",
+	"exists":     "Tests whether a predicate holds for any element of a list or key of a map.",
+	"exists_one": "Tests whether a predicate holds for exactly one element of a list or key of a map.",
+	"map":        "Transforms each element of a list or key of a map, returning a list of the results.",
+	"filter":     "Returns the elements of a list or keys of a map for which a predicate holds.",
+
+	// standard library
+	"size":       "Returns the number of elements in a list or map, characters in a string, or bytes in a bytes value.",
+	"contains":   "Tests whether a string contains the given substring.",
+	"startsWith": "Tests whether a string starts with the given prefix.",
+	"endsWith":   "Tests whether a string ends with the given suffix.",
+	"matches":    "Tests whether a string matches the given RE2 regular expression.",
+	"int":        "Converts a value to an int.",
+	"uint":       "Converts a value to a uint.",
+	"double":     "Converts a value to a double.",
+	"string":     "Converts a value to a string.",
+	"bytes":      "Converts a string to bytes.",
+	"bool":       "Converts a string to a bool.",
+	"duration":   "Converts a string (e.g. \"1h30m\") to a google.protobuf.Duration.",
+	"timestamp":  "Converts an RFC3339 string to a google.protobuf.Timestamp.",
+	"dyn":        "Indicates that the type of a value should be treated as dynamic during type-checking.",
+	"type":       "Returns the type of a value.",
+
+	// strings extension
+	"charAt":      "Returns the character at the given index.",
+	"indexOf":     "Returns the index of the first occurrence of a substring, or -1 if not found.",
+	"lastIndexOf": "Returns the index of the last occurrence of a substring, or -1 if not found.",
+	"lowerAscii":  "Returns a copy of the string with all ASCII characters converted to lowercase.",
+	"upperAscii":  "Returns a copy of the string with all ASCII characters converted to uppercase.",
+	"replace":     "Replaces occurrences of a substring with a replacement string.",
+	"split":       "Splits a string into a list of strings using the given separator.",
+	"substring":   "Returns the substring between the given indexes.",
+	"trim":        "Removes leading and trailing whitespace from a string.",
+	"join":        "Concatenates a list of strings, with an optional separator.",
+	"reverse":     "Returns the characters of the string in reverse order.",
+	"format":      "Formats a string using printf-style substitutions.",
+
+	// protovalidate extensions
+	"isEmail":       "Tests whether a string is a valid email address, as defined by the HTML specification.",
+	"isHostname":    "Tests whether a string is a valid hostname, as defined by RFC 1034.",
+	"isIp":          "Tests whether a string is a valid IP address, optionally of the given version (4 or 6).",
+	"isIpPrefix":    "Tests whether a string is a valid IP prefix, optionally of the given version (4 or 6) and optionally requiring the host bits to be zero.",
+	"isUri":         "Tests whether a string is a valid absolute URI, as defined by RFC 3986.",
+	"isUriRef":      "Tests whether a string is a valid URI reference (an absolute URI or a relative reference), as defined by RFC 3986.",
+	"isHostAndPort": "Tests whether a string is a valid host and port pair, optionally requiring the port to be present.",
+	"isNan":         "Tests whether a double is NaN.",
+	"isInf":         "Tests whether a double is infinite, optionally only for the given sign (1 or -1).",
+	"unique":        "Tests whether all elements of a list are unique.",
+}
+
+// isCelIdentifier reports whether the function name can be written as an
+// identifier in an expression (as opposed to operators and internal names).
+func isCelIdentifier(name string) bool {
+	for i, r := range name {
+		if !isCelIdentRune(r) || (i == 0 && r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return name != "" && name[0] != '_'
+}
+
+func isCelIdentRune(r rune) bool {
+	return r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')
+}
+
+// formatCelOverload returns a readable signature for a function overload,
+// e.g. 'string.startsWith(string) -> bool'.
+func formatCelOverload(name string, overload *decls.OverloadDecl) string {
+	args := overload.ArgTypes()
+	var argNames []string
+	if overload.IsMemberFunction() && len(args) > 0 {
+		for _, arg := range args[1:] {
+			argNames = append(argNames, arg.String())
+		}
+		return fmt.Sprintf("%s.%s(%s) -> %s", args[0], name, strings.Join(argNames, ", "), overload.ResultType())
+	}
+	for _, arg := range args {
+		argNames = append(argNames, arg.String())
+	}
+	return fmt.Sprintf("%s(%s) -> %s", name, strings.Join(argNames, ", "), overload.ResultType())
+}
+
+// celOperandBefore returns the text of the operand preceding a '.' at the
+// given offset, e.g. 'this.items[0]' in 'size(this.items[0].|'.
+func celOperandBefore(runes []rune, dot int) string {
+	start := dot
+	depth := 0
+scan:
+	for ; start > 0; start-- {
+		switch r := runes[start-1]; {
+		case r == ')' || r == ']':
+			depth++
+		case r == '(' || r == '[':
+			if depth == 0 {
+				break scan
+			}
+			depth--
+		case depth > 0, isCelIdentRune(r), r == '.':
+		default:
+			break scan
+		}
+	}
+	return strings.TrimSpace(string(runes[start:dot]))
+}
@@ -0,0 +1,269 @@
+package lsp
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/kralicky/protocompile/linker"
+	"github.com/kralicky/tools-lite/gopls/pkg/protocol"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckCelExpressions(t *testing.T) {
+	const src = `
+syntax = "proto2";
+import "buf/validate/validate.proto";
+import "google/protobuf/timestamp.proto";
+
+message Foo {
+  option (buf.validate.message).cel = {
+    id: "msg"
+    expression: "this.name.size() > 0 && this.nmae != ''"
+  };
+  optional string name = 1 [(buf.validate.field).cel = {
+    id: "len"
+    expression: "this.size() + 'a' > 1"
+  }];
+  repeated int32 ids = 2 [(buf.validate.field).repeated.items.cel = {
+    id: "items"
+    expression: "this > 0"
+  }];
+  map<string, int64> m = 3 [(buf.validate.field).map.values.cel = {
+    id: "values"
+    expression: "this.startsWith('x')"
+  }];
+  optional google.protobuf.Timestamp ts = 4 [(buf.validate.field).cel = {
+    id: "ts"
+    expression: "this < now"
+  }];
+  optional string syntax_error = 5 [(buf.validate.field).cel = {
+    id: "syntax"
+    expression: "this.matches(\"\\d\")"
+  }];
+  optional int32 output = 6 [(buf.validate.field).cel = {
+    id: "output"
+    expression: "this + 1"
+  }];
+  optional string multiline = 7 [(buf.validate.field).cel = {
+    id: "multiline"
+    expression:
+      "this.size() > 0"
+      " && this.sise() < 10"
+  }];
+}
+
+extend buf.validate.StringRules {
+  optional bool lowercase = 80048952 [(buf.validate.predefined).cel = {
+    id: "string.lowercase"
+    expression: "rule && this != this.lowerAscii() ? 'must be lowercase' : ''"
+  }];
+}
+`
+	res := compileFile(t, src)
+	var actual []string
+	for _, err := range checkCelExpressions(res) {
+		span := err.GetPosition()
+		lines := strings.Split(src, "\n")
+		start, end := span.Start(), span.End()
+		var text string
+		if start.Line == end.Line {
+			text = lines[start.Line-1][start.Col-1 : end.Col-1]
+		}
+		actual = append(actual, fmt.Sprintf("%d:%d %q: %s", start.Line, start.Col, text, err.Unwrap()))
+	}
+	require.Equal(t, []string{
+		`9:47 "nmae": undefined field 'nmae'`,
+		`13:30 "+": found no matching overload for '_+_' applied to '(int, string)'`,
+		`21:23 "startsWith": found no matching overload for 'startsWith' applied to 'int.(string)'`,
+		`29:31 "\\\"": Syntax error: token recognition error at: '"\d'`,
+		`29:36 "\\\"": Syntax error: token recognition error at: '")'`,
+		`29:39 "": Syntax error: mismatched input '<EOF>' expecting {'[', '{', '(', ')', '.', '-', '!', 'true', 'false', 'null', NUM_FLOAT, NUM_INT, NUM_UINT, STRING, BYTES, IDENTIFIER}`,
+		`33:17 "\"this + 1\"": expression outputs int, wanted either bool or string`,
+		`39:17 "sise": undeclared reference to 'sise' (in container '')`,
+	}, actual)
+}
+
+const celCompletionTestSrc = `
+syntax = "proto3";
+import "buf/validate/validate.proto";
+
+message Foo {
+  option (buf.validate.message).cel = {
+    id: "msg"
+    expression: "this.name.startsWith('a') && size(this.ids) > 0"
+  };
+  // The name
+  string name = 1 [(buf.validate.field).cel = {
+    id: "email"
+    expression: "this.isEm"
+  }];
+  repeated int32 ids = 2 [(buf.validate.field).cel = {
+    id: "ids"
+    expression: "this.all(x, x > 0)"
+  }];
+  int64 count = 3 [(buf.validate.field).cel = {
+    id: "count"
+    expression: "this > 0 || th"
+  }];
+}
+`
+
+// celTestOffset returns the file offset of the end of the first occurrence
+// of substr in src.
+func celTestOffset(t *testing.T, src, substr string) int {
+	t.Helper()
+	i := strings.Index(src, substr)
+	require.GreaterOrEqual(t, i, 0, "substring %q not found", substr)
+	return i + len(substr)
+}
+
+func TestCompleteCelExpression(t *testing.T) {
+	res := compileFile(t, celCompletionTestSrc)
+	findDescriptor := linker.ResolverFromFile(res).FindDescriptorByName
+
+	complete := func(substr string) map[string]protocol.CompletionItem {
+		constraint, expr, offset, ok := findCelExpressionAt(res, celTestOffset(t, celCompletionTestSrc, substr))
+		require.True(t, ok)
+		items := map[string]protocol.CompletionItem{}
+		for _, item := range completeCelExpression(constraint, expr, offset, findDescriptor) {
+			items[item.Label] = item
+		}
+		return items
+	}
+
+	items := complete(`"this.na`)
+	require.Contains(t, items, "name")
+	require.Equal(t, protocol.FieldCompletion, items["name"].Kind)
+	require.NotContains(t, items, "ids")
+
+	items = complete(`"this.isEm`)
+	require.Len(t, items, 1)
+	require.Equal(t, protocol.MethodCompletion, items["isEmail"].Kind)
+	require.Equal(t, "string.isEmail() -> bool", items["isEmail"].Detail)
+
+	items = complete(`this > 0 || th`)
+	require.Contains(t, items, "this")
+	require.Equal(t, "int", items["this"].Detail)
+	require.NotContains(t, items, "name")
+}
+
+func TestHoverCelExpression(t *testing.T) {
+	res := compileFile(t, celCompletionTestSrc)
+	findDescriptor := linker.ResolverFromFile(res).FindDescriptorByName
+
+	hover := func(substr string) string {
+		constraint, expr, offset, ok := findCelExpressionAt(res, celTestOffset(t, celCompletionTestSrc, substr)-1)
+		require.True(t, ok)
+		h := hoverCelExpression(constraint, expr, offset, findDescriptor)
+		if h == nil {
+			return ""
+		}
+		return h.Contents.Value
+	}
+
+	require.Contains(t, hover(`size(this.ids`), "```cel\n.ids: list(int)\n```")
+	require.Contains(t, hover(`size(this.ids`), "repeated int32 ids = 2")
+	require.Contains(t, hover(`&& size`), "```cel\nsize: int\n```")
+	require.Contains(t, hover(`&& size`), "size(list(A)) -> int")
+	require.Contains(t, hover(`this.all(x, x`), "```cel\nx: int\n```")
+	require.Contains(t, hover(`this.al`), "```cel\nall: bool\n```")
+}
+
+func TestCelSemanticTokens(t *testing.T) {
+	const src = `
+syntax = "proto3";
+import "buf/validate/validate.proto";
+
+message Foo {
+  option (buf.validate.message).cel = {
+    id: "msg"
+    expression: "has(this.name) && this.ids.all(x, x in [1, 2u]) && Foo{old: 1}.old != this.old ? 'a\"b' : ''"
+  };
+  string name = 1;
+  repeated int32 ids = 2 [(buf.validate.field).cel = {
+    id: "ids"
+    expression:
+      "this.map(y, y * 1.5).exists(z,"
+      " z > 0) && size(this) > 0"
+  }];
+  int64 old = 3 [deprecated = true, (buf.validate.field).cel = {
+    id: "old"
+    expression: "this != 0 && now > timestamp('2024-01-01T00:00:00Z') == true"
+  }];
+}
+`
+	res := compileFile(t, src)
+	s := semanticItems{
+		parseRes:     res,
+		maybeLinkRes: res,
+	}
+	s.inspect(res.AST())
+	sort.Slice(s.items, func(i, j int) bool {
+		if s.items[i].line != s.items[j].line {
+			return s.items[i].line < s.items[j].line
+		}
+		return s.items[i].start < s.items[j].start
+	})
+	lines := strings.Split(src, "\n")
+	var actual []string
+	for _, item := range s.items {
+		if item.lang != tokenLanguageCel {
+			continue
+		}
+		text := lines[item.line][item.start : item.start+item.len]
+		actual = append(actual, fmt.Sprintf("%d:%d %s %s %s", item.line+1, item.start+1, text, item.typ, item.mods))
+	}
+	require.Equal(t, []string{
+		`8:18 has TypeMacro ModifierDefaultLibrary`,
+		`8:22 this TypeKeyword tokenModifier(0)`,
+		`8:27 name TypeProperty ModifierReadonly`,
+		`8:33 && TypeOperator tokenModifier(0)`,
+		`8:36 this TypeKeyword tokenModifier(0)`,
+		`8:41 ids TypeProperty ModifierReadonly`,
+		`8:45 all TypeMacro ModifierDefaultLibrary`,
+		`8:49 x TypeVariable tokenModifier(5)`,
+		`8:52 x TypeVariable ModifierReadonly`,
+		`8:54 in TypeOperator tokenModifier(0)`,
+		`8:58 1 TypeNumber tokenModifier(0)`,
+		`8:61 2u TypeNumber tokenModifier(0)`,
+		`8:66 && TypeOperator tokenModifier(0)`,
+		`8:69 Foo TypeType tokenModifier(0)`,
+		`8:73 old TypeProperty tokenModifier(20)`,
+		`8:78 1 TypeNumber tokenModifier(0)`,
+		`8:81 old TypeProperty tokenModifier(20)`,
+		`8:85 != TypeOperator tokenModifier(0)`,
+		`8:88 this TypeKeyword tokenModifier(0)`,
+		`8:93 old TypeProperty tokenModifier(20)`,
+		`8:97 ? TypeOperator tokenModifier(0)`,
+		`8:99 'a\"b' TypeString tokenModifier(0)`,
+		`8:108 '' TypeString tokenModifier(0)`,
+		`14:8 this TypeKeyword tokenModifier(0)`,
+		`14:13 map TypeMacro ModifierDefaultLibrary`,
+		`14:17 y TypeVariable tokenModifier(5)`,
+		`14:20 y TypeVariable ModifierReadonly`,
+		`14:22 * TypeOperator tokenModifier(0)`,
+		`14:24 1.5 TypeNumber tokenModifier(0)`,
+		`14:29 exists TypeMacro ModifierDefaultLibrary`,
+		`14:36 z TypeVariable tokenModifier(5)`,
+		`15:9 z TypeVariable ModifierReadonly`,
+		`15:11 > TypeOperator tokenModifier(0)`,
+		`15:13 0 TypeNumber tokenModifier(0)`,
+		`15:16 && TypeOperator tokenModifier(0)`,
+		`15:19 size TypeFunction ModifierDefaultLibrary`,
+		`15:24 this TypeKeyword tokenModifier(0)`,
+		`15:30 > TypeOperator tokenModifier(0)`,
+		`15:32 0 TypeNumber tokenModifier(0)`,
+		`19:18 this TypeKeyword tokenModifier(0)`,
+		`19:23 != TypeOperator tokenModifier(0)`,
+		`19:26 0 TypeNumber tokenModifier(0)`,
+		`19:28 && TypeOperator tokenModifier(0)`,
+		`19:31 now TypeVariable tokenModifier(516)`,
+		`19:35 > TypeOperator tokenModifier(0)`,
+		`19:37 timestamp TypeFunction ModifierDefaultLibrary`,
+		`19:47 '2024-01-01T00:00:00Z' TypeString tokenModifier(0)`,
+		`19:71 == TypeOperator tokenModifier(0)`,
+		`19:74 true TypeKeyword tokenModifier(0)`,
+	}, actual)
+}
@@ -55,6 +55,11 @@ func (c *Cache) GetCodeActions(ctx context.Context, params *protocol.CodeActionP
 						}
 						result = append(result, actions...)
 					}
+				case diagnosticKindInvalidNumber, diagnosticKindRedeclared, diagnosticKindMissingZeroValue,
+					diagnosticKindJSONNameConflict, diagnosticKindOptionValueType, diagnosticKindMissingSyntax:
+					if want[protocol.QuickFix] {
+						result = append(result, c.quickFixActions(params.TextDocument.URI, d, data.Metadata)...)
+					}
 				}
 			}
 		}
@@ -68,6 +73,28 @@ func (c *Cache) GetCodeActions(ctx context.Context, params *protocol.CodeActionP
 			result = append(result, FindRefactorActions(ctx, params, linkRes, mapper, want)...)
 		}
 	}
+	if want[protocol.RefactorMove] {
+		if linkRes, err := c.FindResultByURI(params.TextDocument.URI); err == nil {
+			mapper, err := c.GetMapper(params.TextDocument.URI)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, c.moveToFileActions(params, linkRes, mapper)...)
+			result = append(result, c.nestingActions(params, linkRes, mapper)...)
+		}
+	}
+	if want[protocol.RefactorRewrite] || want[protocol.SourceFixAll] {
+		if linkRes, err := c.FindResultByURI(params.TextDocument.URI); err == nil {
+			mapper, err := c.GetMapper(params.TextDocument.URI)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, c.reserveDeletedActions(ctx, params, linkRes, mapper, want)...)
+			if want[protocol.RefactorRewrite] {
+				result = append(result, c.changeFieldTypeActions(params, linkRes, mapper)...)
+			}
+		}
+	}
 
 	if want[protocol.SourceOrganizeImports] {
 		result = aggregateOrganizeImportsActions(result)
@@ -153,6 +180,7 @@ var supportedCodeActions = map[protocol.CodeActionKind]bool{
 	protocol.RefactorRewrite:       true,
 	protocol.RefactorInline:        true,
 	protocol.RefactorExtract:       true,
+	protocol.RefactorMove:          true,
 }
 
 // Logic here copied from gopls/internal/server/code_action.go
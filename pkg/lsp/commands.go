@@ -6,11 +6,13 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"path/filepath"
 	"runtime"
 
 	"github.com/kralicky/protols/pkg/format"
 	"github.com/kralicky/tools-lite/gopls/pkg/file"
 	"github.com/kralicky/tools-lite/gopls/pkg/protocol"
+	"google.golang.org/protobuf/reflect/protoreflect"
 )
 
 type SelectRangeParams struct {
@@ -66,6 +68,36 @@ type GenerateWorkspaceRequest struct {
 	Workspace protocol.WorkspaceFolder `json:"workspace"`
 }
 
+type MoveToFileRequest struct {
+	// The URI of the file containing the declaration to move.
+	URI protocol.DocumentURI `json:"uri"`
+	// The fully-qualified name of the top-level message, enum, or service
+	// to move.
+	Symbol string `json:"symbol"`
+	// The URI of the file to move the declaration into. The file is created
+	// if it does not exist.
+	Destination protocol.DocumentURI `json:"destination"`
+}
+
+type ChangeNestingRequest struct {
+	// The URI of the file containing the declaration to move.
+	URI protocol.DocumentURI `json:"uri"`
+	// The fully-qualified name of the message or enum to move. Nested
+	// declarations are moved to the top level, and top-level declarations are
+	// moved into the only message using them.
+	Symbol string `json:"symbol"`
+}
+
+type ChangeFieldTypeRequest struct {
+	// The URI of the file containing the field.
+	URI protocol.DocumentURI `json:"uri"`
+	// The fully-qualified name of the field.
+	Field string `json:"field"`
+	// The new type of the field: either the name of a scalar type, or the
+	// fully-qualified name of a message or enum.
+	Type string `json:"type"`
+}
+
 type UnknownCommandHandler interface {
 	Execute(ctx context.Context, uc UnknownCommand) (any, error)
 }
@@ -183,6 +215,86 @@ func (s *Server) ExecuteCommand(ctx context.Context, params *protocol.ExecuteCom
 			return nil, err
 		}
 		return c.FindGeneratedDefinition(ctx, req.TextDocumentPositionParams)
+	case "protols/moveToFile":
+		var req MoveToFileRequest
+		if err := json.Unmarshal(params.Arguments[0], &req); err != nil {
+			return nil, err
+		}
+		c, err := s.CacheForURI(req.URI)
+		if err != nil {
+			return nil, err
+		}
+		changes, newFile, err := c.MoveToFile(ctx, protoreflect.FullName(req.Symbol), req.Destination)
+		if err != nil {
+			return nil, err
+		}
+		var create []protocol.DocumentURI
+		if newFile != nil {
+			create = append(create, req.Destination)
+			changes = append(changes, protocol.TextEditsToDocumentChanges(req.Destination, 0, []protocol.TextEdit{{NewText: string(newFile)}})...)
+		}
+		label := fmt.Sprintf("Move %s to %s", req.Symbol, filepath.Base(req.Destination.Path()))
+		resp, err := s.applyEditCreatingFiles(ctx, label, create, changes)
+		if err == nil && !resp.Applied {
+			err = fmt.Errorf("failed to apply edits: %s", resp.FailureReason)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return nil, nil
+	case "protols/changeNesting":
+		var req ChangeNestingRequest
+		if err := json.Unmarshal(params.Arguments[0], &req); err != nil {
+			return nil, err
+		}
+		c, err := s.CacheForURI(req.URI)
+		if err != nil {
+			return nil, err
+		}
+		changes, newName, err := c.ChangeNesting(ctx, protoreflect.FullName(req.Symbol))
+		if err != nil {
+			return nil, err
+		}
+		resp, err := s.client.ApplyEdit(ctx, &protocol.ApplyWorkspaceEditParams{
+			Label: fmt.Sprintf("Move %s to %s", req.Symbol, newName),
+			Edit:  protocol.WorkspaceEdit{DocumentChanges: changes},
+		})
+		if err == nil && !resp.Applied {
+			err = fmt.Errorf("failed to apply edits: %s", resp.FailureReason)
+		}
+		return nil, err
+	case "protols/changeFieldType":
+		var req ChangeFieldTypeRequest
+		if err := json.Unmarshal(params.Arguments[0], &req); err != nil {
+			return nil, err
+		}
+		c, err := s.CacheForURI(req.URI)
+		if err != nil {
+			return nil, err
+		}
+		changes, warning, err := c.ChangeFieldType(ctx, protoreflect.FullName(req.Field), req.Type)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := s.client.ApplyEdit(ctx, &protocol.ApplyWorkspaceEditParams{
+			Label: fmt.Sprintf("Change type of %s to %s", req.Field, req.Type),
+			Edit:  protocol.WorkspaceEdit{DocumentChanges: changes},
+		})
+		if err == nil && !resp.Applied {
+			err = fmt.Errorf("failed to apply edits: %s", resp.FailureReason)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if warning != "" {
+			if err := s.client.ShowMessage(ctx, &protocol.ShowMessageParams{
+				Type:    protocol.Warning,
+				Message: warning,
+			}); err != nil {
+				slog.Error("failed to show message", "error", err)
+			}
+		}
+		return nil, nil
 	default:
 		var jsonData map[string]interface{}
 		if err := json.Unmarshal(params.Arguments[0], &jsonData); err != nil {
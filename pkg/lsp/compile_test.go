@@ -0,0 +1,78 @@
+package lsp
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kralicky/protocompile"
+	"github.com/kralicky/protocompile/linker"
+	"github.com/kralicky/protocompile/parser"
+	"github.com/kralicky/protocompile/reporter"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// newTestCompiler returns a compiler reading the given sources, and resolving
+// any other imports from the global registry.
+func newTestCompiler(sources map[string]string, rep reporter.Reporter) protocompile.Compiler {
+	return protocompile.Compiler{
+		Resolver: protocompile.CompositeResolver{
+			&protocompile.SourceResolver{
+				Accessor: protocompile.SourceAccessorFromMap(sources),
+			},
+			protocompile.ResolverFunc(func(path protocompile.UnresolvedPath, _ protocompile.ImportContext) (protocompile.SearchResult, error) {
+				fd, err := protoregistry.GlobalFiles.FindFileByPath(string(path))
+				if err != nil {
+					return protocompile.SearchResult{}, err
+				}
+				return protocompile.SearchResult{ResolvedPath: protocompile.ResolvedPath(path), Proto: protodesc.ToFileDescriptorProto(fd)}, nil
+			}),
+		},
+		Reporter:       rep,
+		SourceInfoMode: protocompile.SourceInfoExtraOptionLocations,
+		RetainASTs:     true,
+	}
+}
+
+// compileFiles compiles all of the given sources, which must be free of
+// errors.
+func compileFiles(t *testing.T, sources map[string]string) linker.Files {
+	t.Helper()
+	compiler := newTestCompiler(sources, nil)
+	var paths []protocompile.ResolvedPath
+	for path := range sources {
+		paths = append(paths, protocompile.ResolvedPath(path))
+	}
+	res, err := compiler.Compile(context.Background(), paths...)
+	require.NoError(t, err)
+	return res.Files
+}
+
+// compileFile compiles the source as test.proto, which must be free of errors.
+func compileFile(t *testing.T, src string) linker.Result {
+	t.Helper()
+	return compileFiles(t, map[string]string{"test.proto": src})[0].(linker.Result)
+}
+
+// compileFileWithDiagnostics compiles the source as test.proto, returning the
+// most complete result available along with all reported errors and warnings.
+func compileFileWithDiagnostics(t *testing.T, src string) (parser.Result, []reporter.ErrorWithPos) {
+	t.Helper()
+	var diagnostics []reporter.ErrorWithPos
+	compiler := newTestCompiler(map[string]string{"test.proto": src}, reporter.NewReporter(func(err reporter.ErrorWithPos) error {
+		diagnostics = append(diagnostics, err)
+		return nil
+	}, func(err reporter.ErrorWithPos) {
+		diagnostics = append(diagnostics, err)
+	}))
+	res, _ := compiler.Compile(context.Background(), "test.proto")
+	if r, ok := res.UnlinkedParserResults["test.proto"]; ok {
+		return r, diagnostics
+	}
+	if r, ok := res.PartialLinkResults["test.proto"]; ok {
+		return r, diagnostics
+	}
+	require.Len(t, res.Files, 1)
+	return res.Files[0].(parser.Result), diagnostics
+}
@@ -84,6 +84,7 @@ func (c *Cache) compileLocked(protos ...string) {
 	}
 	slog.Debug("done compiling", "protos", len(protos))
 	c.partialResultsMu.Lock()
+	var updated []linker.Result
 	for _, r := range res.Files {
 		path := r.Path()
 		found := false
@@ -99,6 +100,9 @@ func (c *Cache) compileLocked(protos ...string) {
 			if f.Path() == path {
 				found = true
 				slog.With("path", path).Debug("updating existing linker result")
+				if f != r {
+					updated = append(updated, r.(linker.Result))
+				}
 				c.results[i] = r
 				if p, ok := c.pragmas.Load(protocompile.ResolvedPath(path)); ok {
 					p.update(pragmas)
@@ -109,6 +113,7 @@ func (c *Cache) compileLocked(protos ...string) {
 		if !found {
 			slog.With("path", path).Debug("adding new linker result")
 			c.results = append(c.results, r)
+			updated = append(updated, r.(linker.Result))
 			c.pragmas.Store(protocompile.ResolvedPath(path), &pragmaMap{m: pragmas})
 		}
 		delete(c.partiallyLinkedResults, protocompile.ResolvedPath(path))
@@ -150,6 +155,15 @@ func (c *Cache) compileLocked(protos ...string) {
 	}
 	c.partialResultsMu.Unlock()
 
+	for _, r := range updated {
+		for _, err := range checkCelExpressions(r) {
+			c.diagHandler.HandleError(err)
+		}
+		for _, err := range checkEditionFeatures(r) {
+			c.diagHandler.HandleError(err)
+		}
+	}
+
 	syntheticFiles := c.resolver.CheckIncompleteDescriptors(c.results)
 	if len(syntheticFiles) == 0 {
 		return
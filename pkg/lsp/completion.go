@@ -10,6 +10,9 @@ import (
 	"sort"
 	"strings"
 
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/decls"
+	"github.com/google/cel-go/common/types"
 	"github.com/kralicky/protocompile/ast"
 	"github.com/kralicky/protocompile/ast/paths"
 	"github.com/kralicky/protocompile/linker"
@@ -85,6 +88,14 @@ func (c *Cache) GetCompletions(params *protocol.CompletionParams) (result *proto
 		return nil, err
 	}
 
+	if latestAstValid && maybeCurrentLinkRes != nil {
+		if constraint, expr, offset, ok := findCelExpressionAt(maybeCurrentLinkRes, posOffset); ok {
+			return &protocol.CompletionList{
+				Items: completeCelExpression(constraint, expr, offset, c.FindDescriptorByName),
+			}, nil
+		}
+	}
+
 	var searchTarget parser.Result
 	if !latestAstValid {
 		// The user is in the middle of editing the file and it's not valid yet,
@@ -494,7 +505,19 @@ func (c *Cache) GetCompletions(params *protocol.CompletionParams) (result *proto
 			if endQuoteIdx != -1 {
 				partialVersionSuffix = strings.TrimSpace(textFollowingCursor[:endQuoteIdx])
 			}
-			completions = append(completions, completeSyntaxVersions(partialVersion, partialVersionSuffix, params.Position)...)
+			completions = append(completions, completeSyntaxVersions([]string{"proto2", "proto3"}, partialVersion, partialVersionSuffix, params.Position)...)
+		}
+	case *ast.EditionNode:
+		// complete edition versions
+		quoteIdx := strings.IndexRune(textPrecedingCursor, '"')
+		if quoteIdx != -1 {
+			partialVersion := strings.TrimSpace(textPrecedingCursor[quoteIdx+1:])
+			endQuoteIdx := strings.IndexRune(textFollowingCursor, '"')
+			var partialVersionSuffix string
+			if endQuoteIdx != -1 {
+				partialVersionSuffix = strings.TrimSpace(textFollowingCursor[:endQuoteIdx])
+			}
+			completions = append(completions, completeSyntaxVersions([]string{"2023"}, partialVersion, partialVersionSuffix, params.Position)...)
 		}
 	case *ast.PackageNode:
 		// complete package names
@@ -706,7 +729,14 @@ func (c *Cache) completeFieldLiteralValues(
 		}
 	case protoreflect.EnumKind:
 		enum := fd.Enum()
-		return completeEnumValues(enum, partialName, partialNameSuffix, pos)
+		items := completeEnumValues(enum, partialName, partialNameSuffix, pos)
+		if fd.ContainingMessage().FullName() == featureSetDesc.FullName() {
+			// the zero value of each feature enum is a placeholder and can't be set
+			items = slices.DeleteFunc(items, func(item protocol.CompletionItem) bool {
+				return enum.Values().ByName(protoreflect.Name(item.Label)).Number() == 0
+			})
+		}
+		return items
 	case protoreflect.BoolKind:
 		return completeKeywords([]string{"true", "false"}, partialName, partialNameSuffix, pos)
 	}
@@ -919,6 +949,9 @@ func (c *Cache) deepCompleteOptionNames(
 			if !strings.HasPrefix(string(fld.Name()), partialName) {
 				continue
 			}
+			if !isFeatureCompletionAllowed(prev, fld, linkRes) {
+				continue
+			}
 			if (partialNameSuffix == "" && fld.Name() != protoreflect.Name(partialName)) ||
 				(fld.Name() != protoreflect.Name(partialName+partialNameSuffix)) {
 				if _, ok := existingOpts[string(fld.FullName())]; ok && fld.Cardinality() != protoreflect.Repeated {
@@ -1144,9 +1177,9 @@ func completeImports(cache *Cache, partialPath, partialPathSuffix string, existi
 	return items
 }
 
-func completeSyntaxVersions(partialVersion, partialVersionSuffix string, pos protocol.Position) []protocol.CompletionItem {
+func completeSyntaxVersions(versions []string, partialVersion, partialVersionSuffix string, pos protocol.Position) []protocol.CompletionItem {
 	items := []protocol.CompletionItem{}
-	for _, version := range []string{"proto2", "proto3"} {
+	for _, version := range versions {
 		replaceRange := protocol.Range{
 			Start: pos,
 			End:   adjustColumn(pos, len(partialVersionSuffix)),
@@ -1324,13 +1357,12 @@ func messageKeywordCompletions(fileNode *ast.FileNode, partialName, partialNameS
 
 func fileKeywordCompletions(fileNode *ast.FileNode, partialName, partialNameSuffix string, pos protocol.Position) []protocol.CompletionItem {
 	possibleKeywords := make([]string, 0, 8)
-	// TODO(editions): add edition keyword
 	var completions []protocol.CompletionItem
-	if fileNode.Syntax == nil {
-		if strings.HasPrefix("syntax", partialName) {
+	if fileNode.Syntax == nil && fileNode.Edition == nil {
+		if strings.HasPrefix("syntax", partialName) || strings.HasPrefix("edition", partialName) {
 			completions = append(completions, syntaxSnippets()...)
 		}
-		possibleKeywords = append(possibleKeywords, "syntax")
+		possibleKeywords = append(possibleKeywords, "syntax", "edition")
 	}
 	hasPkgNode := false
 	for _, pkg := range fileNode.Decls {
@@ -1362,7 +1394,12 @@ func syntaxSnippets() []protocol.CompletionItem {
 			InsertTextFormat: &snippetMode,
 			InsertText:       "syntax = \"proto2\";\n",
 		},
-		// TODO(editions): add edition keyword
+		{
+			Label:            "edition: 2023",
+			Kind:             protocol.SnippetCompletion,
+			InsertTextFormat: &snippetMode,
+			InsertText:       "edition = \"2023\";\n",
+		},
 	}
 }
 
@@ -1478,7 +1515,11 @@ func relativeFullName(target, fromPkg protoreflect.FullName) string {
 				break
 			}
 		}
-		if i == l {
+		// the shorter package only matches if it ends at a component boundary
+		// of the longer one
+		if i == l && (len(targetPkg) == len(fromPkg) ||
+			(len(targetPkg) > l && targetPkg[l] == '.') ||
+			(len(fromPkg) > l && fromPkg[l] == '.')) {
 			lastDot = i
 		}
 		if lastDot > 0 {
@@ -1515,6 +1556,154 @@ func relativeFullName(target, fromPkg protoreflect.FullName) string {
 
 var errOutOfRange = errors.New("out of range")
 
+// completeCelExpression completes variables, fields, and functions within
+// a protovalidate CEL expression, given the offset of the cursor in the
+// expression text.
+func completeCelExpression(
+	constraint celConstraint,
+	expr *celExpression,
+	offset int,
+	findDescriptor func(protoreflect.FullName) (protoreflect.Descriptor, error),
+) []protocol.CompletionItem {
+	env, err := constraint.env()
+	if err != nil {
+		return nil
+	}
+	offset = min(offset, len(expr.runes))
+	start := offset
+	for start > 0 && isCelIdentRune(expr.runes[start-1]) {
+		start--
+	}
+	partialName := string(expr.runes[start:offset])
+	rng := positionsToRange(expr.sourcePos(start, false), expr.sourcePos(offset, true))
+
+	if start > 0 && expr.runes[start-1] == '.' {
+		operand := celOperandBefore(expr.runes, start-1)
+		if operand == "" {
+			return nil
+		}
+		checked, issues := env.Compile(operand)
+		if issues.Err() != nil {
+			return nil
+		}
+		return completeCelMembers(env, checked.OutputType(), partialName, rng, findDescriptor)
+	}
+
+	var items []protocol.CompletionItem
+	for _, v := range append(constraint.variables(), celVariable{"now", cel.TimestampType}) {
+		if strings.HasPrefix(v.name, partialName) {
+			items = append(items, protocol.CompletionItem{
+				Label:    v.name,
+				Kind:     protocol.VariableCompletion,
+				Detail:   v.typ.String(),
+				TextEdit: &protocol.Or_CompletionItem_textEdit{Value: protocol.TextEdit{Range: rng, NewText: v.name}},
+			})
+		}
+	}
+	items = append(items, completeCelFunctions(env, func(o *decls.OverloadDecl) bool {
+		return !o.IsMemberFunction()
+	}, false, true, partialName, rng)...)
+	for _, kw := range []string{"true", "false", "null"} {
+		if strings.HasPrefix(kw, partialName) {
+			items = append(items, protocol.CompletionItem{
+				Label:    kw,
+				Kind:     protocol.KeywordCompletion,
+				TextEdit: &protocol.Or_CompletionItem_textEdit{Value: protocol.TextEdit{Range: rng, NewText: kw}},
+			})
+		}
+	}
+	return items
+}
+
+// completeCelMembers completes the fields and member functions available on
+// a value of the given type.
+func completeCelMembers(
+	env *cel.Env,
+	typ *cel.Type,
+	partialName string,
+	rng protocol.Range,
+	findDescriptor func(protoreflect.FullName) (protoreflect.Descriptor, error),
+) []protocol.CompletionItem {
+	var items []protocol.CompletionItem
+	if typ.Kind() == types.StructKind {
+		if desc, err := findDescriptor(protoreflect.FullName(typ.TypeName())); err == nil {
+			if md, ok := desc.(protoreflect.MessageDescriptor); ok {
+				for i := 0; i < md.Fields().Len(); i++ {
+					fld := md.Fields().Get(i)
+					if !strings.HasPrefix(string(fld.Name()), partialName) {
+						continue
+					}
+					item := fieldCompletion(fld, rng, messageLiteralStyle)
+					item.TextEdit = &protocol.Or_CompletionItem_textEdit{Value: protocol.TextEdit{Range: rng, NewText: string(fld.Name())}}
+					item.InsertTextFormat = nil
+					item.InsertTextMode = nil
+					items = append(items, item)
+				}
+			}
+		}
+	}
+	isDyn := typ.Kind() == types.DynKind || typ.Kind() == types.AnyKind
+	items = append(items, completeCelFunctions(env, func(o *decls.OverloadDecl) bool {
+		return o.IsMemberFunction() && len(o.ArgTypes()) > 0 && (isDyn || o.ArgTypes()[0].IsAssignableType(typ))
+	}, true, isDyn || typ.Kind() == types.ListKind || typ.Kind() == types.MapKind, partialName, rng)...)
+	return items
+}
+
+// completeCelFunctions completes the functions that have at least one
+// overload matching the filter, and optionally the member or global macros.
+func completeCelFunctions(env *cel.Env, filter func(*decls.OverloadDecl) bool, member, withMacros bool, partialName string, rng protocol.Range) []protocol.CompletionItem {
+	var items []protocol.CompletionItem
+	newItem := func(name string, kind protocol.CompletionItemKind, signatures []string) protocol.CompletionItem {
+		textFmt := protocol.SnippetTextFormat
+		return protocol.CompletionItem{
+			Label:  name,
+			Kind:   kind,
+			Detail: strings.Join(signatures, "\n"),
+			Documentation: &protocol.Or_CompletionItem_documentation{
+				Value: protocol.MarkupContent{
+					Kind:  protocol.Markdown,
+					Value: celFunctionDocs[name],
+				},
+			},
+			InsertTextFormat: &textFmt,
+			TextEdit:         &protocol.Or_CompletionItem_textEdit{Value: protocol.TextEdit{Range: rng, NewText: name + "(${0})"}},
+		}
+	}
+	functions := env.Functions()
+	names := make([]string, 0, len(functions))
+	for name := range functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	kind := protocol.FunctionCompletion
+	if member {
+		kind = protocol.MethodCompletion
+	}
+	for _, name := range names {
+		if !isCelIdentifier(name) || !strings.HasPrefix(name, partialName) {
+			continue
+		}
+		var signatures []string
+		for _, overload := range functions[name].OverloadDecls() {
+			if filter(overload) {
+				signatures = append(signatures, formatCelOverload(name, overload))
+			}
+		}
+		if len(signatures) == 0 {
+			continue
+		}
+		items = append(items, newItem(name, kind, signatures))
+	}
+	if withMacros {
+		for _, macro := range celMacros {
+			if macro.member == member && strings.HasPrefix(macro.name, partialName) {
+				items = append(items, newItem(macro.name, kind, []string{macro.signature}))
+			}
+		}
+	}
+	return items
+}
+
 func findPartialNames(fileNode *ast.FileNode, node ast.Node, mapper *protocol.Mapper, posOffset int) (string, string, error) {
 	pos := fileNode.NodeInfo(node)
 	if !pos.IsValid() {
@@ -19,6 +19,8 @@ func Test_relativeFullName(t *testing.T) {
 		{"foo.bar.baz.A", "foo", "bar.baz.A"},
 		{"foo.bar.baz.A", "foo.bar.baz", "A"},
 		{"foo.bar.baz.A", "x.y", "foo.bar.baz.A"},
+		{"baz.qux.A", "b", "baz.qux.A"},
+		{"b.A", "baz.qux", "b.A"},
 	}
 	for i, tt := range tests {
 		t.Run(fmt.Sprint(i), func(t *testing.T) {
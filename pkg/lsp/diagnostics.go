@@ -6,7 +6,9 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"regexp"
 	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -14,6 +16,7 @@ import (
 	"github.com/kralicky/protocompile"
 	"github.com/kralicky/protocompile/ast"
 	"github.com/kralicky/protocompile/linker"
+	"github.com/kralicky/protocompile/options"
 	"github.com/kralicky/protocompile/parser"
 	"github.com/kralicky/protocompile/reporter"
 	"github.com/kralicky/tools-lite/gopls/pkg/protocol"
@@ -149,9 +152,32 @@ type RelatedInformation struct {
 }
 
 const (
-	diagnosticKind               = "kind"
-	diagnosticKindUndeclaredName = "undeclaredName"
-	diagnosticKindUnusedImport   = "unusedImport"
+	diagnosticKind                 = "kind"
+	diagnosticKindUndeclaredName   = "undeclaredName"
+	diagnosticKindUnusedImport     = "unusedImport"
+	diagnosticKindInvalidNumber    = "invalidNumber"
+	diagnosticKindRedeclared       = "redeclared"
+	diagnosticKindMissingZeroValue = "missingZeroValue"
+	diagnosticKindJSONNameConflict = "jsonNameConflict"
+	diagnosticKindOptionValueType  = "optionValueType"
+	diagnosticKindMissingSyntax    = "missingSyntax"
+)
+
+// Patterns matching the messages of errors that are not reported with a
+// dedicated error type. Errors about numbers are only matched when they are
+// reported at a number literal, which is where they are always reported.
+var (
+	invalidNumberErrorPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`^message \S+: fields \w+ and \w+ both have the same tag \d+$`),
+		regexp.MustCompile(`^message \S+: field \w+ is using tag \d+ which is in reserved range \d+ to \d+$`),
+		regexp.MustCompile(`^enum \S+: value \w+ is using number -?\d+ which is in reserved range -?\d+ to -?\d+$`),
+		regexp.MustCompile(`^tag number \d+ must be greater than zero$`),
+		regexp.MustCompile(`^tag number \d+ is higher than max allowed tag number \(\d+\)$`),
+		regexp.MustCompile(`^tag number \d+ is in disallowed reserved range \d+-\d+$`),
+	}
+	missingZeroValueErrorPattern = regexp.MustCompile(`^first value of open enum \S+ must be zero$`)
+	jsonNameConflictErrorPattern = regexp.MustCompile(`^field \S+: (?:custom|default) JSON name ".*" conflicts with (?:custom|default) JSON name of field (\w+), defined at `)
+	optionValueTypeErrorPattern  = regexp.MustCompile(`^expecting ([a-z0-9 ]+), got `)
 )
 
 type DiagnosticData struct {
@@ -382,10 +408,62 @@ func metadataForError(errWithPos reporter.ErrorWithPos) map[string]string {
 			"name":         err.UndeclaredName(),
 			"hint":         err.Hint(),
 		}
+	case options.OptionValueError:
+		if m := optionValueTypeErrorPattern.FindStringSubmatch(err.Error()); m != nil {
+			return map[string]string{
+				diagnosticKind: diagnosticKindOptionValueType,
+				"expected":     m[1],
+			}
+		}
+		return nil
+	}
+	var redeclared reporter.SymbolRedeclaredError
+	switch {
+	case errors.As(err, &redeclared):
+		name, ok := strings.CutSuffix(redeclared.Error(), " redeclared in this block (see details)")
+		if !ok {
+			return nil
+		}
+		return map[string]string{
+			diagnosticKind: diagnosticKindRedeclared,
+			"name":         name,
+		}
+	case errors.Is(err, parser.ErrNoSyntax):
+		return map[string]string{diagnosticKind: diagnosticKindMissingSyntax}
+	case reportedAtNumber(errWithPos) && matchesAny(invalidNumberErrorPatterns, err.Error()):
+		return map[string]string{diagnosticKind: diagnosticKindInvalidNumber}
+	case reportedAtNumber(errWithPos) && missingZeroValueErrorPattern.MatchString(err.Error()):
+		return map[string]string{diagnosticKind: diagnosticKindMissingZeroValue}
+	}
+	if m := jsonNameConflictErrorPattern.FindStringSubmatch(err.Error()); m != nil {
+		return map[string]string{
+			diagnosticKind: diagnosticKindJSONNameConflict,
+			"field":        m[1],
+		}
 	}
 	return nil
 }
 
+// reportedAtNumber reports whether the error is positioned at an integer
+// literal, such as the number of a field or enum value.
+func reportedAtNumber(err reporter.ErrorWithPos) bool {
+	info, ok := err.GetPosition().(ast.NodeInfo)
+	if !ok || !info.IsValid() {
+		return false
+	}
+	_, parseErr := strconv.ParseInt(info.RawText(), 0, 64)
+	return parseErr == nil
+}
+
+func matchesAny(patterns []*regexp.Regexp, s string) bool {
+	for _, p := range patterns {
+		if p.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
 func werrorCategoryForError(err error) string {
 	var xse parser.ExtendedSyntaxError
 	if errors.As(err, &xse) {
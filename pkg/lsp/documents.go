@@ -75,6 +75,7 @@ func (c *Cache) DidModifyFiles(ctx context.Context, modifications []file.Modific
 		}
 		switch m.Action {
 		case file.Close:
+			c.semanticTokens.Delete(m.URI)
 		case file.Open, file.Save:
 			fh, err := c.compiler.fs.ReadFile(ctx, m.URI)
 			if err == nil || fh.Version() != m.Version {
@@ -92,6 +93,9 @@ func (c *Cache) DidModifyFiles(ctx context.Context, modifications []file.Modific
 			func() {
 				c.documentVersions.Update(modifications...)
 			},
+			func() {
+				c.warnDeletedImportsLocked(modifications)
+			},
 			c.diagHandler.Flush,
 		)
 	}
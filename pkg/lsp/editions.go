@@ -0,0 +1,304 @@
+package lsp
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/kralicky/protocompile/ast"
+	"github.com/kralicky/protocompile/editions"
+	"github.com/kralicky/protocompile/linker"
+	"github.com/kralicky/protocompile/protoutil"
+	"github.com/kralicky/protocompile/reporter"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// checkEditionFeatures reports combinations of features in an editions file
+// that are accepted by the compiler but rejected by protoc.
+func checkEditionFeatures(res linker.Result) []reporter.ErrorWithPos {
+	file := res.AST()
+	if file == nil || res.Syntax() != protoreflect.Editions {
+		return nil
+	}
+	var errs []reporter.ErrorWithPos
+	visitField := func(fd protoreflect.FieldDescriptor) {
+		errs = append(errs, checkFieldFeatures(res, fd)...)
+	}
+	var visitMessage func(md protoreflect.MessageDescriptor)
+	visitMessage = func(md protoreflect.MessageDescriptor) {
+		if md.IsMapEntry() {
+			return
+		}
+		for i := 0; i < md.Fields().Len(); i++ {
+			visitField(md.Fields().Get(i))
+		}
+		for i := 0; i < md.Extensions().Len(); i++ {
+			visitField(md.Extensions().Get(i))
+		}
+		for i := 0; i < md.Messages().Len(); i++ {
+			visitMessage(md.Messages().Get(i))
+		}
+	}
+	for i := 0; i < res.Messages().Len(); i++ {
+		visitMessage(res.Messages().Get(i))
+	}
+	for i := 0; i < res.Extensions().Len(); i++ {
+		visitField(res.Extensions().Get(i))
+	}
+	return errs
+}
+
+func checkFieldFeatures(res linker.Result, fd protoreflect.FieldDescriptor) []reporter.ErrorWithPos {
+	fdp, ok := fd.(protoutil.DescriptorProtoWrapper).AsProto().(*descriptorpb.FieldDescriptorProto)
+	if !ok {
+		return nil
+	}
+	node := res.FieldNode(fdp)
+	if node == nil || node.Unwrap() == nil {
+		return nil
+	}
+	file := res.AST()
+	var opts []*ast.OptionNode
+	if node.GetOptions() != nil {
+		opts = node.GetOptions().GetOptions()
+	}
+	var errs []reporter.ErrorWithPos
+	report := func(feature protoreflect.Name, msg string) {
+		var target ast.Node = node.GetName()
+		if n := findFeatureOptionNode(res, opts, feature); n != nil {
+			target = n
+		}
+		errs = append(errs, reporter.Error(file.NodeInfo(target), errors.New(msg)))
+	}
+
+	features := fdp.GetOptions().GetFeatures()
+	if features == nil {
+		features = &descriptorpb.FeatureSet{}
+	}
+	isMessage := fd.Kind() == protoreflect.MessageKind || fd.Kind() == protoreflect.GroupKind
+
+	if features.FieldPresence != nil {
+		switch {
+		case fd.Cardinality() == protoreflect.Repeated:
+			report("field_presence", "repeated fields cannot specify field presence")
+		case fd.IsExtension():
+			report("field_presence", "extensions cannot specify field presence")
+		case fd.ContainingOneof() != nil && !fd.ContainingOneof().IsSynthetic():
+			report("field_presence", "oneof fields cannot specify field presence")
+		case isMessage && features.GetFieldPresence() == descriptorpb.FeatureSet_IMPLICIT:
+			report("field_presence", "message fields cannot specify implicit field presence")
+		}
+	}
+	if fdp.DefaultValue != nil && fd.Cardinality() != protoreflect.Repeated && !fd.IsExtension() && !isMessage &&
+		(fd.ContainingOneof() == nil || fd.ContainingOneof().IsSynthetic()) {
+		presence, err := protoutil.ResolveFeature(fd, fieldPresenceFeature)
+		if err == nil && descriptorpb.FeatureSet_FieldPresence(presence.Enum()) == descriptorpb.FeatureSet_IMPLICIT {
+			var target ast.Node = node.GetName()
+			if n := findOptionNode(res, opts, "default"); n != nil {
+				target = n
+			}
+			errs = append(errs, reporter.Error(file.NodeInfo(target), errors.New("fields with implicit presence cannot have a default value")))
+		}
+	}
+	if features.RepeatedFieldEncoding != nil {
+		if fd.Cardinality() != protoreflect.Repeated || fd.IsMap() || !isPackableKind(fd.Kind()) {
+			report("repeated_field_encoding", "only repeated primitive fields can specify repeated field encoding")
+		}
+	}
+	if features.Utf8Validation != nil {
+		if !isStringOrStringMap(fd) {
+			report("utf8_validation", "only string fields can specify utf8 validation")
+		}
+	}
+	if features.MessageEncoding != nil {
+		switch {
+		case fd.IsMap():
+			report("message_encoding", "map fields cannot specify message encoding")
+		case !isMessage:
+			report("message_encoding", "only message fields can specify message encoding")
+		}
+	}
+	return errs
+}
+
+var (
+	featureSetDesc       = (*descriptorpb.FeatureSet)(nil).ProtoReflect().Descriptor()
+	fieldPresenceFeature = featureSetDesc.Fields().ByName("field_presence")
+)
+
+func isPackableKind(kind protoreflect.Kind) bool {
+	switch kind {
+	case protoreflect.StringKind, protoreflect.BytesKind, protoreflect.MessageKind, protoreflect.GroupKind:
+		return false
+	}
+	return true
+}
+
+func isStringOrStringMap(fd protoreflect.FieldDescriptor) bool {
+	if fd.IsMap() {
+		return fd.MapKey().Kind() == protoreflect.StringKind || fd.MapValue().Kind() == protoreflect.StringKind
+	}
+	return fd.Kind() == protoreflect.StringKind
+}
+
+// findFeatureOptionNode returns the node that sets the named feature in the
+// given options, either as 'features.name = ...' or as a field of a message
+// literal assigned to 'features'.
+func findFeatureOptionNode(res linker.Result, opts []*ast.OptionNode, feature protoreflect.Name) ast.Node {
+	for _, opt := range opts {
+		uo := res.OptionDescriptor(opt)
+		if uo == nil || len(uo.GetName()) == 0 {
+			continue
+		}
+		names := uo.GetName()
+		if names[0].GetIsExtension() || names[0].GetNamePart() != "features" {
+			continue
+		}
+		if len(names) > 1 {
+			if !names[1].GetIsExtension() && names[1].GetNamePart() == string(feature) {
+				return opt
+			}
+			continue
+		}
+		if lit, ok := opt.Val.Unwrap().(*ast.MessageLiteralNode); ok {
+			for _, elem := range lit.Elements {
+				if fd := res.FindFieldDescriptorByMessageFieldNode(elem); fd != nil && fd.Name() == feature {
+					return elem
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func findOptionNode(res linker.Result, opts []*ast.OptionNode, name string) ast.Node {
+	for _, opt := range opts {
+		uo := res.OptionDescriptor(opt)
+		if uo == nil || len(uo.GetName()) != 1 {
+			continue
+		}
+		if part := uo.GetName()[0]; !part.GetIsExtension() && part.GetNamePart() == name {
+			return opt
+		}
+	}
+	return nil
+}
+
+// effectiveFeaturesMarkdown describes the resolved value of each feature that
+// applies to the given element, along with where the value comes from. It
+// returns an empty string for elements in proto2 and proto3 files.
+func effectiveFeaturesMarkdown(desc protoreflect.Descriptor) string {
+	if desc.ParentFile() == nil || desc.ParentFile().Syntax() != protoreflect.Editions {
+		return ""
+	}
+	target, ok := featureTargetType(desc)
+	if !ok {
+		return ""
+	}
+	var sb strings.Builder
+	edition := strings.TrimPrefix(editions.GetEdition(desc).String(), "EDITION_")
+	fmt.Fprintf(&sb, "Effective features (edition %s):\n", edition)
+	fields := featureSetDesc.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		feature := fields.Get(i)
+		if !slices.Contains(feature.Options().(*descriptorpb.FieldOptions).GetTargets(), target) {
+			continue
+		}
+		val, err := protoutil.ResolveFeature(desc, feature)
+		if err != nil {
+			continue
+		}
+		var valueName string
+		if ev := feature.Enum().Values().ByNumber(val.Enum()); ev != nil {
+			valueName = string(ev.Name())
+		} else {
+			valueName = fmt.Sprint(val.Enum())
+		}
+		fmt.Fprintf(&sb, "- `%s = %s`", feature.Name(), valueName)
+		switch origin := featureOrigin(desc, feature); {
+		case origin == nil:
+			sb.WriteString(" (edition default)")
+		case origin == desc:
+			sb.WriteString(" (set here)")
+		case origin == desc.ParentFile():
+			fmt.Fprintf(&sb, " (inherited from file `%s`)", origin.ParentFile().Path())
+		default:
+			fmt.Fprintf(&sb, " (inherited from `%s`)", origin.FullName())
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// featureOrigin returns the nearest ancestor of the element (including the
+// element itself) which explicitly sets the given feature, or nil if the
+// feature has its edition default value.
+func featureOrigin(desc protoreflect.Descriptor, feature protoreflect.FieldDescriptor) protoreflect.Descriptor {
+	for d := desc; d != nil; d = d.Parent() {
+		withFeatures, ok := d.Options().(editions.HasFeatures)
+		if !ok {
+			continue
+		}
+		if features := withFeatures.GetFeatures(); features != nil && features.ProtoReflect().Has(feature) {
+			return d
+		}
+	}
+	return nil
+}
+
+func featureTargetType(desc protoreflect.Descriptor) (descriptorpb.FieldOptions_OptionTargetType, bool) {
+	switch desc.(type) {
+	case protoreflect.FileDescriptor:
+		return descriptorpb.FieldOptions_TARGET_TYPE_FILE, true
+	case protoreflect.MessageDescriptor:
+		return descriptorpb.FieldOptions_TARGET_TYPE_MESSAGE, true
+	case protoreflect.FieldDescriptor:
+		return descriptorpb.FieldOptions_TARGET_TYPE_FIELD, true
+	case protoreflect.OneofDescriptor:
+		return descriptorpb.FieldOptions_TARGET_TYPE_ONEOF, true
+	case protoreflect.EnumDescriptor:
+		return descriptorpb.FieldOptions_TARGET_TYPE_ENUM, true
+	case protoreflect.EnumValueDescriptor:
+		return descriptorpb.FieldOptions_TARGET_TYPE_ENUM_ENTRY, true
+	case protoreflect.ServiceDescriptor:
+		return descriptorpb.FieldOptions_TARGET_TYPE_SERVICE, true
+	case protoreflect.MethodDescriptor:
+		return descriptorpb.FieldOptions_TARGET_TYPE_METHOD, true
+	}
+	return 0, false
+}
+
+var optionsTargetTypes = map[protoreflect.FullName]descriptorpb.FieldOptions_OptionTargetType{
+	"google.protobuf.FileOptions":           descriptorpb.FieldOptions_TARGET_TYPE_FILE,
+	"google.protobuf.ExtensionRangeOptions": descriptorpb.FieldOptions_TARGET_TYPE_EXTENSION_RANGE,
+	"google.protobuf.MessageOptions":        descriptorpb.FieldOptions_TARGET_TYPE_MESSAGE,
+	"google.protobuf.FieldOptions":          descriptorpb.FieldOptions_TARGET_TYPE_FIELD,
+	"google.protobuf.OneofOptions":          descriptorpb.FieldOptions_TARGET_TYPE_ONEOF,
+	"google.protobuf.EnumOptions":           descriptorpb.FieldOptions_TARGET_TYPE_ENUM,
+	"google.protobuf.EnumValueOptions":      descriptorpb.FieldOptions_TARGET_TYPE_ENUM_ENTRY,
+	"google.protobuf.ServiceOptions":        descriptorpb.FieldOptions_TARGET_TYPE_SERVICE,
+	"google.protobuf.MethodOptions":         descriptorpb.FieldOptions_TARGET_TYPE_METHOD,
+}
+
+// isFeatureCompletionAllowed reports whether the option field should be
+// offered as a completion after the previous part of an option name. The
+// 'features' option is only offered in editions files, and individual
+// features are only offered for the element types they can be set on.
+func isFeatureCompletionAllowed(prev protoreflect.Descriptor, fld protoreflect.FieldDescriptor, linkRes linker.Result) bool {
+	if _, ok := optionsTargetTypes[fld.ContainingMessage().FullName()]; ok &&
+		fld.Message() != nil && fld.Message().FullName() == featureSetDesc.FullName() {
+		return linkRes.Syntax() == protoreflect.Editions
+	}
+	prevFd, ok := prev.(protoreflect.FieldDescriptor)
+	if !ok || fld.ContainingMessage().FullName() != featureSetDesc.FullName() {
+		return true
+	}
+	target, ok := optionsTargetTypes[prevFd.ContainingMessage().FullName()]
+	if !ok {
+		return true
+	}
+	targets := fld.Options().(*descriptorpb.FieldOptions).GetTargets()
+	return len(targets) == 0 || slices.Contains(targets, target)
+}
@@ -0,0 +1,63 @@
+package lsp
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckEditionFeatures(t *testing.T) {
+	const src = `
+edition = "2023";
+
+option features.field_presence = IMPLICIT;
+
+message Foo {
+  string name = 1 [features.field_presence = EXPLICIT];
+  int32 bad = 2 [default = 5];
+  int32 good = 3 [default = 5, features.field_presence = EXPLICIT];
+  Foo child = 4 [features.field_presence = IMPLICIT];
+  repeated int32 nums = 5 [features.field_presence = EXPLICIT];
+  repeated int32 packed = 6 [features.repeated_field_encoding = EXPANDED];
+  repeated string strs = 7 [features = { repeated_field_encoding: PACKED }];
+  int32 delim = 8 [features.message_encoding = DELIMITED];
+  Foo delimited = 9 [features.message_encoding = DELIMITED];
+  map<string, Foo> m = 10 [features.message_encoding = DELIMITED];
+  int32 utf = 11 [features.utf8_validation = NONE];
+  map<string, int32> utf_map = 12 [features.utf8_validation = NONE];
+  oneof kind {
+    int32 a = 13 [features.field_presence = EXPLICIT];
+  }
+  extensions 100 to 200;
+}
+
+extend Foo {
+  int32 ext = 100 [features.field_presence = LEGACY_REQUIRED];
+}
+`
+	res := compileFile(t, src)
+	var actual []string
+	for _, err := range checkEditionFeatures(res) {
+		span := err.GetPosition()
+		lines := strings.Split(src, "\n")
+		start, end := span.Start(), span.End()
+		var text string
+		if start.Line == end.Line {
+			text = lines[start.Line-1][start.Col-1 : end.Col-1]
+		}
+		actual = append(actual, fmt.Sprintf("%d:%d %q: %s", start.Line, start.Col, text, err.Unwrap()))
+	}
+	require.Equal(t, []string{
+		`8:18 "default = 5": fields with implicit presence cannot have a default value`,
+		`10:18 "features.field_presence = IMPLICIT": message fields cannot specify implicit field presence`,
+		`11:28 "features.field_presence = EXPLICIT": repeated fields cannot specify field presence`,
+		`13:42 "repeated_field_encoding: PACKED": only repeated primitive fields can specify repeated field encoding`,
+		`14:20 "features.message_encoding = DELIMITED": only message fields can specify message encoding`,
+		`16:28 "features.message_encoding = DELIMITED": map fields cannot specify message encoding`,
+		`17:19 "features.utf8_validation = NONE": only string fields can specify utf8 validation`,
+		`20:19 "features.field_presence = EXPLICIT": oneof fields cannot specify field presence`,
+		`26:20 "features.field_presence = LEGACY_REQUIRED": extensions cannot specify field presence`,
+	}, actual)
+}
@@ -0,0 +1,744 @@
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/kralicky/protocompile/ast"
+	"github.com/kralicky/protocompile/linker"
+	"github.com/kralicky/protocompile/protoutil"
+	"github.com/kralicky/tools-lite/gopls/pkg/protocol"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// fieldType is the type of a field: a scalar kind, or a message or enum.
+type fieldType struct {
+	kind protoreflect.Kind
+	// Set for message and enum kinds only.
+	desc protoreflect.Descriptor
+}
+
+func (t fieldType) String() string {
+	if t.desc != nil {
+		return string(t.desc.FullName())
+	}
+	return t.kind.String()
+}
+
+// wrapped returns the kind of the value held by a well-known wrapper message
+// type, such as google.protobuf.Int32Value.
+func (t fieldType) wrapped() (protoreflect.Kind, bool) {
+	md, ok := t.desc.(protoreflect.MessageDescriptor)
+	if !ok || md.ParentFile().Path() != "google/protobuf/wrappers.proto" {
+		return 0, false
+	}
+	fd := md.Fields().ByName("value")
+	if fd == nil {
+		return 0, false
+	}
+	return fd.Kind(), true
+}
+
+var scalarKinds = map[string]protoreflect.Kind{}
+
+func init() {
+	for k := protoreflect.DoubleKind; k <= protoreflect.Sint64Kind; k++ {
+		switch k {
+		case protoreflect.GroupKind, protoreflect.MessageKind, protoreflect.EnumKind:
+			continue
+		}
+		scalarKinds[k.String()] = k
+	}
+}
+
+// fieldTypeAlternatives lists the types offered as replacements for fields
+// of each scalar kind: related scalar types, and the corresponding wrapper.
+var fieldTypeAlternatives = map[protoreflect.Kind][]string{
+	protoreflect.Int32Kind:    {"int64", "uint32", "sint32", "google.protobuf.Int32Value"},
+	protoreflect.Int64Kind:    {"int32", "uint64", "sint64", "google.protobuf.Int64Value"},
+	protoreflect.Uint32Kind:   {"uint64", "int32", "google.protobuf.UInt32Value"},
+	protoreflect.Uint64Kind:   {"uint32", "int64", "google.protobuf.UInt64Value"},
+	protoreflect.Sint32Kind:   {"sint64", "int32"},
+	protoreflect.Sint64Kind:   {"sint32", "int64"},
+	protoreflect.Fixed32Kind:  {"sfixed32", "fixed64", "uint32"},
+	protoreflect.Fixed64Kind:  {"sfixed64", "fixed32", "uint64"},
+	protoreflect.Sfixed32Kind: {"fixed32", "sfixed64", "int32"},
+	protoreflect.Sfixed64Kind: {"fixed64", "sfixed32", "int64"},
+	protoreflect.FloatKind:    {"double", "google.protobuf.FloatValue"},
+	protoreflect.DoubleKind:   {"float", "google.protobuf.DoubleValue"},
+	protoreflect.BoolKind:     {"google.protobuf.BoolValue"},
+	protoreflect.StringKind:   {"bytes", "google.protobuf.StringValue"},
+	protoreflect.BytesKind:    {"string", "google.protobuf.BytesValue"},
+}
+
+// fieldTypeChange computes the edits needed to change the type of a field:
+// the field's declaration is updated along with its default value and the
+// options that only apply to some types, option values assigned to the field
+// are converted to the new type, and the import providing the new type is
+// added (while the one providing the old type is removed if it is no longer
+// used).
+type fieldTypeChange struct {
+	*fileMove
+
+	field    protoreflect.FieldDescriptor
+	from, to fieldType
+
+	// Whether the new type is not wire-compatible with the old one.
+	wireIncompatible bool
+	// The number of option values that could not be converted to the new
+	// type, and need to be updated by hand.
+	unconverted int
+}
+
+// changeFieldType returns the change of the type of the field with the given
+// name to newType, which is either the name of a scalar type or the fully
+// qualified name of a message or enum.
+func changeFieldType(files linker.Files, contents func(path string) ([]byte, error), name protoreflect.FullName, newType string) (*fieldTypeChange, map[string][]sourceEdit, error) {
+	ftc := &fieldTypeChange{
+		fileMove: &fileMove{
+			byPath:    map[string]linker.Result{},
+			contents:  contents,
+			rewriters: map[string]*sourceRewriter{},
+		},
+	}
+	for _, f := range files {
+		if res, ok := f.(linker.Result); ok && !f.IsPlaceholder() {
+			ftc.byPath[f.Path()] = res
+		}
+	}
+	resolver := files.AsResolver()
+	desc, err := resolver.FindDescriptorByName(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	fd, ok := desc.(protoreflect.FieldDescriptor)
+	if !ok {
+		return nil, nil, fmt.Errorf("%s is not a field", name)
+	}
+	if fd.IsMap() || fd.Kind() == protoreflect.GroupKind {
+		return nil, nil, fmt.Errorf("cannot change the type of %s: map and group fields are not supported", name)
+	}
+	ftc.field = fd
+	ftc.from = typeOfField(fd)
+	if kind, ok := scalarKinds[newType]; ok {
+		ftc.to = fieldType{kind: kind}
+	} else {
+		typeName := protoreflect.FullName(strings.TrimPrefix(newType, "."))
+		d, err := resolver.FindDescriptorByName(typeName)
+		if err != nil {
+			// well-known types can be imported even if no file uses them yet
+			if d, err = protoregistry.GlobalFiles.FindDescriptorByName(typeName); err != nil {
+				return nil, nil, fmt.Errorf("unknown type %s", newType)
+			}
+		}
+		switch d := d.(type) {
+		case protoreflect.MessageDescriptor:
+			if d.IsMapEntry() {
+				return nil, nil, fmt.Errorf("cannot use map entry %s as a field type", d.FullName())
+			}
+			ftc.to = fieldType{kind: protoreflect.MessageKind, desc: d}
+		case protoreflect.EnumDescriptor:
+			ftc.to = fieldType{kind: protoreflect.EnumKind, desc: d}
+		default:
+			return nil, nil, fmt.Errorf("%s is not a message or enum", newType)
+		}
+	}
+	if ftc.from.String() == ftc.to.String() {
+		return nil, nil, fmt.Errorf("%s is already of type %s", name, newType)
+	}
+	ftc.wireIncompatible = !wireCompatible(ftc.from, ftc.to)
+
+	source := ftc.byPath[fd.ParentFile().Path()]
+	if source == nil {
+		return nil, nil, fmt.Errorf("cannot change the type of %s: source file %s is not available", name, fd.ParentFile().Path())
+	}
+	if err := ftc.updateDecl(source); err != nil {
+		return nil, nil, err
+	}
+	for _, res := range ftc.byPath {
+		if err := ftc.updateOptionValues(res); err != nil {
+			return nil, nil, err
+		}
+	}
+	edits := map[string][]sourceEdit{}
+	for path, w := range ftc.rewriters {
+		if len(w.edits) > 0 {
+			edits[path] = w.edits
+		}
+	}
+	return ftc, edits, nil
+}
+
+func typeOfField(fd protoreflect.FieldDescriptor) fieldType {
+	switch fd.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return fieldType{kind: fd.Kind(), desc: fd.Message()}
+	case protoreflect.EnumKind:
+		return fieldType{kind: fd.Kind(), desc: fd.Enum()}
+	}
+	return fieldType{kind: fd.Kind()}
+}
+
+// wireCompatible reports whether values of one type can be read as values of
+// the other without loss of data, other than the truncation of numbers that
+// are out of range.
+func wireCompatible(from, to fieldType) bool {
+	group := func(t fieldType) int {
+		switch t.kind {
+		case protoreflect.Int32Kind, protoreflect.Uint32Kind, protoreflect.Int64Kind, protoreflect.Uint64Kind,
+			protoreflect.BoolKind, protoreflect.EnumKind:
+			return 1
+		case protoreflect.Sint32Kind, protoreflect.Sint64Kind:
+			return 2
+		case protoreflect.Fixed32Kind, protoreflect.Sfixed32Kind:
+			return 3
+		case protoreflect.Fixed64Kind, protoreflect.Sfixed64Kind:
+			return 4
+		}
+		return 0
+	}
+	if g := group(from); g != 0 {
+		return g == group(to)
+	}
+	switch {
+	case from.kind == to.kind:
+		// the same message type is handled by the caller
+		return from.kind != protoreflect.MessageKind && from.kind != protoreflect.GroupKind
+	case from.kind == protoreflect.BytesKind:
+		// embedded messages are compatible with bytes holding their encoding
+		return to.kind == protoreflect.StringKind || to.kind == protoreflect.MessageKind
+	case to.kind == protoreflect.BytesKind:
+		return from.kind == protoreflect.StringKind || from.kind == protoreflect.MessageKind
+	}
+	return false
+}
+
+// updateDecl updates the type of the field's declaration, its compact
+// options, and the imports of the file declaring it.
+func (ftc *fieldTypeChange) updateDecl(source linker.Result) error {
+	w, err := ftc.rewriter(source)
+	if err != nil {
+		return err
+	}
+	fdp := ftc.field.(protoutil.DescriptorProtoWrapper).AsProto().(*descriptorpb.FieldDescriptorProto)
+	decl := source.FieldNode(fdp)
+	if decl == nil {
+		return fmt.Errorf("could not find the declaration of %s", ftc.field.FullName())
+	}
+	node, ok := decl.Unwrap().(*ast.FieldNode)
+	if !ok || node.FieldType == nil || node.Tag == nil {
+		return fmt.Errorf("could not find the declaration of %s", ftc.field.FullName())
+	}
+	typeStart, typeEnd := w.span(node.FieldType)
+	w.edit(typeStart, typeEnd, ftc.typeName(source))
+
+	if node.Options != nil {
+		var opts []string
+		changed := false
+		for _, opt := range node.Options.Options {
+			start, end := w.span(opt)
+			text := w.text(start, end)
+			nameStart, nameEnd := w.span(opt.Name)
+			switch w.text(nameStart, nameEnd) {
+			case "default":
+				if ftc.to.kind == protoreflect.MessageKind {
+					changed = true
+					continue
+				}
+				if converted, ok := ftc.convertValue(w, opt.Val, ftc.from, ftc.to); ok {
+					valStart, _ := w.span(opt.Val)
+					text = w.text(start, valStart) + converted
+				} else {
+					ftc.unconverted++
+				}
+			case "packed":
+				if !ftc.field.IsList() || !isPackable(ftc.to.kind) {
+					changed = true
+					continue
+				}
+			case "jstype":
+				switch ftc.to.kind {
+				case protoreflect.Int64Kind, protoreflect.Uint64Kind, protoreflect.Sint64Kind,
+					protoreflect.Fixed64Kind, protoreflect.Sfixed64Kind:
+				default:
+					changed = true
+					continue
+				}
+			}
+			changed = changed || text != w.text(start, end)
+			opts = append(opts, text)
+		}
+		if changed {
+			_, tagEnd := w.span(node.Tag)
+			openStart, _ := w.span(node.Options.OpenBracket)
+			_, closeEnd := w.span(node.Options.CloseBracket)
+			if len(opts) == 0 {
+				w.edit(tagEnd, closeEnd, "")
+			} else {
+				w.edit(openStart, closeEnd, "["+strings.Join(opts, ", ")+"]")
+			}
+		}
+	}
+
+	var add, remove []string
+	if ftc.to.desc != nil {
+		path := ftc.to.desc.ParentFile().Path()
+		if importProvider(source, path) == "" {
+			add = append(add, path)
+		}
+	}
+	if ftc.from.desc != nil {
+		path := ftc.from.desc.ParentFile().Path()
+		if path != source.Path() && (ftc.to.desc == nil || ftc.to.desc.ParentFile().Path() != path) && referencesToFile(source, path) == 1 {
+			remove = append(remove, path)
+		}
+	}
+	updateFileImports(w, add, remove)
+	return nil
+}
+
+// typeName returns the name of the new type as it should be written in the
+// given file.
+func (ftc *fieldTypeChange) typeName(res linker.Result) string {
+	if ftc.to.desc == nil {
+		return ftc.to.kind.String()
+	}
+	return relativeFullName(ftc.to.desc.FullName(), res.Package())
+}
+
+func isPackable(kind protoreflect.Kind) bool {
+	switch kind {
+	case protoreflect.StringKind, protoreflect.BytesKind, protoreflect.MessageKind, protoreflect.GroupKind:
+		return false
+	}
+	return true
+}
+
+// referencesToFile returns the number of references in the file to the types
+// and extensions declared in the file at the given path.
+func referencesToFile(res linker.Result, path string) int {
+	imports := res.Imports()
+	for i := 0; i < imports.Len(); i++ {
+		imp := imports.Get(i)
+		if imp.Path() != path {
+			continue
+		}
+		if imp.IsPublic {
+			return -1
+		}
+		count := 0
+		rangeTypeDescriptors(imp.FileDescriptor, func(d protoreflect.Descriptor) bool {
+			count += len(res.FindReferences(d))
+			return true
+		})
+		return count
+	}
+	return -1
+}
+
+// updateOptionValues converts the values assigned to the field in the
+// options and message literals of the file.
+func (ftc *fieldTypeChange) updateOptionValues(res linker.Result) error {
+	isField := func(fd protoreflect.FieldDescriptor) bool {
+		return fd != nil && fd.FullName() == ftc.field.FullName()
+	}
+	type assignment struct {
+		name ast.Node
+		sep  *ast.RuneNode
+		val  *ast.ValueNode
+	}
+	var assignments []assignment
+	ast.Inspect(res.AST(), func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.OptionNode:
+			if node.Name == nil || len(node.Name.Parts) == 0 || node.Val == nil {
+				return true
+			}
+			last := node.Name.Parts[len(node.Name.Parts)-1].GetFieldRef()
+			if len(node.Name.Parts) == 1 && last != nil && !last.IsExtension() && last.Value() == "default" {
+				// the field's default value is handled by updateDecl
+				return true
+			}
+			if last != nil && isField(res.FindFieldDescriptorByFieldReferenceNode(last)) {
+				assignments = append(assignments, assignment{name: last, sep: node.Equals, val: node.Val})
+			}
+		case *ast.MessageFieldNode:
+			if !node.IsIncomplete() && isField(res.FindFieldDescriptorByMessageFieldNode(node)) {
+				assignments = append(assignments, assignment{name: node.Name, sep: node.Sep, val: node.Val})
+			}
+		}
+		return true
+	})
+	if len(assignments) == 0 {
+		return nil
+	}
+	w, err := ftc.rewriter(res)
+	if err != nil {
+		return err
+	}
+	for _, a := range assignments {
+		vals := []*ast.ValueNode{a.val}
+		if arr := a.val.GetArrayLiteral(); arr != nil {
+			vals = arr.FilterValues()
+		}
+		for _, val := range vals {
+			converted, ok := ftc.convertValue(w, val, ftc.from, ftc.to)
+			if !ok {
+				ftc.unconverted++
+				continue
+			}
+			start, end := w.span(val)
+			if converted == w.text(start, end) {
+				continue
+			}
+			if a.sep == nil && val == a.val && val.GetMessageLiteral() != nil && ftc.to.kind != protoreflect.MessageKind {
+				// scalar values require a separator
+				_, nameEnd := w.span(a.name)
+				w.edit(nameEnd, nameEnd, ":")
+			}
+			w.edit(start, end, converted)
+		}
+	}
+	return nil
+}
+
+// convertValue returns the text of the given value converted from one type to
+// the other, or false if there is no equivalent value of the new type.
+func (ftc *fieldTypeChange) convertValue(w *sourceRewriter, val *ast.ValueNode, from, to fieldType) (string, bool) {
+	start, end := w.span(val)
+	text := w.text(start, end)
+	if kind, ok := from.wrapped(); ok && to.desc == nil {
+		lit := val.GetMessageLiteral()
+		if lit == nil {
+			return "", false
+		}
+		switch len(lit.Elements) {
+		case 0:
+			return zeroValue(to.kind), true
+		case 1:
+			if elem := lit.Elements[0]; elem.Name != nil && elem.Name.Name != nil && elem.Name.Name.AsIdentifier() == "value" {
+				return ftc.convertValue(w, elem.Val, fieldType{kind: kind}, to)
+			}
+		}
+		return "", false
+	}
+	if kind, ok := to.wrapped(); ok && from.desc == nil {
+		converted, ok := ftc.convertValue(w, val, from, fieldType{kind: kind})
+		if !ok {
+			return "", false
+		}
+		return "{value: " + converted + "}", true
+	}
+	if to.kind == protoreflect.MessageKind || from.kind == protoreflect.MessageKind {
+		// message literals can only be kept if they are empty
+		if lit := val.GetMessageLiteral(); lit != nil && len(lit.Elements) == 0 && to.kind == protoreflect.MessageKind {
+			return text, true
+		}
+		return "", false
+	}
+
+	v := val.Value()
+	if from.kind == protoreflect.EnumKind {
+		id, ok := v.(ast.Identifier)
+		if !ok {
+			return "", false
+		}
+		ev := from.desc.(protoreflect.EnumDescriptor).Values().ByName(protoreflect.Name(id))
+		if ev == nil {
+			return "", false
+		}
+		if to.kind == protoreflect.EnumKind {
+			if to.desc.(protoreflect.EnumDescriptor).Values().ByName(ev.Name()) != nil {
+				return text, true
+			}
+			v = uint64(0)
+		}
+		if to.kind == protoreflect.StringKind || to.kind == protoreflect.BytesKind {
+			return strconv.Quote(string(id)), true
+		}
+		if n := ev.Number(); n < 0 {
+			v, text = int64(n), strconv.Itoa(int(n))
+		} else {
+			v, text = uint64(n), strconv.Itoa(int(n))
+		}
+	}
+	if to.kind == protoreflect.EnumKind {
+		ed := to.desc.(protoreflect.EnumDescriptor)
+		switch v := v.(type) {
+		case ast.Identifier:
+			if ed.Values().ByName(protoreflect.Name(v)) != nil {
+				return text, true
+			}
+		case string:
+			if ed.Values().ByName(protoreflect.Name(v)) != nil {
+				return v, true
+			}
+		default:
+			if n, ok := integerValue(v); ok && n.IsInt64() && n.Int64() >= math.MinInt32 && n.Int64() <= math.MaxInt32 {
+				if ev := ed.Values().ByNumber(protoreflect.EnumNumber(n.Int64())); ev != nil {
+					return string(ev.Name()), true
+				}
+			}
+		}
+		return "", false
+	}
+	return convertScalar(text, v, to.kind)
+}
+
+// convertScalar converts a scalar literal with the given source text and
+// value to an equivalent literal of the given kind.
+func convertScalar(text string, v any, to protoreflect.Kind) (string, bool) {
+	switch to {
+	case protoreflect.StringKind, protoreflect.BytesKind:
+		switch v := v.(type) {
+		case string:
+			return text, true
+		case ast.Identifier:
+			return strconv.Quote(string(v)), true
+		case uint64, int64, float64:
+			return strconv.Quote(text), true
+		}
+	case protoreflect.BoolKind:
+		switch v := v.(type) {
+		case ast.Identifier:
+			if v == "true" || v == "false" {
+				return text, true
+			}
+		case string:
+			if v == "true" || v == "false" {
+				return v, true
+			}
+		case uint64:
+			if v <= 1 {
+				return strconv.FormatBool(v == 1), true
+			}
+		}
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		switch v := v.(type) {
+		case uint64, int64, float64:
+			return text, true
+		case ast.Identifier:
+			if v == "inf" || v == "nan" {
+				return text, true
+			}
+		case string:
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				return strconv.FormatFloat(f, 'g', -1, 64), true
+			}
+		}
+	default:
+		n, ok := integerValue(v)
+		if !ok || !integerInRange(n, to) {
+			return "", false
+		}
+		switch v.(type) {
+		case uint64, int64:
+			return text, true
+		}
+		return n.String(), true
+	}
+	return "", false
+}
+
+func integerValue(v any) (*big.Int, bool) {
+	switch v := v.(type) {
+	case uint64:
+		return new(big.Int).SetUint64(v), true
+	case int64:
+		return big.NewInt(v), true
+	case float64:
+		if math.IsInf(v, 0) || math.IsNaN(v) || v != math.Trunc(v) {
+			return nil, false
+		}
+		n, _ := big.NewFloat(v).Int(nil)
+		return n, true
+	case string:
+		return new(big.Int).SetString(v, 10)
+	case ast.Identifier:
+		switch v {
+		case "true":
+			return big.NewInt(1), true
+		case "false":
+			return big.NewInt(0), true
+		}
+	}
+	return nil, false
+}
+
+func integerInRange(n *big.Int, kind protoreflect.Kind) bool {
+	var min, max *big.Int
+	switch kind {
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		min, max = big.NewInt(math.MinInt32), big.NewInt(math.MaxInt32)
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		min, max = big.NewInt(0), big.NewInt(math.MaxUint32)
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		min, max = big.NewInt(math.MinInt64), big.NewInt(math.MaxInt64)
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		min, max = big.NewInt(0), new(big.Int).SetUint64(math.MaxUint64)
+	default:
+		return false
+	}
+	return n.Cmp(min) >= 0 && n.Cmp(max) <= 0
+}
+
+func zeroValue(kind protoreflect.Kind) string {
+	switch kind {
+	case protoreflect.StringKind, protoreflect.BytesKind:
+		return `""`
+	case protoreflect.BoolKind:
+		return "false"
+	}
+	return "0"
+}
+
+// changeFieldTypeTitle returns the title of a code action changing the type of
+// the field to the given type.
+func changeFieldTypeTitle(field protoreflect.FieldDescriptor, to fieldType) string {
+	title := fmt.Sprintf("Change type of %s to %s", field.Name(), to)
+	if !wireCompatible(typeOfField(field), to) {
+		title += " (wire-incompatible)"
+	}
+	return title
+}
+
+// unconvertedWarning describes the option values which could not be converted
+// to the new type, if there are any.
+func (ftc *fieldTypeChange) unconvertedWarning() string {
+	switch ftc.unconverted {
+	case 0:
+		return ""
+	case 1:
+		return fmt.Sprintf("Changed type of %s to %s: 1 option value needs to be updated by hand", ftc.field.Name(), ftc.to)
+	default:
+		return fmt.Sprintf("Changed type of %s to %s: %d option values need to be updated by hand", ftc.field.Name(), ftc.to, ftc.unconverted)
+	}
+}
+
+// ChangeFieldType returns the document changes that change the type of the
+// field with the given name to newType, which is either the name of a scalar
+// type or the fully qualified name of a message or enum. If some option values
+// could not be converted to the new type, a warning describing them is
+// returned as well.
+func (c *Cache) ChangeFieldType(ctx context.Context, name protoreflect.FullName, newType string) ([]protocol.DocumentChanges, string, error) {
+	c.resultsMu.RLock()
+	defer c.resultsMu.RUnlock()
+
+	docs := c.newWorkspaceDocuments(ctx, name)
+	ftc, edits, err := changeFieldType(c.results, docs.contents, name, newType)
+	if err != nil {
+		return nil, "", err
+	}
+	changes, err := docs.documentChanges(edits)
+	if err != nil {
+		return nil, "", err
+	}
+	return changes, ftc.unconvertedWarning(), nil
+}
+
+// alternativeFieldTypes returns the types offered as replacements for the type
+// of the field: the related scalar or wrapper types. Messages and enums are
+// not listed, since any of them could take the place of a message or enum
+// field; they can still be chosen through the Type of a ChangeFieldTypeRequest.
+func alternativeFieldTypes(field protoreflect.FieldDescriptor) []fieldType {
+	from := typeOfField(field)
+	var alternatives []fieldType
+	add := func(t fieldType) {
+		if t.String() == from.String() || slices.ContainsFunc(alternatives, func(alt fieldType) bool { return alt.String() == t.String() }) {
+			return
+		}
+		alternatives = append(alternatives, t)
+	}
+	wrappedKind, wrapper := from.wrapped()
+	switch {
+	case wrapper:
+		add(fieldType{kind: wrappedKind})
+	case from.kind == protoreflect.EnumKind:
+		add(fieldType{kind: protoreflect.Int32Kind})
+	case from.desc == nil:
+		for _, name := range fieldTypeAlternatives[from.kind] {
+			if kind, ok := scalarKinds[name]; ok {
+				add(fieldType{kind: kind})
+			} else if d, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(name)); err == nil {
+				add(fieldType{kind: protoreflect.MessageKind, desc: d})
+			}
+		}
+	}
+	return alternatives
+}
+
+// changeFieldTypeActions offers to change the type of the field whose type is
+// at the cursor to one of its alternative types. The edits are computed when
+// the command is executed.
+func (c *Cache) changeFieldTypeActions(params *protocol.CodeActionParams, linkRes linker.Result, mapper *protocol.Mapper) []protocol.CodeAction {
+	fileNode := linkRes.AST()
+	if fileNode == nil || params.Range.Start != params.Range.End || !c.resolver.IsRealWorkspaceLocalFile(params.TextDocument.URI) {
+		return nil
+	}
+	offset, err := mapper.PositionOffset(params.Range.Start)
+	if err != nil {
+		return nil
+	}
+	var field protoreflect.FieldDescriptor
+	check := func(fd protoreflect.FieldDescriptor) bool {
+		fdp := fd.(protoutil.DescriptorProtoWrapper).AsProto().(*descriptorpb.FieldDescriptorProto)
+		decl := linkRes.FieldNode(fdp)
+		if decl == nil {
+			return true
+		}
+		node, ok := decl.Unwrap().(*ast.FieldNode)
+		if !ok || node.FieldType == nil {
+			return true
+		}
+		info := fileNode.NodeInfo(node.FieldType)
+		if offset >= info.Start().Offset && offset <= info.End().Offset+1 {
+			field = fd
+			return false
+		}
+		return true
+	}
+	rangeTypeDescriptors(linkRes, func(d protoreflect.Descriptor) bool {
+		switch d := d.(type) {
+		case protoreflect.MessageDescriptor:
+			for i := 0; i < d.Fields().Len(); i++ {
+				if !check(d.Fields().Get(i)) {
+					return false
+				}
+			}
+		case protoreflect.ExtensionDescriptor:
+			return check(d)
+		}
+		return true
+	})
+	if field == nil || field.IsMap() || field.Kind() == protoreflect.GroupKind {
+		return nil
+	}
+
+	var actions []protocol.CodeAction
+	for _, to := range alternativeFieldTypes(field) {
+		title := changeFieldTypeTitle(field, to)
+		data, _ := json.Marshal(ChangeFieldTypeRequest{
+			URI:   params.TextDocument.URI,
+			Field: string(field.FullName()),
+			Type:  to.String(),
+		})
+		actions = append(actions, protocol.CodeAction{
+			Title: title,
+			Kind:  protocol.RefactorRewrite,
+			Command: &protocol.Command{
+				Title:     title,
+				Command:   "protols/changeFieldType",
+				Arguments: []json.RawMessage{data},
+			},
+		})
+	}
+	return actions
+}
@@ -0,0 +1,306 @@
+package lsp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+func TestChangeFieldType(t *testing.T) {
+	optionsProto := `syntax = "proto2";
+
+package test;
+
+import "google/protobuf/descriptor.proto";
+
+message Limits {
+  optional int32 count = 1 [default = 10];
+  repeated int32 sizes = 2 [packed = true];
+}
+
+extend google.protobuf.MessageOptions {
+  optional Limits limits = 50000;
+}
+`
+	usageProto := `syntax = "proto2";
+
+package test;
+
+import "options.proto";
+
+message Foo {
+  option (limits) = {
+    count: 3
+    sizes: [1, 2]
+  };
+}
+
+message Bar {
+  option (limits).count = -1;
+}
+`
+	cases := []struct {
+		name             string
+		field            protoreflect.FullName
+		newType          string
+		want             map[string]string
+		wireIncompatible bool
+		unconverted      int
+	}{
+		{
+			name:    "scalar",
+			field:   "test.Limits.count",
+			newType: "int64",
+			want: map[string]string{
+				"options.proto": `syntax = "proto2";
+
+package test;
+
+import "google/protobuf/descriptor.proto";
+
+message Limits {
+  optional int64 count = 1 [default = 10];
+  repeated int32 sizes = 2 [packed = true];
+}
+
+extend google.protobuf.MessageOptions {
+  optional Limits limits = 50000;
+}
+`,
+			},
+		},
+		{
+			name:             "incompatible scalar",
+			field:            "test.Limits.count",
+			newType:          "uint32",
+			wireIncompatible: false,
+			unconverted:      1,
+			want: map[string]string{
+				"options.proto": `syntax = "proto2";
+
+package test;
+
+import "google/protobuf/descriptor.proto";
+
+message Limits {
+  optional uint32 count = 1 [default = 10];
+  repeated int32 sizes = 2 [packed = true];
+}
+
+extend google.protobuf.MessageOptions {
+  optional Limits limits = 50000;
+}
+`,
+			},
+		},
+		{
+			name:             "string",
+			field:            "test.Limits.sizes",
+			newType:          "string",
+			wireIncompatible: true,
+			want: map[string]string{
+				"options.proto": `syntax = "proto2";
+
+package test;
+
+import "google/protobuf/descriptor.proto";
+
+message Limits {
+  optional int32 count = 1 [default = 10];
+  repeated string sizes = 2;
+}
+
+extend google.protobuf.MessageOptions {
+  optional Limits limits = 50000;
+}
+`,
+				"usage.proto": `syntax = "proto2";
+
+package test;
+
+import "options.proto";
+
+message Foo {
+  option (limits) = {
+    count: 3
+    sizes: ["1", "2"]
+  };
+}
+
+message Bar {
+  option (limits).count = -1;
+}
+`,
+			},
+		},
+		{
+			name:             "wrapper",
+			field:            "test.Limits.count",
+			newType:          "google.protobuf.Int32Value",
+			wireIncompatible: true,
+			want: map[string]string{
+				"options.proto": `syntax = "proto2";
+
+package test;
+
+import "google/protobuf/descriptor.proto";
+import "google/protobuf/wrappers.proto";
+
+message Limits {
+  optional google.protobuf.Int32Value count = 1;
+  repeated int32 sizes = 2 [packed = true];
+}
+
+extend google.protobuf.MessageOptions {
+  optional Limits limits = 50000;
+}
+`,
+				"usage.proto": `syntax = "proto2";
+
+package test;
+
+import "options.proto";
+
+message Foo {
+  option (limits) = {
+    count: {value: 3}
+    sizes: [1, 2]
+  };
+}
+
+message Bar {
+  option (limits).count = {value: -1};
+}
+`,
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			sources := map[string]string{
+				"options.proto": optionsProto,
+				"usage.proto":   usageProto,
+			}
+			files := compileFiles(t, sources)
+			ftc, edits, err := changeFieldType(files, func(path string) ([]byte, error) {
+				return []byte(sources[path]), nil
+			}, c.field, c.newType)
+			require.NoError(t, err)
+			require.Equal(t, c.wireIncompatible, ftc.wireIncompatible)
+			require.Equal(t, c.unconverted, ftc.unconverted)
+			got := map[string]string{}
+			for path, e := range edits {
+				out, err := applySourceEdits([]byte(sources[path]), e)
+				require.NoError(t, err)
+				got[path] = string(out)
+			}
+			require.Equal(t, c.want, got)
+			if c.unconverted == 0 {
+				for path, src := range got {
+					sources[path] = src
+				}
+				compileFiles(t, sources)
+			}
+		})
+	}
+}
+
+func TestChangeFieldTypeFromWrapper(t *testing.T) {
+	sources := map[string]string{
+		"a.proto": `syntax = "proto3";
+
+package test;
+
+import "google/protobuf/descriptor.proto";
+import "google/protobuf/wrappers.proto";
+
+message Limits {
+  google.protobuf.StringValue name = 1;
+}
+
+extend google.protobuf.FileOptions {
+  Limits limits = 50000;
+}
+
+option (limits) = {
+  name {value: "foo"}
+};
+`,
+	}
+	want := `syntax = "proto3";
+
+package test;
+
+import "google/protobuf/descriptor.proto";
+
+message Limits {
+  string name = 1;
+}
+
+extend google.protobuf.FileOptions {
+  Limits limits = 50000;
+}
+
+option (limits) = {
+  name: "foo"
+};
+`
+	files := compileFiles(t, sources)
+	ftc, edits, err := changeFieldType(files, func(path string) ([]byte, error) {
+		return []byte(sources[path]), nil
+	}, "test.Limits.name", "string")
+	require.NoError(t, err)
+	require.True(t, ftc.wireIncompatible)
+	require.Equal(t, "Change type of name to string (wire-incompatible)", changeFieldTypeTitle(ftc.field, ftc.to))
+	out, err := applySourceEdits([]byte(sources["a.proto"]), edits["a.proto"])
+	require.NoError(t, err)
+	require.Equal(t, want, string(out))
+}
+
+func TestAlternativeFieldTypes(t *testing.T) {
+	const src = `syntax = "proto3";
+
+package test;
+
+import "google/protobuf/wrappers.proto";
+
+message Foo {
+  int32 count = 1;
+  Kind kind = 2;
+  Bar bar = 3;
+  google.protobuf.StringValue name = 4;
+  map<string, Bar> bars = 5;
+
+  message Nested {}
+}
+
+message Bar {}
+
+enum Kind {
+  KIND_UNSPECIFIED = 0;
+}
+
+enum Color {
+  COLOR_UNSPECIFIED = 0;
+}
+`
+	res := compileFile(t, src)
+	foo := res.Messages().ByName("Foo")
+	cases := []struct {
+		field protoreflect.Name
+		want  []string
+	}{
+		{"count", []string{"int64", "uint32", "sint32", "google.protobuf.Int32Value"}},
+		{"kind", []string{"int32"}},
+		{"bar", nil},
+		{"name", []string{"string"}},
+	}
+	for _, c := range cases {
+		var got []string
+		for _, alt := range alternativeFieldTypes(foo.Fields().ByName(c.field)) {
+			got = append(got, alt.String())
+		}
+		require.Equal(t, c.want, got, c.field)
+	}
+}
@@ -0,0 +1,377 @@
+package lsp
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/kralicky/protocompile/ast"
+	"github.com/kralicky/protocompile/linker"
+	"github.com/kralicky/protocompile/reporter"
+	"github.com/kralicky/tools-lite/gopls/pkg/file"
+	"github.com/kralicky/tools-lite/gopls/pkg/protocol"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// WillRenameFiles returns the edits that update the imports of the renamed
+// files, or of the files within renamed directories, across the workspace.
+// If enabled in the settings, the go_package option of each renamed file is
+// also updated if it refers to the file's directory.
+func (c *Cache) WillRenameFiles(ctx context.Context, renames []protocol.FileRename) ([]protocol.DocumentChanges, error) {
+	c.resultsMu.RLock()
+	defer c.resultsMu.RUnlock()
+
+	renamed := map[string]string{}
+	for _, f := range c.results {
+		uri, err := c.resolver.PathToURI(f.Path())
+		if err != nil || !uri.IsFile() || !c.resolver.IsRealWorkspaceLocalFile(uri) {
+			continue
+		}
+		filename := uri.Path()
+		for _, r := range renames {
+			oldName, newName := protocol.DocumentURI(r.OldURI).Path(), protocol.DocumentURI(r.NewURI).Path()
+			var newFilename string
+			if filename == oldName {
+				newFilename = newName
+			} else if rest, ok := strings.CutPrefix(filename, oldName+"/"); ok {
+				newFilename = path.Join(newName, rest)
+			} else {
+				continue
+			}
+			// the file must stay within the workspace to be importable by the
+			// same files
+			newPath := c.resolver.PathForNewURI(protocol.URIFromPath(newFilename))
+			if strings.HasSuffix(newPath, ".proto") && !path.IsAbs(newPath) && !strings.HasPrefix(newPath, "../") {
+				renamed[f.Path()] = newPath
+			}
+		}
+	}
+	if len(renamed) == 0 {
+		return nil, nil
+	}
+
+	updateGoPackage := c.settings.Load().FileOperations.GetUpdateGoPackage()
+	var changes []protocol.DocumentChanges
+	for _, f := range c.results {
+		res, ok := f.(linker.Result)
+		if !ok || f.IsPlaceholder() {
+			continue
+		}
+		uri, err := c.resolver.PathToURI(f.Path())
+		if err != nil || !c.resolver.IsRealWorkspaceLocalFile(uri) {
+			continue
+		}
+		fh, err := c.resolver.ReadFile(ctx, uri)
+		if err != nil {
+			return nil, err
+		}
+		content, err := fh.Content()
+		if err != nil {
+			return nil, err
+		}
+		w := &sourceRewriter{res: res, file: res.AST(), content: content}
+		renameImports(w, renamed, updateGoPackage)
+		if len(w.edits) == 0 {
+			continue
+		}
+		mapper := protocol.NewMapper(uri, content)
+		var edits []protocol.TextEdit
+		for _, e := range w.edits {
+			rng, err := mapper.OffsetRange(e.start, e.end)
+			if err != nil {
+				return nil, err
+			}
+			edits = append(edits, protocol.TextEdit{Range: rng, NewText: e.text})
+		}
+		changes = append(changes, protocol.TextEditsToDocumentChanges(uri, fh.Version(), edits)...)
+	}
+	slices.SortFunc(changes, func(a, b protocol.DocumentChanges) int {
+		return strings.Compare(string(a.TextDocumentEdit.TextDocument.URI), string(b.TextDocumentEdit.TextDocument.URI))
+	})
+	return changes, nil
+}
+
+// renameImports rewrites the imports of the renamed files, given as a map of
+// old to new import paths. If the file itself was moved to another directory
+// and updateGoPackage is set, its go_package option is updated as well.
+func renameImports(w *sourceRewriter, renamed map[string]string, updateGoPackage bool) {
+	imported := importedPaths(w.res)
+	var opts []*ast.OptionNode
+	for _, decl := range w.file.Decls {
+		if opt := decl.GetOption(); opt != nil {
+			opts = append(opts, opt)
+		}
+		imp := decl.GetImport()
+		if imp == nil || imp.IsIncomplete() {
+			continue
+		}
+		oldPath := imported[imp]
+		if newPath, ok := renamed[oldPath]; ok {
+			start, end := w.span(imp.Name)
+			w.edit(start, end, fmt.Sprintf("%q", renamedImportName(imp.Name.AsString(), oldPath, newPath)))
+		}
+	}
+
+	newPath, ok := renamed[w.res.Path()]
+	if !ok || !updateGoPackage {
+		return
+	}
+	oldDir, newDir := path.Dir(w.res.Path()), path.Dir(newPath)
+	opt, _ := findOptionNode(w.res, opts, "go_package").(*ast.OptionNode)
+	if opt == nil || oldDir == newDir || oldDir == "." {
+		return
+	}
+	importPath, pkgName, hasPkgName := strings.Cut(w.res.FileDescriptorProto().GetOptions().GetGoPackage(), ";")
+	prefix, ok := strings.CutSuffix(importPath, oldDir)
+	if !ok || (prefix != "" && !strings.HasSuffix(prefix, "/")) {
+		return
+	}
+	if newDir == "." {
+		importPath = strings.TrimSuffix(prefix, "/")
+	} else {
+		importPath = prefix + newDir
+	}
+	if importPath == "" {
+		return
+	}
+	if hasPkgName {
+		if pkgName == path.Base(oldDir) {
+			pkgName = path.Base(importPath)
+		}
+		importPath += ";" + pkgName
+	}
+	start, end := w.span(opt.Val)
+	w.edit(start, end, fmt.Sprintf("%q", importPath))
+}
+
+// renamedImportName returns the new name of an import of the file renamed
+// from oldPath to newPath. Imports naming the file by a path relative to the
+// importing file or to the workspace root, rather than by the path the
+// resolver knows it by, keep doing so if possible.
+func renamedImportName(name, oldPath, newPath string) string {
+	if name == oldPath {
+		return newPath
+	}
+	if prefix, ok := strings.CutSuffix(oldPath, "/"+name); ok {
+		if rest, ok := strings.CutPrefix(newPath, prefix+"/"); ok {
+			return rest
+		}
+	}
+	return newPath
+}
+
+// importedPaths returns the paths of the files imported by each complete
+// import of the file. These are the paths the resolver found the files at,
+// which differ from the import names when they are translated, such as for
+// relative imports within a go module.
+func importedPaths(res linker.Result) map[*ast.ImportNode]string {
+	deps := res.FileDescriptorProto().GetDependency()
+	paths := map[*ast.ImportNode]string{}
+	for _, decl := range res.AST().Decls {
+		imp := decl.GetImport()
+		if imp == nil || imp.IsIncomplete() {
+			continue
+		}
+		if len(paths) == len(deps) {
+			break
+		}
+		paths[imp] = deps[len(paths)]
+	}
+	return paths
+}
+
+// WillDeleteFiles returns the edits that remove the imports of the deleted
+// files, or of the files within deleted directories, from workspace files
+// which do not use any of their symbols. Workspace files which still depend
+// on a deleted file are returned, keyed by the path of the deleted file. A
+// warning is reported on each of their imports once the files are deleted.
+func (c *Cache) WillDeleteFiles(ctx context.Context, deletes []protocol.FileDelete) ([]protocol.DocumentChanges, map[string][]string, error) {
+	c.resultsMu.RLock()
+	defer c.resultsMu.RUnlock()
+
+	deleted := map[string]protoreflect.FileDescriptor{}
+	deletedBy := map[string]protocol.DocumentURI{}
+	for _, f := range c.results {
+		uri, err := c.resolver.PathToURI(f.Path())
+		if err != nil || !uri.IsFile() || !c.resolver.IsRealWorkspaceLocalFile(uri) {
+			continue
+		}
+		filename := uri.Path()
+		for _, d := range deletes {
+			name := protocol.DocumentURI(d.URI).Path()
+			if filename == name || strings.HasPrefix(filename, name+"/") {
+				deleted[f.Path()] = f
+				deletedBy[f.Path()] = protocol.DocumentURI(d.URI)
+			}
+		}
+	}
+	if len(deleted) == 0 {
+		return nil, nil, nil
+	}
+
+	var changes []protocol.DocumentChanges
+	dependents := map[string][]string{}
+	pending := map[protocol.DocumentURI][]deletedImport{}
+	for _, f := range c.results {
+		res, ok := f.(linker.Result)
+		if !ok || f.IsPlaceholder() {
+			continue
+		}
+		if _, ok := deleted[f.Path()]; ok {
+			continue
+		}
+		uri, err := c.resolver.PathToURI(f.Path())
+		if err != nil || !c.resolver.IsRealWorkspaceLocalFile(uri) {
+			continue
+		}
+		fh, err := c.resolver.ReadFile(ctx, uri)
+		if err != nil {
+			return nil, nil, err
+		}
+		content, err := fh.Content()
+		if err != nil {
+			return nil, nil, err
+		}
+		w := &sourceRewriter{res: res, file: res.AST(), content: content}
+		imported := importedPaths(res)
+		for _, imp := range removeDeletedImports(w, deleted) {
+			path := imported[imp]
+			dependents[path] = append(dependents[path], f.Path())
+			pending[deletedBy[path]] = append(pending[deletedBy[path]], deletedImport{
+				importer: f.Path(),
+				name:     imp.Name.AsString(),
+			})
+		}
+		if len(w.edits) == 0 {
+			continue
+		}
+		mapper := protocol.NewMapper(uri, content)
+		var edits []protocol.TextEdit
+		for _, e := range w.edits {
+			rng, err := mapper.OffsetRange(e.start, e.end)
+			if err != nil {
+				return nil, nil, err
+			}
+			edits = append(edits, protocol.TextEdit{Range: rng, NewText: e.text})
+		}
+		changes = append(changes, protocol.TextEditsToDocumentChanges(uri, fh.Version(), edits)...)
+	}
+	for _, d := range deletes {
+		uri := protocol.DocumentURI(d.URI)
+		if imports, ok := pending[uri]; ok {
+			c.pendingDeletes.Store(uri, &pendingDelete{imports: imports})
+		} else {
+			c.pendingDeletes.Delete(uri)
+		}
+	}
+	for _, paths := range dependents {
+		slices.Sort(paths)
+	}
+	slices.SortFunc(changes, func(a, b protocol.DocumentChanges) int {
+		return strings.Compare(string(a.TextDocumentEdit.TextDocument.URI), string(b.TextDocumentEdit.TextDocument.URI))
+	})
+	return changes, dependents, nil
+}
+
+// removeDeletedImports removes the imports of the deleted files, given as a
+// map of their paths to descriptors, if the file does not use any of their
+// symbols. The imports which are still in use are returned.
+func removeDeletedImports(w *sourceRewriter, deleted map[string]protoreflect.FileDescriptor) []*ast.ImportNode {
+	imported := importedPaths(w.res)
+	var used []*ast.ImportNode
+	var removed [][2]int
+	for _, decl := range w.file.Decls {
+		imp := decl.GetImport()
+		if imp == nil || imp.IsIncomplete() {
+			continue
+		}
+		f, ok := deleted[imported[imp]]
+		if !ok {
+			continue
+		}
+		// public imports are part of the file's api, even if unused
+		if imp.Public != nil || usesFile(w.res, f) {
+			used = append(used, imp)
+			continue
+		}
+		start, end := w.lineSpan(w.declSpan(imp))
+		if n := len(removed); n > 0 && removed[n-1][1] == start {
+			removed[n-1][1] = end
+		} else {
+			removed = append(removed, [2]int{start, end})
+		}
+	}
+	for _, r := range removed {
+		start, end := r[0], r[1]
+		// if a whole block of imports is removed, remove one of the blank
+		// lines surrounding it as well
+		if start > 1 && w.content[start-2] == '\n' && end < len(w.content) && w.content[end] == '\n' {
+			end++
+		}
+		w.edit(start, end, "")
+	}
+	return used
+}
+
+// usesFile reports whether res refers to any of the symbols declared in f,
+// or in the files publicly imported by f.
+func usesFile(res linker.Result, f protoreflect.FileDescriptor) bool {
+	used := false
+	rangeTypeDescriptors(f, func(desc protoreflect.Descriptor) bool {
+		used = len(res.FindReferences(desc)) > 0
+		return !used
+	})
+	if used {
+		return true
+	}
+	imports := f.Imports()
+	for i := 0; i < imports.Len(); i++ {
+		if imp := imports.Get(i); imp.IsPublic && usesFile(res, imp.FileDescriptor) {
+			return true
+		}
+	}
+	return false
+}
+
+// pendingDelete holds the imports of a file, or of the files in a directory,
+// which are still in use while it is about to be deleted.
+type pendingDelete struct {
+	imports []deletedImport
+}
+
+// deletedImport is an import of a file which is about to be deleted, but which
+// is still in use by the importing file.
+type deletedImport struct {
+	importer string // path of the importing file
+	name     string // import name, as written
+}
+
+// warnDeletedImportsLocked reports a warning on each import of the deleted
+// files which was still in use when they were about to be deleted.
+func (c *Cache) warnDeletedImportsLocked(modifications []file.Modification) {
+	for _, m := range modifications {
+		if m.Action != file.Delete {
+			continue
+		}
+		pending, ok := c.pendingDeletes.LoadAndDelete(m.URI)
+		if !ok {
+			continue
+		}
+		for _, di := range pending.imports {
+			res, err := c.findParseResultByPathLocked(di.importer)
+			if err != nil || res.AST() == nil {
+				continue
+			}
+			for _, decl := range res.AST().Decls {
+				imp := decl.GetImport()
+				if imp == nil || imp.IsIncomplete() || imp.Name.AsString() != di.name {
+					continue
+				}
+				c.diagHandler.HandleWarning(reporter.Error(res.AST().NodeInfo(imp.Name),
+					fmt.Errorf("%s was deleted, but is still in use", di.name)))
+			}
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package lsp
+
+import (
+	"testing"
+
+	"github.com/kralicky/protocompile/linker"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+func TestRenameImports(t *testing.T) {
+	sources := map[string]string{
+		"foo/bar.proto": `syntax = "proto3";
+
+package foo;
+
+option go_package = "example.com/api/foo;foo";
+
+message Bar {}
+`,
+		"foo/baz.proto": `syntax = "proto3";
+
+package foo;
+
+import "foo/bar.proto";
+
+option go_package = "example.com/api/foo";
+
+message Baz {
+  Bar bar = 1;
+}
+`,
+	}
+	files := compileFiles(t, sources)
+	renamed := map[string]string{
+		"foo/bar.proto": "qux/bar.proto",
+		"foo/baz.proto": "qux/baz.proto",
+	}
+	want := map[string]string{
+		"foo/bar.proto": `syntax = "proto3";
+
+package foo;
+
+option go_package = "example.com/api/qux;qux";
+
+message Bar {}
+`,
+		"foo/baz.proto": `syntax = "proto3";
+
+package foo;
+
+import "qux/bar.proto";
+
+option go_package = "example.com/api/qux";
+
+message Baz {
+  Bar bar = 1;
+}
+`,
+	}
+	for _, f := range files {
+		res := f.(linker.Result)
+		w := &sourceRewriter{res: res, file: res.AST(), content: []byte(sources[f.Path()])}
+		renameImports(w, renamed, true)
+		out, err := applySourceEdits(w.content, w.edits)
+		require.NoError(t, err)
+		require.Equal(t, want[f.Path()], string(out))
+
+		w.edits = nil
+		renameImports(w, renamed, false)
+		out, err = applySourceEdits(w.content, w.edits)
+		require.NoError(t, err)
+		if f.Path() == "foo/bar.proto" {
+			require.Equal(t, sources[f.Path()], string(out))
+		}
+	}
+}
+
+func TestRemoveDeletedImports(t *testing.T) {
+	sources := map[string]string{
+		"deleted.proto": `syntax = "proto3";
+
+package test;
+
+import "google/protobuf/descriptor.proto";
+
+message Foo {}
+
+extend google.protobuf.MessageOptions {
+  string label = 50000;
+}
+`,
+		"unused.proto": `syntax = "proto3";
+
+package test;
+
+import "deleted.proto";
+import "google/protobuf/empty.proto";
+
+message Bar {
+  google.protobuf.Empty empty = 1;
+}
+`,
+		"type.proto": `syntax = "proto3";
+
+package test;
+
+import "deleted.proto";
+
+message Baz {
+  Foo foo = 1;
+}
+`,
+		"option.proto": `syntax = "proto3";
+
+package test;
+
+import "deleted.proto";
+
+message Qux {
+  option (label) = "qux";
+}
+`,
+		"public.proto": `syntax = "proto3";
+
+package test;
+
+import public "deleted.proto";
+`,
+	}
+	files := compileFiles(t, sources)
+	deleted := map[string]protoreflect.FileDescriptor{
+		"deleted.proto": files.FindFileByPath("deleted.proto"),
+	}
+	want := map[string]string{
+		"unused.proto": `syntax = "proto3";
+
+package test;
+
+import "google/protobuf/empty.proto";
+
+message Bar {
+  google.protobuf.Empty empty = 1;
+}
+`,
+	}
+	for _, f := range files {
+		if f.Path() == "deleted.proto" {
+			continue
+		}
+		res := f.(linker.Result)
+		w := &sourceRewriter{res: res, file: res.AST(), content: []byte(sources[f.Path()])}
+		used := removeDeletedImports(w, deleted)
+		out, err := applySourceEdits(w.content, w.edits)
+		require.NoError(t, err)
+		if expected, ok := want[f.Path()]; ok {
+			require.Empty(t, used)
+			require.Equal(t, expected, string(out))
+		} else {
+			require.Len(t, used, 1, f.Path())
+			require.Equal(t, sources[f.Path()], string(out))
+		}
+	}
+}
@@ -2,6 +2,10 @@ package lsp
 
 import (
 	"fmt"
+	"slices"
+	"strings"
+
+	celast "github.com/google/cel-go/common/ast"
 
 	"github.com/kralicky/protocompile/ast"
 	"github.com/kralicky/protols/pkg/format"
@@ -10,6 +14,9 @@ import (
 )
 
 func (c *Cache) ComputeHover(params protocol.TextDocumentPositionParams) (*protocol.Hover, error) {
+	if hover := c.tryHoverCelExpression(params); hover != nil {
+		return hover, nil
+	}
 	desc, rng, err := c.FindTypeDescriptorAtLocation(params)
 	if err != nil {
 		return nil, err
@@ -51,10 +58,14 @@ func (c *Cache) ComputeHover(params protocol.TextDocumentPositionParams) (*proto
 	if err != nil {
 		return nil, err
 	}
+	value := fmt.Sprintf("```protobuf\n%s\n```\n", text)
+	if features := effectiveFeaturesMarkdown(desc); features != "" {
+		value += "\n---\n" + features
+	}
 	return &protocol.Hover{
 		Contents: protocol.MarkupContent{
 			Kind:  protocol.Markdown,
-			Value: fmt.Sprintf("```protobuf\n%s\n```\n", text),
+			Value: value,
 		},
 		Range: rng,
 	}, nil
@@ -72,3 +83,136 @@ func makeTooltip(d protoreflect.Descriptor) *protocol.OrPTooltipPLabel {
 		},
 	}
 }
+
+// tryHoverCelExpression returns hover information for the sub-expression at
+// the given position within a protovalidate CEL expression, showing its type
+// and, for function calls, the signatures and documentation of the function.
+func (c *Cache) tryHoverCelExpression(params protocol.TextDocumentPositionParams) *protocol.Hover {
+	res, err := c.FindResultOrPartialResultByURI(params.TextDocument.URI)
+	if err != nil {
+		return nil
+	}
+	mapper, err := c.GetMapper(params.TextDocument.URI)
+	if err != nil {
+		return nil
+	}
+	offset, err := mapper.PositionOffset(params.Position)
+	if err != nil {
+		return nil
+	}
+	constraint, expr, pos, ok := findCelExpressionAt(res, offset)
+	if !ok {
+		return nil
+	}
+	return hoverCelExpression(constraint, expr, pos, c.FindDescriptorByName)
+}
+
+func hoverCelExpression(
+	constraint celConstraint,
+	expr *celExpression,
+	pos int,
+	findDescriptor func(protoreflect.FullName) (protoreflect.Descriptor, error),
+) *protocol.Hover {
+	env, err := constraint.env()
+	if err != nil {
+		return nil
+	}
+	parsed, issues := env.Parse(expr.text)
+	if issues.Err() != nil {
+		return nil
+	}
+	parsedAst := parsed.NativeRep()
+	var checkedAst *celast.AST
+	if checked, issues := env.Check(parsed); issues.Err() == nil {
+		checkedAst = checked.NativeRep()
+	}
+
+	var target celast.Expr
+	var targetStart, targetStop int
+	celast.PreOrderVisit(parsedAst.Expr(), celast.NewExprVisitor(func(e celast.Expr) {
+		if e.Kind() == celast.IdentKind && strings.HasPrefix(e.AsIdent(), "@") {
+			return
+		}
+		start, stop, ok := expr.tokenRange(parsedAst, e.ID())
+		if !ok || pos < start || pos >= stop {
+			return
+		}
+		if target == nil || stop-start < targetStop-targetStart {
+			target, targetStart, targetStop = e, start, stop
+		}
+	}))
+	if target == nil {
+		return nil
+	}
+
+	kind := target.Kind()
+	var label string
+	switch kind {
+	case celast.IdentKind:
+		label = target.AsIdent()
+	case celast.SelectKind:
+		label = "." + target.AsSelect().FieldName()
+	case celast.CallKind:
+		label = target.AsCall().FunctionName()
+	default:
+		label = string(expr.runes[targetStart:targetStop])
+	}
+	if call, ok := parsedAst.SourceInfo().GetMacroCall(target.ID()); ok && call.Kind() == celast.CallKind {
+		kind, label = celast.CallKind, call.AsCall().FunctionName()
+	}
+
+	var contents strings.Builder
+	contents.WriteString("```cel\n")
+	contents.WriteString(label)
+	if checkedAst != nil {
+		contents.WriteString(": " + checkedAst.GetType(target.ID()).String())
+	}
+	contents.WriteString("\n```\n")
+
+	switch kind {
+	case celast.CallKind:
+		var signatures []string
+		if fn, ok := env.Functions()[label]; ok {
+			var overloadIDs []string
+			if checkedAst != nil {
+				overloadIDs = checkedAst.GetOverloadIDs(target.ID())
+			}
+			for _, overload := range fn.OverloadDecls() {
+				if len(overloadIDs) == 0 || slices.Contains(overloadIDs, overload.ID()) {
+					signatures = append(signatures, formatCelOverload(label, overload))
+				}
+			}
+		} else {
+			for _, macro := range celMacros {
+				if macro.name == label {
+					signatures = append(signatures, macro.signature)
+				}
+			}
+		}
+		if len(signatures) > 0 {
+			fmt.Fprintf(&contents, "```cel\n%s\n```\n", strings.Join(signatures, "\n"))
+		}
+		if doc, ok := celFunctionDocs[label]; ok {
+			contents.WriteString(doc + "\n")
+		}
+	case celast.SelectKind:
+		if checkedAst == nil {
+			break
+		}
+		sel := target.AsSelect()
+		if fd := celSelectedField(checkedAst.GetType(sel.Operand().ID()), sel.FieldName(), findDescriptor); fd != nil {
+			if str, err := format.PrintDescriptor(fd); err == nil {
+				fmt.Fprintf(&contents, "```protobuf\n%s\n```\n", str)
+			}
+		}
+	}
+
+	rng := positionsToRange(expr.sourcePos(targetStart, false), expr.sourcePos(targetStop, true))
+	return &protocol.Hover{
+		Contents: protocol.MarkupContent{
+			Kind:  protocol.Markdown,
+			Value: contents.String(),
+		},
+		Range: rng,
+	}
+}
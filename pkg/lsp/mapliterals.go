@@ -0,0 +1,238 @@
+package lsp
+
+import (
+	"context"
+	"strings"
+
+	"github.com/kralicky/protocompile/ast"
+	"github.com/kralicky/protocompile/linker"
+	"github.com/kralicky/tools-lite/gopls/pkg/protocol"
+)
+
+// simplifyMapLiteralFields transforms multiple entries of the same map field
+// in a message literal into a single field with an array of entries. For
+// example, given an option with a field 'map<string, string> labels':
+//
+//	option (foo) = {
+//	  labels: {
+//	    key:   "a"
+//	    value: "1"
+//	  }
+//	  labels: {key: "b", value: "2"}
+//	};
+//
+// becomes:
+//
+//	option (foo) = {
+//	  labels: [
+//	    {key: "a", value: "1"},
+//	    {key: "b", value: "2"}
+//	  ]
+//	};
+//
+// A map field whose value is already an array can instead be expanded back
+// into one field per entry.
+func simplifyMapLiteralFields(ctx context.Context, request *protocol.CodeActionParams, linkRes linker.Result, mapper *protocol.Mapper, results chan<- protocol.CodeAction) {
+	if request.Range == (protocol.Range{}) || request.Range.Start != request.Range.End {
+		return
+	}
+	offset, err := mapper.PositionOffset(request.Range.Start)
+	if err != nil {
+		return
+	}
+	fileNode := linkRes.AST()
+
+	var literal *ast.MessageLiteralNode
+	var target *ast.MessageFieldNode
+	ast.Inspect(fileNode, func(node ast.Node) bool {
+		if lit, ok := node.(*ast.MessageLiteralNode); ok {
+			for _, elem := range lit.Elements {
+				if elem.IsIncomplete() {
+					continue
+				}
+				info := fileNode.NodeInfo(elem.Name)
+				if offset >= info.Start().Offset && offset <= info.End().Offset+1 {
+					literal, target = lit, elem
+				}
+			}
+		}
+		return true
+	})
+	if target == nil {
+		return
+	}
+	fd := linkRes.FindFieldDescriptorByMessageFieldNode(target)
+	if fd == nil || !fd.IsMap() {
+		return
+	}
+
+	var fields []*ast.MessageFieldNode
+	for _, elem := range literal.Elements {
+		if !elem.IsIncomplete() && linkRes.FindFieldDescriptorByMessageFieldNode(elem) == fd {
+			fields = append(fields, elem)
+		}
+	}
+	enqueue := func(title string, rewrite func(w *sourceRewriter) bool) {
+		if action, ok := enqueueCheckedRewrite(title, protocol.RefactorRewrite, linkRes, mapper, rewrite); ok {
+			results <- action
+		}
+	}
+	if len(fields) > 1 {
+		enqueue("Simplify map literal fields", func(w *sourceRewriter) bool {
+			return w.collapseMapEntries(fields)
+		})
+	}
+	if arr := target.Val.GetArrayLiteral(); arr != nil && len(arr.FilterValues()) > 0 {
+		enqueue("Expand map literal entries", func(w *sourceRewriter) bool {
+			return w.expandMapEntries(target)
+		})
+	}
+}
+
+// mapEntries returns the map entries in the value of a map field, which is
+// either a single message literal or an array of them.
+func mapEntries(field *ast.MessageFieldNode) ([]*ast.MessageLiteralNode, bool) {
+	if lit := field.Val.GetMessageLiteral(); lit != nil {
+		return []*ast.MessageLiteralNode{lit}, true
+	}
+	arr := field.Val.GetArrayLiteral()
+	if arr == nil {
+		return nil, false
+	}
+	var entries []*ast.MessageLiteralNode
+	for _, val := range arr.FilterValues() {
+		lit := val.GetMessageLiteral()
+		if lit == nil {
+			return nil, false
+		}
+		entries = append(entries, lit)
+	}
+	return entries, true
+}
+
+// mapEntryText returns the text of a map entry in compact form, such as
+// '{key: "a", value: "1"}'. Entries containing comments are returned as-is.
+func (w *sourceRewriter) mapEntryText(entry *ast.MessageLiteralNode) string {
+	if w.hasComments(entry) {
+		start, end := w.span(entry)
+		return w.text(start, end)
+	}
+	parts := make([]string, 0, len(entry.Elements))
+	for _, elem := range entry.Elements {
+		nameStart, nameEnd := w.span(elem.Name)
+		valStart, valEnd := w.span(elem.Val)
+		parts = append(parts, w.text(nameStart, nameEnd)+": "+w.text(valStart, valEnd))
+	}
+	return "{" + strings.Join(parts, ", ") + "}"
+}
+
+// hasComments reports whether there are any comments within the node.
+func (w *sourceRewriter) hasComments(node ast.Node) bool {
+	found := false
+	ast.Inspect(node, func(n ast.Node) bool {
+		if found {
+			return false
+		}
+		if n == node {
+			return true
+		}
+		info := w.file.NodeInfo(n)
+		if info.LeadingComments().Len() > 0 || info.TrailingComments().Len() > 0 {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// fieldTerminator returns the separator following a message literal field,
+// if it has one.
+func (w *sourceRewriter) fieldTerminator(field *ast.MessageFieldNode) string {
+	if field.Semicolon == nil {
+		return ""
+	}
+	start, end := w.span(field.Semicolon)
+	return w.text(start, end)
+}
+
+// removalSpan returns the span to remove in order to delete the given
+// message literal field, including its line if nothing else is on it.
+func (w *sourceRewriter) removalSpan(field *ast.MessageFieldNode) (int, int) {
+	start, end := w.declSpan(field)
+	lineStart, lineEnd := w.lineSpan(start, end)
+	if lineStart != start || lineEnd != end {
+		return lineStart, lineEnd
+	}
+	for end < len(w.content) && w.content[end] == ' ' {
+		end++
+	}
+	return start, end
+}
+
+// onOwnLine reports whether nothing but the field is on its line.
+func (w *sourceRewriter) onOwnLine(field *ast.MessageFieldNode) bool {
+	start, end := w.declSpan(field)
+	lineStart, lineEnd := w.lineSpan(start, end)
+	return lineStart != start || lineEnd != end
+}
+
+// collapseMapEntries replaces the given fields with a single field whose value
+// is an array of all of their entries, in compact form.
+func (w *sourceRewriter) collapseMapEntries(fields []*ast.MessageFieldNode) bool {
+	var entries []string
+	for _, field := range fields {
+		lits, ok := mapEntries(field)
+		if !ok {
+			return false
+		}
+		for _, lit := range lits {
+			entries = append(entries, w.mapEntryText(lit))
+		}
+	}
+	first := fields[0]
+	nameStart, _ := w.span(first.Name)
+	valStart, _ := w.span(first.Val)
+	prefix := strings.TrimRight(w.text(nameStart, valStart), " \t")
+	if first.Sep == nil {
+		// a field with an array value requires a separator
+		prefix += ":"
+	}
+	var text string
+	if w.onOwnLine(first) {
+		indent := w.indentation(nameStart)
+		text = prefix + " [\n" + indent + "  " + strings.Join(entries, ",\n"+indent+"  ") + "\n" + indent + "]"
+	} else {
+		text = prefix + " [" + strings.Join(entries, ", ") + "]"
+	}
+	_, end := w.declSpan(first)
+	w.edit(nameStart, end, text+w.fieldTerminator(first))
+	for _, field := range fields[1:] {
+		start, end := w.removalSpan(field)
+		w.edit(start, end, "")
+	}
+	return true
+}
+
+// expandMapEntries replaces a map field whose value is an array of entries
+// with one field per entry.
+func (w *sourceRewriter) expandMapEntries(field *ast.MessageFieldNode) bool {
+	lits, ok := mapEntries(field)
+	if !ok || field.Val.GetArrayLiteral() == nil {
+		return false
+	}
+	nameStart, _ := w.span(field.Name)
+	nameEnd := w.file.NodeInfo(field.Name).End().Offset + 1
+	name := w.text(nameStart, nameEnd)
+	terminator := w.fieldTerminator(field)
+	lines := make([]string, len(lits))
+	for i, lit := range lits {
+		lines[i] = name + ": " + w.mapEntryText(lit) + terminator
+	}
+	sep := " "
+	if w.onOwnLine(field) {
+		sep = "\n" + w.indentation(nameStart)
+	}
+	_, end := w.declSpan(field)
+	w.edit(nameStart, end, strings.Join(lines, sep))
+	return true
+}
@@ -0,0 +1,584 @@
+package lsp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/kralicky/protocompile"
+	"github.com/kralicky/protocompile/ast"
+	"github.com/kralicky/protocompile/editions"
+	"github.com/kralicky/protocompile/linker"
+	"github.com/kralicky/protocompile/protoutil"
+	"github.com/kralicky/protols/pkg/format"
+	"github.com/kralicky/tools-lite/gopls/pkg/protocol"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// MigrateToEdition rewrites the source of a proto2 or proto3 file to use the
+// given edition. Labels, packed options, groups, and the behaviors implied by
+// the file's syntax are translated into equivalent features. Each feature is
+// set once at the file level to the value used by most elements, and is only
+// overridden on the elements that differ. The migrated source is compiled and
+// compared against the original before it is returned.
+func MigrateToEdition(res linker.Result, content []byte, edition descriptorpb.Edition) ([]byte, error) {
+	if !protocompile.IsEditionSupported(edition) || edition < descriptorpb.Edition_EDITION_2023 {
+		return nil, fmt.Errorf("unsupported edition %s", edition)
+	}
+	if res.Syntax() == protoreflect.Editions {
+		return nil, fmt.Errorf("%s already uses editions", res.Path())
+	}
+	if res.AST() == nil {
+		return nil, errors.New("no source available")
+	}
+	m := &editionMigration{
+		sourceRewriter: sourceRewriter{
+			res:     res,
+			file:    res.AST(),
+			content: content,
+		},
+		edition:   edition,
+		wants:     map[protoreflect.Name][]featureWant{},
+		overrides: map[protoreflect.Descriptor][]string{},
+	}
+	m.collectWants()
+	fileOptions, err := m.chooseFileFeatures()
+	if err != nil {
+		return nil, err
+	}
+	if err := m.rewrite(fileOptions); err != nil {
+		return nil, err
+	}
+	migrated, err := applySourceEdits(content, m.edits)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := format.Format(bytes.NewReader(migrated), &buf); err != nil {
+		return nil, fmt.Errorf("failed to format migrated source: %w", err)
+	}
+	newRes, err := compileMigratedSource(res, buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	if err := checkMigrationEquivalence(res, newRes); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// migratableFeatures lists the features which may be set at the file level,
+// in the order they are written.
+var migratableFeatures = []protoreflect.Name{
+	"field_presence",
+	"repeated_field_encoding",
+	"utf8_validation",
+	"enum_type",
+	"json_format",
+}
+
+type featureWant struct {
+	desc  protoreflect.Descriptor
+	value protoreflect.EnumNumber
+}
+
+type editionMigration struct {
+	sourceRewriter
+	edition descriptorpb.Edition
+
+	// wants holds, for each feature in migratableFeatures, the value each
+	// element needs in order to keep its current behavior.
+	wants map[protoreflect.Name][]featureWant
+	// overrides holds the features to set directly on individual elements,
+	// formatted as 'features.name = VALUE'.
+	overrides map[protoreflect.Descriptor][]string
+}
+
+func (m *editionMigration) want(desc protoreflect.Descriptor, feature protoreflect.Name, value protoreflect.EnumNumber) {
+	m.wants[feature] = append(m.wants[feature], featureWant{desc: desc, value: value})
+}
+
+func (m *editionMigration) override(desc protoreflect.Descriptor, feature protoreflect.Name, value protoreflect.EnumNumber) {
+	m.overrides[desc] = append(m.overrides[desc], fmt.Sprintf("features.%s = %s", feature, featureValueName(feature, value)))
+}
+
+func featureValueName(feature protoreflect.Name, value protoreflect.EnumNumber) protoreflect.Name {
+	return featureSetDesc.Fields().ByName(feature).Enum().Values().ByNumber(value).Name()
+}
+
+func (m *editionMigration) collectWants() {
+	proto3 := m.res.Syntax() == protoreflect.Proto3
+	jsonFormat := protoreflect.EnumNumber(descriptorpb.FeatureSet_LEGACY_BEST_EFFORT)
+	enumType := protoreflect.EnumNumber(descriptorpb.FeatureSet_CLOSED)
+	utf8 := protoreflect.EnumNumber(descriptorpb.FeatureSet_NONE)
+	if proto3 {
+		jsonFormat = protoreflect.EnumNumber(descriptorpb.FeatureSet_ALLOW)
+		enumType = protoreflect.EnumNumber(descriptorpb.FeatureSet_OPEN)
+		utf8 = protoreflect.EnumNumber(descriptorpb.FeatureSet_VERIFY)
+	}
+
+	visitField := func(fd protoreflect.FieldDescriptor) {
+		isMessage := fd.Kind() == protoreflect.MessageKind || fd.Kind() == protoreflect.GroupKind
+		switch {
+		case fd.Cardinality() == protoreflect.Required:
+			m.override(fd, "field_presence", protoreflect.EnumNumber(descriptorpb.FeatureSet_LEGACY_REQUIRED))
+		case fd.Cardinality() == protoreflect.Repeated, isMessage, fd.IsExtension(),
+			fd.ContainingOneof() != nil && !fd.ContainingOneof().IsSynthetic():
+		case proto3 && !fd.HasOptionalKeyword():
+			m.want(fd, "field_presence", protoreflect.EnumNumber(descriptorpb.FeatureSet_IMPLICIT))
+		default:
+			m.want(fd, "field_presence", protoreflect.EnumNumber(descriptorpb.FeatureSet_EXPLICIT))
+		}
+		if fd.Cardinality() == protoreflect.Repeated && !fd.IsMap() && isPackableKind(fd.Kind()) {
+			if fd.IsPacked() {
+				m.want(fd, "repeated_field_encoding", protoreflect.EnumNumber(descriptorpb.FeatureSet_PACKED))
+			} else {
+				m.want(fd, "repeated_field_encoding", protoreflect.EnumNumber(descriptorpb.FeatureSet_EXPANDED))
+			}
+		}
+		if isStringOrStringMap(fd) {
+			m.want(fd, "utf8_validation", utf8)
+		}
+		if fd.Kind() == protoreflect.GroupKind {
+			m.override(fd, "message_encoding", protoreflect.EnumNumber(descriptorpb.FeatureSet_DELIMITED))
+		}
+	}
+	visitEnum := func(ed protoreflect.EnumDescriptor) {
+		m.want(ed, "enum_type", enumType)
+		m.want(ed, "json_format", jsonFormat)
+	}
+	var visitMessage func(md protoreflect.MessageDescriptor)
+	visitMessage = func(md protoreflect.MessageDescriptor) {
+		if md.IsMapEntry() {
+			return
+		}
+		m.want(md, "json_format", jsonFormat)
+		for i := 0; i < md.Fields().Len(); i++ {
+			visitField(md.Fields().Get(i))
+		}
+		for i := 0; i < md.Extensions().Len(); i++ {
+			visitField(md.Extensions().Get(i))
+		}
+		for i := 0; i < md.Enums().Len(); i++ {
+			visitEnum(md.Enums().Get(i))
+		}
+		for i := 0; i < md.Messages().Len(); i++ {
+			visitMessage(md.Messages().Get(i))
+		}
+	}
+	for i := 0; i < m.res.Messages().Len(); i++ {
+		visitMessage(m.res.Messages().Get(i))
+	}
+	for i := 0; i < m.res.Enums().Len(); i++ {
+		visitEnum(m.res.Enums().Get(i))
+	}
+	for i := 0; i < m.res.Extensions().Len(); i++ {
+		visitField(m.res.Extensions().Get(i))
+	}
+}
+
+// chooseFileFeatures picks the file-level value of each feature that
+// minimizes the number of per-element overrides, preferring the edition's
+// default on ties. It returns the file options to add.
+func (m *editionMigration) chooseFileFeatures() ([]string, error) {
+	var fileOptions []string
+	for _, name := range migratableFeatures {
+		feature := featureSetDesc.Fields().ByName(name)
+		def, err := protoutil.GetFeatureDefault(m.edition, feature)
+		if err != nil {
+			return nil, err
+		}
+		counts := map[protoreflect.EnumNumber]int{}
+		for _, w := range m.wants[name] {
+			counts[w.value]++
+		}
+		best := def.Enum()
+		values := feature.Enum().Values()
+		for i := 0; i < values.Len(); i++ {
+			if v := values.Get(i).Number(); counts[v] > counts[best] {
+				best = v
+			}
+		}
+		if best != def.Enum() {
+			fileOptions = append(fileOptions, fmt.Sprintf("option features.%s = %s;", name, featureValueName(name, best)))
+		}
+		for _, w := range m.wants[name] {
+			if w.value != best {
+				m.override(w.desc, name, w.value)
+			}
+		}
+	}
+	return fileOptions, nil
+}
+
+func (m *editionMigration) rewrite(fileOptions []string) error {
+	editionDecl := fmt.Sprintf("edition = %q;", strings.TrimPrefix(m.edition.String(), "EDITION_"))
+	headerEnd := 0
+	if m.file.Syntax != nil {
+		start, end := m.declSpan(m.file.Syntax)
+		m.edit(start, end, editionDecl)
+		headerEnd = end
+	} else {
+		m.edit(0, 0, editionDecl+"\n\n")
+	}
+	for _, decl := range m.file.Decls {
+		switch {
+		case decl.GetPackage() != nil, decl.GetImport() != nil, decl.GetOption() != nil:
+			_, headerEnd = m.declSpan(decl.Unwrap())
+		}
+	}
+	if len(fileOptions) > 0 {
+		offset, _ := m.insertionPoint(headerEnd)
+		m.edit(offset, offset, "\n\n"+strings.Join(fileOptions, "\n"))
+	}
+
+	for i := 0; i < m.res.Messages().Len(); i++ {
+		if err := m.rewriteMessage(m.res.Messages().Get(i)); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < m.res.Enums().Len(); i++ {
+		m.rewriteEnum(m.res.Enums().Get(i))
+	}
+	for i := 0; i < m.res.Extensions().Len(); i++ {
+		if err := m.rewriteField(m.res.Extensions().Get(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *editionMigration) rewriteEnum(ed protoreflect.EnumDescriptor) {
+	edp, ok := ed.(protoutil.DescriptorProtoWrapper).AsProto().(*descriptorpb.EnumDescriptorProto)
+	if !ok {
+		return
+	}
+	node := m.res.EnumNode(edp)
+	if node == nil || len(m.overrides[ed]) == 0 {
+		return
+	}
+	_, end := m.span(node.OpenBrace)
+	m.insertDecls(end, optionDecls(m.overrides[ed]))
+}
+
+// rewriteMessage rewrites the contents of a message. Nested messages are
+// rewritten before the fields that reference them, so that the edits made
+// inside a group's body are in place before the group itself is moved.
+func (m *editionMigration) rewriteMessage(md protoreflect.MessageDescriptor) error {
+	if md.IsMapEntry() {
+		return nil
+	}
+	mdp, ok := md.(protoutil.DescriptorProtoWrapper).AsProto().(*descriptorpb.DescriptorProto)
+	if !ok {
+		return nil
+	}
+	node := m.res.MessageNode(mdp)
+	if node == nil {
+		return nil
+	}
+	if len(m.overrides[md]) > 0 {
+		var openBrace ast.Node
+		switch n := node.Unwrap().(type) {
+		case *ast.MessageNode:
+			openBrace = n.OpenBrace
+		case *ast.GroupNode:
+			openBrace = n.OpenBrace
+		}
+		if openBrace != nil {
+			_, end := m.span(openBrace)
+			m.insertDecls(end, optionDecls(m.overrides[md]))
+		}
+	}
+	for i := 0; i < md.Messages().Len(); i++ {
+		if err := m.rewriteMessage(md.Messages().Get(i)); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < md.Enums().Len(); i++ {
+		m.rewriteEnum(md.Enums().Get(i))
+	}
+	for i := 0; i < md.Fields().Len(); i++ {
+		if err := m.rewriteField(md.Fields().Get(i)); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < md.Extensions().Len(); i++ {
+		if err := m.rewriteField(md.Extensions().Get(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func optionDecls(features []string) []string {
+	decls := make([]string, len(features))
+	for i, f := range features {
+		decls[i] = "option " + f + ";"
+	}
+	return decls
+}
+
+func (m *editionMigration) rewriteField(fd protoreflect.FieldDescriptor) error {
+	fdp, ok := fd.(protoutil.DescriptorProtoWrapper).AsProto().(*descriptorpb.FieldDescriptorProto)
+	if !ok {
+		return nil
+	}
+	node := m.res.FieldNode(fdp)
+	if node == nil {
+		return nil
+	}
+	switch n := node.Unwrap().(type) {
+	case *ast.FieldNode:
+		if n.Label != nil && (n.Label.Val == "optional" || n.Label.Val == "required") {
+			start, _ := m.span(n.Label)
+			end, _ := m.span(n.FieldType)
+			m.edit(start, end, "")
+		}
+		_, tagEnd := m.span(n.Tag)
+		m.rewriteCompactOptions(n.Options, tagEnd, []string{"packed"}, m.overrides[fd])
+	case *ast.MapFieldNode:
+		_, tagEnd := m.span(n.Tag)
+		m.rewriteCompactOptions(n.Options, tagEnd, []string{"packed"}, m.overrides[fd])
+	case *ast.GroupNode:
+		return m.rewriteGroup(fd, fdp, n)
+	}
+	return nil
+}
+
+// rewriteGroup splits a group into a message and a delimited field. Groups
+// declared directly in a message keep their place; groups in a oneof or an
+// extend block are replaced by the field, and the message is declared just
+// before the enclosing oneof or extend block.
+func (m *editionMigration) rewriteGroup(fd protoreflect.FieldDescriptor, fdp *descriptorpb.FieldDescriptorProto, n *ast.GroupNode) error {
+	var field strings.Builder
+	if fd.Cardinality() == protoreflect.Repeated {
+		field.WriteString("repeated ")
+	}
+	tagStart, tagEnd := m.span(n.Tag)
+	fmt.Fprintf(&field, "%s %s = %s", n.Name.Val, fd.Name(), m.text(tagStart, tagEnd))
+	if opts, _ := m.compactOptions(n.Options, []string{"packed"}, m.overrides[fd]); len(opts) > 0 {
+		field.WriteString(" [" + strings.Join(opts, ", ") + "]")
+	}
+	field.WriteString(";")
+
+	declStart, declEnd := m.declSpan(n)
+	bodyStart, _ := m.span(n.OpenBrace)
+	_, bodyEnd := m.span(n.CloseBrace)
+
+	var container ast.Node
+	if oneof := fd.ContainingOneof(); oneof != nil && !oneof.IsSynthetic() {
+		if odp, ok := oneof.(protoutil.DescriptorProtoWrapper).AsProto().(*descriptorpb.OneofDescriptorProto); ok {
+			container = m.res.OneofNode(odp)
+		}
+	} else if fd.IsExtension() {
+		container = m.res.FieldExtendeeNode(fdp)
+	}
+	if container == nil {
+		m.edit(declStart, bodyStart, "message "+n.Name.Val+" ")
+		m.edit(bodyEnd, declEnd, "\n"+field.String())
+		return nil
+	}
+
+	var bodyEdits, rest []sourceEdit
+	for _, e := range m.edits {
+		if e.start >= bodyStart && e.end <= bodyEnd {
+			e.start -= bodyStart
+			e.end -= bodyStart
+			bodyEdits = append(bodyEdits, e)
+		} else {
+			rest = append(rest, e)
+		}
+	}
+	body, err := applySourceEdits(m.content[bodyStart:bodyEnd], bodyEdits)
+	if err != nil {
+		return err
+	}
+	m.edits = rest
+	m.edit(declStart, declEnd, field.String())
+	containerStart, _ := m.span(container)
+	m.edit(containerStart, containerStart, "message "+n.Name.Val+" "+string(body)+"\n")
+	return nil
+}
+
+// compileMigratedSource compiles the migrated source in place of the original
+// file, resolving its imports from the original file's dependencies.
+func compileMigratedSource(res linker.Result, src []byte) (linker.Result, error) {
+	deps := map[string]protoreflect.FileDescriptor{}
+	var collect func(imports protoreflect.FileImports)
+	collect = func(imports protoreflect.FileImports) {
+		for i := 0; i < imports.Len(); i++ {
+			imp := imports.Get(i).FileDescriptor
+			if _, ok := deps[imp.Path()]; ok {
+				continue
+			}
+			deps[imp.Path()] = imp
+			collect(imp.Imports())
+		}
+	}
+	collect(res.Imports())
+
+	compiler := protocompile.Compiler{
+		Resolver: protocompile.CompositeResolver{
+			&protocompile.SourceResolver{
+				Accessor: protocompile.SourceAccessorFromMap(map[string]string{res.Path(): string(src)}),
+			},
+			protocompile.ResolverFunc(func(path protocompile.UnresolvedPath, _ protocompile.ImportContext) (protocompile.SearchResult, error) {
+				fd, ok := deps[string(path)]
+				if !ok {
+					return protocompile.SearchResult{}, fmt.Errorf("file not found: %s", path)
+				}
+				return protocompile.SearchResult{ResolvedPath: protocompile.ResolvedPath(path), Proto: protodesc.ToFileDescriptorProto(fd)}, nil
+			}),
+		},
+		RetainASTs: true,
+	}
+	files, err := compiler.Compile(context.Background(), protocompile.ResolvedPath(res.Path()))
+	if err != nil {
+		return nil, fmt.Errorf("migrated source does not compile: %w", err)
+	}
+	return files.Files[0].(linker.Result), nil
+}
+
+// checkMigrationEquivalence reports an error if the two files do not describe
+// the same elements with the same behavior.
+func checkMigrationEquivalence(before, after protoreflect.FileDescriptor) error {
+	want, got := describeSemantics(before), describeSemantics(after)
+	var diffs []string
+	for name, w := range want {
+		if g, ok := got[name]; !ok {
+			diffs = append(diffs, fmt.Sprintf("%s: missing after migration", name))
+		} else if g != w {
+			diffs = append(diffs, fmt.Sprintf("%s: %s, want %s", name, g, w))
+		}
+	}
+	for name := range got {
+		if _, ok := want[name]; !ok {
+			diffs = append(diffs, fmt.Sprintf("%s: unexpected after migration", name))
+		}
+	}
+	if len(diffs) == 0 {
+		return nil
+	}
+	slices.Sort(diffs)
+	return fmt.Errorf("migrated file is not equivalent to the original:\n  %s", strings.Join(diffs, "\n  "))
+}
+
+func describeSemantics(f protoreflect.FileDescriptor) map[protoreflect.FullName]string {
+	out := map[protoreflect.FullName]string{}
+	describeField := func(fd protoreflect.FieldDescriptor) {
+		var typ string
+		switch fd.Kind() {
+		case protoreflect.MessageKind, protoreflect.GroupKind:
+			typ = "message " + string(fd.Message().FullName())
+		case protoreflect.EnumKind:
+			typ = "enum " + string(fd.Enum().FullName())
+		default:
+			typ = fd.Kind().String()
+		}
+		var oneof protoreflect.Name
+		if od := fd.ContainingOneof(); od != nil && !od.IsSynthetic() {
+			oneof = od.Name()
+		}
+		var def string
+		if fd.HasDefault() {
+			def = fmt.Sprint(fd.Default().Interface())
+		}
+		var utf8 protoreflect.Name
+		if isStringOrStringMap(fd) {
+			utf8 = resolvedFeatureName(fd, "utf8_validation")
+		}
+		out[fd.FullName()] = fmt.Sprintf("number=%d type=%q cardinality=%s presence=%t packed=%t delimited=%t utf8=%q json=%q default=%q oneof=%q",
+			fd.Number(), typ, fd.Cardinality(), fd.HasPresence(), fd.IsPacked(), fd.Kind() == protoreflect.GroupKind,
+			utf8, fd.JSONName(), def, oneof)
+	}
+	describeEnum := func(ed protoreflect.EnumDescriptor) {
+		values := make([]string, ed.Values().Len())
+		for i := range values {
+			v := ed.Values().Get(i)
+			values[i] = fmt.Sprintf("%s=%d", v.Name(), v.Number())
+		}
+		out[ed.FullName()] = fmt.Sprintf("closed=%t json_format=%s values=%s",
+			ed.IsClosed(), resolvedFeatureName(ed, "json_format"), strings.Join(values, ","))
+	}
+	var describeMessage func(md protoreflect.MessageDescriptor)
+	describeMessage = func(md protoreflect.MessageDescriptor) {
+		if md.IsMapEntry() {
+			return
+		}
+		out[md.FullName()] = fmt.Sprintf("json_format=%s", resolvedFeatureName(md, "json_format"))
+		for i := 0; i < md.Fields().Len(); i++ {
+			describeField(md.Fields().Get(i))
+		}
+		for i := 0; i < md.Extensions().Len(); i++ {
+			describeField(md.Extensions().Get(i))
+		}
+		for i := 0; i < md.Enums().Len(); i++ {
+			describeEnum(md.Enums().Get(i))
+		}
+		for i := 0; i < md.Messages().Len(); i++ {
+			describeMessage(md.Messages().Get(i))
+		}
+	}
+	for i := 0; i < f.Messages().Len(); i++ {
+		describeMessage(f.Messages().Get(i))
+	}
+	for i := 0; i < f.Enums().Len(); i++ {
+		describeEnum(f.Enums().Get(i))
+	}
+	for i := 0; i < f.Extensions().Len(); i++ {
+		describeField(f.Extensions().Get(i))
+	}
+	return out
+}
+
+func resolvedFeatureName(desc protoreflect.Descriptor, name protoreflect.Name) protoreflect.Name {
+	feature := featureSetDesc.Fields().ByName(name)
+	val, err := protoutil.ResolveFeature(desc, feature)
+	if err != nil {
+		return ""
+	}
+	return featureValueName(name, val.Enum())
+}
+
+// migrateToEdition offers to migrate a proto2 or proto3 file to the latest
+// supported edition when the cursor is on its syntax declaration.
+func migrateToEdition(ctx context.Context, request *protocol.CodeActionParams, linkRes linker.Result, mapper *protocol.Mapper, results chan<- protocol.CodeAction) {
+	if request.Range == (protocol.Range{}) || request.Range.Start != request.Range.End {
+		return
+	}
+	fileNode := linkRes.AST()
+	if fileNode == nil || fileNode.Syntax == nil || linkRes.Syntax() == protoreflect.Editions {
+		return
+	}
+	offset, err := mapper.PositionOffset(request.Range.Start)
+	if err != nil {
+		return
+	}
+	info := fileNode.NodeInfo(fileNode.Syntax)
+	if offset < info.Start().Offset || offset > info.End().Offset+1 {
+		return
+	}
+	edition := editions.MaxSupportedEdition
+	title := fmt.Sprintf("Migrate to edition %s", strings.TrimPrefix(edition.String(), "EDITION_"))
+	results <- actionQueue.enqueue(title, protocol.RefactorRewrite, mapper.URI, fileNode.Version(), func(ca *protocol.CodeAction) error {
+		migrated, err := MigrateToEdition(linkRes, mapper.Content, edition)
+		if err != nil {
+			return err
+		}
+		rng, err := mapper.OffsetRange(0, len(mapper.Content))
+		if err != nil {
+			return err
+		}
+		ca.Edit = &protocol.WorkspaceEdit{
+			Changes: map[protocol.DocumentURI][]protocol.TextEdit{
+				mapper.URI: {{Range: rng, NewText: string(migrated)}},
+			},
+		}
+		return nil
+	})
+}
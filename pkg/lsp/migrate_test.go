@@ -0,0 +1,140 @@
+package lsp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func TestMigrateToEdition(t *testing.T) {
+	cases := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "proto2",
+			src: `
+syntax = "proto2";
+
+package test;
+
+message Foo {
+  required string name = 1;
+  optional int32 count = 2 [default = 5];
+  repeated int32 values = 3;
+  repeated int32 packed_values = 4 [packed = true, deprecated = true];
+  optional group Result = 5 {
+    optional string url = 1;
+  }
+  oneof kind {
+    string text = 6;
+    group Blob = 7 {
+      repeated bytes data = 1;
+    }
+  }
+  optional Kind k = 8;
+  extensions 100 to 200;
+}
+
+enum Kind {
+  KIND_A = 1;
+  KIND_B = 2;
+}
+
+extend Foo {
+  optional string ext = 100;
+}
+`,
+			want: `
+edition = "2023";
+
+package test;
+
+option features.enum_type   = CLOSED;
+option features.json_format = LEGACY_BEST_EFFORT;
+
+option features.utf8_validation = NONE;
+
+message Foo {
+  string         name          = 1 [features.field_presence = LEGACY_REQUIRED];
+  int32          count         = 2 [default = 5];
+  repeated int32 values        = 3 [features.repeated_field_encoding = EXPANDED];
+  repeated int32 packed_values = 4 [deprecated = true];
+  message Result {
+    string url = 1;
+  }
+  Result result = 5 [features.message_encoding = DELIMITED];
+  message Blob {
+    repeated bytes data = 1;
+  }
+  oneof kind {
+    string text = 6;
+    Blob   blob = 7 [features.message_encoding = DELIMITED];
+  }
+  Kind k = 8;
+  extensions 100 to 200;
+}
+
+enum Kind {
+  KIND_A = 1;
+  KIND_B = 2;
+}
+
+extend Foo {
+  string ext = 100;
+}
+`,
+		},
+		{
+			name: "proto3",
+			src: `
+syntax = "proto3";
+
+package test;
+
+message Foo {
+  string name = 1;
+  int32 count = 2;
+  optional int32 maybe = 3;
+  repeated int32 values = 4 [packed = false];
+  repeated int32 more_values = 5;
+  Kind kind = 6;
+}
+
+enum Kind {
+  KIND_UNSPECIFIED = 0;
+}
+`,
+			want: `
+edition = "2023";
+
+package test;
+
+option features.field_presence = IMPLICIT;
+
+message Foo {
+  string         name        = 1;
+  int32          count       = 2;
+  int32          maybe       = 3 [features.field_presence = EXPLICIT];
+  repeated int32 values      = 4 [features.repeated_field_encoding = EXPANDED];
+  repeated int32 more_values = 5;
+  Kind           kind        = 6;
+}
+
+enum Kind {
+  KIND_UNSPECIFIED = 0;
+}
+`,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			res := compileFile(t, c.src)
+			out, err := MigrateToEdition(res, []byte(c.src), descriptorpb.Edition_EDITION_2023)
+			require.NoError(t, err)
+			require.Equal(t, c.want[1:], string(out))
+		})
+	}
+}
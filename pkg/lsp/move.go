@@ -0,0 +1,877 @@
+package lsp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/kralicky/protocompile/ast"
+	"github.com/kralicky/protocompile/linker"
+	"github.com/kralicky/protocompile/protoutil"
+	"github.com/kralicky/protols/pkg/format"
+	"github.com/kralicky/tools-lite/gopls/pkg/protocol"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// fileMove computes the edits needed to move a top-level message, enum, or
+// service declaration from its file into another (new or existing) file.
+type fileMove struct {
+	byPath    map[string]linker.Result
+	contents  func(path string) ([]byte, error)
+	rewriters map[string]*sourceRewriter
+
+	desc     protoreflect.Descriptor
+	source   linker.Result
+	destPath string
+	// dest is nil if the destination file does not exist yet.
+	dest            linker.Result
+	srcPkg, destPkg protoreflect.FullName
+	moved           map[protoreflect.Descriptor]bool
+
+	// The region of the source file holding the declaration, including its
+	// leading comments.
+	start, end int
+	refs       []typeReference
+	// The moved declaration, with its references qualified for the
+	// destination package.
+	movedText []byte
+	// Imports that the destination file needs in order to resolve the
+	// references inside the moved declaration.
+	destImports []string
+	// Imports to add to and remove from each file, keyed by path.
+	addImports, removeImports map[string][]string
+}
+
+// moveToFile returns the edits to each existing file, keyed by path, that
+// move the top-level declaration with the given name into the file at
+// destPath. If there is no file at destPath, the contents of the new file are
+// returned as well.
+func moveToFile(files linker.Files, contents func(path string) ([]byte, error), name protoreflect.FullName, destPath string) (map[string][]sourceEdit, []byte, error) {
+	m := &fileMove{
+		byPath:    map[string]linker.Result{},
+		contents:  contents,
+		rewriters: map[string]*sourceRewriter{},
+		destPath:  destPath,
+		moved:     map[protoreflect.Descriptor]bool{},
+
+		addImports:    map[string][]string{},
+		removeImports: map[string][]string{},
+	}
+	for _, f := range files {
+		if res, ok := f.(linker.Result); ok && !f.IsPlaceholder() {
+			m.byPath[f.Path()] = res
+		}
+	}
+	desc, err := files.AsResolver().FindDescriptorByName(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch desc.(type) {
+	case protoreflect.MessageDescriptor, protoreflect.EnumDescriptor, protoreflect.ServiceDescriptor:
+	default:
+		return nil, nil, fmt.Errorf("cannot move %s: only messages, enums, and services can be moved", name)
+	}
+	if _, ok := desc.Parent().(protoreflect.FileDescriptor); !ok {
+		return nil, nil, fmt.Errorf("cannot move %s: only top-level declarations can be moved", name)
+	}
+	m.desc = desc
+	m.source = m.byPath[desc.ParentFile().Path()]
+	if m.source == nil {
+		return nil, nil, fmt.Errorf("cannot move %s: source file %s is not available", name, desc.ParentFile().Path())
+	}
+	if destPath == m.source.Path() {
+		return nil, nil, fmt.Errorf("%s is already in %s", name, destPath)
+	}
+	m.srcPkg = m.source.Package()
+	m.destPkg = m.srcPkg
+	if dest := m.byPath[destPath]; dest != nil {
+		m.dest = dest
+		m.destPkg = dest.Package()
+		srcProto, destProto := m.source.FileDescriptorProto(), dest.FileDescriptorProto()
+		if srcProto.GetSyntax() != destProto.GetSyntax() || srcProto.GetEdition() != destProto.GetEdition() {
+			return nil, nil, fmt.Errorf("cannot move %s: %s and %s use different syntax", name, m.source.Path(), destPath)
+		}
+	}
+	if newName := m.newFullName(desc); newName != name {
+		if _, err := files.AsResolver().FindDescriptorByName(newName); err == nil {
+			return nil, nil, fmt.Errorf("cannot move %s: %s already exists", name, newName)
+		}
+	}
+	m.collectMoved(desc)
+
+	if err := m.moveDecl(); err != nil {
+		return nil, nil, err
+	}
+	if err := m.updateImports(); err != nil {
+		return nil, nil, err
+	}
+	newFile, err := m.finish()
+	if err != nil {
+		return nil, nil, err
+	}
+	edits := map[string][]sourceEdit{}
+	for path, w := range m.rewriters {
+		if len(w.edits) > 0 {
+			edits[path] = w.edits
+		}
+	}
+	return edits, newFile, nil
+}
+
+func (m *fileMove) rewriter(res linker.Result) (*sourceRewriter, error) {
+	if w, ok := m.rewriters[res.Path()]; ok {
+		return w, nil
+	}
+	content, err := m.contents(res.Path())
+	if err != nil {
+		return nil, err
+	}
+	w := &sourceRewriter{res: res, file: res.AST(), content: content}
+	m.rewriters[res.Path()] = w
+	return w, nil
+}
+
+func (m *fileMove) collectMoved(desc protoreflect.Descriptor) {
+	m.moved[desc] = true
+	if md, ok := desc.(protoreflect.MessageDescriptor); ok {
+		for i := 0; i < md.Messages().Len(); i++ {
+			m.collectMoved(md.Messages().Get(i))
+		}
+		for i := 0; i < md.Enums().Len(); i++ {
+			m.moved[md.Enums().Get(i)] = true
+		}
+		for i := 0; i < md.Extensions().Len(); i++ {
+			m.moved[md.Extensions().Get(i)] = true
+		}
+	}
+}
+
+// newFullName returns the name the given descriptor will have once it has
+// been moved into the destination package.
+func (m *fileMove) newFullName(desc protoreflect.Descriptor) protoreflect.FullName {
+	if !m.moved[desc] && desc != m.desc || m.srcPkg == m.destPkg {
+		return desc.FullName()
+	}
+	rel := string(desc.FullName())
+	if m.srcPkg != "" {
+		rel = strings.TrimPrefix(rel, string(m.srcPkg)+".")
+	}
+	if m.destPkg == "" {
+		return protoreflect.FullName(rel)
+	}
+	return protoreflect.FullName(string(m.destPkg) + "." + rel)
+}
+
+func (m *fileMove) inRegion(res linker.Result, start, end int) bool {
+	return res == m.source && start >= m.start && end <= m.end
+}
+
+// moveDecl removes the declaration from the source file and qualifies the
+// type references inside and outside of it for the destination package.
+func (m *fileMove) moveDecl() error {
+	w, err := m.rewriter(m.source)
+	if err != nil {
+		return err
+	}
+	node := declNodeForDescriptor(m.source, m.desc)
+	if node == nil {
+		return fmt.Errorf("could not find the declaration of %s", m.desc.FullName())
+	}
+	m.start, m.end = w.lineSpan(w.attachedDeclSpan(node))
+
+	m.refs, err = m.typeReferences()
+	if err != nil {
+		return err
+	}
+	var regionEdits []sourceEdit
+	for _, ref := range m.refs {
+		text, ok := m.requalify(ref)
+		if !ok {
+			continue
+		}
+		if m.inRegion(ref.res, ref.start, ref.end) {
+			regionEdits = append(regionEdits, sourceEdit{start: ref.start - m.start, end: ref.end - m.start, text: text})
+			continue
+		}
+		rw, err := m.rewriter(ref.res)
+		if err != nil {
+			return err
+		}
+		rw.edit(ref.start, ref.end, text)
+	}
+	m.movedText, err = applySourceEdits(w.content[m.start:m.end], regionEdits)
+	if err != nil {
+		return err
+	}
+
+	w.removeLines(m.start, m.end)
+	return nil
+}
+
+// typeReference is a reference to a message, enum, or extension by name.
+type typeReference struct {
+	res        linker.Result
+	desc       protoreflect.Descriptor
+	start, end int
+	text       string
+}
+
+// typeReferences returns the references to the moved descriptors in every
+// file, and the references in the source file to the descriptors visible to
+// it. Where one reference contains another (such as a qualified name and its
+// components), only the outermost one is returned.
+func (m *fileMove) typeReferences() ([]typeReference, error) {
+	var refs []typeReference
+	add := func(res linker.Result, desc protoreflect.Descriptor) error {
+		found := res.FindReferences(desc)
+		if len(found) == 0 {
+			return nil
+		}
+		w, err := m.rewriter(res)
+		if err != nil {
+			return err
+		}
+		for _, ref := range found {
+			node := referenceNameNode(ref.Node)
+			if node == nil {
+				continue
+			}
+			start, end := w.span(node)
+			refs = append(refs, typeReference{res: res, desc: desc, start: start, end: end, text: w.text(start, end)})
+		}
+		return nil
+	}
+	paths := make([]string, 0, len(m.byPath))
+	for path := range m.byPath {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+	for _, path := range paths {
+		res := m.byPath[path]
+		if res == m.source {
+			continue
+		}
+		for desc := range m.moved {
+			if err := add(res, desc); err != nil {
+				return nil, err
+			}
+		}
+	}
+	visited := map[string]bool{}
+	var visit func(f protoreflect.FileDescriptor) error
+	visit = func(f protoreflect.FileDescriptor) error {
+		if visited[f.Path()] {
+			return nil
+		}
+		visited[f.Path()] = true
+		var err error
+		rangeTypeDescriptors(f, func(desc protoreflect.Descriptor) bool {
+			err = add(m.source, desc)
+			return err == nil
+		})
+		if err != nil {
+			return err
+		}
+		for i := 0; i < f.Imports().Len(); i++ {
+			if err := visit(f.Imports().Get(i).FileDescriptor); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := visit(m.source); err != nil {
+		return nil, err
+	}
+	return outermostReferences(refs), nil
+}
+
+// outermostReferences sorts the references by position, dropping those that
+// are contained within another reference, such as the components of a
+// qualified name.
+func outermostReferences(refs []typeReference) []typeReference {
+	slices.SortFunc(refs, func(a, b typeReference) int {
+		if c := strings.Compare(a.res.Path(), b.res.Path()); c != 0 {
+			return c
+		}
+		if a.start != b.start {
+			return a.start - b.start
+		}
+		return b.end - a.end
+	})
+	outermost := refs[:0]
+	for _, ref := range refs {
+		if n := len(outermost); n > 0 {
+			prev := outermost[n-1]
+			if prev.res == ref.res && ref.start >= prev.start && ref.end <= prev.end {
+				continue
+			}
+		}
+		outermost = append(outermost, ref)
+	}
+	return outermost
+}
+
+// referenceNameNode returns the identifier holding the referenced name within
+// a node returned by FindReferences.
+func referenceNameNode(node ast.Node) ast.Node {
+	switch node := ast.Unwrap(node).(type) {
+	case *ast.IdentNode, *ast.CompoundIdentNode:
+		return node
+	case *ast.ExtendNode:
+		return ast.Unwrap(node.Extendee)
+	case *ast.RPCTypeNode:
+		return ast.Unwrap(node.MessageType)
+	case *ast.FieldReferenceNode:
+		return ast.Unwrap(node.Name)
+	case *ast.MapTypeNode:
+		return ast.Unwrap(node.ValueType)
+	}
+	return nil
+}
+
+// rangeTypeDescriptors calls fn for each message, enum, and extension
+// declared in the file, including nested ones.
+func rangeTypeDescriptors(f protoreflect.FileDescriptor, fn func(protoreflect.Descriptor) bool) {
+	var rangeMessages func(msgs protoreflect.MessageDescriptors, enums protoreflect.EnumDescriptors, exts protoreflect.ExtensionDescriptors) bool
+	rangeMessages = func(msgs protoreflect.MessageDescriptors, enums protoreflect.EnumDescriptors, exts protoreflect.ExtensionDescriptors) bool {
+		for i := 0; i < enums.Len(); i++ {
+			if !fn(enums.Get(i)) {
+				return false
+			}
+		}
+		for i := 0; i < exts.Len(); i++ {
+			if !fn(exts.Get(i)) {
+				return false
+			}
+		}
+		for i := 0; i < msgs.Len(); i++ {
+			md := msgs.Get(i)
+			if !fn(md) || !rangeMessages(md.Messages(), md.Enums(), md.Extensions()) {
+				return false
+			}
+		}
+		return true
+	}
+	rangeMessages(f.Messages(), f.Enums(), f.Extensions())
+}
+
+// requalify returns the text a reference should be replaced with after the
+// move, if it needs to change.
+func (m *fileMove) requalify(ref typeReference) (string, bool) {
+	if m.srcPkg == m.destPkg {
+		return "", false
+	}
+	inRegion := m.inRegion(ref.res, ref.start, ref.end)
+	if !inRegion && !m.moved[ref.desc] {
+		return "", false
+	}
+	newName := m.newFullName(ref.desc)
+	var text string
+	switch {
+	case strings.HasPrefix(ref.text, "."):
+		text = "." + string(newName)
+	case inRegion && m.moved[ref.desc] && !strings.Contains(ref.text, "."):
+		// unqualified references between moved elements resolve the same way
+		// in the destination package
+		return "", false
+	case inRegion:
+		text = relativeFullName(newName, m.destPkg)
+	default:
+		text = relativeFullName(newName, ref.res.Package())
+	}
+	return text, text != ref.text
+}
+
+// updateImports adds the imports needed by the moved declaration to the
+// destination file, updates the imports of the files referencing it, and
+// removes imports from the source file that were only used by it.
+func (m *fileMove) updateImports() error {
+	insideProviders := map[string]bool{}
+	outsideProviders := map[string]bool{}
+	sourceRefsMoved := false
+	var destImports []string
+	for _, ref := range m.refs {
+		if ref.res != m.source {
+			continue
+		}
+		inRegion := m.inRegion(ref.res, ref.start, ref.end)
+		if m.moved[ref.desc] {
+			if !inRegion {
+				sourceRefsMoved = true
+			}
+			continue
+		}
+		declPath := ref.desc.ParentFile().Path()
+		provider := importProvider(m.source, declPath)
+		if !inRegion {
+			outsideProviders[provider] = true
+			continue
+		}
+		insideProviders[provider] = true
+		if declPath == m.destPath || m.dest != nil && importProvider(m.dest, declPath) != "" {
+			continue
+		}
+		if !slices.Contains(destImports, provider) {
+			destImports = append(destImports, provider)
+		}
+	}
+	slices.Sort(destImports)
+	m.destImports = destImports
+
+	// the files importing the destination must not be imported by it
+	destImportsFile := func(path string) bool {
+		if m.dest != nil && importsTransitively(m.dest, path) {
+			return true
+		}
+		for _, imp := range destImports {
+			if imp == path {
+				return true
+			}
+			if res := m.byPath[imp]; res != nil && importsTransitively(res, path) {
+				return true
+			}
+		}
+		return false
+	}
+
+	srcPath := m.source.Path()
+	for _, provider := range slices.Sorted(maps.Keys(insideProviders)) {
+		if !outsideProviders[provider] && provider != srcPath {
+			m.removeImports[srcPath] = append(m.removeImports[srcPath], provider)
+		}
+	}
+	if sourceRefsMoved && importProvider(m.source, m.destPath) == "" {
+		if destImportsFile(srcPath) {
+			return fmt.Errorf("cannot move %s: %s would still reference it, creating an import cycle with %s", m.desc.FullName(), srcPath, m.destPath)
+		}
+		m.addImports[srcPath] = append(m.addImports[srcPath], m.destPath)
+	}
+
+	for _, path := range slices.Sorted(maps.Keys(m.rewriters)) {
+		res := m.byPath[path]
+		if res == m.source || !slices.ContainsFunc(m.refs, func(ref typeReference) bool { return ref.res == res }) {
+			continue
+		}
+		needsDest := res != m.dest && importProvider(res, m.destPath) == ""
+		if needsDest && destImportsFile(path) {
+			return fmt.Errorf("cannot move %s: %s references it, which would create an import cycle with %s", m.desc.FullName(), path, m.destPath)
+		}
+		if needsDest {
+			m.addImports[path] = append(m.addImports[path], m.destPath)
+		}
+		if !(res == m.dest && insideProviders[srcPath] || m.referencesRemainingSource(res)) {
+			m.removeImports[path] = append(m.removeImports[path], srcPath)
+		}
+	}
+	if m.dest != nil {
+		if _, err := m.rewriter(m.dest); err != nil {
+			return err
+		}
+		m.addImports[m.destPath] = append(m.addImports[m.destPath], destImports...)
+	}
+	for path, w := range m.rewriters {
+		updateFileImports(w, m.addImports[path], m.removeImports[path])
+	}
+	return nil
+}
+
+// finish inserts the moved declaration into the destination file, or returns
+// the contents of the new destination file.
+func (m *fileMove) finish() ([]byte, error) {
+	moved := strings.TrimRight(string(m.movedText), "\n") + "\n"
+	if m.dest != nil {
+		w, err := m.rewriter(m.dest)
+		if err != nil {
+			return nil, err
+		}
+		text := "\n" + moved
+		if !bytes.HasSuffix(w.content, []byte("\n")) {
+			text = "\n" + text
+		}
+		w.edit(len(w.content), len(w.content), text)
+		return nil, nil
+	}
+
+	src := m.rewriters[m.source.Path()]
+	file := m.source.AST()
+	var sections []string
+	switch {
+	case file.Syntax != nil:
+		sections = append(sections, src.text(src.declSpan(file.Syntax)))
+	case file.Edition != nil:
+		sections = append(sections, src.text(src.declSpan(file.Edition)))
+	}
+	var pkg, options, imports []string
+	for _, decl := range file.Decls {
+		switch {
+		case decl.GetPackage() != nil:
+			pkg = append(pkg, src.text(src.declSpan(decl.GetPackage())))
+		case decl.GetOption() != nil:
+			// file options such as go_package usually depend on the directory
+			if path.Dir(m.destPath) == path.Dir(m.source.Path()) {
+				options = append(options, src.text(src.declSpan(decl.GetOption())))
+			}
+		}
+	}
+	for _, imp := range m.destImports {
+		imports = append(imports, fmt.Sprintf("import %q;", imp))
+	}
+	for _, section := range [][]string{pkg, imports, options} {
+		if len(section) > 0 {
+			sections = append(sections, strings.Join(section, "\n"))
+		}
+	}
+	sections = append(sections, moved)
+
+	var buf bytes.Buffer
+	if err := format.Format(strings.NewReader(strings.Join(sections, "\n\n")), &buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func declNodeForDescriptor(res linker.Result, desc protoreflect.Descriptor) ast.Node {
+	switch desc := desc.(protoutil.DescriptorProtoWrapper).AsProto().(type) {
+	case *descriptorpb.DescriptorProto:
+		return res.MessageNode(desc).Unwrap()
+	case *descriptorpb.EnumDescriptorProto:
+		return res.EnumNode(desc)
+	case *descriptorpb.ServiceDescriptorProto:
+		return res.ServiceNode(desc)
+	}
+	return nil
+}
+
+// referencesRemainingSource reports whether the file references anything
+// that remains in (or is publicly imported by) the source file after the move.
+func (m *fileMove) referencesRemainingSource(res linker.Result) bool {
+	for i := 0; i < m.source.Imports().Len(); i++ {
+		if m.source.Imports().Get(i).IsPublic {
+			return true
+		}
+	}
+	found := false
+	rangeTypeDescriptors(m.source, func(desc protoreflect.Descriptor) bool {
+		found = !m.moved[desc] && len(res.FindReferences(desc)) > 0
+		return !found
+	})
+	return found
+}
+
+// importProvider returns the path of the file imported by f through which the
+// file at the given path is visible, or an empty string if it is not visible.
+func importProvider(f protoreflect.FileDescriptor, path string) string {
+	if f.Path() == path {
+		return path
+	}
+	imports := f.Imports()
+	for i := 0; i < imports.Len(); i++ {
+		imp := imports.Get(i)
+		if imp.Path() == path || publiclyImports(imp.FileDescriptor, path) {
+			return imp.Path()
+		}
+	}
+	return ""
+}
+
+func publiclyImports(f protoreflect.FileDescriptor, path string) bool {
+	imports := f.Imports()
+	for i := 0; i < imports.Len(); i++ {
+		imp := imports.Get(i)
+		if imp.IsPublic && (imp.Path() == path || publiclyImports(imp.FileDescriptor, path)) {
+			return true
+		}
+	}
+	return false
+}
+
+func importsTransitively(f protoreflect.FileDescriptor, path string) bool {
+	seen := map[string]bool{}
+	var visit func(f protoreflect.FileDescriptor) bool
+	visit = func(f protoreflect.FileDescriptor) bool {
+		imports := f.Imports()
+		for i := 0; i < imports.Len(); i++ {
+			imp := imports.Get(i)
+			if imp.Path() == path {
+				return true
+			}
+			if !seen[imp.Path()] {
+				seen[imp.Path()] = true
+				if visit(imp.FileDescriptor) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	return visit(f)
+}
+
+// updateFileImports adds and removes non-public imports of a file. Removed
+// imports are replaced with added ones where possible, so that the edits do
+// not overlap.
+func updateFileImports(w *sourceRewriter, add, remove []string) {
+	for _, path := range remove {
+		if len(add) > 0 && replaceImport(w, path, add[0]) {
+			add = add[1:]
+			continue
+		}
+		removeImport(w, path)
+	}
+	for _, path := range add {
+		addImport(w, path)
+	}
+}
+
+func addImport(w *sourceRewriter, path string) {
+	offset, text := 0, ""
+	hasImports := false
+	for _, decl := range w.file.Decls {
+		switch {
+		case decl.GetImport() != nil:
+			hasImports = true
+			fallthrough
+		case decl.GetPackage() != nil:
+			_, offset = w.declSpan(decl.Unwrap())
+		}
+	}
+	if offset == 0 {
+		if w.file.Syntax != nil {
+			_, offset = w.declSpan(w.file.Syntax)
+		} else if w.file.Edition != nil {
+			_, offset = w.declSpan(w.file.Edition)
+		}
+	}
+	decl := fmt.Sprintf("import %q;", path)
+	switch {
+	case offset == 0:
+		text = decl + "\n\n"
+	case hasImports:
+		text = "\n" + decl
+	default:
+		text = "\n\n" + decl
+	}
+	w.edit(offset, offset, text)
+}
+
+// replaceImport replaces a non-public import of one file with an import of
+// another, reporting whether the file had such an import.
+func replaceImport(w *sourceRewriter, oldPath, newPath string) bool {
+	for _, decl := range w.file.Decls {
+		if imp := decl.GetImport(); imp != nil && imp.Public == nil && imp.Name.AsString() == oldPath {
+			start, end := w.span(imp.Name)
+			w.edit(start, end, fmt.Sprintf("%q", newPath))
+			return true
+		}
+	}
+	return false
+}
+
+func removeImport(w *sourceRewriter, path string) {
+	for _, decl := range w.file.Decls {
+		if imp := decl.GetImport(); imp != nil && imp.Public == nil && imp.Name.AsString() == path {
+			start, end := w.lineSpan(w.declSpan(imp))
+			w.edit(start, end, "")
+		}
+	}
+}
+
+// MoveToFile returns the document changes that move the top-level message,
+// enum, or service with the given name into the file at dest. If there is no
+// file at dest, the contents of the new file are returned as well, and the
+// caller is responsible for creating it along with the changes.
+func (c *Cache) MoveToFile(ctx context.Context, name protoreflect.FullName, dest protocol.DocumentURI) ([]protocol.DocumentChanges, []byte, error) {
+	c.resultsMu.RLock()
+	defer c.resultsMu.RUnlock()
+
+	desc, err := c.results.AsResolver().FindDescriptorByName(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	srcPath := desc.ParentFile().Path()
+	srcURI, err := c.resolver.PathToURI(srcPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	destPath, err := c.resolver.URIToPath(dest)
+	if err != nil {
+		// a new file; its import path is relative to that of the source file
+		if !dest.IsFile() || !strings.HasPrefix(dest.Path(), protocol.DocumentURI(c.workspace.URI).Path()) {
+			return nil, nil, fmt.Errorf("cannot move %s to %s: destination is outside the workspace", name, dest)
+		}
+		rel, err := filepath.Rel(filepath.Dir(srcURI.Path()), dest.Path())
+		if err != nil {
+			return nil, nil, err
+		}
+		destPath = path.Join(path.Dir(srcPath), filepath.ToSlash(rel))
+	}
+
+	docs := c.newWorkspaceDocuments(ctx, name)
+	edits, newFile, err := moveToFile(c.results, docs.contents, name, destPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	changes, err := docs.documentChanges(edits)
+	if err != nil {
+		return nil, nil, err
+	}
+	return changes, newFile, nil
+}
+
+// workspaceDocuments reads the workspace files modified by a refactor,
+// keeping track of their versions so the edits can be applied to them.
+type workspaceDocuments struct {
+	ctx      context.Context
+	cache    *Cache
+	name     protoreflect.FullName
+	mappers  map[string]*protocol.Mapper
+	versions map[string]int32
+}
+
+func (c *Cache) newWorkspaceDocuments(ctx context.Context, name protoreflect.FullName) *workspaceDocuments {
+	return &workspaceDocuments{
+		ctx:      ctx,
+		cache:    c,
+		name:     name,
+		mappers:  map[string]*protocol.Mapper{},
+		versions: map[string]int32{},
+	}
+}
+
+// contents returns the latest contents of the file at the given path, which
+// must be a well-formed workspace file.
+func (d *workspaceDocuments) contents(path string) ([]byte, error) {
+	c := d.cache
+	uri, err := c.resolver.PathToURI(path)
+	if err != nil {
+		return nil, err
+	}
+	if !c.resolver.IsRealWorkspaceLocalFile(uri) {
+		return nil, fmt.Errorf("cannot refactor %s: %s would need to be modified, but is not a workspace file", d.name, path)
+	}
+	if ok, _ := c.LatestDocumentContentsWellFormed(uri, false); !ok {
+		return nil, fmt.Errorf("cannot refactor %s: %s has errors", d.name, path)
+	}
+	fh, err := c.resolver.ReadFile(d.ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	content, err := fh.Content()
+	if err != nil {
+		return nil, err
+	}
+	d.mappers[path] = protocol.NewMapper(uri, content)
+	d.versions[path] = fh.Version()
+	return content, nil
+}
+
+// documentChanges converts the edits to each file read by contents, keyed by
+// path, into document changes.
+func (d *workspaceDocuments) documentChanges(edits map[string][]sourceEdit) ([]protocol.DocumentChanges, error) {
+	var changes []protocol.DocumentChanges
+	for _, path := range slices.Sorted(maps.Keys(edits)) {
+		mapper := d.mappers[path]
+		var textEdits []protocol.TextEdit
+		for _, e := range edits[path] {
+			rng, err := mapper.OffsetRange(e.start, e.end)
+			if err != nil {
+				return nil, err
+			}
+			textEdits = append(textEdits, protocol.TextEdit{Range: rng, NewText: e.text})
+		}
+		changes = append(changes, protocol.TextEditsToDocumentChanges(mapper.URI, d.versions[path], textEdits)...)
+	}
+	return changes, nil
+}
+
+// moveToFileActions offers to move the top-level message, enum, or service
+// declared at the cursor into a new file named after it, or into one of the
+// other files in the same directory.
+func (c *Cache) moveToFileActions(params *protocol.CodeActionParams, linkRes linker.Result, mapper *protocol.Mapper) []protocol.CodeAction {
+	c.resultsMu.RLock()
+	defer c.resultsMu.RUnlock()
+
+	fileNode := linkRes.AST()
+	if fileNode == nil || !c.resolver.IsRealWorkspaceLocalFile(params.TextDocument.URI) {
+		return nil
+	}
+	offset, err := mapper.PositionOffset(params.Range.Start)
+	if err != nil {
+		return nil
+	}
+	var name protoreflect.Name
+	for _, decl := range fileNode.Decls {
+		var keyword, ident ast.Node
+		switch decl := decl.Unwrap().(type) {
+		case *ast.MessageNode:
+			keyword, ident = decl.Keyword, decl.Name
+			name = protoreflect.Name(decl.Name.AsIdentifier())
+		case *ast.EnumNode:
+			keyword, ident = decl.Keyword, decl.Name
+			name = protoreflect.Name(decl.Name.AsIdentifier())
+		case *ast.ServiceNode:
+			keyword, ident = decl.Keyword, decl.Name
+			name = protoreflect.Name(decl.Name.AsIdentifier())
+		default:
+			continue
+		}
+		if keyword == nil || ident == nil {
+			continue
+		}
+		if offset >= fileNode.NodeInfo(keyword).Start().Offset && offset <= fileNode.NodeInfo(ident).End().Offset+1 {
+			break
+		}
+		name = ""
+	}
+	if name == "" {
+		return nil
+	}
+	fqn := linkRes.Package().Append(name)
+
+	newCommand := func(title string, dest protocol.DocumentURI) protocol.CodeAction {
+		data, _ := json.Marshal(MoveToFileRequest{
+			URI:         params.TextDocument.URI,
+			Symbol:      string(fqn),
+			Destination: dest,
+		})
+		return protocol.CodeAction{
+			Title: title,
+			Kind:  protocol.RefactorMove,
+			Command: &protocol.Command{
+				Title:     title,
+				Command:   "protols/moveToFile",
+				Arguments: []json.RawMessage{data},
+			},
+		}
+	}
+
+	dir := filepath.Dir(params.TextDocument.URI.Path())
+	newFileName := strings.ToLower(screamingSnakeCase(string(name))) + ".proto"
+	newFileURI := protocol.URIFromPath(filepath.Join(dir, newFileName))
+	var actions []protocol.CodeAction
+	if _, err := c.resolver.URIToPath(newFileURI); err != nil {
+		actions = append(actions, newCommand(fmt.Sprintf("Move %s to new file %s", name, newFileName), newFileURI))
+	}
+	var siblings []protocol.DocumentURI
+	for _, f := range c.results {
+		uri, err := c.resolver.PathToURI(f.Path())
+		if err != nil || uri == params.TextDocument.URI || !uri.IsFile() || filepath.Dir(uri.Path()) != dir {
+			continue
+		}
+		if c.resolver.IsRealWorkspaceLocalFile(uri) {
+			siblings = append(siblings, uri)
+		}
+	}
+	slices.Sort(siblings)
+	for _, uri := range siblings {
+		actions = append(actions, newCommand(fmt.Sprintf("Move %s to %s", name, filepath.Base(uri.Path())), uri))
+	}
+	return actions
+}
@@ -0,0 +1,274 @@
+package lsp
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/kralicky/tools-lite/gopls/pkg/protocol"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+func TestMoveToFile(t *testing.T) {
+	cases := []struct {
+		name     string
+		sources  map[string]string
+		symbol   protoreflect.FullName
+		dest     string
+		want     map[string]string
+		wantErr  string
+		wantFile string
+	}{
+		{
+			name: "new file",
+			sources: map[string]string{
+				"a.proto": `syntax = "proto3";
+
+package test;
+
+import "google/protobuf/timestamp.proto";
+
+option go_package = "example.com/test";
+
+// Foo is a foo.
+message Foo {
+  google.protobuf.Timestamp time = 1;
+  Kind kind = 2;
+}
+
+message Bar {
+  Foo foo = 1;
+}
+
+enum Kind {
+  KIND_UNSPECIFIED = 0;
+}
+`,
+				"b.proto": `syntax = "proto3";
+
+package test;
+
+import "a.proto";
+
+message Baz {
+  Foo foo = 1;
+}
+`,
+			},
+			symbol:  "test.Foo",
+			dest:    "foo.proto",
+			wantErr: "import cycle",
+		},
+		{
+			name: "new file without cycle",
+			sources: map[string]string{
+				"a.proto": `syntax = "proto3";
+
+package test;
+
+import "google/protobuf/timestamp.proto";
+
+option go_package = "example.com/test";
+
+message Bar {
+  Foo foo = 1;
+  google.protobuf.Timestamp time = 2;
+}
+
+// Foo is a foo.
+message Foo {
+  google.protobuf.Timestamp time = 1;
+  Nested nested = 2;
+  message Nested {}
+}
+`,
+				"b.proto": `syntax = "proto3";
+
+package test;
+
+import "a.proto";
+
+message Baz {
+  Foo foo = 1;
+  Foo.Nested nested = 2;
+}
+`,
+			},
+			symbol: "test.Foo",
+			dest:   "foo.proto",
+			want: map[string]string{
+				"a.proto": `syntax = "proto3";
+
+package test;
+
+import "google/protobuf/timestamp.proto";
+import "foo.proto";
+
+option go_package = "example.com/test";
+
+message Bar {
+  Foo foo = 1;
+  google.protobuf.Timestamp time = 2;
+}
+`,
+				"b.proto": `syntax = "proto3";
+
+package test;
+
+import "foo.proto";
+
+message Baz {
+  Foo foo = 1;
+  Foo.Nested nested = 2;
+}
+`,
+			},
+			wantFile: `syntax = "proto3";
+
+package test;
+
+import "google/protobuf/timestamp.proto";
+
+option go_package = "example.com/test";
+
+// Foo is a foo.
+message Foo {
+  google.protobuf.Timestamp time   = 1;
+  Nested                    nested = 2;
+  message Nested {}
+}
+`,
+		},
+		{
+			name: "existing file in another package",
+			sources: map[string]string{
+				"a.proto": `syntax = "proto3";
+
+package test;
+
+message Foo {
+  Bar bar = 1;
+  test.Bar bar2 = 2;
+}
+
+message Bar {}
+`,
+				"other/b.proto": `syntax = "proto3";
+
+package other;
+
+import "a.proto";
+
+message Baz {
+  test.Foo foo = 1;
+}
+`,
+			},
+			symbol: "test.Foo",
+			dest:   "other/b.proto",
+			want: map[string]string{
+				"a.proto": `syntax = "proto3";
+
+package test;
+
+message Bar {}
+`,
+				"other/b.proto": `syntax = "proto3";
+
+package other;
+
+import "a.proto";
+
+message Baz {
+  Foo foo = 1;
+}
+
+message Foo {
+  test.Bar bar = 1;
+  test.Bar bar2 = 2;
+}
+`,
+			},
+		},
+		{
+			name: "existing file needing imports",
+			sources: map[string]string{
+				"a.proto": `syntax = "proto3";
+
+package test;
+
+import "google/protobuf/timestamp.proto";
+
+message Foo {
+  google.protobuf.Timestamp time = 1;
+}
+`,
+				"b.proto": `syntax = "proto3";
+
+package test;
+
+message Baz {}
+`,
+			},
+			symbol: "test.Foo",
+			dest:   "b.proto",
+			want: map[string]string{
+				"a.proto": `syntax = "proto3";
+
+package test;
+
+`,
+				"b.proto": `syntax = "proto3";
+
+package test;
+
+import "google/protobuf/timestamp.proto";
+
+message Baz {}
+
+message Foo {
+  google.protobuf.Timestamp time = 1;
+}
+`,
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			files := compileFiles(t, c.sources)
+			edits, newFile, err := moveToFile(files, func(path string) ([]byte, error) {
+				return []byte(c.sources[path]), nil
+			}, c.symbol, c.dest)
+			if c.wantErr != "" {
+				require.ErrorContains(t, err, c.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			got := map[string]string{}
+			for path, e := range edits {
+				out, err := applySourceEdits([]byte(c.sources[path]), e)
+				require.NoError(t, err)
+				got[path] = string(out)
+			}
+			require.Equal(t, c.want, got)
+			require.Equal(t, c.wantFile, string(newFile))
+		})
+	}
+}
+
+func TestDocumentChangeJSON(t *testing.T) {
+	uri := protocol.DocumentURI("file:///work/foo.proto")
+	changes := []documentChange{
+		{CreateFile: &protocol.CreateFile{Kind: string(protocol.Create), URI: uri}},
+		{DocumentChanges: protocol.TextEditsToDocumentChanges(uri, 0, []protocol.TextEdit{{NewText: "syntax = \"proto3\";\n"}})[0]},
+	}
+	data, err := json.Marshal(changes)
+	require.NoError(t, err)
+	require.JSONEq(t, `[
+  {"kind": "create", "uri": "file:///work/foo.proto"},
+  {
+    "textDocument": {"uri": "file:///work/foo.proto", "version": 0},
+    "edits": [{"range": {"start": {"line": 0, "character": 0}, "end": {"line": 0, "character": 0}}, "newText": "syntax = \"proto3\";\n"}]
+  }
+]`, string(data))
+}
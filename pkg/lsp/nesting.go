@@ -0,0 +1,559 @@
+package lsp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/kralicky/protocompile/ast"
+	"github.com/kralicky/protocompile/linker"
+	"github.com/kralicky/tools-lite/gopls/pkg/protocol"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// nestingMove computes the edits needed to move a nested message or enum out
+// to file scope, or to move a top-level one into the body of a message in the
+// same file. References to the moved declaration (and to everything nested
+// within it) are updated in every file, as are the references inside it that
+// would otherwise resolve differently from its new scope.
+type nestingMove struct {
+	*fileMove
+
+	// The message to move the declaration into, or nil if it is moved to
+	// file scope.
+	into             protoreflect.MessageDescriptor
+	oldName, newName protoreflect.FullName
+	// The names of all symbols visible to the workspace, before the move.
+	symbols map[protoreflect.FullName]bool
+	// The old and new names of the values of a moved enum, which are scoped
+	// to the enum's parent rather than to the enum itself.
+	oldValues, newValues map[protoreflect.FullName]bool
+}
+
+// changeNesting returns the edits to each file, keyed by path, that move the
+// message or enum with the given name into the message named into, or to file
+// scope if into is empty. The declaration is renamed if its name is taken in
+// its new scope; the new name is returned as well.
+func changeNesting(files linker.Files, contents func(path string) ([]byte, error), name, into protoreflect.FullName) (map[string][]sourceEdit, protoreflect.FullName, error) {
+	m := &nestingMove{
+		fileMove: &fileMove{
+			byPath:    map[string]linker.Result{},
+			contents:  contents,
+			rewriters: map[string]*sourceRewriter{},
+			moved:     map[protoreflect.Descriptor]bool{},
+		},
+		oldName:   name,
+		symbols:   collectSymbols(files),
+		oldValues: map[protoreflect.FullName]bool{},
+		newValues: map[protoreflect.FullName]bool{},
+	}
+	for _, f := range files {
+		if res, ok := f.(linker.Result); ok && !f.IsPlaceholder() {
+			m.byPath[f.Path()] = res
+		}
+	}
+	desc, err := files.AsResolver().FindDescriptorByName(name)
+	if err != nil {
+		return nil, "", err
+	}
+	switch desc := desc.(type) {
+	case protoreflect.MessageDescriptor:
+		if desc.IsMapEntry() {
+			return nil, "", fmt.Errorf("cannot move %s: map entries cannot be moved", name)
+		}
+	case protoreflect.EnumDescriptor:
+	default:
+		return nil, "", fmt.Errorf("cannot move %s: only messages and enums can be moved", name)
+	}
+	m.desc = desc
+	m.source = m.byPath[desc.ParentFile().Path()]
+	if m.source == nil {
+		return nil, "", fmt.Errorf("cannot move %s: source file %s is not available", name, desc.ParentFile().Path())
+	}
+
+	var scope protoreflect.FullName
+	var prefix string
+	if into == "" {
+		parent, ok := desc.Parent().(protoreflect.MessageDescriptor)
+		if !ok {
+			return nil, "", fmt.Errorf("%s is already at file scope", name)
+		}
+		scope = m.source.Package()
+		prefix = string(parent.Name()) + string(desc.Name())
+	} else {
+		d, err := files.AsResolver().FindDescriptorByName(into)
+		if err != nil {
+			return nil, "", err
+		}
+		md, ok := d.(protoreflect.MessageDescriptor)
+		if !ok || md.IsMapEntry() {
+			return nil, "", fmt.Errorf("cannot move %s into %s: not a message", name, into)
+		}
+		if _, ok := desc.Parent().(protoreflect.FileDescriptor); !ok {
+			return nil, "", fmt.Errorf("cannot move %s into %s: only top-level declarations can be nested", name, into)
+		}
+		if md.ParentFile().Path() != m.source.Path() {
+			return nil, "", fmt.Errorf("cannot move %s into %s: %s is declared in another file", name, into, into)
+		}
+		if _, within := trimScope(into, name); within {
+			return nil, "", fmt.Errorf("cannot move %s into itself", name)
+		}
+		m.into = md
+		scope = into
+		prefix = string(desc.Name())
+	}
+	taken := func(name protoreflect.Name) bool {
+		return m.symbols[scope.Append(name)]
+	}
+	newName := desc.Name()
+	if taken(newName) {
+		newName = protoreflect.Name(findNewUnusedName(prefix, taken))
+	}
+	m.newName = scope.Append(newName)
+	if ed, ok := desc.(protoreflect.EnumDescriptor); ok {
+		for i := 0; i < ed.Values().Len(); i++ {
+			v := ed.Values().Get(i)
+			if taken(v.Name()) {
+				return nil, "", fmt.Errorf("cannot move %s: enum value %s conflicts with %s", name, v.Name(), scope.Append(v.Name()))
+			}
+			m.oldValues[v.FullName()] = true
+			m.newValues[scope.Append(v.Name())] = true
+		}
+	}
+	m.collectMoved(desc)
+
+	if err := m.moveNestedDecl(); err != nil {
+		return nil, "", err
+	}
+	edits := map[string][]sourceEdit{}
+	for path, w := range m.rewriters {
+		if len(w.edits) > 0 {
+			edits[path] = w.edits
+		}
+	}
+	return edits, m.newName, nil
+}
+
+// moveNestedDecl removes the declaration from its current scope, updates the
+// references to and within it, and inserts it into its new scope.
+func (m *nestingMove) moveNestedDecl() error {
+	w, err := m.rewriter(m.source)
+	if err != nil {
+		return err
+	}
+	node := declNodeForDescriptor(m.source, m.desc)
+	if node == nil {
+		return fmt.Errorf("could not find the declaration of %s", m.desc.FullName())
+	}
+	start, end := w.attachedDeclSpan(node)
+	lineStart, lineEnd := w.lineSpan(start, end)
+	m.start, m.end = w.movableSpan(node)
+
+	var nameNode ast.Node
+	switch node := node.(type) {
+	case *ast.MessageNode:
+		nameNode = node.Name
+	case *ast.EnumNode:
+		nameNode = node.Name
+	}
+	nameStart, nameEnd := w.span(nameNode)
+	var regionEdits []sourceEdit
+	if m.newName.Name() != m.desc.Name() {
+		regionEdits = append(regionEdits, sourceEdit{start: nameStart - m.start, end: nameEnd - m.start, text: string(m.newName.Name())})
+	}
+
+	m.refs, err = m.typeReferences()
+	if err != nil {
+		return err
+	}
+	for _, ref := range m.refs {
+		if ref.res == m.source && ref.start == nameStart && ref.end == nameEnd {
+			continue
+		}
+		text, ok := m.requalifyNested(ref)
+		if !ok {
+			continue
+		}
+		if m.inRegion(ref.res, ref.start, ref.end) {
+			regionEdits = append(regionEdits, sourceEdit{start: ref.start - m.start, end: ref.end - m.start, text: text})
+			continue
+		}
+		rw, err := m.rewriter(ref.res)
+		if err != nil {
+			return err
+		}
+		rw.edit(ref.start, ref.end, text)
+	}
+	moved, err := applySourceEdits(w.content[m.start:m.end], regionEdits)
+	if err != nil {
+		return err
+	}
+	text := string(moved)
+	oldIndent := w.indentation(start)
+	w.removeLines(lineStart, lineEnd)
+
+	if m.into == nil {
+		// place the declaration after the top-level message containing it
+		var top protoreflect.Descriptor = m.desc
+		for {
+			if _, ok := top.Parent().(protoreflect.FileDescriptor); ok {
+				break
+			}
+			top = top.Parent()
+		}
+		_, topEnd := w.declSpan(declNodeForDescriptor(m.source, top))
+		offset, _ := w.insertionPoint(topEnd)
+		w.edit(offset, offset, "\n\n"+reindent(text, oldIndent, ""))
+		return nil
+	}
+
+	// place the declaration at the end of the message's body
+	into, ok := declNodeForDescriptor(m.source, m.into).(*ast.MessageNode)
+	if !ok {
+		return fmt.Errorf("could not find the declaration of %s", m.into.FullName())
+	}
+	intoStart, _ := w.span(into)
+	indent := w.indentation(intoStart)
+	text = indent + "  " + reindent(text, oldIndent, indent+"  ")
+	closeStart, _ := w.span(into.CloseBrace)
+	closeLineStart := bytes.LastIndexByte(w.content[:closeStart], '\n') + 1
+	if len(bytes.TrimSpace(w.content[closeLineStart:closeStart])) == 0 && len(into.Decls) > 0 {
+		w.edit(closeLineStart, closeLineStart, text+"\n")
+	} else {
+		w.edit(closeStart, closeStart, "\n"+text+"\n"+indent)
+	}
+	return nil
+}
+
+// requalifyNested returns the text a reference should be replaced with after
+// the move, if the name it uses would no longer resolve to the same element.
+func (m *nestingMove) requalifyNested(ref typeReference) (string, bool) {
+	target := m.renamed(ref.desc.FullName())
+	var scope protoreflect.FullName
+	if md := enclosingMessage(ref.res, ref.start); md != nil {
+		scope = md.FullName()
+	} else {
+		scope = ref.res.Package()
+	}
+	if m.inRegion(ref.res, ref.start, ref.end) {
+		if _, ok := trimScope(scope, m.oldName); !ok {
+			// only possible for references in the options of an enum
+			scope = m.newName.Parent()
+		}
+	}
+	scope = m.renamed(scope)
+	name := strings.Join(strings.Fields(ref.text), "")
+	if m.resolve(name, scope) == target {
+		return "", false
+	}
+	if strings.HasPrefix(name, ".") {
+		return "." + string(target), true
+	}
+	// use the shortest suffix of the name that resolves to the element
+	parts := strings.Split(string(target), ".")
+	for i := len(parts) - 1; i >= 0; i-- {
+		text := strings.Join(parts[i:], ".")
+		if m.resolve(text, scope) == target {
+			return text, true
+		}
+	}
+	return "." + string(target), true
+}
+
+// renamed returns the name the element with the given name has after the move.
+func (m *nestingMove) renamed(name protoreflect.FullName) protoreflect.FullName {
+	if rest, ok := trimScope(name, m.oldName); ok {
+		return protoreflect.FullName(string(m.newName) + rest)
+	}
+	return name
+}
+
+// exists reports whether there is a symbol with the given name after the move.
+func (m *nestingMove) exists(name protoreflect.FullName) bool {
+	if rest, ok := trimScope(name, m.newName); ok {
+		return m.symbols[protoreflect.FullName(string(m.oldName)+rest)]
+	}
+	if _, ok := trimScope(name, m.oldName); ok {
+		return false
+	}
+	if m.newValues[name] {
+		return true
+	}
+	return m.symbols[name] && !m.oldValues[name]
+}
+
+// resolve returns the full name that the given (possibly qualified) name
+// refers to from within the given scope after the move, following the same
+// rules as protoc: the first component of the name is looked up in each
+// enclosing scope, starting with the innermost one, and the rest of the name
+// is resolved relative to the first match. Returns an empty string if the
+// name does not resolve.
+func (m *nestingMove) resolve(name string, scope protoreflect.FullName) protoreflect.FullName {
+	if strings.HasPrefix(name, ".") {
+		return protoreflect.FullName(name[1:])
+	}
+	first, _, _ := strings.Cut(name, ".")
+	for {
+		if m.exists(scope.Append(protoreflect.Name(first))) {
+			if scope == "" {
+				return protoreflect.FullName(name)
+			}
+			return protoreflect.FullName(string(scope) + "." + name)
+		}
+		if scope == "" {
+			return ""
+		}
+		scope = scope.Parent()
+	}
+}
+
+// trimScope returns the remainder of name after the given scope, including
+// its leading dot, if name is the scope itself or is contained within it.
+func trimScope(name, scope protoreflect.FullName) (string, bool) {
+	if name == scope {
+		return "", true
+	}
+	if strings.HasPrefix(string(name), string(scope)+".") {
+		return string(name[len(scope):]), true
+	}
+	return "", false
+}
+
+// reindent replaces the indentation from of each line of text after the
+// first with to. Blank lines are left empty.
+func reindent(text, from, to string) string {
+	lines := strings.Split(text, "\n")
+	for i := 1; i < len(lines); i++ {
+		line := strings.TrimPrefix(lines[i], from)
+		if strings.TrimSpace(line) == "" {
+			lines[i] = ""
+			continue
+		}
+		lines[i] = to + line
+	}
+	return strings.Join(lines, "\n")
+}
+
+// collectSymbols returns the names of all packages and declarations in the
+// given files and in the files they import.
+func collectSymbols(files linker.Files) map[protoreflect.FullName]bool {
+	symbols := map[protoreflect.FullName]bool{}
+	visited := map[string]bool{}
+	var addMessages func(msgs protoreflect.MessageDescriptors)
+	addEnums := func(enums protoreflect.EnumDescriptors) {
+		for i := 0; i < enums.Len(); i++ {
+			ed := enums.Get(i)
+			symbols[ed.FullName()] = true
+			for j := 0; j < ed.Values().Len(); j++ {
+				symbols[ed.Values().Get(j).FullName()] = true
+			}
+		}
+	}
+	addExtensions := func(exts protoreflect.ExtensionDescriptors) {
+		for i := 0; i < exts.Len(); i++ {
+			symbols[exts.Get(i).FullName()] = true
+		}
+	}
+	addMessages = func(msgs protoreflect.MessageDescriptors) {
+		for i := 0; i < msgs.Len(); i++ {
+			md := msgs.Get(i)
+			symbols[md.FullName()] = true
+			for j := 0; j < md.Fields().Len(); j++ {
+				symbols[md.Fields().Get(j).FullName()] = true
+			}
+			for j := 0; j < md.Oneofs().Len(); j++ {
+				symbols[md.Oneofs().Get(j).FullName()] = true
+			}
+			addMessages(md.Messages())
+			addEnums(md.Enums())
+			addExtensions(md.Extensions())
+		}
+	}
+	var visit func(f protoreflect.FileDescriptor)
+	visit = func(f protoreflect.FileDescriptor) {
+		if visited[f.Path()] {
+			return
+		}
+		visited[f.Path()] = true
+		for pkg := f.Package(); pkg != ""; pkg = pkg.Parent() {
+			symbols[pkg] = true
+		}
+		addMessages(f.Messages())
+		addEnums(f.Enums())
+		addExtensions(f.Extensions())
+		for i := 0; i < f.Services().Len(); i++ {
+			sd := f.Services().Get(i)
+			symbols[sd.FullName()] = true
+			for j := 0; j < sd.Methods().Len(); j++ {
+				symbols[sd.Methods().Get(j).FullName()] = true
+			}
+		}
+		for i := 0; i < f.Imports().Len(); i++ {
+			visit(f.Imports().Get(i).FileDescriptor)
+		}
+	}
+	for _, f := range files {
+		visit(f)
+	}
+	return symbols
+}
+
+// enclosingMessage returns the innermost message whose declaration in the
+// file contains the given offset, or nil if the offset is at file scope.
+func enclosingMessage(res linker.Result, offset int) protoreflect.MessageDescriptor {
+	var found protoreflect.MessageDescriptor
+	rangeTypeDescriptors(res, func(d protoreflect.Descriptor) bool {
+		md, ok := d.(protoreflect.MessageDescriptor)
+		if !ok || md.IsMapEntry() {
+			return true
+		}
+		node := declNodeForDescriptor(res, md)
+		if node == nil {
+			return true
+		}
+		info := res.AST().NodeInfo(node)
+		if offset >= info.Start().Offset && offset <= info.End().Offset {
+			found = md
+		}
+		return true
+	})
+	return found
+}
+
+// soleUser returns the message in the same file that holds every reference to
+// the given top-level message or enum and to its nested declarations, if
+// there is exactly one such message.
+func soleUser(files linker.Files, desc protoreflect.Descriptor) protoreflect.MessageDescriptor {
+	source, ok := files.FindFileByPath(desc.ParentFile().Path()).(linker.Result)
+	if !ok {
+		return nil
+	}
+	node := declNodeForDescriptor(source, desc)
+	if node == nil {
+		return nil
+	}
+	info := source.AST().NodeInfo(node)
+	m := &fileMove{moved: map[protoreflect.Descriptor]bool{}}
+	m.collectMoved(desc)
+	var user protoreflect.MessageDescriptor
+	for _, f := range files {
+		res, ok := f.(linker.Result)
+		if !ok || f.IsPlaceholder() {
+			continue
+		}
+		for d := range m.moved {
+			for _, ref := range res.FindReferences(d) {
+				if res != source {
+					return nil
+				}
+				offset := source.AST().NodeInfo(ref.Node).Start().Offset
+				if offset >= info.Start().Offset && offset <= info.End().Offset {
+					continue
+				}
+				md := enclosingMessage(source, offset)
+				if md == nil || user != nil && md != user {
+					return nil
+				}
+				user = md
+			}
+		}
+	}
+	return user
+}
+
+// ChangeNesting returns the document changes that move the nested message or
+// enum with the given name to file scope, or that move the top-level one into
+// the only message that uses it, along with its new name.
+func (c *Cache) ChangeNesting(ctx context.Context, name protoreflect.FullName) ([]protocol.DocumentChanges, protoreflect.FullName, error) {
+	c.resultsMu.RLock()
+	defer c.resultsMu.RUnlock()
+
+	desc, err := c.results.AsResolver().FindDescriptorByName(name)
+	if err != nil {
+		return nil, "", err
+	}
+	var into protoreflect.FullName
+	if _, ok := desc.Parent().(protoreflect.MessageDescriptor); !ok {
+		user := soleUser(c.results, desc)
+		if user == nil {
+			return nil, "", fmt.Errorf("cannot move %s: it must be used by exactly one message in the same file", name)
+		}
+		into = user.FullName()
+	}
+	docs := c.newWorkspaceDocuments(ctx, name)
+	edits, newName, err := changeNesting(c.results, docs.contents, name, into)
+	if err != nil {
+		return nil, "", err
+	}
+	changes, err := docs.documentChanges(edits)
+	if err != nil {
+		return nil, "", err
+	}
+	return changes, newName, nil
+}
+
+// nestingActions offers to move the nested message or enum declared at the
+// cursor to file scope, or to move the top-level one at the cursor into the
+// only message that uses it. Whether there is such a message is checked when
+// listing the action; the edits are computed when the command is executed.
+func (c *Cache) nestingActions(params *protocol.CodeActionParams, linkRes linker.Result, mapper *protocol.Mapper) []protocol.CodeAction {
+	fileNode := linkRes.AST()
+	if fileNode == nil || !c.resolver.IsRealWorkspaceLocalFile(params.TextDocument.URI) {
+		return nil
+	}
+	offset, err := mapper.PositionOffset(params.Range.Start)
+	if err != nil {
+		return nil
+	}
+	var desc protoreflect.Descriptor
+	rangeTypeDescriptors(linkRes, func(d protoreflect.Descriptor) bool {
+		var keyword, ident ast.Node
+		switch node := declNodeForDescriptor(linkRes, d).(type) {
+		case *ast.MessageNode:
+			keyword, ident = node.Keyword, node.Name
+		case *ast.EnumNode:
+			keyword, ident = node.Keyword, node.Name
+		default:
+			return true
+		}
+		if keyword == nil || ident == nil {
+			return true
+		}
+		if offset >= fileNode.NodeInfo(keyword).Start().Offset && offset <= fileNode.NodeInfo(ident).End().Offset+1 {
+			desc = d
+			return false
+		}
+		return true
+	})
+	if desc == nil {
+		return nil
+	}
+
+	var title string
+	if _, ok := desc.Parent().(protoreflect.MessageDescriptor); ok {
+		title = fmt.Sprintf("Move %s to top level", desc.Name())
+	} else {
+		c.resultsMu.RLock()
+		user := soleUser(c.results, desc)
+		c.resultsMu.RUnlock()
+		if user == nil {
+			return nil
+		}
+		title = fmt.Sprintf("Move %s into %s", desc.Name(), user.Name())
+	}
+	data, _ := json.Marshal(ChangeNestingRequest{
+		URI:    params.TextDocument.URI,
+		Symbol: string(desc.FullName()),
+	})
+	return []protocol.CodeAction{{
+		Title: title,
+		Kind:  protocol.RefactorMove,
+		Command: &protocol.Command{
+			Title:     title,
+			Command:   "protols/changeNesting",
+			Arguments: []json.RawMessage{data},
+		},
+	}}
+}
@@ -0,0 +1,301 @@
+package lsp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+func TestChangeNesting(t *testing.T) {
+	cases := []struct {
+		name        string
+		sources     map[string]string
+		symbol      protoreflect.FullName
+		into        protoreflect.FullName
+		want        map[string]string
+		wantName    protoreflect.FullName
+		wantErr     string
+		wantUser    protoreflect.FullName
+		checkUserOf protoreflect.FullName
+	}{
+		{
+			name: "promote",
+			sources: map[string]string{
+				"a.proto": `syntax = "proto3";
+
+package test;
+
+message Outer {
+  // Inner is an inner message.
+  message Inner {
+    Kind kind = 1;
+    enum Kind {
+      KIND_UNSPECIFIED = 0;
+    }
+  }
+
+  Inner inner = 1;
+  Inner.Kind kind = 2;
+}
+
+message Other {
+  Outer.Inner inner = 1;
+  .test.Outer.Inner.Kind kind = 2;
+}
+`,
+				"b.proto": `syntax = "proto3";
+
+package other;
+
+import "a.proto";
+
+message Baz {
+  test.Outer.Inner inner = 1;
+}
+`,
+			},
+			symbol:   "test.Outer.Inner",
+			wantName: "test.Inner",
+			want: map[string]string{
+				"a.proto": `syntax = "proto3";
+
+package test;
+
+message Outer {
+  Inner inner = 1;
+  Inner.Kind kind = 2;
+}
+
+// Inner is an inner message.
+message Inner {
+  Kind kind = 1;
+  enum Kind {
+    KIND_UNSPECIFIED = 0;
+  }
+}
+
+message Other {
+  Inner inner = 1;
+  .test.Inner.Kind kind = 2;
+}
+`,
+				"b.proto": `syntax = "proto3";
+
+package other;
+
+import "a.proto";
+
+message Baz {
+  test.Inner inner = 1;
+}
+`,
+			},
+		},
+		{
+			name: "promote with conflicting name",
+			sources: map[string]string{
+				"a.proto": `syntax = "proto3";
+
+package test;
+
+message Foo {
+  message Bar {}
+  Bar bar = 1;
+}
+
+message Bar {}
+`,
+			},
+			symbol:   "test.Foo.Bar",
+			wantName: "test.FooBar",
+			want: map[string]string{
+				"a.proto": `syntax = "proto3";
+
+package test;
+
+message Foo {
+  FooBar bar = 1;
+}
+
+message FooBar {}
+
+message Bar {}
+`,
+			},
+		},
+		{
+			name: "promote enum with conflicting values",
+			sources: map[string]string{
+				"a.proto": `syntax = "proto3";
+
+package test;
+
+message Foo {
+  enum Kind {
+    UNKNOWN = 0;
+  }
+}
+
+enum Other {
+  UNKNOWN = 0;
+}
+`,
+			},
+			symbol:  "test.Foo.Kind",
+			wantErr: "enum value UNKNOWN conflicts with test.UNKNOWN",
+		},
+		{
+			name: "demote",
+			sources: map[string]string{
+				"a.proto": `syntax = "proto3";
+
+package test;
+
+import "google/protobuf/timestamp.proto";
+
+message Foo {
+  Bar bar = 1;
+  repeated Bar bars = 2;
+}
+
+message Timestamp {}
+
+// Bar is only used by Foo.
+message Bar {
+  google.protobuf.Timestamp time = 1;
+  Timestamp local = 2;
+}
+`,
+			},
+			symbol:      "test.Bar",
+			into:        "test.Foo",
+			checkUserOf: "test.Bar",
+			wantUser:    "test.Foo",
+			wantName:    "test.Foo.Bar",
+			want: map[string]string{
+				"a.proto": `syntax = "proto3";
+
+package test;
+
+import "google/protobuf/timestamp.proto";
+
+message Foo {
+  Bar bar = 1;
+  repeated Bar bars = 2;
+  // Bar is only used by Foo.
+  message Bar {
+    google.protobuf.Timestamp time = 1;
+    Timestamp local = 2;
+  }
+}
+
+message Timestamp {}
+`,
+			},
+		},
+		{
+			name: "demote into empty message",
+			sources: map[string]string{
+				"a.proto": `syntax = "proto3";
+
+package test;
+
+message Foo {}
+
+message Bar {
+  Foo foo = 1;
+}
+`,
+			},
+			symbol:      "test.Bar",
+			into:        "test.Foo",
+			checkUserOf: "test.Bar",
+			wantName:    "test.Foo.Bar",
+			want: map[string]string{
+				"a.proto": `syntax = "proto3";
+
+package test;
+
+message Foo {
+  message Bar {
+    Foo foo = 1;
+  }
+}
+`,
+			},
+		},
+		{
+			name: "demote with shadowed reference",
+			sources: map[string]string{
+				"a.proto": `syntax = "proto3";
+
+package test;
+
+message Foo {
+  message Timestamp {}
+  Bar bar = 1;
+}
+
+message Timestamp {}
+
+message Bar {
+  Timestamp time = 1;
+}
+`,
+			},
+			symbol:   "test.Bar",
+			into:     "test.Foo",
+			wantName: "test.Foo.Bar",
+			want: map[string]string{
+				"a.proto": `syntax = "proto3";
+
+package test;
+
+message Foo {
+  message Timestamp {}
+  Bar bar = 1;
+  message Bar {
+    test.Timestamp time = 1;
+  }
+}
+
+message Timestamp {}
+`,
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			files := compileFiles(t, c.sources)
+			if c.checkUserOf != "" {
+				desc, err := files.AsResolver().FindDescriptorByName(c.checkUserOf)
+				require.NoError(t, err)
+				user := soleUser(files, desc)
+				if c.wantUser == "" {
+					require.Nil(t, user)
+				} else {
+					require.NotNil(t, user)
+					require.Equal(t, c.wantUser, user.FullName())
+				}
+			}
+			edits, newName, err := changeNesting(files, func(path string) ([]byte, error) {
+				return []byte(c.sources[path]), nil
+			}, c.symbol, c.into)
+			if c.wantErr != "" {
+				require.ErrorContains(t, err, c.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.wantName, newName)
+			got := map[string]string{}
+			for path, e := range edits {
+				out, err := applySourceEdits([]byte(c.sources[path]), e)
+				require.NoError(t, err)
+				got[path] = string(out)
+			}
+			require.Equal(t, c.want, got)
+			compileFiles(t, got)
+		})
+	}
+}
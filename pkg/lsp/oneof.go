@@ -0,0 +1,232 @@
+package lsp
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/kralicky/protocompile/ast"
+	"github.com/kralicky/protocompile/linker"
+	"github.com/kralicky/protocompile/protoutil"
+	"github.com/kralicky/tools-lite/gopls/pkg/protocol"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// wrapFieldsInOneof offers to move the selected fields of a message into a
+// new oneof. Fields lose their 'optional' labels, since oneof fields always
+// track presence. In proto3 files, fields without presence gain it, which is
+// noted in the action's title. Fields that set features.field_presence cannot
+// be wrapped.
+func wrapFieldsInOneof(ctx context.Context, request *protocol.CodeActionParams, linkRes linker.Result, mapper *protocol.Mapper, results chan<- protocol.CodeAction) {
+	_, desc, fields, ok := findSelectedFields(request, linkRes, mapper)
+	if !ok {
+		return
+	}
+	title := "Wrap fields in new oneof"
+	var implicit []string
+	for _, fld := range fields {
+		if fld.Label != nil && fld.Label.Val != "optional" {
+			results <- protocol.CodeAction{
+				Title: title,
+				Kind:  protocol.RefactorRewrite,
+				Disabled: &protocol.CodeActionDisabled{
+					Reason: fmt.Sprintf("%s fields cannot be part of a oneof", fld.Label.Val),
+				},
+			}
+			return
+		}
+		fd := desc.Fields().ByName(protoreflect.Name(fld.Name.AsIdentifier()))
+		if fd == nil {
+			return
+		}
+		if features := fd.Options().(*descriptorpb.FieldOptions).GetFeatures(); features != nil && features.FieldPresence != nil {
+			results <- protocol.CodeAction{
+				Title: title,
+				Kind:  protocol.RefactorRewrite,
+				Disabled: &protocol.CodeActionDisabled{
+					Reason: fmt.Sprintf("%s sets features.field_presence, which oneof fields cannot specify", fd.Name()),
+				},
+			}
+			return
+		}
+		if !fd.HasPresence() {
+			implicit = append(implicit, string(fd.Name()))
+		}
+	}
+	if len(implicit) > 0 {
+		title += fmt.Sprintf(" (adds presence to %s)", strings.Join(implicit, ", "))
+	}
+	results <- enqueueRewrite(title, protocol.RefactorRewrite, linkRes, mapper, func(w *sourceRewriter) bool {
+		w.wrapInOneof(fields, newOneofName(desc))
+		return true
+	})
+}
+
+// dissolveOneof offers to replace the oneof at the cursor with its fields.
+// The fields are no longer mutually exclusive. In proto2 and proto3 files they
+// become 'optional' fields, so that they keep their presence; in files using
+// editions, whether they keep it depends on the file's field presence feature.
+func dissolveOneof(ctx context.Context, request *protocol.CodeActionParams, linkRes linker.Result, mapper *protocol.Mapper, results chan<- protocol.CodeAction) {
+	if request.Range == (protocol.Range{}) || request.Range.Start != request.Range.End {
+		return
+	}
+	fileNode := linkRes.AST()
+	offset, err := mapper.PositionOffset(request.Range.Start)
+	if err != nil {
+		return
+	}
+	var od protoreflect.OneofDescriptor
+	var node *ast.OneofNode
+	rangeTypeDescriptors(linkRes, func(d protoreflect.Descriptor) bool {
+		md, ok := d.(protoreflect.MessageDescriptor)
+		if !ok {
+			return true
+		}
+		for i := 0; i < md.Oneofs().Len(); i++ {
+			o := md.Oneofs().Get(i)
+			if o.IsSynthetic() {
+				continue
+			}
+			n := linkRes.OneofNode(o.(protoutil.DescriptorProtoWrapper).AsProto().(*descriptorpb.OneofDescriptorProto))
+			if n == nil || n.Keyword == nil || n.Name == nil {
+				continue
+			}
+			if offset >= fileNode.NodeInfo(n.Keyword).Start().Offset && offset <= fileNode.NodeInfo(n.Name).End().Offset+1 {
+				od, node = o, n
+				return false
+			}
+		}
+		return true
+	})
+	if node == nil {
+		return
+	}
+
+	title := fmt.Sprintf("Dissolve oneof %s into separate fields", od.Name())
+	for _, decl := range node.Decls {
+		if decl.GetOption() != nil {
+			results <- protocol.CodeAction{
+				Title: title,
+				Kind:  protocol.RefactorRewrite,
+				Disabled: &protocol.CodeActionDisabled{
+					Reason: fmt.Sprintf("oneof %s has options", od.Name()),
+				},
+			}
+			return
+		}
+	}
+	labels := map[ast.Node]string{}
+	var lost []string
+	implicitPresence := false
+	if linkRes.Syntax() == protoreflect.Editions {
+		// oneof fields cannot specify field presence, so once outside of the
+		// oneof they use the presence of the containing message
+		presence, err := protoutil.ResolveFeature(od.Parent(), fieldPresenceFeature)
+		implicitPresence = err == nil && descriptorpb.FeatureSet_FieldPresence(presence.Enum()) == descriptorpb.FeatureSet_IMPLICIT
+	}
+	for i := 0; i < od.Fields().Len(); i++ {
+		fd := od.Fields().Get(i)
+		fdp := fd.(protoutil.DescriptorProtoWrapper).AsProto().(*descriptorpb.FieldDescriptorProto)
+		n := linkRes.FieldNode(fdp)
+		if n == nil {
+			return
+		}
+		switch linkRes.Syntax() {
+		case protoreflect.Proto2:
+			labels[n.Unwrap()] = "optional "
+		case protoreflect.Proto3:
+			if fd.Kind() != protoreflect.MessageKind {
+				labels[n.Unwrap()] = "optional "
+			}
+		default:
+			if fd.Kind() != protoreflect.MessageKind && implicitPresence {
+				lost = append(lost, string(fd.Name()))
+			}
+		}
+	}
+	if len(lost) > 0 {
+		title += fmt.Sprintf(" (removes presence from %s)", strings.Join(lost, ", "))
+	}
+	results <- enqueueRewrite(title, protocol.RefactorRewrite, linkRes, mapper, func(w *sourceRewriter) bool {
+		w.dissolveOneof(node, labels)
+		return true
+	})
+}
+
+// wrapInOneof replaces the given fields with a new oneof containing them. The
+// oneof is placed where the first field was.
+func (w *sourceRewriter) wrapInOneof(fields []*ast.FieldNode, name string) {
+	firstStart, _ := w.span(fields[0])
+	indent := w.indentation(firstStart)
+	body := make([]string, len(fields))
+	for i, fld := range fields {
+		start, end := w.attachedDeclSpan(fld)
+		text := w.movableText(start, end)
+		if fld.Label != nil {
+			labelStart, _ := w.span(fld.Label)
+			typeStart, _ := w.span(fld.FieldType)
+			text = text[:labelStart-start] + text[typeStart-start:]
+		}
+		body[i] = indent + "  " + strings.ReplaceAll(text, "\n"+indent, "\n"+indent+"  ")
+	}
+	oneof := fmt.Sprintf("oneof %s {\n%s\n%s}", name, strings.Join(body, "\n"), indent)
+	for i, fld := range fields {
+		start, end := w.attachedDeclSpan(fld)
+		lineStart, lineEnd := w.lineSpan(start, end)
+		switch {
+		case i > 0:
+			w.edit(lineStart, lineEnd, "")
+		case lineStart != start || lineEnd != end:
+			w.edit(lineStart, lineEnd, indent+oneof+"\n")
+		default:
+			w.edit(start, end, oneof)
+		}
+	}
+}
+
+// dissolveOneof replaces the oneof with its fields, adding the given labels
+// to them.
+func (w *sourceRewriter) dissolveOneof(node *ast.OneofNode, labels map[ast.Node]string) {
+	oneofStart, _ := w.span(node)
+	indent := w.indentation(oneofStart)
+	var fields []string
+	for _, decl := range node.Decls {
+		fld := decl.Unwrap()
+		start, end := w.attachedDeclSpan(fld)
+		fieldStart, _ := w.span(fld)
+		text := w.movableText(start, end)
+		text = text[:fieldStart-start] + labels[fld] + text[fieldStart-start:]
+		fieldIndent := w.indentation(fieldStart)
+		fields = append(fields, indent+strings.ReplaceAll(text, "\n"+fieldIndent, "\n"+indent))
+	}
+	start, end := w.declSpan(node)
+	lineStart, lineEnd := w.lineSpan(start, end)
+	if lineStart != start || lineEnd != end {
+		w.edit(lineStart, lineEnd, strings.Join(fields, "\n")+"\n")
+	} else {
+		w.edit(start, end, strings.TrimLeftFunc(strings.Join(fields, "\n"), unicode.IsSpace))
+	}
+}
+
+// movableText returns the text of the declaration in the given span, along
+// with its trailing comment if it is on a line of its own.
+func (w *sourceRewriter) movableText(start, end int) string {
+	lineStart, lineEnd := w.lineSpan(start, end)
+	if lineStart == start && lineEnd == end {
+		return w.text(start, end)
+	}
+	return strings.TrimRightFunc(w.text(start, lineEnd), unicode.IsSpace)
+}
+
+// newOneofName returns a name for a new oneof in the message that does not
+// collide with any of its fields, oneofs, or nested declarations.
+func newOneofName(desc protoreflect.MessageDescriptor) string {
+	return findNewUnusedName("new_oneof", func(name protoreflect.Name) bool {
+		return desc.Fields().ByName(name) != nil ||
+			desc.Oneofs().ByName(name) != nil ||
+			desc.Messages().ByName(name) != nil ||
+			desc.Enums().ByName(name) != nil
+	})
+}
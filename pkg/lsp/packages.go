@@ -2,6 +2,8 @@ package lsp
 
 import (
 	"fmt"
+	"path"
+	"strconv"
 	"strings"
 
 	"github.com/kralicky/protocompile/ast"
@@ -12,57 +14,80 @@ import (
 )
 
 func (c *Cache) TryFindPackageReferences(params protocol.TextDocumentPositionParams) []protocol.Location {
+	name, _, prefix, ok := c.packageNameAtLocation(params)
+	if !ok {
+		return nil
+	}
+	return c.FindPackageNameRefs(name, prefix)
+}
+
+// packageNameAtLocation returns the package name in the package statement
+// under the cursor, and its range. If the cursor is on one of the leading
+// components of the name, only the components up to the cursor are returned,
+// and prefix is set.
+func (c *Cache) packageNameAtLocation(params protocol.TextDocumentPositionParams) (name protoreflect.FullName, rng protocol.Range, prefix bool, ok bool) {
 	parseRes, err := c.FindParseResultByURI(params.TextDocument.URI)
 	if err != nil {
-		return nil
+		return
 	}
 
 	mapper, err := c.GetMapper(params.TextDocument.URI)
 	if err != nil {
-		return nil
+		return
 	}
 
 	offset, err := mapper.PositionOffset(params.Position)
 	if err != nil {
-		return nil
+		return
 	}
 
 	fileNode := parseRes.AST()
 	if fileNode == nil {
-		return nil
+		return
 	}
 
 	tokenAtOffset, comment := fileNode.ItemAtOffset(offset)
 	if tokenAtOffset == ast.TokenError || comment.IsValid() {
-		return nil
+		return
 	}
 
-LOOP:
 	for _, decl := range fileNode.Decls {
-		if decl := decl.GetPackage(); decl != nil {
-			if decl.Name != nil {
-				if tokenAtOffset >= decl.Name.Start() && tokenAtOffset <= decl.Name.End() {
-					switch name := decl.Name.Unwrap().(type) {
-					case *ast.IdentNode:
-						return c.FindPackageNameRefs(protoreflect.FullName(name.Val), false)
-					case *ast.CompoundIdentNode:
-						idents := name.FilterIdents()
-						for i, ident := range idents {
-							if tokenAtOffset >= ident.Start() && tokenAtOffset <= ident.End() {
-								var parts []string
-								for j := 0; j <= i; j++ {
-									parts = append(parts, idents[j].Val)
-								}
-								return c.FindPackageNameRefs(protoreflect.FullName(strings.Join(parts, ".")), i < len(idents)-1)
-							}
-						}
+		decl := decl.GetPackage()
+		if decl == nil {
+			continue
+		}
+		if decl.Name == nil || tokenAtOffset < decl.Name.Start() || tokenAtOffset > decl.Name.End() {
+			return
+		}
+		switch node := decl.Name.Unwrap().(type) {
+		case *ast.IdentNode:
+			if info := fileNode.NodeInfo(node); info.IsValid() {
+				return protoreflect.FullName(node.Val), toRange(info), false, true
+			}
+		case *ast.CompoundIdentNode:
+			idents := node.FilterIdents()
+			for i, ident := range idents {
+				if tokenAtOffset >= ident.Start() && tokenAtOffset <= ident.End() {
+					var parts []string
+					for j := 0; j <= i; j++ {
+						parts = append(parts, idents[j].Val)
 					}
+					start := fileNode.NodeInfo(idents[0])
+					end := fileNode.NodeInfo(idents[i])
+					if !start.IsValid() || !end.IsValid() {
+						return
+					}
+					rng = protocol.Range{
+						Start: toPosition(start.Start()),
+						End:   toPosition(end.End()),
+					}
+					return protoreflect.FullName(strings.Join(parts, ".")), rng, i < len(idents)-1, true
 				}
 			}
-			break LOOP
 		}
+		return
 	}
-	return nil
+	return
 }
 
 func (c *Cache) FindPackageNameRefs(name protoreflect.FullName, prefixMatch bool) []protocol.Location {
@@ -223,3 +248,352 @@ LOOP:
 	}
 	return nil
 }
+
+// packageRename holds the state of renaming a package, or every package
+// within a package prefix, across a set of files.
+type packageRename struct {
+	oldName, newName protoreflect.FullName
+	prefix           bool
+	files            linker.Files
+	contents         func(path string) ([]byte, error)
+	rewriters        map[string]*sourceRewriter
+}
+
+// packageFileMover returns the new path of the file with the given path once
+// the directory oldDir, which matches its package, is moved to newDir. It
+// returns false if the file is not in a directory matching its package.
+type packageFileMover func(oldPath, oldDir, newDir string) (string, bool, error)
+
+// moveDir returns the slash-separated file name with its directory replaced
+// by newDir, if the directory is oldDir or ends with it.
+func moveDir(filename, oldDir, newDir string) (string, bool) {
+	dir := path.Dir(filename)
+	if dir != oldDir && !strings.HasSuffix(dir, "/"+oldDir) {
+		return "", false
+	}
+	return path.Join(strings.TrimSuffix(dir, oldDir), newDir, path.Base(filename)), true
+}
+
+// renamePackage returns the edits to each file, keyed by path, that rename
+// the package oldName to newName. If prefix is set, the packages nested
+// within oldName are renamed as well. If move is set, the files whose
+// directory matches their package are moved into the directory matching the
+// new package; the old and new paths of the moved files are returned.
+func renamePackage(files linker.Files, contents func(path string) ([]byte, error), oldName, newName protoreflect.FullName, prefix bool, move packageFileMover) (map[string][]sourceEdit, map[string]string, error) {
+	if !newName.IsValid() {
+		return nil, nil, fmt.Errorf("invalid package name %q", newName)
+	}
+	if newName == oldName {
+		return nil, nil, nil
+	}
+	r := &packageRename{
+		oldName:   oldName,
+		newName:   newName,
+		prefix:    prefix,
+		files:     files,
+		contents:  contents,
+		rewriters: map[string]*sourceRewriter{},
+	}
+
+	resolver := files.AsResolver()
+	var renamedFiles []linker.Result
+	for _, f := range files {
+		res, ok := f.(linker.Result)
+		if !ok || f.IsPlaceholder() {
+			continue
+		}
+		if _, ok := r.renamed(f.Package()); !ok {
+			continue
+		}
+		renamedFiles = append(renamedFiles, res)
+		var conflict protoreflect.FullName
+		rangeTypeDescriptors(f, func(desc protoreflect.Descriptor) bool {
+			newName := r.newFullName(desc)
+			if other, err := resolver.FindDescriptorByName(newName); err == nil {
+				if _, ok := r.renamed(other.ParentFile().Package()); !ok {
+					conflict = newName
+					return false
+				}
+			}
+			return true
+		})
+		if conflict != "" {
+			return nil, nil, fmt.Errorf("cannot rename package %s: %s already exists", oldName, conflict)
+		}
+	}
+	if len(renamedFiles) == 0 {
+		return nil, nil, fmt.Errorf("no files found in package %s", oldName)
+	}
+
+	for _, res := range renamedFiles {
+		if err := r.renamePackageDecl(res); err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, f := range files {
+		res, ok := f.(linker.Result)
+		if !ok || f.IsPlaceholder() {
+			continue
+		}
+		if err := r.requalifyReferences(res); err != nil {
+			return nil, nil, err
+		}
+		if err := r.renameOptionValues(res); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var moved map[string]string
+	if move != nil {
+		moved = map[string]string{}
+		for _, res := range renamedFiles {
+			newPkg, _ := r.renamed(res.Package())
+			oldDir := strings.ReplaceAll(string(res.Package()), ".", "/")
+			newDir := strings.ReplaceAll(string(newPkg), ".", "/")
+			newPath, ok, err := move(res.Path(), oldDir, newDir)
+			if err != nil {
+				return nil, nil, err
+			}
+			if !ok {
+				continue
+			}
+			if files.FindFileByPath(newPath) != nil {
+				return nil, nil, fmt.Errorf("cannot move %s to %s: the file already exists", res.Path(), newPath)
+			}
+			moved[res.Path()] = newPath
+		}
+		if len(moved) > 0 {
+			for _, f := range files {
+				res, ok := f.(linker.Result)
+				if !ok || f.IsPlaceholder() || !r.importsAny(res, moved) {
+					continue
+				}
+				w, err := r.rewriter(res)
+				if err != nil {
+					return nil, nil, err
+				}
+				renameImports(w, moved, true)
+			}
+		}
+	}
+
+	edits := map[string][]sourceEdit{}
+	for path, w := range r.rewriters {
+		if len(w.edits) > 0 {
+			edits[path] = w.edits
+		}
+	}
+	return edits, moved, nil
+}
+
+func (r *packageRename) rewriter(res linker.Result) (*sourceRewriter, error) {
+	if w, ok := r.rewriters[res.Path()]; ok {
+		return w, nil
+	}
+	content, err := r.contents(res.Path())
+	if err != nil {
+		return nil, err
+	}
+	w := &sourceRewriter{res: res, file: res.AST(), content: content}
+	r.rewriters[res.Path()] = w
+	return w, nil
+}
+
+// renamed returns the new name of the given package, if it is renamed.
+func (r *packageRename) renamed(pkg protoreflect.FullName) (protoreflect.FullName, bool) {
+	if pkg == r.oldName {
+		return r.newName, true
+	}
+	if r.prefix {
+		if rest, ok := strings.CutPrefix(string(pkg), string(r.oldName)+"."); ok {
+			return protoreflect.FullName(string(r.newName) + "." + rest), true
+		}
+	}
+	return pkg, false
+}
+
+// newFullName returns the name the given descriptor will have once its
+// package has been renamed.
+func (r *packageRename) newFullName(desc protoreflect.Descriptor) protoreflect.FullName {
+	pkg := desc.ParentFile().Package()
+	newPkg, ok := r.renamed(pkg)
+	if !ok {
+		return desc.FullName()
+	}
+	rel := strings.TrimPrefix(string(desc.FullName()), string(pkg)+".")
+	return protoreflect.FullName(string(newPkg) + "." + rel)
+}
+
+func (r *packageRename) renamePackageDecl(res linker.Result) error {
+	newPkg, _ := r.renamed(res.Package())
+	for _, decl := range res.AST().Decls {
+		if pkg := decl.GetPackage(); pkg != nil && pkg.Name != nil {
+			w, err := r.rewriter(res)
+			if err != nil {
+				return err
+			}
+			start, end := w.span(pkg.Name)
+			w.edit(start, end, string(newPkg))
+			return nil
+		}
+	}
+	return nil
+}
+
+// requalifyReferences updates the references in the file whose names would
+// no longer resolve to the same descriptor once the packages are renamed,
+// either because the referenced descriptor is renamed or because the file
+// itself is.
+func (r *packageRename) requalifyReferences(res linker.Result) error {
+	_, fileRenamed := r.renamed(res.Package())
+	var refs []typeReference
+	visited := map[string]bool{}
+	var visit func(f protoreflect.FileDescriptor) error
+	visit = func(f protoreflect.FileDescriptor) error {
+		if visited[f.Path()] {
+			return nil
+		}
+		visited[f.Path()] = true
+		_, depRenamed := r.renamed(f.Package())
+		if fileRenamed || depRenamed {
+			var err error
+			rangeTypeDescriptors(f, func(desc protoreflect.Descriptor) bool {
+				for _, ref := range res.FindReferences(desc) {
+					node := referenceNameNode(ref.Node)
+					if node == nil {
+						continue
+					}
+					var w *sourceRewriter
+					if w, err = r.rewriter(res); err != nil {
+						return false
+					}
+					start, end := w.span(node)
+					refs = append(refs, typeReference{res: res, desc: desc, start: start, end: end, text: w.text(start, end)})
+				}
+				return true
+			})
+			if err != nil {
+				return err
+			}
+		}
+		for i := 0; i < f.Imports().Len(); i++ {
+			if err := visit(f.Imports().Get(i).FileDescriptor); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := visit(res); err != nil {
+		return err
+	}
+	if len(refs) == 0 {
+		return nil
+	}
+	w, err := r.rewriter(res)
+	if err != nil {
+		return err
+	}
+	for _, ref := range outermostReferences(refs) {
+		if text, ok := r.requalify(ref); ok {
+			w.edit(ref.start, ref.end, text)
+		}
+	}
+	return nil
+}
+
+// requalify returns the text a reference should be replaced with after the
+// rename, if it needs to change.
+func (r *packageRename) requalify(ref typeReference) (string, bool) {
+	name := ref.desc.FullName()
+	newName := r.newFullName(ref.desc)
+	fromPkg, _ := r.renamed(ref.res.Package())
+	if strings.HasPrefix(ref.text, ".") {
+		return "." + string(newName), newName != name
+	}
+	// the name resolved relative to an enclosing scope; it still does if the
+	// scope it resolved in encloses the referencing file after the rename
+	if string(newName) == ref.text || strings.HasSuffix(string(newName), "."+ref.text) {
+		scope := strings.TrimSuffix(strings.TrimSuffix(string(newName), ref.text), ".")
+		if scope == "" || scope == string(fromPkg) ||
+			strings.HasPrefix(string(fromPkg), scope+".") ||
+			strings.HasPrefix(scope, string(fromPkg)+".") {
+			return "", false
+		}
+	}
+	text := relativeFullName(newName, fromPkg)
+	return text, text != ref.text
+}
+
+// renameOptionValues updates the Any type urls in the file's option values,
+// and the string option values which hold the fully qualified name of a
+// renamed descriptor, optionally prefixed with a type url.
+func (r *packageRename) renameOptionValues(res linker.Result) error {
+	resolver := r.files.AsResolver()
+	var err error
+	visitValue := func(node ast.Node) bool {
+		if err != nil {
+			return false
+		}
+		if ref, ok := node.(*ast.FieldReferenceNode); ok && ref.IsAnyTypeReference() {
+			desc, findErr := resolver.FindDescriptorByName(protoreflect.FullName(ref.Name.AsIdentifier()))
+			if findErr != nil || r.newFullName(desc) == desc.FullName() {
+				return false
+			}
+			var w *sourceRewriter
+			if w, err = r.rewriter(res); err != nil {
+				return false
+			}
+			start, end := w.span(ref.Name)
+			w.edit(start, end, string(r.newFullName(desc)))
+			return false
+		}
+		lit, ok := node.(*ast.StringLiteralNode)
+		if !ok {
+			return true
+		}
+		urlPrefix, name := "", lit.AsString()
+		if i := strings.LastIndexByte(name, '/'); i >= 0 {
+			urlPrefix, name = name[:i+1], name[i+1:]
+		}
+		if rest, ok := strings.CutPrefix(name, "."); ok && urlPrefix == "" {
+			urlPrefix, name = ".", rest
+		}
+		desc, findErr := resolver.FindDescriptorByName(protoreflect.FullName(name))
+		if findErr != nil {
+			return true
+		}
+		newName := r.newFullName(desc)
+		if newName == desc.FullName() {
+			return true
+		}
+		var w *sourceRewriter
+		if w, err = r.rewriter(res); err != nil {
+			return false
+		}
+		start, end := w.span(lit)
+		w.edit(start, end, strconv.Quote(urlPrefix+string(newName)))
+		return true
+	}
+	ast.Inspect(res.AST(), func(node ast.Node) bool {
+		if opt, ok := node.(*ast.OptionNode); ok {
+			ast.Inspect(opt.Val, visitValue)
+			return false
+		}
+		return err == nil
+	})
+	return err
+}
+
+// importsAny reports whether the file imports any of the given paths.
+func (r *packageRename) importsAny(res linker.Result, paths map[string]string) bool {
+	if _, ok := paths[res.Path()]; ok {
+		return true
+	}
+	imports := res.Imports()
+	for i := 0; i < imports.Len(); i++ {
+		if _, ok := paths[imports.Get(i).Path()]; ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,314 @@
+package lsp
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kralicky/tools-lite/gopls/pkg/protocol"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+func TestRenamePackage(t *testing.T) {
+	cases := []struct {
+		name      string
+		sources   map[string]string
+		oldName   protoreflect.FullName
+		newName   protoreflect.FullName
+		prefix    bool
+		moveFiles bool
+		want      map[string]string
+		wantMoved map[string]string
+		wantErr   string
+	}{
+		{
+			name: "package",
+			sources: map[string]string{
+				"foo/bar/a.proto": `syntax = "proto3";
+
+package foo.bar;
+
+import "google/protobuf/any.proto";
+import "google/protobuf/descriptor.proto";
+import "foo/b.proto";
+
+extend google.protobuf.MessageOptions {
+  string type_name = 50000;
+  google.protobuf.Any example = 50001;
+}
+
+message A {
+  B b = 1;
+  foo.B b2 = 2;
+  Nested nested = 3;
+  message Nested {}
+}
+`,
+				"foo/b.proto": `syntax = "proto3";
+
+package foo;
+
+message B {}
+`,
+				"c.proto": `syntax = "proto3";
+
+package other;
+
+import "foo/bar/a.proto";
+
+message C {
+  option (foo.bar.type_name) = "foo.bar.A";
+  option (foo.bar.example) = {
+    [type.googleapis.com/foo.bar.A.Nested]: {}
+  };
+  foo.bar.A a = 1;
+  .foo.bar.A.Nested nested = 2;
+}
+`,
+			},
+			oldName: "foo.bar",
+			newName: "baz",
+			want: map[string]string{
+				"foo/bar/a.proto": `syntax = "proto3";
+
+package baz;
+
+import "google/protobuf/any.proto";
+import "google/protobuf/descriptor.proto";
+import "foo/b.proto";
+
+extend google.protobuf.MessageOptions {
+  string type_name = 50000;
+  google.protobuf.Any example = 50001;
+}
+
+message A {
+  foo.B b = 1;
+  foo.B b2 = 2;
+  Nested nested = 3;
+  message Nested {}
+}
+`,
+				"c.proto": `syntax = "proto3";
+
+package other;
+
+import "foo/bar/a.proto";
+
+message C {
+  option (baz.type_name) = "baz.A";
+  option (baz.example) = {
+    [type.googleapis.com/baz.A.Nested]: {}
+  };
+  baz.A a = 1;
+  .baz.A.Nested nested = 2;
+}
+`,
+			},
+		},
+		{
+			name: "prefix with moved files",
+			sources: map[string]string{
+				"foo/bar/a.proto": `syntax = "proto3";
+
+package foo.bar;
+
+import "foo/b.proto";
+
+option go_package = "example.com/foo/bar";
+
+message A {
+  B b = 1;
+}
+`,
+				"foo/b.proto": `syntax = "proto3";
+
+package foo;
+
+message B {}
+`,
+			},
+			oldName:   "foo",
+			newName:   "qux.v1",
+			prefix:    true,
+			moveFiles: true,
+			want: map[string]string{
+				"foo/bar/a.proto": `syntax = "proto3";
+
+package qux.v1.bar;
+
+import "qux/v1/b.proto";
+
+option go_package = "example.com/qux/v1/bar";
+
+message A {
+  B b = 1;
+}
+`,
+				"foo/b.proto": `syntax = "proto3";
+
+package qux.v1;
+
+message B {}
+`,
+			},
+			wantMoved: map[string]string{
+				"foo/bar/a.proto": "qux/v1/bar/a.proto",
+				"foo/b.proto":     "qux/v1/b.proto",
+			},
+		},
+		{
+			name: "conflict",
+			sources: map[string]string{
+				"a.proto": `syntax = "proto3";
+
+package foo;
+
+message A {}
+`,
+				"b.proto": `syntax = "proto3";
+
+package bar;
+
+message A {}
+`,
+			},
+			oldName: "foo",
+			newName: "bar",
+			wantErr: "bar.A already exists",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			files := compileFiles(t, c.sources)
+			var move packageFileMover
+			if c.moveFiles {
+				move = func(oldPath, oldDir, newDir string) (string, bool, error) {
+					newPath, ok := moveDir(oldPath, oldDir, newDir)
+					return newPath, ok, nil
+				}
+			}
+			edits, moved, err := renamePackage(files, func(path string) ([]byte, error) {
+				return []byte(c.sources[path]), nil
+			}, c.oldName, c.newName, c.prefix, move)
+			if c.wantErr != "" {
+				require.ErrorContains(t, err, c.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			got := map[string]string{}
+			for path, e := range edits {
+				out, err := applySourceEdits([]byte(c.sources[path]), e)
+				require.NoError(t, err)
+				got[path] = string(out)
+			}
+			require.Equal(t, c.want, got)
+			if c.wantMoved == nil {
+				require.Empty(t, moved)
+			} else {
+				require.Equal(t, c.wantMoved, moved)
+			}
+		})
+	}
+}
+
+func TestRenamePackageMoveFilesGoModule(t *testing.T) {
+	dir := t.TempDir()
+	sources := map[string]string{
+		"go.mod": "module example.com/ws\n\ngo 1.22\n",
+		"foo/a.proto": `syntax = "proto3";
+
+package foo;
+
+message A {}
+`,
+		"b.proto": `syntax = "proto3";
+
+package b;
+
+import "foo/a.proto";
+
+message B {
+  foo.A a = 1;
+}
+`,
+		"c.proto": `syntax = "proto3";
+
+package c;
+
+import "example.com/ws/foo/a.proto";
+
+message C {
+  foo.A a = 1;
+}
+`,
+	}
+	var protos []string
+	for name, src := range sources {
+		filename := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(filename), 0o755))
+		require.NoError(t, os.WriteFile(filename, []byte(src), 0o644))
+		if strings.HasSuffix(name, ".proto") {
+			protos = append(protos, filename)
+		}
+	}
+	c := NewCache(protocol.WorkspaceFolder{URI: string(protocol.URIFromPath(dir))})
+	movePackageFiles := true
+	c.DidChangeConfiguration(context.Background(), Settings{
+		Rename: RenameSettings{MovePackageFiles: &movePackageFiles},
+	})
+	c.LoadFiles(protos)
+
+	edit, err := c.RenamePackage(context.Background(), "foo", false, "baz.qux")
+	require.NoError(t, err)
+	got := map[string]string{}
+	var renames []*protocol.RenameFile
+	for _, change := range edit.DocumentChanges {
+		if change.RenameFile != nil {
+			renames = append(renames, change.RenameFile)
+			continue
+		}
+		uri := change.TextDocumentEdit.TextDocument.URI
+		rel, err := filepath.Rel(dir, uri.Path())
+		require.NoError(t, err)
+		out, _, err := protocol.ApplyEdits(protocol.NewMapper(uri, []byte(sources[rel])), protocol.AsTextEdits(change.TextDocumentEdit.Edits))
+		require.NoError(t, err)
+		got[filepath.ToSlash(rel)] = string(out)
+	}
+	require.Equal(t, map[string]string{
+		"foo/a.proto": `syntax = "proto3";
+
+package baz.qux;
+
+message A {}
+`,
+		"b.proto": `syntax = "proto3";
+
+package b;
+
+import "baz/qux/a.proto";
+
+message B {
+  baz.qux.A a = 1;
+}
+`,
+		"c.proto": `syntax = "proto3";
+
+package c;
+
+import "example.com/ws/baz/qux/a.proto";
+
+message C {
+  baz.qux.A a = 1;
+}
+`,
+	}, got)
+	require.Equal(t, []*protocol.RenameFile{{
+		Kind:   "rename",
+		OldURI: protocol.URIFromPath(filepath.Join(dir, "foo/a.proto")),
+		NewURI: protocol.URIFromPath(filepath.Join(dir, "baz/qux/a.proto")),
+	}}, renames)
+}
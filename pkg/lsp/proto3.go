@@ -0,0 +1,369 @@
+package lsp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/kralicky/protocompile/ast"
+	"github.com/kralicky/protocompile/linker"
+	"github.com/kralicky/protocompile/protoutil"
+	"github.com/kralicky/protols/pkg/format"
+	"github.com/kralicky/tools-lite/gopls/pkg/protocol"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// Proto3ConversionBlockers lists the elements of a proto2 file that have no
+// proto3 equivalent and must be removed before the file can be converted.
+func Proto3ConversionBlockers(res linker.Result) []string {
+	var blockers []string
+	visitField := func(fd protoreflect.FieldDescriptor) {
+		if fd.Kind() == protoreflect.GroupKind {
+			blockers = append(blockers, fmt.Sprintf("group %s", fd.Message().FullName()))
+		}
+		if fd.IsExtension() && !isOptionsMessage(fd.ContainingMessage()) {
+			blockers = append(blockers, fmt.Sprintf("extension %s of %s", fd.FullName(), fd.ContainingMessage().FullName()))
+		}
+		if fd.Kind() == protoreflect.EnumKind && fd.Enum().ParentFile().Path() != res.Path() && fd.Enum().IsClosed() {
+			blockers = append(blockers, fmt.Sprintf("field %s uses closed enum %s", fd.FullName(), fd.Enum().FullName()))
+		}
+	}
+	var visitMessage func(md protoreflect.MessageDescriptor)
+	visitMessage = func(md protoreflect.MessageDescriptor) {
+		if md.IsMapEntry() {
+			return
+		}
+		if md.ExtensionRanges().Len() > 0 {
+			blockers = append(blockers, fmt.Sprintf("extension ranges in %s", md.FullName()))
+		}
+		for i := 0; i < md.Fields().Len(); i++ {
+			visitField(md.Fields().Get(i))
+		}
+		for i := 0; i < md.Extensions().Len(); i++ {
+			visitField(md.Extensions().Get(i))
+		}
+		for i := 0; i < md.Messages().Len(); i++ {
+			visitMessage(md.Messages().Get(i))
+		}
+	}
+	for i := 0; i < res.Messages().Len(); i++ {
+		visitMessage(res.Messages().Get(i))
+	}
+	for i := 0; i < res.Extensions().Len(); i++ {
+		visitField(res.Extensions().Get(i))
+	}
+	return blockers
+}
+
+// isOptionsMessage reports whether the message is one of the options messages
+// in descriptor.proto, which are the only messages proto3 files may extend.
+func isOptionsMessage(md protoreflect.MessageDescriptor) bool {
+	return md.ParentFile().Path() == "google/protobuf/descriptor.proto" && strings.HasSuffix(string(md.Name()), "Options")
+}
+
+// ConvertToProto3 rewrites the source of a proto2 file as proto3. Required
+// fields lose their requiredness, optional scalar fields keep their presence
+// using proto3 'optional', default values are kept as comments, and enums
+// without a zero value as their first value get one. It returns an error if
+// the file contains elements with no proto3 equivalent; see
+// Proto3ConversionBlockers.
+func ConvertToProto3(res linker.Result, content []byte) ([]byte, error) {
+	if res.Syntax() != protoreflect.Proto2 {
+		return nil, fmt.Errorf("%s is not a proto2 file", res.Path())
+	}
+	if res.AST() == nil {
+		return nil, errors.New("no source available")
+	}
+	if blockers := Proto3ConversionBlockers(res); len(blockers) > 0 {
+		return nil, fmt.Errorf("cannot convert to proto3:\n  %s", strings.Join(blockers, "\n  "))
+	}
+	c := &proto3Conversion{
+		sourceRewriter: sourceRewriter{
+			res:     res,
+			file:    res.AST(),
+			content: content,
+		},
+	}
+	if err := c.rewrite(); err != nil {
+		return nil, err
+	}
+	converted, err := applySourceEdits(content, c.edits)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := format.Format(bytes.NewReader(converted), &buf); err != nil {
+		return nil, fmt.Errorf("failed to format converted source: %w", err)
+	}
+	if _, err := compileMigratedSource(res, buf.Bytes()); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+type proto3Conversion struct {
+	sourceRewriter
+}
+
+func (c *proto3Conversion) rewrite() error {
+	if c.file.Syntax != nil {
+		start, end := c.declSpan(c.file.Syntax)
+		c.edit(start, end, `syntax = "proto3";`)
+	} else {
+		c.edit(0, 0, "syntax = \"proto3\";\n\n")
+	}
+	var visitMessage func(md protoreflect.MessageDescriptor) error
+	visitMessage = func(md protoreflect.MessageDescriptor) error {
+		if md.IsMapEntry() {
+			return nil
+		}
+		for i := 0; i < md.Fields().Len(); i++ {
+			c.rewriteField(md.Fields().Get(i))
+		}
+		for i := 0; i < md.Extensions().Len(); i++ {
+			c.rewriteField(md.Extensions().Get(i))
+		}
+		for i := 0; i < md.Enums().Len(); i++ {
+			if err := c.rewriteEnum(md.Enums().Get(i)); err != nil {
+				return err
+			}
+		}
+		for i := 0; i < md.Messages().Len(); i++ {
+			if err := visitMessage(md.Messages().Get(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for i := 0; i < c.res.Messages().Len(); i++ {
+		if err := visitMessage(c.res.Messages().Get(i)); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < c.res.Enums().Len(); i++ {
+		if err := c.rewriteEnum(c.res.Enums().Get(i)); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < c.res.Extensions().Len(); i++ {
+		c.rewriteField(c.res.Extensions().Get(i))
+	}
+	return nil
+}
+
+func (c *proto3Conversion) rewriteField(fd protoreflect.FieldDescriptor) {
+	fdp, ok := fd.(protoutil.DescriptorProtoWrapper).AsProto().(*descriptorpb.FieldDescriptorProto)
+	if !ok {
+		return
+	}
+	node := c.res.FieldNode(fdp)
+	if node == nil {
+		return
+	}
+	n, ok := node.Unwrap().(*ast.FieldNode)
+	if !ok {
+		return
+	}
+	// Singular message fields and extensions always track presence, so they
+	// don't need an explicit label. Other fields keep their presence using
+	// proto3 optional.
+	keepPresence := fd.Kind() != protoreflect.MessageKind && !fd.IsExtension()
+	if n.Label != nil && (n.Label.Val == "optional" || n.Label.Val == "required") {
+		start, _ := c.span(n.Label)
+		switch {
+		case keepPresence:
+			_, end := c.span(n.Label)
+			c.edit(start, end, "optional")
+		default:
+			end, _ := c.span(n.FieldType)
+			c.edit(start, end, "")
+		}
+	}
+	if def := findOptionNode(c.res, n.GetOptions().GetOptions(), "default"); def != nil {
+		val := def.(*ast.OptionNode).Val
+		valStart, valEnd := c.span(val)
+		start, _ := c.span(n)
+		c.edit(start, start, fmt.Sprintf("// default = %s\n%s", c.text(valStart, valEnd), c.indentation(start)))
+		_, tagEnd := c.span(n.Tag)
+		c.rewriteCompactOptions(n.Options, tagEnd, []string{"default"}, nil)
+	}
+}
+
+// rewriteEnum makes a zero value the first value of the enum, either by moving
+// an existing zero value to the front or by adding a new one.
+func (c *proto3Conversion) rewriteEnum(ed protoreflect.EnumDescriptor) error {
+	values := ed.Values()
+	if values.Len() == 0 || values.Get(0).Number() == 0 {
+		return nil
+	}
+	first := c.enumValueNode(values.Get(0))
+	if first == nil {
+		return nil
+	}
+	insertAt, _ := c.span(first)
+	indent := c.indentation(insertAt)
+	if zero := values.ByNumber(0); zero != nil {
+		node := c.enumValueNode(zero)
+		if node == nil {
+			return nil
+		}
+		start, end := c.lineSpan(c.declSpan(node))
+		decl := strings.TrimSpace(c.text(start, end))
+		c.edit(start, end, "")
+		c.edit(insertAt, insertAt, decl+"\n"+indent)
+		return nil
+	}
+	name, err := c.zeroValueName(ed)
+	if err != nil {
+		return err
+	}
+	c.edit(insertAt, insertAt, fmt.Sprintf("%s = 0;\n%s", name, indent))
+	return nil
+}
+
+func (c *proto3Conversion) enumValueNode(vd protoreflect.EnumValueDescriptor) *ast.EnumValueNode {
+	vdp, ok := vd.(protoutil.DescriptorProtoWrapper).AsProto().(*descriptorpb.EnumValueDescriptorProto)
+	if !ok {
+		return nil
+	}
+	return c.res.EnumValueNode(vdp)
+}
+
+// zeroValueName returns a name for a new zero value of the enum, following
+// the usual ENUM_NAME_UNSPECIFIED convention. Enum values share a scope with
+// the enum itself, so the name must not collide with any of its siblings.
+func (c *proto3Conversion) zeroValueName(ed protoreflect.EnumDescriptor) (string, error) {
+	prefix := screamingSnakeCase(string(ed.Name()))
+	for _, suffix := range []string{"_UNSPECIFIED", "_UNKNOWN", "_UNSET"} {
+		name := prefix + suffix
+		if c.res.FindDescriptorByName(ed.FullName().Parent().Append(protoreflect.Name(name))) == nil {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("could not find an unused name for the zero value of %s", ed.FullName())
+}
+
+func screamingSnakeCase(s string) string {
+	var b strings.Builder
+	for i, r := range s {
+		if i > 0 && unicode.IsUpper(r) {
+			prev := rune(s[i-1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
+
+// requiredFieldNames returns the names of the required fields in the file.
+func requiredFieldNames(res linker.Result) []string {
+	var names []string
+	var visitMessage func(md protoreflect.MessageDescriptor)
+	visitMessage = func(md protoreflect.MessageDescriptor) {
+		for i := 0; i < md.Fields().Len(); i++ {
+			if fd := md.Fields().Get(i); fd.Cardinality() == protoreflect.Required {
+				names = append(names, string(fd.FullName()))
+			}
+		}
+		for i := 0; i < md.Messages().Len(); i++ {
+			visitMessage(md.Messages().Get(i))
+		}
+	}
+	for i := 0; i < res.Messages().Len(); i++ {
+		visitMessage(res.Messages().Get(i))
+	}
+	return names
+}
+
+// changedEnumDefaults returns the names of the enums in the file whose first
+// value is not zero. In proto3, the first value must be zero, and it is the
+// default value of any field of the enum type, so converting the file changes
+// the default of every such field without an explicit default.
+func changedEnumDefaults(res linker.Result) []string {
+	var names []string
+	visitEnums := func(enums protoreflect.EnumDescriptors) {
+		for i := 0; i < enums.Len(); i++ {
+			if ed := enums.Get(i); ed.Values().Len() > 0 && ed.Values().Get(0).Number() != 0 {
+				names = append(names, string(ed.FullName()))
+			}
+		}
+	}
+	var visitMessage func(md protoreflect.MessageDescriptor)
+	visitMessage = func(md protoreflect.MessageDescriptor) {
+		visitEnums(md.Enums())
+		for i := 0; i < md.Messages().Len(); i++ {
+			visitMessage(md.Messages().Get(i))
+		}
+	}
+	for i := 0; i < res.Messages().Len(); i++ {
+		visitMessage(res.Messages().Get(i))
+	}
+	visitEnums(res.Enums())
+	return names
+}
+
+// convertToProto3 offers to convert a proto2 file to proto3 when the cursor
+// is on its syntax declaration. If the file contains elements that cannot be
+// converted, the action is shown as disabled along with the reason. Required
+// fields that would be dropped and enums whose default value would change are
+// listed in the action's title.
+func convertToProto3(ctx context.Context, request *protocol.CodeActionParams, linkRes linker.Result, mapper *protocol.Mapper, results chan<- protocol.CodeAction) {
+	if request.Range == (protocol.Range{}) || request.Range.Start != request.Range.End {
+		return
+	}
+	fileNode := linkRes.AST()
+	if fileNode == nil || fileNode.Syntax == nil || linkRes.Syntax() != protoreflect.Proto2 {
+		return
+	}
+	offset, err := mapper.PositionOffset(request.Range.Start)
+	if err != nil {
+		return
+	}
+	info := fileNode.NodeInfo(fileNode.Syntax)
+	if offset < info.Start().Offset || offset > info.End().Offset+1 {
+		return
+	}
+	title := "Convert to proto3"
+	if blockers := Proto3ConversionBlockers(linkRes); len(blockers) > 0 {
+		results <- protocol.CodeAction{
+			Title: title,
+			Kind:  protocol.RefactorRewrite,
+			Disabled: &protocol.CodeActionDisabled{
+				Reason: "proto3 does not support: " + strings.Join(blockers, ", "),
+			},
+		}
+		return
+	}
+	var changes []string
+	if required := requiredFieldNames(linkRes); len(required) > 0 {
+		changes = append(changes, fmt.Sprintf("drops 'required' from %s", strings.Join(required, ", ")))
+	}
+	if enums := changedEnumDefaults(linkRes); len(enums) > 0 {
+		changes = append(changes, fmt.Sprintf("changes the default value of %s", strings.Join(enums, ", ")))
+	}
+	if len(changes) > 0 {
+		title += fmt.Sprintf(" (%s)", strings.Join(changes, "; "))
+	}
+	results <- actionQueue.enqueue(title, protocol.RefactorRewrite, mapper.URI, fileNode.Version(), func(ca *protocol.CodeAction) error {
+		converted, err := ConvertToProto3(linkRes, mapper.Content)
+		if err != nil {
+			return err
+		}
+		rng, err := mapper.OffsetRange(0, len(mapper.Content))
+		if err != nil {
+			return err
+		}
+		ca.Edit = &protocol.WorkspaceEdit{
+			Changes: map[protocol.DocumentURI][]protocol.TextEdit{
+				mapper.URI: {{Range: rng, NewText: string(converted)}},
+			},
+		}
+		return nil
+	})
+}
@@ -0,0 +1,106 @@
+package lsp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConvertToProto3(t *testing.T) {
+	const src = `
+syntax = "proto2";
+
+package test;
+
+import "google/protobuf/descriptor.proto";
+
+message Foo {
+  required string name = 1;
+  optional int32 count = 2 [default = 5, deprecated = true];
+  optional Bar bar = 3;
+  repeated int32 values = 4;
+  optional Kind kind = 5 [default = KIND_B];
+  enum Nested {
+    NESTED_A = 1;
+  }
+}
+
+message Bar {}
+
+enum Kind {
+  KIND_A = 1;
+  KIND_ZERO = 0;
+  KIND_B = 2;
+}
+
+extend google.protobuf.FieldOptions {
+  optional string tag = 50000;
+}
+`
+	const want = `
+syntax = "proto3";
+
+package test;
+
+import "google/protobuf/descriptor.proto";
+
+message Foo {
+  optional string name = 1;
+  // default = 5
+  optional int32 count  = 2 [deprecated = true];
+  Bar            bar    = 3;
+  repeated int32 values = 4;
+  // default = KIND_B
+  optional Kind kind = 5;
+  enum Nested {
+    NESTED_UNSPECIFIED = 0;
+    NESTED_A           = 1;
+  }
+}
+
+message Bar {}
+
+enum Kind {
+  KIND_ZERO = 0;
+  KIND_A    = 1;
+  KIND_B    = 2;
+}
+
+extend google.protobuf.FieldOptions {
+  string tag = 50000;
+}
+`
+	res := compileFile(t, src)
+	require.Equal(t, []string{"test.Foo.name"}, requiredFieldNames(res))
+	require.Equal(t, []string{"test.Foo.Nested", "test.Kind"}, changedEnumDefaults(res))
+	out, err := ConvertToProto3(res, []byte(src))
+	require.NoError(t, err)
+	require.Equal(t, want[1:], string(out))
+}
+
+func TestProto3ConversionBlockers(t *testing.T) {
+	const src = `
+syntax = "proto2";
+
+package test;
+
+message Foo {
+  optional group Result = 1 {
+    optional string url = 2;
+  }
+  extensions 100 to 200;
+}
+
+extend Foo {
+  optional int32 ext = 100;
+}
+`
+	res := compileFile(t, src)
+	require.Equal(t, []string{
+		"extension ranges in test.Foo",
+		"group test.Foo.Result",
+		"extension test.ext of test.Foo",
+	}, Proto3ConversionBlockers(res))
+	_, err := ConvertToProto3(res, []byte(src))
+	require.ErrorContains(t, err, "cannot convert to proto3")
+}
@@ -0,0 +1,446 @@
+package lsp
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/kralicky/protocompile/ast"
+	"github.com/kralicky/protocompile/parser"
+	"github.com/kralicky/protocompile/walk"
+	"github.com/kralicky/tools-lite/gopls/pkg/protocol"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// quickFix is a fix for a diagnostic, computed from the parsed source of the
+// file the diagnostic was reported in.
+type quickFix struct {
+	title     string
+	preferred bool
+	edits     []sourceEdit
+}
+
+// quickFixActions returns the quick fixes for a diagnostic whose metadata
+// identifies one of the compile errors handled by quickFixes.
+func (c *Cache) quickFixActions(uri protocol.DocumentURI, diagnostic protocol.Diagnostic, metadata map[string]string) []protocol.CodeAction {
+	res, err := c.FindParseResultByURI(uri)
+	if err != nil || res.AST() == nil {
+		return nil
+	}
+	mapper, err := c.GetMapper(uri)
+	if err != nil {
+		return nil
+	}
+	offset, err := mapper.PositionOffset(diagnostic.Range.Start)
+	if err != nil {
+		return nil
+	}
+	var actions []protocol.CodeAction
+	for _, fix := range quickFixes(res, mapper.Content, metadata, offset) {
+		w := &sourceRewriter{file: res.AST(), content: mapper.Content, edits: fix.edits}
+		edits, err := w.textEdits(mapper)
+		if err != nil {
+			continue
+		}
+		actions = append(actions, protocol.CodeAction{
+			Title:       fix.title,
+			Kind:        protocol.QuickFix,
+			Diagnostics: []protocol.Diagnostic{diagnostic},
+			IsPreferred: fix.preferred,
+			Edit: &protocol.WorkspaceEdit{
+				DocumentChanges: protocol.TextEditsToDocumentChanges(uri, res.AST().Version(), edits),
+			},
+		})
+	}
+	return actions
+}
+
+// quickFixes returns the fixes for the diagnostic with the given metadata
+// whose range starts at the given offset. The source content must match the
+// parse result, since the diagnostic's range is only known by its offset.
+func quickFixes(res parser.Result, content []byte, metadata map[string]string, offset int) []quickFix {
+	file := res.AST()
+	if file == nil || offset < 0 || offset > len(content) {
+		return nil
+	}
+	q := &quickFixer{
+		res:     res,
+		file:    file,
+		content: content,
+		offset:  offset,
+	}
+	switch metadata[diagnosticKind] {
+	case diagnosticKindInvalidNumber:
+		return q.renumber()
+	case diagnosticKindRedeclared:
+		return q.rename(protoreflect.FullName(metadata["name"]))
+	case diagnosticKindMissingZeroValue:
+		return q.addZeroValue()
+	case diagnosticKindJSONNameConflict:
+		return q.fixJSONName(metadata["field"])
+	case diagnosticKindOptionValueType:
+		return q.convertOptionValue(metadata["expected"])
+	case diagnosticKindMissingSyntax:
+		return q.addSyntax()
+	}
+	return nil
+}
+
+type quickFixer struct {
+	res     parser.Result
+	file    *ast.FileNode
+	content []byte
+	offset  int
+}
+
+func (q *quickFixer) rewriter() *sourceRewriter {
+	return &sourceRewriter{file: q.file, content: q.content}
+}
+
+// at reports whether the node starts at the diagnostic's offset.
+func (q *quickFixer) at(n ast.Node) bool {
+	return n != nil && q.file.NodeInfo(n).Start().Offset == q.offset
+}
+
+func (q *quickFixer) fix(title string, preferred bool, w *sourceRewriter) []quickFix {
+	return []quickFix{{title: title, preferred: preferred, edits: w.edits}}
+}
+
+// declaredNames returns the full names of all elements declared in the file.
+// Enum values are named within the scope enclosing their enum.
+func (q *quickFixer) declaredNames() map[protoreflect.FullName]bool {
+	names := map[protoreflect.FullName]bool{}
+	walk.DescriptorProtos(q.res.FileDescriptorProto(), func(name protoreflect.FullName, _ proto.Message) error {
+		names[name] = true
+		return nil
+	})
+	return names
+}
+
+// renumber changes the number of the field or enum value whose number is at
+// the diagnostic's offset to the next number that is free in its message or
+// enum.
+func (q *quickFixer) renumber() []quickFix {
+	var number ast.Node
+	var next int64
+	var ok bool
+	walk.DescriptorProtos(q.res.FileDescriptorProto(), func(_ protoreflect.FullName, m proto.Message) error {
+		switch m := m.(type) {
+		case *descriptorpb.DescriptorProto:
+			for _, fld := range m.Field {
+				decl := q.res.FieldNode(fld)
+				if decl == nil {
+					continue
+				}
+				if n, isField := decl.Unwrap().(taggedFieldNode); isField && q.at(n.GetTag()) {
+					number = n.GetTag()
+					next, ok = nextFieldNumber(m, fld)
+				}
+			}
+		case *descriptorpb.EnumDescriptorProto:
+			for _, val := range m.Value {
+				if n := q.res.EnumValueNode(val); n != nil && q.at(n.Number) {
+					number = n.Number
+					next, ok = nextEnumNumber(m, val)
+				}
+			}
+		}
+		return nil
+	})
+	if number == nil || !ok {
+		return nil
+	}
+	w := q.rewriter()
+	start, end := w.span(number)
+	w.edit(start, end, strconv.FormatInt(next, 10))
+	return q.fix(fmt.Sprintf("Change number to %d", next), true, w)
+}
+
+// nextFieldNumber returns the number following the highest one used by the
+// fields of the message other than exclude, skipping over reserved numbers
+// and extension ranges.
+func nextFieldNumber(md *descriptorpb.DescriptorProto, exclude *descriptorpb.FieldDescriptorProto) (int64, bool) {
+	var next int64 = 1
+	for _, fld := range md.Field {
+		if fld != exclude {
+			next = max(next, int64(fld.GetNumber())+1)
+		}
+	}
+	for moved := true; moved; {
+		moved = false
+		for _, r := range md.ReservedRange {
+			if next >= int64(r.GetStart()) && next < int64(r.GetEnd()) {
+				next, moved = int64(r.GetEnd()), true
+			}
+		}
+		for _, r := range md.ExtensionRange {
+			if next >= int64(r.GetStart()) && next < int64(r.GetEnd()) {
+				next, moved = int64(r.GetEnd()), true
+			}
+		}
+		if next >= int64(protowire.FirstReservedNumber) && next <= int64(protowire.LastReservedNumber) {
+			next, moved = int64(protowire.LastReservedNumber)+1, true
+		}
+	}
+	return next, next <= int64(protowire.MaxValidNumber)
+}
+
+// nextEnumNumber returns the number following the highest one used by the
+// values of the enum other than exclude, skipping over reserved numbers.
+func nextEnumNumber(ed *descriptorpb.EnumDescriptorProto, exclude *descriptorpb.EnumValueDescriptorProto) (int64, bool) {
+	var next int64
+	for _, val := range ed.Value {
+		if val != exclude {
+			next = max(next, int64(val.GetNumber())+1)
+		}
+	}
+	for moved := true; moved; {
+		moved = false
+		for _, r := range ed.ReservedRange {
+			if next >= int64(r.GetStart()) && next <= int64(r.GetEnd()) {
+				next, moved = int64(r.GetEnd())+1, true
+			}
+		}
+	}
+	return next, next <= math.MaxInt32
+}
+
+// rename renames the redeclared element whose name is at the diagnostic's
+// offset to an unused name in the same scope. References to the element are
+// left alone, since they are ambiguous.
+func (q *quickFixer) rename(name protoreflect.FullName) []quickFix {
+	w := q.rewriter()
+	end := q.offset + len(name.Name())
+	if !name.IsValid() || end > len(q.content) || w.text(q.offset, end) != string(name.Name()) {
+		return nil
+	}
+	declared := q.declaredNames()
+	prefix := string(name.Name())
+	if last := prefix[len(prefix)-1]; last >= '0' && last <= '9' {
+		prefix += "_"
+	}
+	newName := findNewUnusedName(prefix, func(n protoreflect.Name) bool {
+		return string(n) == prefix || declared[name.Parent().Append(n)]
+	})
+	w.edit(q.offset, end, newName)
+	return q.fix("Rename to "+newName, false, w)
+}
+
+// addZeroValue fixes an open enum whose first value is not zero, either by
+// moving its zero value to the top, or by adding a new one.
+func (q *quickFixer) addZeroValue() []quickFix {
+	var enum *descriptorpb.EnumDescriptorProto
+	var scope protoreflect.FullName
+	walk.DescriptorProtos(q.res.FileDescriptorProto(), func(name protoreflect.FullName, m proto.Message) error {
+		if ed, ok := m.(*descriptorpb.EnumDescriptorProto); ok && len(ed.Value) > 0 {
+			if n := q.res.EnumValueNode(ed.Value[0]); n != nil && q.at(n.Number) {
+				enum, scope = ed, name.Parent()
+			}
+		}
+		return nil
+	})
+	if enum == nil {
+		return nil
+	}
+	w := q.rewriter()
+	insertAt, _ := w.movableSpan(q.res.EnumValueNode(enum.Value[0]))
+	indent := w.indentation(insertAt)
+	for _, val := range enum.Value[1:] {
+		if val.GetNumber() != 0 {
+			continue
+		}
+		start, end := w.movableSpan(q.res.EnumValueNode(val))
+		w.edit(insertAt, insertAt, w.movableText(start, end)+"\n"+indent)
+		lineStart, lineEnd := w.lineSpan(start, end)
+		w.edit(lineStart, lineEnd, "")
+		return q.fix(fmt.Sprintf("Move %s to the top", val.GetName()), true, w)
+	}
+	declared := q.declaredNames()
+	prefix := screamingSnakeCase(enum.GetName())
+	for _, suffix := range []string{"_UNSPECIFIED", "_UNKNOWN", "_UNSET"} {
+		name := prefix + suffix
+		if declared[scope.Append(protoreflect.Name(name))] {
+			continue
+		}
+		w.edit(insertAt, insertAt, name+" = 0;\n"+indent)
+		return q.fix("Add zero value "+name, true, w)
+	}
+	return nil
+}
+
+// fixJSONName fixes a field whose JSON name conflicts with that of another
+// field in the same message by removing the custom JSON name of either field.
+// Conflicts between default JSON names can only be fixed by renaming one of
+// the fields, since they are reported even if a custom JSON name is set.
+func (q *quickFixer) fixJSONName(otherName string) []quickFix {
+	var node, other taggedFieldNode
+	walk.DescriptorProtos(q.res.FileDescriptorProto(), func(_ protoreflect.FullName, m proto.Message) error {
+		md, ok := m.(*descriptorpb.DescriptorProto)
+		if !ok || node != nil {
+			return nil
+		}
+		for _, fld := range md.Field {
+			if decl := q.res.FieldNode(fld); decl != nil {
+				if n, ok := decl.Unwrap().(taggedFieldNode); ok && n.GetTag() != nil && q.at(n) {
+					node = n
+				}
+			}
+		}
+		if node == nil {
+			return nil
+		}
+		for _, fld := range md.Field {
+			if fld.GetName() == otherName {
+				if decl := q.res.FieldNode(fld); decl != nil {
+					other, _ = decl.Unwrap().(taggedFieldNode)
+				}
+			}
+		}
+		return nil
+	})
+	if node == nil {
+		return nil
+	}
+	w := q.rewriter()
+	if w.removeBuiltinOption(node, "json_name") {
+		return q.fix("Remove json_name option", true, w)
+	}
+	if other != nil && w.removeBuiltinOption(other, "json_name") {
+		return q.fix("Remove json_name option from "+otherName, true, w)
+	}
+	return nil
+}
+
+// removeBuiltinOption removes the builtin option with the given name from the
+// compact options of a field, if it is set there.
+func (w *sourceRewriter) removeBuiltinOption(node taggedFieldNode, name string) bool {
+	opts := node.GetOptions()
+	if opts == nil || node.GetTag() == nil {
+		return false
+	}
+	var kept []string
+	var found bool
+	for _, opt := range opts.Options {
+		if isBuiltinOption(opt, name) {
+			found = true
+			continue
+		}
+		start, end := w.span(opt)
+		kept = append(kept, w.text(start, end))
+	}
+	if !found {
+		return false
+	}
+	openStart, _ := w.span(opts.OpenBracket)
+	_, closeEnd := w.span(opts.CloseBracket)
+	if len(kept) == 0 {
+		_, tagEnd := w.span(node.GetTag())
+		w.edit(tagEnd, closeEnd, "")
+	} else {
+		w.edit(openStart, closeEnd, "["+strings.Join(kept, ", ")+"]")
+	}
+	return true
+}
+
+// taggedFieldNode is implemented by the AST nodes of fields, map fields, and
+// groups.
+type taggedFieldNode interface {
+	ast.Node
+	GetTag() *ast.UintLiteralNode
+	GetOptions() *ast.CompactOptionsNode
+}
+
+// isBuiltinOption reports whether the option sets the builtin option with
+// the given name.
+func isBuiltinOption(opt *ast.OptionNode, name string) bool {
+	if opt.Name == nil || len(opt.Name.Parts) != 1 {
+		return false
+	}
+	ref := opt.Name.Parts[0].GetFieldRef()
+	return ref != nil && !ref.IsExtension() && ref.Value() == name
+}
+
+// convertOptionValue converts an option value at the diagnostic's offset to
+// an equivalent value of the expected type, such as a quoted number where a
+// string is expected.
+func (q *quickFixer) convertOptionValue(expected string) []quickFix {
+	var val *ast.ValueNode
+	check := func(v *ast.ValueNode) {
+		if v == nil {
+			return
+		}
+		if q.at(v) {
+			val = v
+		} else if arr := v.GetArrayLiteral(); arr != nil {
+			for _, elem := range arr.FilterValues() {
+				if q.at(elem) {
+					val = elem
+				}
+			}
+		}
+	}
+	ast.Inspect(q.file, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.OptionNode:
+			check(n.Val)
+		case *ast.MessageFieldNode:
+			check(n.Val)
+		}
+		return val == nil
+	})
+	if val == nil {
+		return nil
+	}
+	w := q.rewriter()
+	start, end := w.span(val)
+	text := w.text(start, end)
+	var converted string
+	switch expected {
+	case "enum", "enum name":
+		if s, ok := val.Value().(string); ok && protoreflect.Name(s).IsValid() {
+			converted = s
+		}
+	default:
+		kind, ok := scalarKinds[expected]
+		if !ok {
+			return nil
+		}
+		converted, _ = convertScalar(text, val.Value(), kind)
+	}
+	if converted == "" || converted == text {
+		return nil
+	}
+	w.edit(start, end, converted)
+	return q.fix("Change value to "+converted, true, w)
+}
+
+// addSyntax adds a syntax declaration to a file that has none. Since such
+// files are compiled as proto2, adding an explicit proto2 syntax is preferred.
+func (q *quickFixer) addSyntax() []quickFix {
+	if q.file.Syntax != nil || q.file.Edition != nil {
+		return nil
+	}
+	w := q.rewriter()
+	insertAt, suffix := 0, "\n"
+	for _, decl := range q.file.Decls {
+		if n := decl.Unwrap(); n != nil {
+			insertAt, _ = w.attachedDeclSpan(n)
+			suffix = "\n\n"
+			break
+		}
+	}
+	var fixes []quickFix
+	for _, syntax := range []string{"proto2", "proto3"} {
+		decl := fmt.Sprintf("syntax = %q;", syntax)
+		fixes = append(fixes, quickFix{
+			title:     "Add " + decl,
+			preferred: syntax == "proto2",
+			edits:     []sourceEdit{{start: insertAt, end: insertAt, text: decl + suffix}},
+		})
+	}
+	return fixes
+}
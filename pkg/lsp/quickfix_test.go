@@ -0,0 +1,376 @@
+package lsp
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuickFixes(t *testing.T) {
+	cases := []struct {
+		name  string
+		src   string
+		at    string
+		title string
+		want  string
+	}{
+		{
+			name: "duplicate field number",
+			src: `syntax = "proto3";
+
+message Foo {
+  string a = 1;
+  string b = 1;
+  reserved 2 to 4;
+}
+`,
+			at:    "1;\n  reserved",
+			title: "Change number to 5",
+			want: `syntax = "proto3";
+
+message Foo {
+  string a = 1;
+  string b = 5;
+  reserved 2 to 4;
+}
+`,
+		},
+		{
+			name: "reserved field number",
+			src: `syntax = "proto3";
+
+message Foo {
+  reserved 2;
+  string a = 1;
+  string b = 2;
+}
+`,
+			at:    "2;\n}",
+			title: "Change number to 3",
+			want: `syntax = "proto3";
+
+message Foo {
+  reserved 2;
+  string a = 1;
+  string b = 3;
+}
+`,
+		},
+		{
+			name: "implementation-reserved field number",
+			src: `syntax = "proto3";
+
+message Foo {
+  string a = 1;
+  string b = 19000;
+}
+`,
+			at:    "19000",
+			title: "Change number to 2",
+			want: `syntax = "proto3";
+
+message Foo {
+  string a = 1;
+  string b = 2;
+}
+`,
+		},
+		{
+			name: "reserved enum value number",
+			src: `syntax = "proto3";
+
+enum Kind {
+  KIND_UNSPECIFIED = 0;
+  KIND_A = 1;
+  reserved 2;
+  KIND_B = 2;
+}
+`,
+			at:    "2;\n}",
+			title: "Change number to 3",
+			want: `syntax = "proto3";
+
+enum Kind {
+  KIND_UNSPECIFIED = 0;
+  KIND_A = 1;
+  reserved 2;
+  KIND_B = 3;
+}
+`,
+		},
+		{
+			name: "duplicate name",
+			src: `syntax = "proto3";
+
+package test;
+
+message Foo {
+  string a = 1;
+  int32 a = 2;
+  bool a1 = 3;
+}
+`,
+			at:    "a = 2",
+			title: "Rename to a2",
+			want: `syntax = "proto3";
+
+package test;
+
+message Foo {
+  string a = 1;
+  int32 a2 = 2;
+  bool a1 = 3;
+}
+`,
+		},
+		{
+			name: "missing enum zero value",
+			src: `syntax = "proto3";
+
+message Foo {
+  enum Kind {
+    // The first kind.
+    KIND_A = 1;
+    KIND_B = 2;
+  }
+}
+`,
+			at:    "1;",
+			title: "Add zero value KIND_UNSPECIFIED",
+			want: `syntax = "proto3";
+
+message Foo {
+  enum Kind {
+    KIND_UNSPECIFIED = 0;
+    // The first kind.
+    KIND_A = 1;
+    KIND_B = 2;
+  }
+}
+`,
+		},
+		{
+			name: "misplaced enum zero value",
+			src: `syntax = "proto3";
+
+enum Kind {
+  KIND_A = 1;
+  // The default kind.
+  KIND_NONE = 0; // none
+}
+`,
+			at:    "1;",
+			title: "Move KIND_NONE to the top",
+			want: `syntax = "proto3";
+
+enum Kind {
+  // The default kind.
+  KIND_NONE = 0; // none
+  KIND_A = 1;
+}
+`,
+		},
+		{
+			name: "custom json name conflict",
+			src: `syntax = "proto3";
+
+message Foo {
+  string foo_bar = 1;
+  string baz = 2 [json_name = "fooBar", deprecated = true];
+}
+`,
+			at:    "string baz",
+			title: "Remove json_name option",
+			want: `syntax = "proto3";
+
+message Foo {
+  string foo_bar = 1;
+  string baz = 2 [deprecated = true];
+}
+`,
+		},
+		{
+			name: "custom json name conflict with other field",
+			src: `syntax = "proto3";
+
+message Foo {
+  string baz = 1 [json_name = "fooBar"];
+  string foo_bar = 2;
+}
+`,
+			at:    "string foo_bar",
+			title: "Remove json_name option from baz",
+			want: `syntax = "proto3";
+
+message Foo {
+  string baz = 1;
+  string foo_bar = 2;
+}
+`,
+		},
+		{
+			name: "option value type",
+			src: `syntax = "proto3";
+
+option java_multiple_files = "true";
+`,
+			at:    `"true"`,
+			title: "Change value to true",
+			want: `syntax = "proto3";
+
+option java_multiple_files = true;
+`,
+		},
+		{
+			name: "option enum value type",
+			src: `syntax = "proto3";
+
+option optimize_for = "SPEED";
+`,
+			at:    `"SPEED"`,
+			title: "Change value to SPEED",
+			want: `syntax = "proto3";
+
+option optimize_for = SPEED;
+`,
+		},
+		{
+			name: "missing syntax",
+			src: `// Copyright notice.
+
+// Package test is a test.
+package test;
+
+message Foo {}
+`,
+			at:    "package test",
+			title: `Add syntax = "proto2";`,
+			want: `// Copyright notice.
+
+syntax = "proto2";
+
+// Package test is a test.
+package test;
+
+message Foo {}
+`,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			res, diagnostics := compileFileWithDiagnostics(t, c.src)
+			offset := strings.Index(c.src, c.at)
+			require.GreaterOrEqual(t, offset, 0)
+			var fixes []quickFix
+			for _, d := range diagnostics {
+				metadata := metadataForError(d)
+				if metadata == nil || d.GetPosition().Start().Offset != offset {
+					continue
+				}
+				fixes = append(fixes, quickFixes(res, []byte(c.src), metadata, offset)...)
+			}
+			var titles []string
+			for _, fix := range fixes {
+				titles = append(titles, fix.title)
+				if fix.title != c.title {
+					continue
+				}
+				out, err := applySourceEdits([]byte(c.src), fix.edits)
+				require.NoError(t, err)
+				require.Equal(t, c.want, string(out))
+
+				_, diagnostics := compileFileWithDiagnostics(t, string(out))
+				require.Empty(t, diagnostics)
+				return
+			}
+			t.Fatalf("no fix %q, got %q", c.title, titles)
+		})
+	}
+}
+
+// TestMetadataForError pins the exact upstream messages that are classified
+// by pattern, so that changes to their wording are caught here.
+func TestMetadataForError(t *testing.T) {
+	cases := []struct {
+		name    string
+		src     string
+		message string
+		want    map[string]string
+	}{
+		{
+			name:    "duplicate field number",
+			src:     "message Foo {\n  string a = 1;\n  string b = 1;\n}\n",
+			message: "message test.Foo: fields a and b both have the same tag 1",
+			want:    map[string]string{diagnosticKind: diagnosticKindInvalidNumber},
+		},
+		{
+			name:    "reserved field number",
+			src:     "message Foo {\n  reserved 2 to 4;\n  string a = 3;\n}\n",
+			message: "message test.Foo: field a is using tag 3 which is in reserved range 2 to 4",
+			want:    map[string]string{diagnosticKind: diagnosticKindInvalidNumber},
+		},
+		{
+			name:    "reserved enum number",
+			src:     "enum E {\n  A = 0;\n  reserved -5 to -1;\n  B = -2;\n}\n",
+			message: "enum test.E: value B is using number -2 which is in reserved range -5 to -1",
+			want:    map[string]string{diagnosticKind: diagnosticKindInvalidNumber},
+		},
+		{
+			name:    "zero field number",
+			src:     "message Foo {\n  string a = 0;\n}\n",
+			message: "tag number 0 must be greater than zero",
+			want:    map[string]string{diagnosticKind: diagnosticKindInvalidNumber},
+		},
+		{
+			name:    "field number too large",
+			src:     "message Foo {\n  string a = 536870912;\n}\n",
+			message: "tag number 536870912 is higher than max allowed tag number (536870911)",
+			want:    map[string]string{diagnosticKind: diagnosticKindInvalidNumber},
+		},
+		{
+			name:    "field number in implementation reserved range",
+			src:     "message Foo {\n  string a = 19000;\n}\n",
+			message: "tag number 19000 is in disallowed reserved range 19000-19999",
+			want:    map[string]string{diagnosticKind: diagnosticKindInvalidNumber},
+		},
+		{
+			name:    "reserved field name",
+			src:     "message Foo {\n  reserved \"a\";\n  string a = 1;\n}\n",
+			message: "message test.Foo: field a is using a reserved name",
+			want:    nil,
+		},
+		{
+			name:    "missing zero value",
+			src:     "enum E {\n  A = 1;\n}\n",
+			message: "first value of open enum test.E must be zero",
+			want:    map[string]string{diagnosticKind: diagnosticKindMissingZeroValue},
+		},
+		{
+			name:    "custom json name conflict",
+			src:     "message Foo {\n  string a = 1 [json_name = \"b\"];\n  string b = 2;\n}\n",
+			message: `field Foo.b: default JSON name "b" conflicts with custom JSON name of field a, defined at test.proto:4:3-34`,
+			want:    map[string]string{diagnosticKind: diagnosticKindJSONNameConflict, "field": "a"},
+		},
+		{
+			name:    "default json name conflict",
+			src:     "message Foo {\n  string foo_bar = 1;\n  string fooBar = 2;\n}\n",
+			message: `field Foo.fooBar: default JSON name "fooBar" conflicts with default JSON name of field foo_bar, defined at test.proto:4:3-22`,
+			want:    map[string]string{diagnosticKind: diagnosticKindJSONNameConflict, "field": "foo_bar"},
+		},
+		{
+			name:    "option value type",
+			src:     "message Foo {\n  string a = 1 [deprecated = \"true\"];\n}\n",
+			message: "expecting bool, got string",
+			want:    map[string]string{diagnosticKind: diagnosticKindOptionValueType, "expected": "bool"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, diagnostics := compileFileWithDiagnostics(t, "syntax = \"proto3\";\npackage test;\n"+c.src)
+			require.Len(t, diagnostics, 1)
+			require.Equal(t, c.message, diagnostics[0].Unwrap().Error())
+			require.Equal(t, c.want, metadataForError(diagnostics[0]))
+		})
+	}
+}
@@ -27,7 +27,14 @@ var analyzers = map[protocol.CodeActionKind][]Analyzer{
 	protocol.RefactorRewrite: {
 		simplifyRepeatedOptions,
 		// simplifyRepeatedFieldLiterals,
+		simplifyMapLiteralFields,
 		renumberFields,
+		migrateToEdition,
+		convertToProto3,
+		wrapFieldsInOneof,
+		dissolveOneof,
+		sortElements,
+		generateResourceService,
 	},
 	protocol.RefactorExtract: {
 		extractFields,
@@ -441,9 +448,11 @@ func newFieldVisitor(tracker *paths.AncestorTracker, paths *[]protopath.Values)
 	}
 }
 
-func extractFields(ctx context.Context, request *protocol.CodeActionParams, linkRes linker.Result, mapper *protocol.Mapper, results chan<- protocol.CodeAction) {
+// findSelectedFields returns the fields enclosed by the requested range, if
+// the range exclusively encloses fields of a single message.
+func findSelectedFields(request *protocol.CodeActionParams, linkRes linker.Result, mapper *protocol.Mapper) (*ast.MessageNode, protoreflect.MessageDescriptor, []*ast.FieldNode, bool) {
 	if request.Range.Start == request.Range.End {
-		return
+		return nil, nil, nil, false
 	}
 	fileNode := linkRes.AST()
 	startOff, endOff, _ := mapper.RangeOffsets(request.Range)
@@ -465,12 +474,12 @@ func extractFields(ctx context.Context, request *protocol.CodeActionParams, link
 		endOff--
 	}
 	if endOff-startOff < 1 {
-		return
+		return nil, nil, nil, false
 	}
 
 	values, ok := findPathsEnclosingRange(linkRes, startToken, endToken, newFieldVisitor)
 	if !ok {
-		return
+		return nil, nil, nil, false
 	}
 
 	var enclosedFields []*ast.FieldNode
@@ -482,173 +491,181 @@ func extractFields(ctx context.Context, request *protocol.CodeActionParams, link
 				continue
 			}
 			if parentNodePath.Len() == 0 {
-				parentNodePath = paths.Slice(path, 0, path.Len()-1)
-			} else if !parentNodePath.Index(-1).Value.Equal(path.Index(-1).Value) {
-				return // fields in the range must share the same parent
+				parentNodePath = paths.Slice(path, 0, path.Len()-3)
+			} else if !parentNodePath.Index(-1).Value.Equal(path.Index(-4).Value) {
+				return nil, nil, nil, false // fields in the range must share the same parent
 			}
 			enclosedFields = append(enclosedFields, fld)
 		} else {
-			return // the range must exclusively enclose message fields
+			return nil, nil, nil, false // the range must exclusively enclose message fields
 		}
 	}
 
 	if len(enclosedFields) == 0 {
-		return
+		return nil, nil, nil, false
 	}
 
 	desc, _, err := deepPathSearch(parentNodePath.Path, linkRes, linkRes)
 	if err != nil {
+		return nil, nil, nil, false
+	}
+	msgDesc, ok := desc.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, nil, nil, false
+	}
+	msgNode := paths.NodeAt[*ast.MessageNode](parentNodePath.Index(-1))
+	if msgNode == nil {
+		return nil, nil, nil, false
+	}
+	openInfo := fileNode.NodeInfo(msgNode.OpenBrace)
+	closeInfo := fileNode.NodeInfo(msgNode.CloseBrace)
+	if startOff < openInfo.Start().Offset || endOff > closeInfo.End().Offset {
+		return nil, nil, nil, false
+	}
+	return msgNode, msgDesc, enclosedFields, true
+}
+
+func extractFields(ctx context.Context, request *protocol.CodeActionParams, linkRes linker.Result, mapper *protocol.Mapper, results chan<- protocol.CodeAction) {
+	msgNode, desc, enclosedFields, ok := findSelectedFields(request, linkRes, mapper)
+	if !ok {
 		return
 	}
-	switch desc := desc.(type) {
-	case protoreflect.MessageDescriptor:
-		msgNode := paths.NodeAt[*ast.MessageNode](parentNodePath.Index(-1))
-		if msgNode == nil {
+	fileNode := linkRes.AST()
+	fieldDescs := make([]protoreflect.FieldDescriptor, len(enclosedFields))
+	for i, fld := range enclosedFields {
+		number, ok := ast.AsInt32(fld.Tag, 0, int32(protowire.MaxValidNumber))
+		if !ok {
 			return
 		}
-		{
-			openInfo := fileNode.NodeInfo(msgNode.OpenBrace)
-			closeInfo := fileNode.NodeInfo(msgNode.CloseBrace)
-			if startOff < openInfo.Start().Offset || endOff > closeInfo.End().Offset {
-				return
-			}
-		}
-		fieldDescs := make([]protoreflect.FieldDescriptor, len(enclosedFields))
-		for i, fld := range enclosedFields {
-			number, ok := ast.AsInt32(fld.Tag, 0, int32(protowire.MaxValidNumber))
-			if !ok {
-				return
-			}
-			fieldDescs[i] = desc.Fields().ByNumber(protowire.Number(number))
+		fieldDescs[i] = desc.Fields().ByNumber(protowire.Number(number))
+	}
+	results <- actionQueue.enqueue("Extract fields into new message", protocol.RefactorExtract, mapper.URI, fileNode.Version(), func(ca *protocol.CodeAction) error {
+		parentInfo := fileNode.NodeInfo(msgNode)
+		parentRange := positionsToRange(parentInfo.Start(), fileNode.NodeInfo(msgNode.CloseBrace).End())
+		endPos := parentInfo.End()
+		if parentInfo.TrailingComments().Len() > 0 {
+			endPos = parentInfo.TrailingComments().Index(parentInfo.TrailingComments().Len() - 1).End()
+			endPos.Col++ // see Comment.End() doc
 		}
-		results <- actionQueue.enqueue("Extract fields into new message", protocol.RefactorExtract, mapper.URI, fileNode.Version(), func(ca *protocol.CodeAction) error {
-			parentInfo := fileNode.NodeInfo(msgNode)
-			parentRange := positionsToRange(parentInfo.Start(), fileNode.NodeInfo(msgNode.CloseBrace).End())
-			endPos := parentInfo.End()
-			if parentInfo.TrailingComments().Len() > 0 {
-				endPos = parentInfo.TrailingComments().Index(parentInfo.TrailingComments().Len() - 1).End()
-				endPos.Col++ // see Comment.End() doc
-			}
-			indentation := parentInfo.Start().Col - 1
-			newMsgInsertPos := toPosition(endPos)
-
-			updatedParentFields := make([]*ast.MessageElement, 0, len(msgNode.Decls))
-			insertedPlaceholder := false
-			for _, decl := range msgNode.Decls {
-				if fld := decl.GetField(); fld != nil && slices.Contains(enclosedFields, fld) {
-					if !insertedPlaceholder {
-						insertedPlaceholder = true
-						updatedParentFields = append(updatedParentFields, nil)
-					}
-					continue
+		indentation := parentInfo.Start().Col - 1
+		newMsgInsertPos := toPosition(endPos)
+
+		updatedParentFields := make([]*ast.MessageElement, 0, len(msgNode.Decls))
+		insertedPlaceholder := false
+		for _, decl := range msgNode.Decls {
+			if fld := decl.GetField(); fld != nil && slices.Contains(enclosedFields, fld) {
+				if !insertedPlaceholder {
+					insertedPlaceholder = true
+					updatedParentFields = append(updatedParentFields, nil)
 				}
-				updatedParentFields = append(updatedParentFields, decl)
+				continue
 			}
-			var newMsgFields []*ast.MessageElement
-			for i, fld := range enclosedFields {
-				// the new field type may need to be updated
-				fldDesc := fieldDescs[i]
-				newFldType := fld.FieldType
-				if fldDesc.Kind() == protoreflect.MessageKind {
-					relName := relativeFullName(fldDesc.Message().FullName(), desc.ParentFile().Package())
-					if relName != string(fld.FieldType.AsIdentifier()) {
-						if strings.Contains(relName, ".") {
-							compoundIdent := &ast.CompoundIdentNode{}
-							parts := strings.Split(relName, ".")
-							for i, part := range parts {
-								compoundIdent.Components = append(compoundIdent.Components, (&ast.IdentNode{Val: part}).AsComplexIdentComponent())
-								if i < len(parts)-1 {
-									compoundIdent.Components = append(compoundIdent.Components, (&ast.RuneNode{Rune: '.'}).AsComplexIdentComponent())
-								}
+			updatedParentFields = append(updatedParentFields, decl)
+		}
+		var newMsgFields []*ast.MessageElement
+		for i, fld := range enclosedFields {
+			// the new field type may need to be updated
+			fldDesc := fieldDescs[i]
+			newFldType := fld.FieldType
+			if fldDesc.Kind() == protoreflect.MessageKind {
+				relName := relativeFullName(fldDesc.Message().FullName(), desc.ParentFile().Package())
+				if relName != string(fld.FieldType.AsIdentifier()) {
+					if strings.Contains(relName, ".") {
+						compoundIdent := &ast.CompoundIdentNode{}
+						parts := strings.Split(relName, ".")
+						for i, part := range parts {
+							compoundIdent.Components = append(compoundIdent.Components, (&ast.IdentNode{Val: part}).AsComplexIdentComponent())
+							if i < len(parts)-1 {
+								compoundIdent.Components = append(compoundIdent.Components, (&ast.RuneNode{Rune: '.'}).AsComplexIdentComponent())
 							}
-							newFldType = compoundIdent.AsIdentValueNode()
-						} else {
-							newFldType = (&ast.IdentNode{Val: relName}).AsIdentValueNode()
 						}
+						newFldType = compoundIdent.AsIdentValueNode()
+					} else {
+						newFldType = (&ast.IdentNode{Val: relName}).AsIdentValueNode()
 					}
 				}
-				newFld := &ast.FieldNode{
-					Label:     fld.Label,
-					FieldType: newFldType,
-					Name:      fld.Name,
-					Equals:    fld.Equals,
-					Tag:       &ast.UintLiteralNode{Val: uint64(i + 1)},
-					Options:   fld.Options,
-					Semicolon: fld.Semicolon,
-				}
-				newMsgFields = append(newMsgFields, newFld.AsMessageElement())
 			}
-			newMsgName := findNewUnusedMessageName(desc)
-			newFieldName := findNewUnusedFieldName(desc, "newField")
-			newMessage := &ast.MessageNode{
-				Keyword:    &ast.IdentNode{Val: "message", IsKeyword: true},
-				Name:       &ast.IdentNode{Val: newMsgName},
-				OpenBrace:  &ast.RuneNode{Rune: '{'},
-				Decls:      newMsgFields,
-				CloseBrace: &ast.RuneNode{Rune: '}'},
-				Semicolon:  &ast.RuneNode{Rune: ';'},
+			newFld := &ast.FieldNode{
+				Label:     fld.Label,
+				FieldType: newFldType,
+				Name:      fld.Name,
+				Equals:    fld.Equals,
+				Tag:       &ast.UintLiteralNode{Val: uint64(i + 1)},
+				Options:   fld.Options,
+				Semicolon: fld.Semicolon,
 			}
+			newMsgFields = append(newMsgFields, newFld.AsMessageElement())
+		}
+		newMsgName := findNewUnusedMessageName(desc)
+		newFieldName := findNewUnusedFieldName(desc, "newField")
+		newMessage := &ast.MessageNode{
+			Keyword:    &ast.IdentNode{Val: "message", IsKeyword: true},
+			Name:       &ast.IdentNode{Val: newMsgName},
+			OpenBrace:  &ast.RuneNode{Rune: '{'},
+			Decls:      newMsgFields,
+			CloseBrace: &ast.RuneNode{Rune: '}'},
+			Semicolon:  &ast.RuneNode{Rune: ';'},
+		}
 
-			var label *ast.IdentNode
-			if isProto2(fileNode) {
-				label = &ast.IdentNode{Val: "optional", IsKeyword: true}
-			}
-			newParentMessageField := &ast.FieldNode{
-				Label:     label,
-				FieldType: (&ast.IdentNode{Val: newMsgName}).AsIdentValueNode(),
-				Name:      &ast.IdentNode{Val: newFieldName},
-				Equals:    &ast.RuneNode{Rune: '='},
-				Tag:       &ast.UintLiteralNode{Val: enclosedFields[0].Tag.Val},
-				Semicolon: &ast.RuneNode{Rune: ';'},
-			}
-			for i, v := range updatedParentFields {
-				if v == nil {
-					updatedParentFields[i] = newParentMessageField.AsMessageElement()
-					break
-				}
-			}
-			updatedParent := &ast.MessageNode{
-				Keyword:    msgNode.Keyword,
-				Name:       msgNode.Name,
-				OpenBrace:  msgNode.OpenBrace,
-				Decls:      updatedParentFields,
-				CloseBrace: msgNode.CloseBrace,
-				Semicolon:  msgNode.Semicolon,
+		var label *ast.IdentNode
+		if isProto2(fileNode) {
+			label = &ast.IdentNode{Val: "optional", IsKeyword: true}
+		}
+		newParentMessageField := &ast.FieldNode{
+			Label:     label,
+			FieldType: (&ast.IdentNode{Val: newMsgName}).AsIdentValueNode(),
+			Name:      &ast.IdentNode{Val: newFieldName},
+			Equals:    &ast.RuneNode{Rune: '='},
+			Tag:       &ast.UintLiteralNode{Val: enclosedFields[0].Tag.Val},
+			Semicolon: &ast.RuneNode{Rune: ';'},
+		}
+		for i, v := range updatedParentFields {
+			if v == nil {
+				updatedParentFields[i] = newParentMessageField.AsMessageElement()
+				break
 			}
+		}
+		updatedParent := &ast.MessageNode{
+			Keyword:    msgNode.Keyword,
+			Name:       msgNode.Name,
+			OpenBrace:  msgNode.OpenBrace,
+			Decls:      updatedParentFields,
+			CloseBrace: msgNode.CloseBrace,
+			Semicolon:  msgNode.Semicolon,
+		}
 
-			updatedParentText, err := format.PrintNode(format.NodeInfoOverlay(fileNode, map[ast.Node]ast.NodeInfo{
-				newMessage:            {},
-				msgNode.Keyword:       {},
-				newParentMessageField: {},
-			}), updatedParent)
-			if err != nil {
-				return fmt.Errorf("error formatting updated parent message: %v", err)
-			}
+		updatedParentText, err := format.PrintNode(format.NodeInfoOverlay(fileNode, map[ast.Node]ast.NodeInfo{
+			newMessage:            {},
+			msgNode.Keyword:       {},
+			newParentMessageField: {},
+		}), updatedParent)
+		if err != nil {
+			return fmt.Errorf("error formatting updated parent message: %v", err)
+		}
 
-			newMessageText, err := format.PrintNode(format.NodeInfoOverlay(fileNode, map[ast.Node]ast.NodeInfo{
-				newMessage: {},
-			}), newMessage)
-			if err != nil {
-				return fmt.Errorf("error formatting new message: %v", err)
-			}
+		newMessageText, err := format.PrintNode(format.NodeInfoOverlay(fileNode, map[ast.Node]ast.NodeInfo{
+			newMessage: {},
+		}), newMessage)
+		if err != nil {
+			return fmt.Errorf("error formatting new message: %v", err)
+		}
 
-			ca.Edit = &protocol.WorkspaceEdit{
-				Changes: map[protocol.DocumentURI][]protocol.TextEdit{
-					request.TextDocument.URI: {
-						{
-							Range:   parentRange,
-							NewText: indentTextHanging(updatedParentText, int(parentRange.Start.Character)),
-						},
-						{
-							Range:   protocol.Range{Start: newMsgInsertPos, End: newMsgInsertPos},
-							NewText: fmt.Sprintf("\n\n%s", indentText(newMessageText, indentation)),
-						},
+		ca.Edit = &protocol.WorkspaceEdit{
+			Changes: map[protocol.DocumentURI][]protocol.TextEdit{
+				request.TextDocument.URI: {
+					{
+						Range:   parentRange,
+						NewText: indentTextHanging(updatedParentText, int(parentRange.Start.Character)),
+					},
+					{
+						Range:   protocol.Range{Start: newMsgInsertPos, End: newMsgInsertPos},
+						NewText: fmt.Sprintf("\n\n%s", indentText(newMessageText, indentation)),
 					},
 				},
-			}
-			return nil
-		})
-	}
+			},
+		}
+		return nil
+	})
 }
 
 func inlineMessageFields(ctx context.Context, request *protocol.CodeActionParams, linkRes linker.Result, mapper *protocol.Mapper, results chan<- protocol.CodeAction) {
@@ -898,18 +915,26 @@ func renumberFields(ctx context.Context, request *protocol.CodeActionParams, lin
 }
 
 func findNewUnusedMessageName(desc protoreflect.MessageDescriptor) string {
-	parent := desc.Parent()
-	prefix := "NewMessage"
-	name := prefix
-	if msgContainer, ok := parent.(interface {
+	msgContainer, ok := desc.Parent().(interface {
 		Messages() protoreflect.MessageDescriptors
-	}); ok {
-		for i := 1; i < 100; i++ {
-			if msgContainer.Messages().ByName(protoreflect.Name(name)) == nil {
-				return name
-			}
-			name = fmt.Sprintf("%s%d", prefix, i)
+	})
+	if !ok {
+		return "NewMessage"
+	}
+	return findNewUnusedName("NewMessage", func(name protoreflect.Name) bool {
+		return msgContainer.Messages().ByName(name) != nil
+	})
+}
+
+// findNewUnusedName returns the given prefix, or the prefix followed by a
+// number if it is taken.
+func findNewUnusedName(prefix string, taken func(protoreflect.Name) bool) string {
+	name := prefix
+	for i := 1; i < 100; i++ {
+		if !taken(protoreflect.Name(name)) {
+			return name
 		}
+		name = fmt.Sprintf("%s%d", prefix, i)
 	}
 	return name
 }
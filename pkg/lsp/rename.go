@@ -1,14 +1,28 @@
 package lsp
 
 import (
+	"context"
 	"fmt"
+	"maps"
+	"slices"
 
 	"github.com/kralicky/protocompile/ast"
+	"github.com/kralicky/protocompile/linker"
 	"github.com/kralicky/tools-lite/gopls/pkg/protocol"
 	"google.golang.org/protobuf/reflect/protoreflect"
 )
 
 func (c *Cache) PrepareRename(in protocol.TextDocumentPositionParams) (*protocol.PrepareRenameResult, error) {
+	if name, rng, prefix, ok := c.packageNameAtLocation(in); ok {
+		if err := c.canRenamePackage(name, prefix); err != nil {
+			return nil, err
+		}
+		return &protocol.PrepareRenameResult{
+			Range:       rng,
+			Placeholder: string(name),
+		}, nil
+	}
+
 	c.resultsMu.RLock()
 	defer c.resultsMu.RUnlock()
 
@@ -69,7 +83,14 @@ func (c *Cache) canRename(desc protoreflect.Descriptor) error {
 	return nil
 }
 
-func (c *Cache) Rename(params *protocol.RenameParams) (*protocol.WorkspaceEdit, error) {
+func (c *Cache) Rename(ctx context.Context, params *protocol.RenameParams) (*protocol.WorkspaceEdit, error) {
+	if name, _, prefix, ok := c.packageNameAtLocation(protocol.TextDocumentPositionParams{
+		TextDocument: params.TextDocument,
+		Position:     params.Position,
+	}); ok {
+		return c.RenamePackage(ctx, name, prefix, protoreflect.FullName(params.NewName))
+	}
+
 	c.resultsMu.RLock()
 	defer c.resultsMu.RUnlock()
 
@@ -194,3 +215,118 @@ func (c *Cache) Rename(params *protocol.RenameParams) (*protocol.WorkspaceEdit,
 		Changes: editsByDocument,
 	}, nil
 }
+
+// canRenamePackage checks that every file in the package, or in the packages
+// within the prefix, is a well-formed file in the workspace.
+func (c *Cache) canRenamePackage(name protoreflect.FullName, prefix bool) error {
+	c.resultsMu.RLock()
+	defer c.resultsMu.RUnlock()
+
+	searchFunc := c.results.RangeFilesByPackage
+	if prefix {
+		searchFunc = c.results.RangeFilesByPackagePrefix
+	}
+	var err error
+	searchFunc(name, func(f linker.File) bool {
+		if f.IsPlaceholder() {
+			return true
+		}
+		uri, uriErr := c.resolver.PathToURI(f.Path())
+		if uriErr != nil || !c.resolver.IsRealWorkspaceLocalFile(uri) {
+			err = fmt.Errorf("package %q is also declared in %s, which is external to this workspace", name, f.Path())
+			return false
+		}
+		if ok, _ := c.LatestDocumentContentsWellFormed(uri, false); !ok {
+			err = fmt.Errorf("source file %q in package %q has errors", uri, name)
+			return false
+		}
+		return true
+	})
+	return err
+}
+
+// RenamePackage returns the edits that rename the package with the given
+// name, or if prefix is set, every package within it, across the workspace.
+// If enabled in the settings, files whose directory matches their package
+// are moved into the directory matching the new package.
+func (c *Cache) RenamePackage(ctx context.Context, name protoreflect.FullName, prefix bool, newName protoreflect.FullName) (*protocol.WorkspaceEdit, error) {
+	if err := c.canRenamePackage(name, prefix); err != nil {
+		return nil, err
+	}
+
+	c.resultsMu.RLock()
+	defer c.resultsMu.RUnlock()
+
+	mappers := map[string]*protocol.Mapper{}
+	versions := map[string]int32{}
+	var move packageFileMover
+	newURIs := map[string]protocol.DocumentURI{}
+	if c.settings.Load().Rename.GetMovePackageFiles() {
+		// the files are moved on disk, and the paths they will be known by are
+		// resolved from their new locations
+		move = func(oldPath, oldDir, newDir string) (string, bool, error) {
+			uri, err := c.resolver.PathToURI(oldPath)
+			if err != nil {
+				return "", false, err
+			}
+			newFilename, ok := moveDir(uri.Path(), oldDir, newDir)
+			if !ok {
+				return "", false, nil
+			}
+			newURI := protocol.URIFromPath(newFilename)
+			newURIs[oldPath] = newURI
+			return c.resolver.PathForNewURI(newURI), true, nil
+		}
+	}
+	edits, moved, err := renamePackage(c.results, func(path string) ([]byte, error) {
+		uri, err := c.resolver.PathToURI(path)
+		if err != nil {
+			return nil, err
+		}
+		if !c.resolver.IsRealWorkspaceLocalFile(uri) {
+			return nil, fmt.Errorf("cannot rename package %s: %s would need to be modified, but is not a workspace file", name, path)
+		}
+		fh, err := c.resolver.ReadFile(ctx, uri)
+		if err != nil {
+			return nil, err
+		}
+		content, err := fh.Content()
+		if err != nil {
+			return nil, err
+		}
+		mappers[path] = protocol.NewMapper(uri, content)
+		versions[path] = fh.Version()
+		return content, nil
+	}, name, newName, prefix, move)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []protocol.DocumentChanges
+	for _, path := range slices.Sorted(maps.Keys(edits)) {
+		mapper := mappers[path]
+		var textEdits []protocol.TextEdit
+		for _, e := range edits[path] {
+			rng, err := mapper.OffsetRange(e.start, e.end)
+			if err != nil {
+				return nil, err
+			}
+			textEdits = append(textEdits, protocol.TextEdit{Range: rng, NewText: e.text})
+		}
+		changes = append(changes, protocol.TextEditsToDocumentChanges(mapper.URI, versions[path], textEdits)...)
+	}
+	for _, oldPath := range slices.Sorted(maps.Keys(moved)) {
+		oldURI, err := c.resolver.PathToURI(oldPath)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, protocol.DocumentChanges{
+			RenameFile: &protocol.RenameFile{
+				Kind:   "rename",
+				OldURI: oldURI,
+				NewURI: newURIs[oldPath],
+			},
+		})
+	}
+	return &protocol.WorkspaceEdit{DocumentChanges: changes}, nil
+}
@@ -0,0 +1,401 @@
+package lsp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/kralicky/protocompile/ast"
+	"github.com/kralicky/protocompile/linker"
+	"github.com/kralicky/protocompile/parser"
+	"github.com/kralicky/protocompile/reporter"
+	"github.com/kralicky/tools-lite/gopls/pkg/file"
+	"github.com/kralicky/tools-lite/gopls/pkg/protocol"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// DeletedElements lists the numbers and names of the fields or enum values
+// that were removed from a message or enum without being reserved.
+type DeletedElements struct {
+	Scope   protoreflect.FullName
+	IsEnum  bool
+	Numbers []int32
+	Names   []string
+}
+
+// FindUnreservedDeletions compares a file against a previous version of its
+// source, and returns the fields and enum values that were removed from
+// messages and enums that still exist, and whose numbers or names are neither
+// reserved nor in use by another element.
+func FindUnreservedDeletions(res linker.Result, previous []byte) ([]DeletedElements, error) {
+	prevRes, err := parsePreviousVersion(res.Path(), previous)
+	if err != nil {
+		return nil, err
+	}
+	return findUnreservedDeletions(res, prevRes), nil
+}
+
+func parsePreviousVersion(path string, previous []byte) (parser.Result, error) {
+	handler := reporter.NewHandler(nil)
+	fileNode, err := parser.Parse(path, bytes.NewReader(previous), handler, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse previous version of %s: %w", path, err)
+	}
+	prevRes, err := parser.ResultFromAST(fileNode, false, handler)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse previous version of %s: %w", path, err)
+	}
+	return prevRes, nil
+}
+
+func findUnreservedDeletions(res linker.Result, prevRes parser.Result) []DeletedElements {
+	var deletions []DeletedElements
+	visitEnum := func(prefix protoreflect.FullName, edp *descriptorpb.EnumDescriptorProto) {
+		name := prefix.Append(protoreflect.Name(edp.GetName()))
+		ed, ok := res.FindDescriptorByName(name).(protoreflect.EnumDescriptor)
+		if !ok {
+			return
+		}
+		d := DeletedElements{Scope: name, IsEnum: true}
+		for _, vdp := range edp.GetValue() {
+			number := protoreflect.EnumNumber(vdp.GetNumber())
+			if ed.Values().ByNumber(number) == nil && !ed.ReservedRanges().Has(number) && !slices.Contains(d.Numbers, int32(number)) {
+				d.Numbers = append(d.Numbers, int32(number))
+			}
+			valueName := protoreflect.Name(vdp.GetName())
+			if ed.Values().ByName(valueName) == nil && !ed.ReservedNames().Has(valueName) {
+				d.Names = append(d.Names, string(valueName))
+			}
+		}
+		if len(d.Numbers) > 0 || len(d.Names) > 0 {
+			deletions = append(deletions, d)
+		}
+	}
+	var visitMessage func(prefix protoreflect.FullName, mdp *descriptorpb.DescriptorProto)
+	visitMessage = func(prefix protoreflect.FullName, mdp *descriptorpb.DescriptorProto) {
+		name := prefix.Append(protoreflect.Name(mdp.GetName()))
+		if md, ok := res.FindDescriptorByName(name).(protoreflect.MessageDescriptor); ok && !md.IsMapEntry() {
+			d := DeletedElements{Scope: name}
+			for _, fdp := range mdp.GetField() {
+				number := protoreflect.FieldNumber(fdp.GetNumber())
+				if md.Fields().ByNumber(number) == nil && !md.ReservedRanges().Has(number) && !md.ExtensionRanges().Has(number) {
+					d.Numbers = append(d.Numbers, int32(number))
+				}
+				fieldName := protoreflect.Name(fdp.GetName())
+				if md.Fields().ByName(fieldName) == nil && !md.ReservedNames().Has(fieldName) {
+					d.Names = append(d.Names, string(fieldName))
+				}
+			}
+			if len(d.Numbers) > 0 || len(d.Names) > 0 {
+				deletions = append(deletions, d)
+			}
+		}
+		for _, nested := range mdp.GetNestedType() {
+			visitMessage(name, nested)
+		}
+		for _, nested := range mdp.GetEnumType() {
+			visitEnum(name, nested)
+		}
+	}
+	pkg := protoreflect.FullName(prevRes.FileDescriptorProto().GetPackage())
+	for _, mdp := range prevRes.FileDescriptorProto().GetMessageType() {
+		visitMessage(pkg, mdp)
+	}
+	for _, edp := range prevRes.FileDescriptorProto().GetEnumType() {
+		visitEnum(pkg, edp)
+	}
+	for _, d := range deletions {
+		slices.Sort(d.Numbers)
+	}
+	return deletions
+}
+
+// ReserveDeletedElements returns edits that reserve the given numbers and
+// names in their message or enum. Numbers are merged into the message's
+// existing reserved ranges and names are appended to its existing reserved
+// names, if there are any; otherwise, new reserved declarations are added
+// following the message's leading options.
+func ReserveDeletedElements(res linker.Result, content []byte, deletions []DeletedElements) ([]sourceEdit, error) {
+	w := &sourceRewriter{
+		res:     res,
+		file:    res.AST(),
+		content: content,
+	}
+	if err := w.reserveDeletedElements(deletions); err != nil {
+		return nil, err
+	}
+	return w.edits, nil
+}
+
+func (w *sourceRewriter) reserveDeletedElements(deletions []DeletedElements) error {
+	for _, d := range deletions {
+		desc := w.res.FindDescriptorByName(d.Scope)
+		if desc == nil {
+			return fmt.Errorf("%s not found in %s", d.Scope, w.res.Path())
+		}
+		if err := w.reserve(declNodeForDescriptor(w.res, desc), d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *sourceRewriter) reserve(node ast.Node, d DeletedElements) error {
+	var (
+		decls      []ast.Node
+		openBrace  *ast.RuneNode
+		closeBrace *ast.RuneNode
+	)
+	switch node := node.(type) {
+	case *ast.MessageNode:
+		for _, decl := range node.Decls {
+			decls = append(decls, decl.Unwrap())
+		}
+		openBrace, closeBrace = node.OpenBrace, node.CloseBrace
+	case *ast.GroupNode:
+		for _, decl := range node.Decls {
+			decls = append(decls, decl.Unwrap())
+		}
+		openBrace, closeBrace = node.OpenBrace, node.CloseBrace
+	case *ast.EnumNode:
+		for _, decl := range node.Decls {
+			decls = append(decls, decl.Unwrap())
+		}
+		openBrace, closeBrace = node.OpenBrace, node.CloseBrace
+	default:
+		return fmt.Errorf("cannot add reserved declarations to %s", d.Scope)
+	}
+	if openBrace == nil || closeBrace == nil {
+		return fmt.Errorf("%s has no body", d.Scope)
+	}
+
+	minNumber, maxNumber := int32(1), int32(protowire.MaxValidNumber)
+	if d.IsEnum {
+		minNumber, maxNumber = math.MinInt32, math.MaxInt32
+	}
+
+	var rangesNode, namesNode *ast.ReservedNode
+	_, after := w.span(openBrace)
+	leadingOptions := true
+	for _, decl := range decls {
+		switch decl := decl.(type) {
+		case *ast.ReservedNode:
+			if len(decl.FilterRanges()) > 0 && rangesNode == nil {
+				rangesNode = decl
+			} else if len(decl.FilterRanges()) == 0 && namesNode == nil {
+				namesNode = decl
+			}
+			_, after = w.declSpan(decl)
+		case *ast.OptionNode:
+			if leadingOptions && rangesNode == nil && namesNode == nil {
+				_, after = w.declSpan(decl)
+			}
+		default:
+			leadingOptions = false
+		}
+	}
+
+	var newDecls []string
+	if len(d.Numbers) > 0 {
+		ranges := make([][2]int32, 0, len(d.Numbers))
+		for _, n := range d.Numbers {
+			ranges = append(ranges, [2]int32{n, n})
+		}
+		if rangesNode != nil {
+			for _, rng := range rangesNode.FilterRanges() {
+				start, ok1 := rng.StartValueAsInt32(minNumber, maxNumber)
+				end, ok2 := rng.EndValueAsInt32(minNumber, maxNumber)
+				if !ok1 || !ok2 {
+					return fmt.Errorf("invalid reserved range in %s", d.Scope)
+				}
+				ranges = append(ranges, [2]int32{start, end})
+			}
+			w.replaceReservedElements(rangesNode, formatReservedRanges(ranges, maxNumber))
+		} else {
+			newDecls = append(newDecls, "reserved "+formatReservedRanges(ranges, maxNumber)+";")
+		}
+	}
+	if len(d.Names) > 0 {
+		// reserved names are identifiers in editions files, and string literals
+		// otherwise
+		identifiers := w.res.Syntax() == protoreflect.Editions
+		var elems []string
+		if namesNode != nil {
+			for _, elem := range namesNode.Elements {
+				if elem.GetComma() != nil {
+					continue
+				}
+				start, end := w.span(elem.Unwrap())
+				elems = append(elems, w.text(start, end))
+			}
+			identifiers = len(namesNode.FilterIdentifiers()) > 0
+		}
+		for _, name := range d.Names {
+			if identifiers {
+				elems = append(elems, name)
+			} else {
+				elems = append(elems, strconv.Quote(name))
+			}
+		}
+		if namesNode != nil {
+			w.replaceReservedElements(namesNode, strings.Join(elems, ", "))
+		} else {
+			newDecls = append(newDecls, "reserved "+strings.Join(elems, ", ")+";")
+		}
+	}
+	if len(newDecls) == 0 {
+		return nil
+	}
+
+	if len(decls) == 0 {
+		// An empty body, possibly all on one line; rewrite it entirely.
+		_, bodyStart := w.span(openBrace)
+		bodyEnd, _ := w.span(closeBrace)
+		outer := w.indentation(w.file.NodeInfo(node).Start().Offset)
+		var body strings.Builder
+		for _, decl := range newDecls {
+			body.WriteString("\n" + outer + "  " + decl)
+		}
+		body.WriteString("\n" + outer)
+		w.edit(bodyStart, bodyEnd, body.String())
+		return nil
+	}
+	firstStart, _ := w.span(decls[0])
+	indent := w.indentation(firstStart)
+	for i, decl := range newDecls {
+		newDecls[i] = indent + decl
+	}
+	w.insertDecls(after, newDecls)
+	return nil
+}
+
+// replaceReservedElements replaces everything between the reserved keyword
+// and the semicolon of the given node.
+func (w *sourceRewriter) replaceReservedElements(node *ast.ReservedNode, text string) {
+	_, start := w.span(node.Keyword)
+	end, _ := w.span(node.Semicolon)
+	w.edit(start, end, " "+text)
+}
+
+// formatReservedRanges sorts and coalesces the given ranges, and formats them
+// as they would appear in a reserved declaration.
+func formatReservedRanges(ranges [][2]int32, maxNumber int32) string {
+	slices.SortFunc(ranges, func(a, b [2]int32) int {
+		return int(int64(a[0]) - int64(b[0]))
+	})
+	merged := ranges[:1]
+	for _, rng := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if int64(rng[0]) <= int64(last[1])+1 {
+			last[1] = max(last[1], rng[1])
+			continue
+		}
+		merged = append(merged, rng)
+	}
+	parts := make([]string, len(merged))
+	for i, rng := range merged {
+		switch {
+		case rng[0] == rng[1]:
+			parts[i] = strconv.Itoa(int(rng[0]))
+		case rng[1] == maxNumber:
+			parts[i] = fmt.Sprintf("%d to max", rng[0])
+		default:
+			parts[i] = fmt.Sprintf("%d to %d", rng[0], rng[1])
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// savedVersion is the parsed contents of a file as it was last saved to disk.
+type savedVersion struct {
+	hash   file.Hash
+	result parser.Result
+}
+
+// savedVersion returns the parsed contents of the file on disk, or nil if they
+// are identical to the current contents. The last parsed version of each file
+// is cached until the file is saved again. Only the file on disk is compared:
+// elements deleted before the last save, even if that change has not been
+// committed yet, are not detected.
+func (c *Cache) savedVersion(ctx context.Context, uri protocol.DocumentURI, current []byte) parser.Result {
+	fh, err := c.resolver.OpenFileFromDisk(ctx, uri)
+	if err != nil {
+		return nil
+	}
+	saved, err := fh.Content()
+	if err != nil || bytes.Equal(saved, current) {
+		return nil
+	}
+	hash := fh.Identity().Hash
+	if sv, ok := c.savedVersions.Load(uri); ok && sv.hash == hash {
+		return sv.result
+	}
+	path, err := c.resolver.URIToPath(uri)
+	if err != nil {
+		return nil
+	}
+	result, err := parsePreviousVersion(path, saved)
+	if err != nil {
+		return nil
+	}
+	c.savedVersions.Store(uri, &savedVersion{hash: hash, result: result})
+	return result
+}
+
+// reserveDeletedActions offers to reserve the numbers and names of fields and
+// enum values that were deleted since the file was last saved. Deletions are
+// found by comparing against the saved file rather than a version control
+// baseline, so once the file is saved they are no longer offered. A rewrite
+// action is offered for the message or enum at the cursor, and a fix-all
+// action covers the whole file.
+func (c *Cache) reserveDeletedActions(ctx context.Context, params *protocol.CodeActionParams, linkRes linker.Result, mapper *protocol.Mapper, want map[protocol.CodeActionKind]bool) []protocol.CodeAction {
+	if linkRes.AST() == nil || !c.resolver.IsRealWorkspaceLocalFile(params.TextDocument.URI) {
+		return nil
+	}
+	saved := c.savedVersion(ctx, params.TextDocument.URI, mapper.Content)
+	if saved == nil {
+		return nil
+	}
+	deletions := findUnreservedDeletions(linkRes, saved)
+	if len(deletions) == 0 {
+		return nil
+	}
+
+	newAction := func(title string, kind protocol.CodeActionKind, deletions []DeletedElements) protocol.CodeAction {
+		return enqueueRewrite(title, kind, linkRes, mapper, func(w *sourceRewriter) bool {
+			return w.reserveDeletedElements(deletions) == nil
+		})
+	}
+
+	var actions []protocol.CodeAction
+	if want[protocol.RefactorRewrite] {
+		offset, err := mapper.PositionOffset(params.Range.Start)
+		if err == nil {
+			for _, d := range deletions {
+				node := declNodeForDescriptor(linkRes, linkRes.FindDescriptorByName(d.Scope))
+				if node == nil {
+					continue
+				}
+				info := linkRes.AST().NodeInfo(node)
+				if offset < info.Start().Offset || offset > info.End().Offset+1 {
+					continue
+				}
+				kind := "fields"
+				if d.IsEnum {
+					kind = "enum values"
+				}
+				actions = append(actions, newAction(fmt.Sprintf("Reserve deleted %s in %s", kind, d.Scope.Name()), protocol.RefactorRewrite, []DeletedElements{d}))
+			}
+		}
+	}
+	if want[protocol.SourceFixAll] {
+		actions = append(actions, newAction("Reserve all deleted fields and enum values", protocol.SourceFixAll, deletions))
+	}
+	return actions
+}
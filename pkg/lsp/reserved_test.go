@@ -0,0 +1,175 @@
+package lsp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReserveDeletedElements(t *testing.T) {
+	const previous = `
+syntax = "proto3";
+
+package test;
+
+message Foo {
+  option deprecated = true;
+  string a = 1;
+  string b = 2;
+  string c = 3;
+  Empty d = 4;
+  message Empty {
+    int32 x = 1;
+  }
+}
+
+message Bar {
+  reserved 1, 3 to 4;
+  reserved "old";
+  int32 a = 2;
+  int32 b = 5;
+  int32 c = 6;
+}
+
+enum Kind {
+  KIND_UNSPECIFIED = 0;
+  KIND_A = 1;
+  KIND_B = 2;
+}
+`
+	const current = `
+syntax = "proto3";
+
+package test;
+
+message Foo {
+  option deprecated = true;
+  string a = 1;
+  int32 c = 5;
+  message Empty {}
+}
+
+message Bar {
+  reserved 1, 3 to 4;
+  reserved "old";
+  int32 a = 2;
+  int32 c = 6;
+}
+
+enum Kind {
+  KIND_UNSPECIFIED = 0;
+  KIND_B = 2;
+}
+`
+	const want = `
+syntax = "proto3";
+
+package test;
+
+message Foo {
+  option deprecated = true;
+  reserved 2 to 4;
+  reserved "b", "d";
+  string a = 1;
+  int32 c = 5;
+  message Empty {
+    reserved 1;
+    reserved "x";
+  }
+}
+
+message Bar {
+  reserved 1, 3 to 5;
+  reserved "old", "b";
+  int32 a = 2;
+  int32 c = 6;
+}
+
+enum Kind {
+  reserved 1;
+  reserved "KIND_A";
+  KIND_UNSPECIFIED = 0;
+  KIND_B = 2;
+}
+`
+	res := compileFile(t, current)
+	deletions, err := FindUnreservedDeletions(res, []byte(previous))
+	require.NoError(t, err)
+	require.Equal(t, []DeletedElements{
+		{Scope: "test.Foo", Numbers: []int32{2, 3, 4}, Names: []string{"b", "d"}},
+		{Scope: "test.Foo.Empty", Numbers: []int32{1}, Names: []string{"x"}},
+		{Scope: "test.Bar", Numbers: []int32{5}, Names: []string{"b"}},
+		{Scope: "test.Kind", IsEnum: true, Numbers: []int32{1}, Names: []string{"KIND_A"}},
+	}, deletions)
+
+	edits, err := ReserveDeletedElements(res, []byte(current), deletions)
+	require.NoError(t, err)
+	out, err := applySourceEdits([]byte(current), edits)
+	require.NoError(t, err)
+	require.Equal(t, want, string(out))
+
+	updated := compileFile(t, string(out))
+	deletions, err = FindUnreservedDeletions(updated, []byte(previous))
+	require.NoError(t, err)
+	require.Empty(t, deletions)
+}
+
+func TestReserveDeletedElementsEditions(t *testing.T) {
+	const previous = `
+edition = "2023";
+
+package test;
+
+message Foo {
+  string a = 1;
+  string b = 2;
+}
+
+message Bar {
+  reserved old;
+  int32 a = 1;
+  int32 b = 2;
+}
+`
+	const current = `
+edition = "2023";
+
+package test;
+
+message Foo {
+  string a = 1;
+}
+
+message Bar {
+  reserved old;
+  int32 a = 1;
+}
+`
+	const want = `
+edition = "2023";
+
+package test;
+
+message Foo {
+  reserved 2;
+  reserved b;
+  string a = 1;
+}
+
+message Bar {
+  reserved old, b;
+  reserved 2;
+  int32 a = 1;
+}
+`
+	res := compileFile(t, current)
+	deletions, err := FindUnreservedDeletions(res, []byte(previous))
+	require.NoError(t, err)
+	edits, err := ReserveDeletedElements(res, []byte(current), deletions)
+	require.NoError(t, err)
+	out, err := applySourceEdits([]byte(current), edits)
+	require.NoError(t, err)
+	require.Equal(t, want, string(out))
+
+	compileFile(t, string(out))
+}
@@ -10,6 +10,7 @@ import (
 	"log/slog"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
 	"strings"
@@ -96,6 +97,20 @@ func (r *Resolver) URIToPath(uri protocol.DocumentURI) (string, error) {
 	return path, nil
 }
 
+// PathForNewURI returns the path a file created at the given URI would be
+// known by, using the same mapping as files created in the workspace: the
+// implicit go package path of its directory when in a go module, and the
+// path relative to the workspace root otherwise.
+func (r *Resolver) PathForNewURI(uri protocol.DocumentURI) string {
+	filename := uri.Path()
+	if r.goLanguageDriver.HasGoModule() {
+		if pkgPath, err := r.goLanguageDriver.ImplicitGoPackagePath(filename); err == nil {
+			return path.Join(pkgPath, path.Base(filename))
+		}
+	}
+	return strings.TrimPrefix(filename, protocol.DocumentURI(r.folder.URI).Path()+"/")
+}
+
 func (r *Resolver) SyntheticFileContents(uri protocol.DocumentURI) (string, error) {
 	r.pathsMu.RLock()
 	defer r.pathsMu.RUnlock()
@@ -0,0 +1,417 @@
+package lsp
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/kralicky/protocompile/ast"
+	"github.com/kralicky/protocompile/linker"
+	"github.com/kralicky/protols/pkg/format"
+	"github.com/kralicky/tools-lite/gopls/pkg/protocol"
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+var apiVersionPattern = regexp.MustCompile(`^v\d+(?:(?:alpha|beta)\d*)?$`)
+
+// generateResourceService offers to generate a service with the standard
+// Get, List, Create, Update, and Delete methods described in the AIPs for the
+// message at the cursor, if it is annotated with (google.api.resource). The
+// service and its request and response messages are inserted after the
+// resource message, and any imports they need are added to the file.
+func generateResourceService(ctx context.Context, request *protocol.CodeActionParams, linkRes linker.Result, mapper *protocol.Mapper, results chan<- protocol.CodeAction) {
+	if request.Range == (protocol.Range{}) || request.Range.Start != request.Range.End {
+		return
+	}
+	fileNode := linkRes.AST()
+	offset, err := mapper.PositionOffset(request.Range.Start)
+	if err != nil {
+		return
+	}
+	var md protoreflect.MessageDescriptor
+	var node *ast.MessageNode
+	for i := 0; i < linkRes.Messages().Len(); i++ {
+		m := linkRes.Messages().Get(i)
+		n, ok := declNodeForDescriptor(linkRes, m).(*ast.MessageNode)
+		if !ok || n.Keyword == nil || n.Name == nil {
+			continue
+		}
+		if offset >= fileNode.NodeInfo(n.Keyword).Start().Offset && offset <= fileNode.NodeInfo(n.Name).End().Offset+1 {
+			md, node = m, n
+			break
+		}
+	}
+	if node == nil {
+		return
+	}
+	rd := resourceDescriptor(md)
+	if rd == nil {
+		return
+	}
+	r := newResourceService(linkRes, md, rd)
+	title := fmt.Sprintf("Generate %s for resource %s", r.service, md.Name())
+	for _, name := range r.declaredNames() {
+		if linkRes.FindDescriptorByName(linkRes.Package().Append(protoreflect.Name(name))) != nil {
+			results <- protocol.CodeAction{
+				Title: title,
+				Kind:  protocol.RefactorRewrite,
+				Disabled: &protocol.CodeActionDisabled{
+					Reason: fmt.Sprintf("%s is already declared", name),
+				},
+			}
+			return
+		}
+	}
+	results <- enqueueRewrite(title, protocol.RefactorRewrite, linkRes, mapper, func(w *sourceRewriter) bool {
+		return w.insertResourceService(node, r) == nil
+	})
+}
+
+// insertResourceService inserts the service and messages for the resource
+// after its message, adding any imports they need.
+func (w *sourceRewriter) insertResourceService(node *ast.MessageNode, r *resourceService) error {
+	decls := make([]string, 0, 7)
+	for _, decl := range r.decls() {
+		text, err := format.PrintNode(format.NodeInfoOverlay(w.file, map[ast.Node]ast.NodeInfo{decl: {}}), decl)
+		if err != nil {
+			return fmt.Errorf("error formatting %T: %w", decl, err)
+		}
+		decls = append(decls, text)
+	}
+	_, end := w.declSpan(node)
+	insertAt, _ := w.insertionPoint(end)
+	w.edit(insertAt, insertAt, "\n\n"+strings.Join(decls, "\n\n"))
+
+	var add []string
+	for _, path := range []string{
+		"google/api/annotations.proto",
+		"google/api/field_behavior.proto",
+		"google/api/resource.proto",
+		"google/protobuf/empty.proto",
+		"google/protobuf/field_mask.proto",
+	} {
+		if importProvider(w.res, path) == "" {
+			add = append(add, path)
+		}
+	}
+	updateFileImports(w, add, nil)
+	return nil
+}
+
+// resourceDescriptor returns the value of the (google.api.resource) option of
+// the message, or nil if it is not set. The options are round-tripped through
+// the wire format, since the extension may have been resolved from source
+// rather than from the generated types.
+func resourceDescriptor(md protoreflect.MessageDescriptor) *annotations.ResourceDescriptor {
+	data, err := proto.Marshal(md.Options())
+	if err != nil || len(data) == 0 {
+		return nil
+	}
+	opts := &descriptorpb.MessageOptions{}
+	if err := (proto.UnmarshalOptions{Resolver: protoregistry.GlobalTypes}).Unmarshal(data, opts); err != nil {
+		return nil
+	}
+	if !proto.HasExtension(opts, annotations.E_Resource) {
+		return nil
+	}
+	return proto.GetExtension(opts, annotations.E_Resource).(*annotations.ResourceDescriptor)
+}
+
+// resourceService describes the standard methods of a resource, with names
+// following AIP-131 through AIP-135.
+type resourceService struct {
+	resource  protoreflect.MessageDescriptor
+	pkg       protoreflect.FullName
+	proto2    bool
+	typ       string // the resource type, e.g. "library.googleapis.com/Book"
+	nameField string
+	singular  string // lowerCamelCase
+	plural    string // lowerCamelCase
+
+	service       string
+	namePattern   string // the resource name pattern, e.g. "publishers/*/books/*"
+	parentPattern string // empty for top-level resources
+	collection    string // the collection identifier, e.g. "books"
+	versionPrefix string // e.g. "/v1", if the package is versioned
+}
+
+func newResourceService(res linker.Result, md protoreflect.MessageDescriptor, rd *annotations.ResourceDescriptor) *resourceService {
+	r := &resourceService{
+		resource:  md,
+		pkg:       res.Package(),
+		proto2:    res.Syntax() == protoreflect.Proto2,
+		typ:       rd.GetType(),
+		nameField: rd.GetNameField(),
+		singular:  rd.GetSingular(),
+		plural:    rd.GetPlural(),
+		service:   string(md.Name()) + "Service",
+	}
+	if r.nameField == "" {
+		r.nameField = "name"
+	}
+	if r.singular == "" {
+		name := []rune(string(md.Name()))
+		name[0] = unicode.ToLower(name[0])
+		r.singular = string(name)
+	}
+	if r.plural == "" {
+		r.plural = r.singular + "s"
+	}
+	if parts := strings.Split(string(r.pkg), "."); apiVersionPattern.MatchString(parts[len(parts)-1]) {
+		r.versionPrefix = "/" + parts[len(parts)-1]
+	}
+
+	r.collection = r.plural
+	r.namePattern = r.plural + "/*"
+	if len(rd.GetPattern()) > 0 {
+		segments := strings.Split(rd.GetPattern()[0], "/")
+		if n := len(segments); n >= 2 && strings.HasPrefix(segments[n-1], "{") {
+			for i, seg := range segments {
+				if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+					segments[i] = "*"
+				}
+			}
+			r.collection = segments[n-2]
+			r.namePattern = strings.Join(segments, "/")
+			r.parentPattern = strings.Join(segments[:n-2], "/")
+		}
+	}
+	return r
+}
+
+func (r *resourceService) resourceName() string {
+	return string(r.resource.Name())
+}
+
+func (r *resourceService) pluralName() string {
+	name := []rune(r.plural)
+	name[0] = unicode.ToUpper(name[0])
+	return string(name)
+}
+
+// declaredNames returns the names of the service and messages to generate.
+func (r *resourceService) declaredNames() []string {
+	singular, plural := r.resourceName(), r.pluralName()
+	return []string{
+		r.service,
+		"Get" + singular + "Request",
+		"List" + plural + "Request",
+		"List" + plural + "Response",
+		"Create" + singular + "Request",
+		"Update" + singular + "Request",
+		"Delete" + singular + "Request",
+	}
+}
+
+// decls returns the service and its request and response messages.
+func (r *resourceService) decls() []ast.Node {
+	names := r.declaredNames()
+	singular := r.resourceName()
+	resourceField := strings.ToLower(screamingSnakeCase(r.singular))
+	collectionField := strings.ToLower(screamingSnakeCase(r.plural))
+	namePath := fmt.Sprintf("%s/{%s=%s}", r.versionPrefix, r.nameField, r.namePattern)
+	collectionPath := fmt.Sprintf("%s/%s", r.versionPrefix, r.collection)
+	if r.parentPattern != "" {
+		collectionPath = fmt.Sprintf("%s/{parent=%s}/%s", r.versionPrefix, r.parentPattern, r.collection)
+	}
+	updatePath := fmt.Sprintf("%s/{%s.%s=%s}", r.versionPrefix, resourceField, r.nameField, r.namePattern)
+
+	service := &ast.ServiceNode{
+		Keyword:    &ast.IdentNode{Val: "service", IsKeyword: true},
+		Name:       &ast.IdentNode{Val: r.service},
+		OpenBrace:  &ast.RuneNode{Rune: '{'},
+		CloseBrace: &ast.RuneNode{Rune: '}'},
+	}
+	for _, rpc := range []struct {
+		name, input, output string
+		rule                [][2]string
+	}{
+		{"Get" + singular, names[1], singular, [][2]string{{"get", namePath}}},
+		{"List" + r.pluralName(), names[2], names[3], [][2]string{{"get", collectionPath}}},
+		{"Create" + singular, names[4], singular, [][2]string{{"post", collectionPath}, {"body", resourceField}}},
+		{"Update" + singular, names[5], singular, [][2]string{{"patch", updatePath}, {"body", resourceField}}},
+		{"Delete" + singular, names[6], relativeFullName("google.protobuf.Empty", r.pkg), [][2]string{{"delete", namePath}}},
+	} {
+		rule := &ast.MessageLiteralNode{
+			Open:  &ast.RuneNode{Rune: '{'},
+			Close: &ast.RuneNode{Rune: '}'},
+		}
+		for _, kv := range rpc.rule {
+			rule.Elements = append(rule.Elements, &ast.MessageFieldNode{
+				Name: &ast.FieldReferenceNode{Name: (&ast.IdentNode{Val: kv[0]}).AsIdentValueNode()},
+				Sep:  &ast.RuneNode{Rune: ':'},
+				Val:  stringLiteral(kv[1]).AsValueNode(),
+			})
+		}
+		service.Decls = append(service.Decls, (&ast.RPCNode{
+			Keyword:    &ast.IdentNode{Val: "rpc", IsKeyword: true},
+			Name:       &ast.IdentNode{Val: rpc.name},
+			Input:      rpcType(rpc.input),
+			Returns:    &ast.IdentNode{Val: "returns", IsKeyword: true},
+			Output:     rpcType(rpc.output),
+			OpenBrace:  &ast.RuneNode{Rune: '{'},
+			CloseBrace: &ast.RuneNode{Rune: '}'},
+			Decls: []*ast.RPCElement{(&ast.OptionNode{
+				Keyword:   &ast.IdentNode{Val: "option", IsKeyword: true},
+				Name:      extensionOptionName("google.api.http"),
+				Equals:    &ast.RuneNode{Rune: '='},
+				Val:       rule.AsValueNode(),
+				Semicolon: &ast.RuneNode{Rune: ';'},
+			}).AsRPCElement()},
+		}).AsServiceElement())
+	}
+
+	required := behaviorOption("REQUIRED")
+	optional := behaviorOption("OPTIONAL")
+	nameOpts := []*ast.OptionNode{required}
+	parentOpts := []*ast.OptionNode{required}
+	if r.typ != "" {
+		nameOpts = append(nameOpts, resourceReferenceOption("type", r.typ))
+		parentOpts = append(parentOpts, resourceReferenceOption("child_type", r.typ))
+	}
+	var parent []*ast.FieldNode
+	if r.parentPattern != "" {
+		parent = append(parent, r.field("", "string", "parent", parentOpts...))
+	}
+	return []ast.Node{
+		service,
+		r.message(names[1],
+			r.field("", "string", r.nameField, nameOpts...),
+		),
+		r.message(names[2], append(parent,
+			r.field("", "int32", "page_size", optional),
+			r.field("", "string", "page_token", optional),
+		)...),
+		r.message(names[3],
+			r.field("repeated", singular, collectionField),
+			r.field("", "string", "next_page_token"),
+		),
+		r.message(names[4], append(parent,
+			r.field("", "string", resourceField+"_id", optional),
+			r.field("", singular, resourceField, required),
+		)...),
+		r.message(names[5],
+			r.field("", singular, resourceField, required),
+			r.field("", relativeFullName("google.protobuf.FieldMask", r.pkg), "update_mask", optional),
+		),
+		r.message(names[6],
+			r.field("", "string", r.nameField, nameOpts...),
+		),
+	}
+}
+
+// message returns a new message with the given fields, numbered in order.
+func (r *resourceService) message(name string, fields ...*ast.FieldNode) *ast.MessageNode {
+	msg := &ast.MessageNode{
+		Keyword:    &ast.IdentNode{Val: "message", IsKeyword: true},
+		Name:       &ast.IdentNode{Val: name},
+		OpenBrace:  &ast.RuneNode{Rune: '{'},
+		CloseBrace: &ast.RuneNode{Rune: '}'},
+	}
+	for i, fld := range fields {
+		fld.Tag = &ast.UintLiteralNode{Val: uint64(i + 1)}
+		msg.Decls = append(msg.Decls, fld.AsMessageElement())
+	}
+	return msg
+}
+
+// field returns a new field without a number. Singular fields are labeled
+// 'optional' in proto2 files.
+func (r *resourceService) field(label, typ, name string, opts ...*ast.OptionNode) *ast.FieldNode {
+	if label == "" && r.proto2 {
+		label = "optional"
+	}
+	fld := &ast.FieldNode{
+		FieldType: identValue(typ),
+		Name:      &ast.IdentNode{Val: name},
+		Equals:    &ast.RuneNode{Rune: '='},
+		Semicolon: &ast.RuneNode{Rune: ';'},
+	}
+	if label != "" {
+		fld.Label = &ast.IdentNode{Val: label, IsKeyword: true}
+	}
+	if len(opts) > 0 {
+		fld.Options = &ast.CompactOptionsNode{
+			OpenBracket:  &ast.RuneNode{Rune: '['},
+			CloseBracket: &ast.RuneNode{Rune: ']'},
+		}
+		for i, opt := range opts {
+			// options are shared between fields, so each field gets a copy
+			opt := &ast.OptionNode{Name: opt.Name, Equals: opt.Equals, Val: opt.Val}
+			if i < len(opts)-1 {
+				opt.Semicolon = &ast.RuneNode{Rune: ','}
+			}
+			fld.Options.Options = append(fld.Options.Options, opt)
+		}
+	}
+	return fld
+}
+
+func behaviorOption(behavior string) *ast.OptionNode {
+	return &ast.OptionNode{
+		Name:   extensionOptionName("google.api.field_behavior"),
+		Equals: &ast.RuneNode{Rune: '='},
+		Val:    (&ast.IdentNode{Val: behavior}).AsValueNode(),
+	}
+}
+
+func resourceReferenceOption(field, typ string) *ast.OptionNode {
+	return &ast.OptionNode{
+		Name:   extensionOptionName("google.api.resource_reference", field),
+		Equals: &ast.RuneNode{Rune: '='},
+		Val:    stringLiteral(typ).AsValueNode(),
+	}
+}
+
+// extensionOptionName returns the name of an option set through the given
+// extension, optionally followed by the path of a field within it.
+func extensionOptionName(extension string, fields ...string) *ast.OptionNameNode {
+	name := &ast.OptionNameNode{
+		Parts: []*ast.ComplexIdentComponent{(&ast.FieldReferenceNode{
+			Open:  &ast.RuneNode{Rune: '('},
+			Name:  identValue(extension),
+			Close: &ast.RuneNode{Rune: ')'},
+		}).AsComplexIdentComponent()},
+	}
+	for _, field := range fields {
+		name.Parts = append(name.Parts,
+			(&ast.RuneNode{Rune: '.'}).AsComplexIdentComponent(),
+			(&ast.FieldReferenceNode{Name: (&ast.IdentNode{Val: field}).AsIdentValueNode()}).AsComplexIdentComponent(),
+		)
+	}
+	return name
+}
+
+func rpcType(name string) *ast.RPCTypeNode {
+	return &ast.RPCTypeNode{
+		OpenParen:   &ast.RuneNode{Rune: '('},
+		MessageType: identValue(name),
+		CloseParen:  &ast.RuneNode{Rune: ')'},
+	}
+}
+
+// identValue returns a new identifier, which may be qualified.
+func identValue(name string) *ast.IdentValueNode {
+	if !strings.Contains(name, ".") {
+		return (&ast.IdentNode{Val: name}).AsIdentValueNode()
+	}
+	compoundIdent := &ast.CompoundIdentNode{}
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		compoundIdent.Components = append(compoundIdent.Components, (&ast.IdentNode{Val: part}).AsComplexIdentComponent())
+		if i < len(parts)-1 {
+			compoundIdent.Components = append(compoundIdent.Components, (&ast.RuneNode{Rune: '.'}).AsComplexIdentComponent())
+		}
+	}
+	return compoundIdent.AsIdentValueNode()
+}
+
+func stringLiteral(val string) *ast.StringLiteralNode {
+	return &ast.StringLiteralNode{Val: val}
+}
@@ -0,0 +1,209 @@
+package lsp
+
+import (
+	"testing"
+
+	"github.com/kralicky/protocompile/ast"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateResourceService(t *testing.T) {
+	cases := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "nested resource",
+			src: `syntax = "proto3";
+
+package library.v1;
+
+import "google/api/resource.proto";
+
+// A book in a publisher's catalog.
+message Book {
+  option (google.api.resource) = {
+    type: "library.googleapis.com/Book"
+    pattern: "publishers/{publisher}/books/{book}"
+  };
+
+  string name = 1;
+  string title = 2;
+} // trailing comment
+
+message Other {}
+`,
+			want: `syntax = "proto3";
+
+package library.v1;
+
+import "google/api/resource.proto";
+import "google/api/annotations.proto";
+import "google/api/field_behavior.proto";
+import "google/protobuf/empty.proto";
+import "google/protobuf/field_mask.proto";
+
+// A book in a publisher's catalog.
+message Book {
+  option (google.api.resource) = {
+    type: "library.googleapis.com/Book"
+    pattern: "publishers/{publisher}/books/{book}"
+  };
+
+  string name = 1;
+  string title = 2;
+} // trailing comment
+
+service BookService {
+  rpc GetBook(GetBookRequest) returns (Book) {
+    option (google.api.http) = {get: "/v1/{name=publishers/*/books/*}"};
+  }
+  rpc ListBooks(ListBooksRequest) returns (ListBooksResponse) {
+    option (google.api.http) = {get: "/v1/{parent=publishers/*}/books"};
+  }
+  rpc CreateBook(CreateBookRequest) returns (Book) {
+    option (google.api.http) = {post: "/v1/{parent=publishers/*}/books", body: "book"};
+  }
+  rpc UpdateBook(UpdateBookRequest) returns (Book) {
+    option (google.api.http) = {patch: "/v1/{book.name=publishers/*/books/*}", body: "book"};
+  }
+  rpc DeleteBook(DeleteBookRequest) returns (google.protobuf.Empty) {
+    option (google.api.http) = {delete: "/v1/{name=publishers/*/books/*}"};
+  }
+}
+
+message GetBookRequest {
+  string name = 1 [(google.api.field_behavior) = REQUIRED, (google.api.resource_reference).type = "library.googleapis.com/Book"];
+}
+
+message ListBooksRequest {
+  string parent     = 1 [(google.api.field_behavior) = REQUIRED, (google.api.resource_reference).child_type = "library.googleapis.com/Book"];
+  int32  page_size  = 2 [(google.api.field_behavior) = OPTIONAL];
+  string page_token = 3 [(google.api.field_behavior) = OPTIONAL];
+}
+
+message ListBooksResponse {
+  repeated Book books           = 1;
+  string        next_page_token = 2;
+}
+
+message CreateBookRequest {
+  string parent  = 1 [(google.api.field_behavior) = REQUIRED, (google.api.resource_reference).child_type = "library.googleapis.com/Book"];
+  string book_id = 2 [(google.api.field_behavior) = OPTIONAL];
+  Book   book    = 3 [(google.api.field_behavior) = REQUIRED];
+}
+
+message UpdateBookRequest {
+  Book                      book        = 1 [(google.api.field_behavior) = REQUIRED];
+  google.protobuf.FieldMask update_mask = 2 [(google.api.field_behavior) = OPTIONAL];
+}
+
+message DeleteBookRequest {
+  string name = 1 [(google.api.field_behavior) = REQUIRED, (google.api.resource_reference).type = "library.googleapis.com/Book"];
+}
+
+message Other {}
+`,
+		},
+		{
+			name: "top-level resource",
+			src: `syntax = "proto2";
+
+package shelves;
+
+import "google/api/annotations.proto";
+import "google/api/resource.proto";
+
+message BookShelf {
+  option (google.api.resource) = {
+    type: "library.googleapis.com/BookShelf"
+    plural: "bookShelves"
+  };
+
+  optional string name = 1;
+}
+`,
+			want: `syntax = "proto2";
+
+package shelves;
+
+import "google/api/annotations.proto";
+import "google/api/resource.proto";
+import "google/api/field_behavior.proto";
+import "google/protobuf/empty.proto";
+import "google/protobuf/field_mask.proto";
+
+message BookShelf {
+  option (google.api.resource) = {
+    type: "library.googleapis.com/BookShelf"
+    plural: "bookShelves"
+  };
+
+  optional string name = 1;
+}
+
+service BookShelfService {
+  rpc GetBookShelf(GetBookShelfRequest) returns (BookShelf) {
+    option (google.api.http) = {get: "/{name=bookShelves/*}"};
+  }
+  rpc ListBookShelves(ListBookShelvesRequest) returns (ListBookShelvesResponse) {
+    option (google.api.http) = {get: "/bookShelves"};
+  }
+  rpc CreateBookShelf(CreateBookShelfRequest) returns (BookShelf) {
+    option (google.api.http) = {post: "/bookShelves", body: "book_shelf"};
+  }
+  rpc UpdateBookShelf(UpdateBookShelfRequest) returns (BookShelf) {
+    option (google.api.http) = {patch: "/{book_shelf.name=bookShelves/*}", body: "book_shelf"};
+  }
+  rpc DeleteBookShelf(DeleteBookShelfRequest) returns (google.protobuf.Empty) {
+    option (google.api.http) = {delete: "/{name=bookShelves/*}"};
+  }
+}
+
+message GetBookShelfRequest {
+  optional string name = 1 [(google.api.field_behavior) = REQUIRED, (google.api.resource_reference).type = "library.googleapis.com/BookShelf"];
+}
+
+message ListBookShelvesRequest {
+  optional int32  page_size  = 1 [(google.api.field_behavior) = OPTIONAL];
+  optional string page_token = 2 [(google.api.field_behavior) = OPTIONAL];
+}
+
+message ListBookShelvesResponse {
+  repeated BookShelf book_shelves    = 1;
+  optional string    next_page_token = 2;
+}
+
+message CreateBookShelfRequest {
+  optional string    book_shelf_id = 1 [(google.api.field_behavior) = OPTIONAL];
+  optional BookShelf book_shelf    = 2 [(google.api.field_behavior) = REQUIRED];
+}
+
+message UpdateBookShelfRequest {
+  optional BookShelf                 book_shelf  = 1 [(google.api.field_behavior) = REQUIRED];
+  optional google.protobuf.FieldMask update_mask = 2 [(google.api.field_behavior) = OPTIONAL];
+}
+
+message DeleteBookShelfRequest {
+  optional string name = 1 [(google.api.field_behavior) = REQUIRED, (google.api.resource_reference).type = "library.googleapis.com/BookShelf"];
+}
+`,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			res := compileFile(t, c.src)
+			md := res.Messages().Get(0)
+			rd := resourceDescriptor(md)
+			require.NotNil(t, rd)
+			w := &sourceRewriter{res: res, file: res.AST(), content: []byte(c.src)}
+			require.NoError(t, w.insertResourceService(declNodeForDescriptor(res, md).(*ast.MessageNode), newResourceService(res, md, rd)))
+			out, err := applySourceEdits([]byte(c.src), w.edits)
+			require.NoError(t, err)
+			require.Equal(t, c.want, string(out))
+
+			compileFile(t, string(out))
+		})
+	}
+}
@@ -0,0 +1,274 @@
+package lsp
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/kralicky/protocompile/ast"
+	"github.com/kralicky/protocompile/linker"
+	"github.com/kralicky/tools-lite/gopls/pkg/protocol"
+)
+
+// sourceRewriter collects text edits against the original source of a file,
+// addressed by the offsets of its AST nodes.
+type sourceRewriter struct {
+	res     linker.Result
+	file    *ast.FileNode
+	content []byte
+	edits   []sourceEdit
+}
+
+type sourceEdit struct {
+	start, end int
+	text       string
+}
+
+// span returns the offsets of the first character of the node and of the
+// character following it. The offset of a node's end position refers to its
+// last character, so it is adjusted here; the last character of a token is
+// always a single byte. Nodes ending in a virtual token (such as the implicit
+// semicolon after a message) end at the position following their last real
+// token instead, so any whitespace this picks up is trimmed.
+func (w *sourceRewriter) span(n ast.Node) (int, int) {
+	info := w.file.NodeInfo(n)
+	start, end := info.Start().Offset, info.End().Offset+1
+	for end > start && unicode.IsSpace(rune(w.content[end-1])) {
+		end--
+	}
+	return start, end
+}
+
+// declSpan is like span, but includes the declaration's trailing semicolon
+// and closing brace, if any.
+func (w *sourceRewriter) declSpan(n ast.Node) (int, int) {
+	start, end := w.span(n)
+	if n, ok := n.(interface{ GetCloseBrace() *ast.RuneNode }); ok && n.GetCloseBrace() != nil {
+		_, closeEnd := w.span(n.GetCloseBrace())
+		end = max(end, closeEnd)
+	}
+	if n, ok := n.(interface{ GetSemicolon() *ast.RuneNode }); ok && n.GetSemicolon() != nil {
+		_, semiEnd := w.span(n.GetSemicolon())
+		end = max(end, semiEnd)
+	}
+	return start, end
+}
+
+// attachedDeclSpan is like declSpan, but also includes the comments directly
+// preceding the declaration.
+func (w *sourceRewriter) attachedDeclSpan(n ast.Node) (int, int) {
+	start, end := w.declSpan(n)
+	comments := w.file.NodeInfo(n).LeadingComments()
+	for i := comments.Len() - 1; i >= 0; i-- {
+		c := comments.Index(i)
+		between := w.content[c.End().Offset+1 : start]
+		if len(bytes.TrimSpace(between)) > 0 || bytes.Count(between, []byte("\n")) > 1 {
+			break
+		}
+		start = c.Start().Offset
+	}
+	return start, end
+}
+
+// lineSpan extends the given span to cover its whole line, including the
+// indentation, any trailing comment, and the line break, if nothing else is
+// on the line. Otherwise, the span is returned unchanged.
+func (w *sourceRewriter) lineSpan(start, end int) (int, int) {
+	lineStart := bytes.LastIndexByte(w.content[:start], '\n') + 1
+	lineEnd := len(w.content)
+	if i := bytes.IndexByte(w.content[end:], '\n'); i >= 0 {
+		lineEnd = end + i + 1
+	}
+	before := bytes.TrimSpace(w.content[lineStart:start])
+	after := bytes.TrimSpace(w.content[end:lineEnd])
+	if len(before) > 0 || (len(after) > 0 && !bytes.HasPrefix(after, []byte("//"))) {
+		return start, end
+	}
+	return lineStart, lineEnd
+}
+
+// removeLines removes the given span, which is usually the result of
+// lineSpan, along with one of the blank lines around it.
+func (w *sourceRewriter) removeLines(start, end int) {
+	if rest := w.content[end:]; bytes.HasPrefix(rest, []byte("\n")) {
+		end++
+	} else if bytes.HasPrefix(rest, []byte("\r\n")) {
+		end += 2
+	} else if bytes.HasSuffix(w.content[:start], []byte("\n\n")) {
+		start--
+	}
+	w.edit(start, end, "")
+}
+
+// indentation returns the whitespace preceding the given offset on its line.
+func (w *sourceRewriter) indentation(offset int) string {
+	lineStart := bytes.LastIndexByte(w.content[:offset], '\n') + 1
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return r
+		}
+		return -1
+	}, string(w.content[lineStart:offset]))
+}
+
+func (w *sourceRewriter) text(start, end int) string {
+	return string(w.content[start:end])
+}
+
+func (w *sourceRewriter) edit(start, end int, text string) {
+	w.edits = append(w.edits, sourceEdit{start: start, end: end, text: text})
+}
+
+// insertionPoint returns the offset at which to insert new declarations after
+// the given offset. If the rest of the line is empty or only holds a comment,
+// the declarations go on the next line so the comment stays where it is.
+func (w *sourceRewriter) insertionPoint(offset int) (int, bool) {
+	rest := w.content[offset:]
+	if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+		rest = rest[:i]
+	}
+	trimmed := bytes.TrimSpace(rest)
+	if len(trimmed) == 0 || bytes.HasPrefix(trimmed, []byte("//")) {
+		return offset + len(rest), true
+	}
+	return offset, false
+}
+
+func (w *sourceRewriter) insertDecls(after int, decls []string) {
+	if len(decls) == 0 {
+		return
+	}
+	offset, nextLine := w.insertionPoint(after)
+	if nextLine {
+		w.edit(offset, offset, "\n"+strings.Join(decls, "\n"))
+	} else {
+		w.edit(offset, offset, " "+strings.Join(decls, " "))
+	}
+}
+
+// compactOptions returns the source text of each option in the given list
+// that is not named in drop, followed by the given additional options. The
+// boolean result reports whether any options were dropped.
+func (w *sourceRewriter) compactOptions(opts *ast.CompactOptionsNode, drop []string, add []string) ([]string, bool) {
+	var kept []string
+	var dropped bool
+	if opts != nil {
+		for _, opt := range opts.Options {
+			if slices.ContainsFunc(drop, func(name string) bool {
+				return findOptionNode(w.res, []*ast.OptionNode{opt}, name) != nil
+			}) {
+				dropped = true
+				continue
+			}
+			start, end := w.span(opt)
+			kept = append(kept, w.text(start, end))
+		}
+	}
+	return append(kept, add...), dropped
+}
+
+// rewriteCompactOptions removes the options named in drop from a field's
+// compact options and appends the given additional options. If the field has
+// no compact options, they are inserted at the given offset.
+func (w *sourceRewriter) rewriteCompactOptions(opts *ast.CompactOptionsNode, insertAt int, drop []string, add []string) {
+	all, dropped := w.compactOptions(opts, drop, add)
+	switch {
+	case opts == nil:
+		if len(all) > 0 {
+			w.edit(insertAt, insertAt, " ["+strings.Join(all, ", ")+"]")
+		}
+	case !dropped && len(add) == 0:
+	case len(all) == 0:
+		_, end := w.span(opts.CloseBracket)
+		w.edit(insertAt, end, "")
+	default:
+		start, _ := w.span(opts.OpenBracket)
+		_, end := w.span(opts.CloseBracket)
+		w.edit(start, end, "["+strings.Join(all, ", ")+"]")
+	}
+}
+
+// textEdits converts the collected edits to protocol text edits.
+func (w *sourceRewriter) textEdits(mapper *protocol.Mapper) ([]protocol.TextEdit, error) {
+	textEdits := make([]protocol.TextEdit, 0, len(w.edits))
+	for _, e := range w.edits {
+		rng, err := mapper.OffsetRange(e.start, e.end)
+		if err != nil {
+			return nil, err
+		}
+		textEdits = append(textEdits, protocol.TextEdit{Range: rng, NewText: e.text})
+	}
+	return textEdits, nil
+}
+
+// enqueueRewrite returns a code action which applies the edits made by
+// rewrite to the file. The edits are computed when the action is resolved;
+// rewrite reports whether it was able to make them.
+func enqueueRewrite(title string, kind protocol.CodeActionKind, linkRes linker.Result, mapper *protocol.Mapper, rewrite func(w *sourceRewriter) bool) protocol.CodeAction {
+	return actionQueue.enqueue(title, kind, mapper.URI, linkRes.AST().Version(), func(ca *protocol.CodeAction) error {
+		w := newSourceRewriter(linkRes, mapper)
+		if !rewrite(w) {
+			return fmt.Errorf("%s: the file can no longer be rewritten", title)
+		}
+		return setRewriteEdit(ca, w, mapper)
+	})
+}
+
+// enqueueCheckedRewrite is like enqueueRewrite, but runs rewrite right away
+// so that the action is only returned if rewrite is able to make its edits.
+// The edits made here are reused when the action is resolved.
+func enqueueCheckedRewrite(title string, kind protocol.CodeActionKind, linkRes linker.Result, mapper *protocol.Mapper, rewrite func(w *sourceRewriter) bool) (protocol.CodeAction, bool) {
+	w := newSourceRewriter(linkRes, mapper)
+	if !rewrite(w) {
+		return protocol.CodeAction{}, false
+	}
+	return actionQueue.enqueue(title, kind, mapper.URI, linkRes.AST().Version(), func(ca *protocol.CodeAction) error {
+		return setRewriteEdit(ca, w, mapper)
+	}), true
+}
+
+func newSourceRewriter(linkRes linker.Result, mapper *protocol.Mapper) *sourceRewriter {
+	return &sourceRewriter{
+		res:     linkRes,
+		file:    linkRes.AST(),
+		content: mapper.Content,
+	}
+}
+
+func setRewriteEdit(ca *protocol.CodeAction, w *sourceRewriter, mapper *protocol.Mapper) error {
+	edits, err := w.textEdits(mapper)
+	if err != nil {
+		return err
+	}
+	ca.Edit = &protocol.WorkspaceEdit{
+		Changes: map[protocol.DocumentURI][]protocol.TextEdit{
+			mapper.URI: edits,
+		},
+	}
+	return nil
+}
+
+func applySourceEdits(content []byte, edits []sourceEdit) ([]byte, error) {
+	edits = slices.Clone(edits)
+	// Insertions at the same offset as a replacement are applied before it.
+	slices.SortStableFunc(edits, func(a, b sourceEdit) int {
+		if a.start != b.start {
+			return a.start - b.start
+		}
+		return min(a.end-a.start, 1) - min(b.end-b.start, 1)
+	})
+	var out bytes.Buffer
+	pos := 0
+	for _, e := range edits {
+		if e.start < pos || e.end < e.start || e.end > len(content) {
+			return nil, fmt.Errorf("bug: overlapping edits at offset %d", e.start)
+		}
+		out.Write(content[pos:e.start])
+		out.WriteString(e.text)
+		pos = e.end
+	}
+	out.Write(content[pos:])
+	return out.Bytes(), nil
+}
@@ -3,19 +3,15 @@ package lsp
 import (
 	"bytes"
 	"fmt"
-	"log/slog"
 	"os"
 	"regexp"
 	"slices"
 	"sort"
 	"strings"
-	"unsafe"
 
-	"github.com/bufbuild/protovalidate-go/celext"
-	"github.com/google/cel-go/cel"
-	celcommon "github.com/google/cel-go/common"
 	celast "github.com/google/cel-go/common/ast"
 	"github.com/google/cel-go/common/operators"
+	"github.com/google/cel-go/common/types"
 	"github.com/kralicky/protocompile"
 	"github.com/kralicky/protocompile/ast"
 	"github.com/kralicky/protocompile/ast/paths"
@@ -24,6 +20,7 @@ import (
 	"github.com/kralicky/tools-lite/gopls/pkg/protocol"
 	"google.golang.org/protobuf/reflect/protopath"
 	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
 )
 
 //go:generate stringer -type=tokenType,tokenModifier -trimprefix=semantic
@@ -105,6 +102,11 @@ type semanticItems struct {
 
 	parseRes     parser.Result // cannot be nil
 	maybeLinkRes linker.Result // can be nil if there are no linker results available
+
+	// protovalidate constraints in maybeLinkRes, indexed by expression
+	celConstraints map[*ast.ValueNode]celConstraint
+	// resolves the message types of fields selected in CEL expressions
+	celResolver linker.Resolver
 }
 
 func (s *semanticItems) AST() *ast.FileNode {
@@ -116,7 +118,7 @@ func (s *semanticItems) AST() *ast.FileNode {
 	return s.parseRes.AST()
 }
 
-func (c *Cache) ComputeSemanticTokens(doc protocol.TextDocumentIdentifier) ([]uint32, error) {
+func (c *Cache) ComputeSemanticTokens(doc protocol.TextDocumentIdentifier) (*protocol.SemanticTokens, error) {
 	c.resultsMu.RLock()
 	defer c.resultsMu.RUnlock()
 	if ok, err := c.latestDocumentContentsWellFormedLocked(doc.URI, false); err != nil {
@@ -129,7 +131,26 @@ func (c *Cache) ComputeSemanticTokens(doc protocol.TextDocumentIdentifier) ([]ui
 	if err != nil {
 		return nil, err
 	}
-	return result.Data, nil
+	result.ResultID = c.semanticTokens.Store(doc.URI, c.documentVersions.Get(doc.URI), result.Data)
+	return result, nil
+}
+
+// ComputeSemanticTokensDelta computes the semantic tokens for the document
+// and returns the edits relative to the previous result, or the full token
+// data (as *protocol.SemanticTokens) if the previous result is not known.
+func (c *Cache) ComputeSemanticTokensDelta(doc protocol.TextDocumentIdentifier, previousResultId string) (any, error) {
+	prev, ok := c.semanticTokens.Get(doc.URI, previousResultId)
+	result, err := c.ComputeSemanticTokens(doc)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return result, nil
+	}
+	return &protocol.SemanticTokensDelta{
+		ResultID: result.ResultID,
+		Edits:    computeSemanticTokensEdits(prev, result.Data),
+	}, nil
 }
 
 func (c *Cache) ComputeSemanticTokensRange(doc protocol.TextDocumentIdentifier, rng protocol.Range) ([]uint32, error) {
@@ -313,19 +334,30 @@ func (s *semanticItems) mktokens(node ast.Node, path protopath.Path, tt tokenTyp
 	s.mkcomments(node)
 }
 
-func (s *semanticItems) mktokens_cel(str *ast.StringLiteralNode, start, end int32, tt tokenType, mods tokenModifier) {
-	lineInfo := s.AST().NodeInfo(str)
-	lineStart := lineInfo.Start()
-
-	nodeTk := semanticItem{
-		lang:  tokenLanguageCel,
-		line:  uint32(lineStart.Line - 1),
-		start: uint32(int32(lineStart.Col) + start),
-		len:   uint32(end - start),
-		typ:   tt,
-		mods:  mods,
+// mktokens_cel creates tokens for the runes in the range [start, end) of an
+// embedded CEL expression. The range is split if it spans multiple string
+// literals.
+func (s *semanticItems) mktokens_cel(expr *celExpression, start, end int, tt tokenType, mods tokenModifier) {
+	for _, part := range expr.parts {
+		partEnd := part.offset + len(part.fileOffsets) - 1
+		tokStart, tokEnd := max(start, part.offset), min(end, partEnd)
+		if tokStart >= tokEnd {
+			continue
+		}
+		startPos := s.AST().SourcePos(part.fileOffsets[tokStart-part.offset])
+		endPos := s.AST().SourcePos(part.fileOffsets[tokEnd-part.offset])
+		if startPos.Line != endPos.Line {
+			continue
+		}
+		s.items = append(s.items, semanticItem{
+			lang:  tokenLanguageCel,
+			line:  uint32(startPos.Line - 1),
+			start: uint32(startPos.Col - 1),
+			len:   uint32(endPos.Col - startPos.Col),
+			typ:   tt,
+			mods:  mods,
+		})
 	}
-	s.items = append(s.items, nodeTk)
 }
 
 func (s *semanticItems) mkcomments(node ast.Node) {
@@ -442,13 +474,6 @@ func (s *semanticItems) multilineComment(comment ast.Comment, cstart, cend ast.S
 	})
 }
 
-var celEnv *cel.Env
-
-func init() {
-	celEnv, _ = celext.DefaultEnv(false)
-	celEnv, _ = celEnv.Extend(cel.EnableMacroCallTracking())
-}
-
 var escapeCharRegex = regexp.MustCompile(`\\([0-7]{1,3}|[abfnrtv\\'"]|[xX][0-9a-fA-F]{1,2}|u[0-9a-fA-F]{4}|U[0-9a-fA-F]{8})`)
 
 func (s *semanticItems) inspect(node ast.Node, walkOptions ...ast.WalkOption) {
@@ -616,7 +641,7 @@ func (s *semanticItems) inspect(node ast.Node, walkOptions ...ast.WalkOption) {
 				}
 			}
 			if hasExpressionField && hasIdField {
-				tokens, _ := s.inspectCelExpr(node)
+				tokens := s.inspectCelExpr(node)
 				for _, lit := range tokens {
 					embeddedStringLiterals[lit] = struct{}{}
 				}
@@ -880,99 +905,191 @@ func (s *semanticItems) inspectArrayLiteral(node ast.Node, val *ast.ArrayLiteral
 	}
 }
 
-func (s *semanticItems) inspectCelExpr(messageLit *ast.MessageLiteralNode) ([]*ast.StringLiteralNode, []ProtoDiagnostic) {
+func (s *semanticItems) inspectCelExpr(messageLit *ast.MessageLiteralNode) []*ast.StringLiteralNode {
 	for _, elem := range messageLit.Elements {
-		if elem.Name.Name.AsIdentifier() == "expression" {
-			diagnostics := []ProtoDiagnostic{}
-			stringNodes := []*ast.StringLiteralNode{}
-			var celExpr string
-			switch val := elem.Val.Unwrap().(type) {
-			case *ast.StringLiteralNode:
-				stringNodes = append(stringNodes, val)
-				celExpr = val.AsString()
-			case *ast.CompoundStringLiteralNode:
-				lines := []string{}
-				for _, part := range val.Elements {
-					if str := part.GetStringLiteral(); str != nil {
-						stringNodes = append(stringNodes, str)
-						strVal := strings.TrimSpace(str.AsString())
-						lines = append(lines, strVal)
-					}
+		if elem.Name.Name.AsIdentifier() != "expression" {
+			continue
+		}
+		expr, ok := newCelExpression(s.AST(), elem.Val)
+		if !ok {
+			return nil
+		}
+		env := celEnv
+		if constraint, ok := s.findCelConstraint(elem.Val); ok {
+			if constraintEnv, err := constraint.env(); err == nil {
+				env = constraintEnv
+			}
+		}
+		parsed, issues := env.Parse(expr.text)
+		if issues.Err() != nil {
+			// parse errors are reported as diagnostics by checkCelExpressions
+			return nil
+		}
+		parsedAst := parsed.NativeRep()
+		var checkedAst *celast.AST
+		if env != celEnv {
+			if checked, issues := env.Check(parsed); issues.Err() == nil {
+				checkedAst = checked.NativeRep()
+			}
+		}
+		s.inspectCelAst(expr, parsedAst, checkedAst)
+
+		var literals []*ast.StringLiteralNode
+		switch val := elem.Val.Unwrap().(type) {
+		case *ast.StringLiteralNode:
+			literals = append(literals, val)
+		case *ast.CompoundStringLiteralNode:
+			for _, part := range val.Elements {
+				if str := part.GetStringLiteral(); str != nil {
+					literals = append(literals, str)
 				}
-				celExpr = strings.Join(lines, "\n")
-			}
-			// escape backslashes that would have been un-escaped by the parser
-			celExpr = strings.ReplaceAll(celExpr, `\`, `\\`)
-			parsed, issues := celEnv.Parse(celExpr)
-			if issues != nil && issues.Err() != nil {
-				slog.Warn("error parsing CEL expression",
-					"location", s.AST().NodeInfo(stringNodes[0]).String(),
-				)
-				fmt.Println(issues.Err())
-				// TODO
-				// diagnostics = append(diagnostics, ProtoDiagnostic{
-				// 	Pos:      s.AST().NodeInfo(stringNodes[0]),
-				// 	Severity: protocol.SeverityWarning,
-				// 	Error:    issues.Err(),
-				// })
-				continue
 			}
-			ast := getAst(parsed)
-			sourceInfo := ast.SourceInfo()
-			celast.PreOrderVisit(ast.Expr(), celast.NewExprVisitor(func(e celast.Expr) {
-				switch e.Kind() {
-				case celast.UnspecifiedExprKind:
-				case celast.CallKind:
-					call := e.AsCall()
-					if displayName, ok := operators.FindReverse(call.FunctionName()); ok && len(displayName) > 0 {
-						start := sourceInfo.GetStartLocation(e.ID())
-						end := start.Column() + len(displayName)
-						s.mktokens_cel(stringNodes[start.Line()-1], int32(start.Column()), int32(end), semanticTypeOperator, 0)
-						return
-					}
-				case celast.IdentKind:
-					ident := e.AsIdent()
-					start := sourceInfo.GetStartLocation(e.ID())
-					end := start.Column() + len(ident)
-
-					tokenType := semanticTypeVariable
-					if ident == "this" {
-						tokenType = semanticTypeKeyword
-					}
-					s.mktokens_cel(stringNodes[start.Line()-1], int32(start.Column()), int32(end), tokenType, 0)
-				case celast.LiteralKind:
-					val := e.AsLiteral().Value()
-					switch val.(type) {
-					case int, int32, int64, uint, uint32, uint64, float32, float64:
-						start := sourceInfo.GetStartLocation(e.ID())
-						end := start.Column() + len(fmt.Sprint(val))
-
-						s.mktokens_cel(stringNodes[start.Line()-1], int32(start.Column()), int32(end), semanticTypeNumber, 0)
-					case string:
-						start := sourceInfo.GetStartLocation(e.ID())
-						end := start.Column() + len(val.(string)) + 2 // +2 for quotes
-						s.mktokens_cel(stringNodes[start.Line()-1], int32(start.Column()), int32(end), semanticTypeString, 0)
-					case bool:
-						start := sourceInfo.GetStartLocation(e.ID())
-						end := start.Column() + len(fmt.Sprint(val))
-
-						s.mktokens_cel(stringNodes[start.Line()-1], int32(start.Column()), int32(end), semanticTypeKeyword, 0)
-					}
+		}
+		return literals
+	}
+	return nil
+}
+
+// findCelConstraint returns the protovalidate constraint for the given
+// expression value, if the expression is part of a known constraint.
+func (s *semanticItems) findCelConstraint(val *ast.ValueNode) (celConstraint, bool) {
+	if s.maybeLinkRes == nil {
+		return celConstraint{}, false
+	}
+	if s.celConstraints == nil {
+		s.celConstraints = make(map[*ast.ValueNode]celConstraint)
+		for _, c := range findCelConstraints(s.maybeLinkRes) {
+			s.celConstraints[c.expression] = c
+		}
+	}
+	c, ok := s.celConstraints[val]
+	return c, ok
+}
+
+// inspectCelAst creates tokens for a parsed CEL expression. Variables bound
+// by comprehension macros are marked readonly. If the checked AST is
+// available, field references that resolve to message fields are also marked
+// readonly (and deprecated, if applicable).
+func (s *semanticItems) inspectCelAst(expr *celExpression, parsed *celast.AST, checked *celast.AST) {
+	sourceInfo := parsed.SourceInfo()
+	mktokens := func(id int64, e celast.Expr, tt tokenType, mods tokenModifier) {
+		if start, end, ok := expr.exprTokenRange(parsed, id, e); ok {
+			s.mktokens_cel(expr, start, end, tt, mods)
+		}
+	}
+	fieldModifiers := func(messageExprId int64, fieldName string) tokenModifier {
+		if checked == nil {
+			return 0
+		}
+		if s.celResolver == nil {
+			s.celResolver = linker.ResolverFromFile(s.maybeLinkRes)
+		}
+		fd := celSelectedField(checked.GetType(messageExprId), fieldName, s.celResolver.FindDescriptorByName)
+		if fd == nil {
+			return 0
+		}
+		mods := semanticModifierReadonly
+		if fd.Options().(*descriptorpb.FieldOptions).GetDeprecated() {
+			mods |= semanticModifierDeprecated
+		}
+		return mods
+	}
+	mkselect := func(e celast.Expr) {
+		sel := e.AsSelect()
+		mktokens(e.ID(), e, semanticTypeProperty, fieldModifiers(sel.Operand().ID(), sel.FieldName()))
+	}
+
+	// Variables bound by comprehension macros, by expression id
+	boundVars := map[int64]struct{}{}
+	celast.PreOrderVisit(parsed.Expr(), celast.NewExprVisitor(func(e celast.Expr) {
+		if e.Kind() != celast.ComprehensionKind {
+			return
+		}
+		comp := e.AsComprehension()
+		for _, sub := range []celast.Expr{comp.LoopCondition(), comp.LoopStep(), comp.Result()} {
+			celast.PreOrderVisit(sub, celast.NewExprVisitor(func(e celast.Expr) {
+				if e.Kind() == celast.IdentKind && e.AsIdent() == comp.IterVar() {
+					boundVars[e.ID()] = struct{}{}
 				}
 			}))
+		}
+	}))
 
-			return stringNodes, diagnostics
+	for id, call := range sourceInfo.MacroCalls() {
+		mktokens(id, call, semanticTypeMacro, semanticModifierDefaultLibrary)
+		args := call.AsCall().Args()
+		if len(args) == 0 {
+			continue
+		}
+		switch arg := args[0]; arg.Kind() {
+		case celast.IdentKind:
+			// the iteration variable in a comprehension macro
+			mktokens(arg.ID(), arg, semanticTypeVariable, semanticModifierDeclaration|semanticModifierReadonly)
+		case celast.SelectKind:
+			// the field in has(x.f), which is not part of the expanded expression
+			mkselect(arg)
 		}
 	}
-	return nil, nil
-}
 
-// there is no method to get the underlying ast i guess?? lol
-func getAst(parsed *cel.Ast) *celast.AST {
-	return (*struct {
-		source celcommon.Source
-		impl   *celast.AST
-	})(unsafe.Pointer(parsed)).impl
+	celast.PreOrderVisit(parsed.Expr(), celast.NewExprVisitor(func(e celast.Expr) {
+		if r, ok := sourceInfo.GetOffsetRange(e.ID()); !ok || r.Start == r.Stop {
+			// synthesized by macro expansion
+			return
+		}
+		switch e.Kind() {
+		case celast.CallKind:
+			call := e.AsCall()
+			if call.FunctionName() == operators.Conditional {
+				// positioned at the '?'
+				if start, _, ok := expr.offsetRange(parsed, e.ID()); ok {
+					s.mktokens_cel(expr, start, start+1, semanticTypeOperator, 0)
+				}
+				return
+			}
+			if displayName, ok := operators.FindReverse(call.FunctionName()); ok {
+				if len(displayName) > 0 {
+					mktokens(e.ID(), e, semanticTypeOperator, 0)
+				}
+				return
+			}
+			if call.IsMemberFunction() {
+				mktokens(e.ID(), e, semanticTypeMethod, semanticModifierDefaultLibrary)
+			} else {
+				mktokens(e.ID(), e, semanticTypeFunction, semanticModifierDefaultLibrary)
+			}
+		case celast.IdentKind:
+			switch ident := e.AsIdent(); {
+			case ident == "this":
+				mktokens(e.ID(), e, semanticTypeKeyword, 0)
+			case ident == "rules" || ident == "rule" || ident == "now":
+				mktokens(e.ID(), e, semanticTypeVariable, semanticModifierDefaultLibrary|semanticModifierReadonly)
+			default:
+				if _, ok := boundVars[e.ID()]; ok {
+					mktokens(e.ID(), e, semanticTypeVariable, semanticModifierReadonly)
+				} else {
+					mktokens(e.ID(), e, semanticTypeVariable, 0)
+				}
+			}
+		case celast.SelectKind:
+			mkselect(e)
+		case celast.StructKind:
+			mktokens(e.ID(), e, semanticTypeType, 0)
+			for _, field := range e.AsStruct().Fields() {
+				if start, end, ok := expr.structFieldRange(parsed, field); ok {
+					s.mktokens_cel(expr, start, end, semanticTypeProperty, fieldModifiers(e.ID(), field.AsStructField().Name()))
+				}
+			}
+		case celast.LiteralKind:
+			switch e.AsLiteral().(type) {
+			case types.Int, types.Uint, types.Double:
+				mktokens(e.ID(), e, semanticTypeNumber, 0)
+			case types.String, types.Bytes:
+				mktokens(e.ID(), e, semanticTypeString, 0)
+			case types.Bool, types.Null:
+				mktokens(e.ID(), e, semanticTypeKeyword, 0)
+			}
+		}
+	}))
 }
 
 func (e *semanticItems) Data() []uint32 {
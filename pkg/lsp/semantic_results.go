@@ -0,0 +1,92 @@
+package lsp
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/kralicky/tools-lite/gopls/pkg/protocol"
+)
+
+// semanticTokensResults stores the most recent semantic tokens computed for
+// each document, so that subsequent requests can be answered with deltas.
+type semanticTokensResults struct {
+	mu      sync.Mutex
+	nextId  uint64
+	results map[protocol.DocumentURI]semanticTokensResult
+}
+
+type semanticTokensResult struct {
+	id      string
+	version int32
+	data    []uint32
+}
+
+func newSemanticTokensResults() *semanticTokensResults {
+	return &semanticTokensResults{
+		results: make(map[protocol.DocumentURI]semanticTokensResult),
+	}
+}
+
+// Store records the token data computed for the given document version and
+// returns its result ID. If the data is unchanged from the previous result
+// for the same version, the previous result ID is reused.
+func (r *semanticTokensResults) Store(uri protocol.DocumentURI, version int32, data []uint32) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if prev, ok := r.results[uri]; ok && prev.version == version && slices.Equal(prev.data, data) {
+		return prev.id
+	}
+	r.nextId++
+	id := fmt.Sprintf("%d.%d", version, r.nextId)
+	r.results[uri] = semanticTokensResult{
+		id:      id,
+		version: version,
+		data:    data,
+	}
+	return id
+}
+
+// Get returns the token data for the given result ID, if it is the most
+// recent result for the document.
+func (r *semanticTokensResults) Get(uri protocol.DocumentURI, id string) ([]uint32, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	prev, ok := r.results[uri]
+	if !ok || prev.id != id {
+		return nil, false
+	}
+	return prev.data, true
+}
+
+func (r *semanticTokensResults) Delete(uri protocol.DocumentURI) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.results, uri)
+}
+
+// computeSemanticTokensEdits returns the edits that transform the previous
+// token data into the new token data. The edit replaces the range between the
+// longest common prefix and suffix, which keeps deltas small for typical
+// single-location changes.
+func computeSemanticTokensEdits(prev, next []uint32) []protocol.SemanticTokensEdit {
+	prefix := 0
+	for prefix < len(prev) && prefix < len(next) && prev[prefix] == next[prefix] {
+		prefix++
+	}
+	if prefix == len(prev) && prefix == len(next) {
+		return []protocol.SemanticTokensEdit{}
+	}
+	suffix := 0
+	for suffix < len(prev)-prefix && suffix < len(next)-prefix &&
+		prev[len(prev)-1-suffix] == next[len(next)-1-suffix] {
+		suffix++
+	}
+	return []protocol.SemanticTokensEdit{
+		{
+			Start:       uint32(prefix),
+			DeleteCount: uint32(len(prev) - prefix - suffix),
+			Data:        slices.Clone(next[prefix : len(next)-suffix]),
+		},
+	}
+}
@@ -0,0 +1,88 @@
+package lsp
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/kralicky/tools-lite/gopls/pkg/protocol"
+	"github.com/stretchr/testify/require"
+)
+
+func applySemanticTokensEdits(data []uint32, edits []protocol.SemanticTokensEdit) []uint32 {
+	data = slices.Clone(data)
+	for i := len(edits) - 1; i >= 0; i-- {
+		edit := edits[i]
+		data = slices.Replace(data, int(edit.Start), int(edit.Start+edit.DeleteCount), edit.Data...)
+	}
+	return data
+}
+
+func TestComputeSemanticTokensEdits(t *testing.T) {
+	cases := []struct {
+		name       string
+		prev, next []uint32
+		edits      []protocol.SemanticTokensEdit
+	}{
+		{
+			name:  "unchanged",
+			prev:  []uint32{0, 1, 2, 3, 0, 1, 0, 4, 8, 0},
+			next:  []uint32{0, 1, 2, 3, 0, 1, 0, 4, 8, 0},
+			edits: []protocol.SemanticTokensEdit{},
+		},
+		{
+			name:  "modified token",
+			prev:  []uint32{0, 1, 2, 3, 0, 1, 0, 4, 8, 0},
+			next:  []uint32{0, 1, 2, 3, 0, 1, 0, 5, 8, 0},
+			edits: []protocol.SemanticTokensEdit{{Start: 7, DeleteCount: 1, Data: []uint32{5}}},
+		},
+		{
+			name:  "inserted token",
+			prev:  []uint32{0, 1, 2, 3, 0, 1, 0, 4, 8, 0},
+			next:  []uint32{0, 1, 2, 3, 0, 0, 5, 1, 7, 0, 1, 0, 4, 8, 0},
+			edits: []protocol.SemanticTokensEdit{{Start: 5, DeleteCount: 0, Data: []uint32{0, 5, 1, 7, 0}}},
+		},
+		{
+			name:  "removed token",
+			prev:  []uint32{0, 1, 2, 3, 0, 1, 0, 4, 8, 0},
+			next:  []uint32{0, 1, 2, 3, 0},
+			edits: []protocol.SemanticTokensEdit{{Start: 5, DeleteCount: 5, Data: []uint32{}}},
+		},
+		{
+			name:  "from empty",
+			prev:  []uint32{},
+			next:  []uint32{0, 1, 2, 3, 0},
+			edits: []protocol.SemanticTokensEdit{{Start: 0, DeleteCount: 0, Data: []uint32{0, 1, 2, 3, 0}}},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			edits := computeSemanticTokensEdits(tc.prev, tc.next)
+			require.Equal(t, tc.edits, edits)
+			require.Equal(t, tc.next, applySemanticTokensEdits(tc.prev, edits))
+		})
+	}
+}
+
+func TestSemanticTokensResults(t *testing.T) {
+	results := newSemanticTokensResults()
+	const uri = protocol.DocumentURI("file:///test.proto")
+
+	id1 := results.Store(uri, 1, []uint32{0, 1, 2, 3, 0})
+	require.Equal(t, id1, results.Store(uri, 1, []uint32{0, 1, 2, 3, 0}))
+
+	data, ok := results.Get(uri, id1)
+	require.True(t, ok)
+	require.Equal(t, []uint32{0, 1, 2, 3, 0}, data)
+
+	id2 := results.Store(uri, 2, []uint32{0, 1, 2, 3, 0})
+	require.NotEqual(t, id1, id2)
+	_, ok = results.Get(uri, id1)
+	require.False(t, ok)
+
+	id3 := results.Store(uri, 2, []uint32{0, 1, 3, 3, 0})
+	require.NotEqual(t, id2, id3)
+
+	results.Delete(uri)
+	_, ok = results.Get(uri, id3)
+	require.False(t, ok)
+}
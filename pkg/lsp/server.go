@@ -2,6 +2,7 @@ package lsp
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log/slog"
 	"maps"
@@ -36,6 +37,7 @@ type Server struct {
 
 type ServerOptions struct {
 	unknownCommandHandlers map[string]UnknownCommandHandler
+	conn                   jsonrpc2.Conn
 }
 
 type ServerOption func(*ServerOptions)
@@ -57,6 +59,14 @@ func WithUnknownCommandHandler(handler UnknownCommandHandler, cmds ...string) Se
 	}
 }
 
+// WithClientConn sets the connection to the client, which is used to send
+// requests that cannot be expressed with the protocol package's types.
+func WithClientConn(conn jsonrpc2.Conn) ServerOption {
+	return func(o *ServerOptions) {
+		o.conn = conn
+	}
+}
+
 func NewServer(client protocol.ClientCloser, opts ...ServerOption) *Server {
 	var options ServerOptions
 	options.apply(opts...)
@@ -137,6 +147,14 @@ func (s *Server) Initialize(ctx context.Context, params *protocol.ParamInitializ
 			},
 		},
 	}
+	folderPattern := protocol.FolderPattern
+	fileOrFolderFilters := append(filters, protocol.FileOperationFilter{
+		Scheme: "file",
+		Pattern: protocol.FileOperationPattern{
+			Glob:    "**",
+			Matches: &folderPattern,
+		},
+	})
 	slog.Debug("Initialize", "folders", folders)
 	defer s.client.LogMessage(ctx, &protocol.LogMessageParams{
 		Type:    protocol.Info,
@@ -162,9 +180,15 @@ func (s *Server) Initialize(ctx context.Context, params *protocol.ParamInitializ
 					DidRename: &protocol.FileOperationRegistrationOptions{
 						Filters: filters,
 					},
+					WillRename: &protocol.FileOperationRegistrationOptions{
+						Filters: fileOrFolderFilters,
+					},
 					DidDelete: &protocol.FileOperationRegistrationOptions{
 						Filters: filters,
 					},
+					WillDelete: &protocol.FileOperationRegistrationOptions{
+						Filters: fileOrFolderFilters,
+					},
 				},
 			},
 			InlayHintProvider:    true,
@@ -184,6 +208,14 @@ func (s *Server) Initialize(ctx context.Context, params *protocol.ParamInitializ
 					protocol.RefactorRewrite,
 					protocol.RefactorInline,
 					protocol.RefactorExtract,
+					protocol.RefactorMove,
+				},
+			},
+			ExecuteCommandProvider: &protocol.ExecuteCommandOptions{
+				Commands: []string{
+					"protols/moveToFile",
+					"protols/changeNesting",
+					"protols/changeFieldType",
 				},
 			},
 			RenameProvider: &protocol.RenameOptions{
@@ -200,7 +232,7 @@ func (s *Server) Initialize(ctx context.Context, params *protocol.ParamInitializ
 					TokenTypes:     semanticTokenTypes,
 					TokenModifiers: semanticTokenModifiers,
 				},
-				Full:  &protocol.Or_SemanticTokensOptions_full{Value: true},
+				Full:  &protocol.Or_SemanticTokensOptions_full{Value: protocol.SemanticTokensFullDelta{Delta: true}},
 				Range: &protocol.Or_SemanticTokensOptions_range{Value: true},
 			},
 			DocumentSymbolProvider: &protocol.Or_ServerCapabilities_documentSymbolProvider{Value: true},
@@ -523,18 +555,16 @@ func (s *Server) SemanticTokensFull(ctx context.Context, params *protocol.Semant
 	if err != nil {
 		return nil, err
 	}
-	tokens, err := c.ComputeSemanticTokens(params.TextDocument)
-	if err != nil {
-		return nil, err
-	}
-	return &protocol.SemanticTokens{
-		Data: tokens,
-	}, nil
+	return c.ComputeSemanticTokens(params.TextDocument)
 }
 
 // SemanticTokensFullDelta implements protocol.Server.
 func (s *Server) SemanticTokensFullDelta(ctx context.Context, params *protocol.SemanticTokensDeltaParams) (result interface{}, err error) {
-	return nil, nil
+	c, err := s.CacheForURI(params.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+	return c.ComputeSemanticTokensDelta(params.TextDocument, params.PreviousResultID)
 }
 
 // SemanticTokensRange implements protocol.Server.
@@ -820,6 +850,59 @@ func (s *Server) clientSupportsResolveEdits() bool {
 		slices.Contains(s.clientCapabilities.TextDocument.CodeAction.ResolveSupport.Properties, "edit")
 }
 
+func (s *Server) clientSupportsResourceOperation(kind protocol.ResourceOperationKind) bool {
+	return s.clientCapabilities.Workspace.WorkspaceEdit != nil &&
+		slices.Contains(s.clientCapabilities.Workspace.WorkspaceEdit.ResourceOperations, kind)
+}
+
+// documentChange is an entry in a workspace edit's document changes. Unlike
+// protocol.DocumentChanges, it can also hold a file creation.
+type documentChange struct {
+	protocol.DocumentChanges
+	CreateFile *protocol.CreateFile
+}
+
+func (d documentChange) MarshalJSON() ([]byte, error) {
+	if d.CreateFile != nil {
+		return json.Marshal(d.CreateFile)
+	}
+	return d.DocumentChanges.MarshalJSON()
+}
+
+// applyEditCreatingFiles asks the client to create each of the given files,
+// then apply the changes. Clients that do not support creating files are only
+// sent the changes, and create each file when it is first edited.
+func (s *Server) applyEditCreatingFiles(ctx context.Context, label string, create []protocol.DocumentURI, changes []protocol.DocumentChanges) (*protocol.ApplyWorkspaceEditResult, error) {
+	if s.conn == nil || !s.clientSupportsResourceOperation(protocol.Create) {
+		return s.client.ApplyEdit(ctx, &protocol.ApplyWorkspaceEditParams{
+			Label: label,
+			Edit:  protocol.WorkspaceEdit{DocumentChanges: changes},
+		})
+	}
+	var documentChanges []documentChange
+	for _, uri := range create {
+		documentChanges = append(documentChanges, documentChange{
+			CreateFile: &protocol.CreateFile{Kind: string(protocol.Create), URI: uri},
+		})
+	}
+	for _, change := range changes {
+		documentChanges = append(documentChanges, documentChange{DocumentChanges: change})
+	}
+	type workspaceEdit struct {
+		DocumentChanges []documentChange `json:"documentChanges"`
+	}
+	params := struct {
+		Label string        `json:"label,omitempty"`
+		Edit  workspaceEdit `json:"edit"`
+	}{Label: label, Edit: workspaceEdit{DocumentChanges: documentChanges}}
+
+	var result *protocol.ApplyWorkspaceEditResult
+	if err := protocol.Call(ctx, s.conn, "workspace/applyEdit", params, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
 // PrepareRename implements protocol.Server.
 func (s *Server) PrepareRename(ctx context.Context, params *protocol.PrepareRenameParams) (*protocol.PrepareRenameResult, error) {
 	c, err := s.CacheForURI(params.TextDocument.URI)
@@ -835,7 +918,7 @@ func (s *Server) Rename(ctx context.Context, params *protocol.RenameParams) (*pr
 	if err != nil {
 		return nil, err
 	}
-	return c.Rename(params)
+	return c.Rename(ctx, params)
 }
 
 // CodeLens implements protocol.Server.
@@ -858,6 +941,10 @@ func (s *Server) Symbol(ctx context.Context, params *protocol.WorkspaceSymbolPar
 
 // ResolveCodeAction implements protocol.Server.
 func (s *Server) ResolveCodeAction(ctx context.Context, codeAction *protocol.CodeAction) (*protocol.CodeAction, error) {
+	if codeAction.Data == nil && codeAction.Command != nil {
+		// actions that only run a command have nothing to resolve
+		return codeAction, nil
+	}
 	uri, version, err := resolveCodeAction(codeAction)
 	if err != nil {
 		return nil, err
@@ -949,13 +1036,64 @@ func (*Server) WillCreateFiles(context.Context, *protocol.CreateFilesParams) (*p
 }
 
 // WillDeleteFiles implements protocol.Server.
-func (*Server) WillDeleteFiles(context.Context, *protocol.DeleteFilesParams) (*protocol.WorkspaceEdit, error) {
-	return nil, notImplemented("WillDeleteFiles")
+func (s *Server) WillDeleteFiles(ctx context.Context, params *protocol.DeleteFilesParams) (*protocol.WorkspaceEdit, error) {
+	deletes := map[*Cache][]protocol.FileDelete{}
+	for _, f := range params.Files {
+		c, err := s.CacheForURI(protocol.DocumentURI(f.URI))
+		if err != nil {
+			return nil, err
+		}
+		deletes[c] = append(deletes[c], f)
+	}
+	var changes []protocol.DocumentChanges
+	var warnings []string
+	for c, files := range deletes {
+		cacheChanges, dependents, err := c.WillDeleteFiles(ctx, files)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, cacheChanges...)
+		for path, importers := range dependents {
+			warnings = append(warnings, fmt.Sprintf("%s is still imported by %s", path, strings.Join(importers, ", ")))
+		}
+	}
+	if len(warnings) > 0 {
+		slices.Sort(warnings)
+		if err := s.client.ShowMessage(ctx, &protocol.ShowMessageParams{
+			Type:    protocol.Warning,
+			Message: strings.Join(warnings, "; "),
+		}); err != nil {
+			slog.Error("failed to show message", "error", err)
+		}
+	}
+	if len(changes) == 0 {
+		return nil, nil
+	}
+	return &protocol.WorkspaceEdit{DocumentChanges: changes}, nil
 }
 
 // WillRenameFiles implements protocol.Server.
-func (*Server) WillRenameFiles(context.Context, *protocol.RenameFilesParams) (*protocol.WorkspaceEdit, error) {
-	return nil, notImplemented("WillRenameFiles")
+func (s *Server) WillRenameFiles(ctx context.Context, params *protocol.RenameFilesParams) (*protocol.WorkspaceEdit, error) {
+	renames := map[*Cache][]protocol.FileRename{}
+	for _, f := range params.Files {
+		c, err := s.CacheForURI(protocol.DocumentURI(f.OldURI))
+		if err != nil {
+			return nil, err
+		}
+		renames[c] = append(renames[c], f)
+	}
+	var changes []protocol.DocumentChanges
+	for c, files := range renames {
+		cacheChanges, err := c.WillRenameFiles(ctx, files)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, cacheChanges...)
+	}
+	if len(changes) == 0 {
+		return nil, nil
+	}
+	return &protocol.WorkspaceEdit{DocumentChanges: changes}, nil
 }
 
 // WillSave implements protocol.Server.
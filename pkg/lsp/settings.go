@@ -1,7 +1,9 @@
 package lsp
 
 type Settings struct {
-	InlayHints InlayHintsSettings `mapstructure:"inlayHints"`
+	InlayHints     InlayHintsSettings     `mapstructure:"inlayHints"`
+	FileOperations FileOperationsSettings `mapstructure:"fileOperations"`
+	Rename         RenameSettings         `mapstructure:"rename"`
 }
 
 type InlayHintsSettings struct {
@@ -22,3 +24,25 @@ func (s *InlayHintsSettings) GetImports() bool {
 	}
 	return *s.Imports
 }
+
+type FileOperationsSettings struct {
+	UpdateGoPackage *bool `mapstructure:"updateGoPackage"`
+}
+
+func (s *FileOperationsSettings) GetUpdateGoPackage() bool {
+	if s.UpdateGoPackage == nil {
+		return true
+	}
+	return *s.UpdateGoPackage
+}
+
+type RenameSettings struct {
+	MovePackageFiles *bool `mapstructure:"movePackageFiles"`
+}
+
+func (s *RenameSettings) GetMovePackageFiles() bool {
+	if s.MovePackageFiles == nil {
+		return false
+	}
+	return *s.MovePackageFiles
+}
@@ -0,0 +1,263 @@
+package lsp
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/kralicky/protocompile/ast"
+	"github.com/kralicky/protocompile/linker"
+	"github.com/kralicky/protols/pkg/format"
+	"github.com/kralicky/protols/pkg/format/protoprint"
+	"github.com/kralicky/tools-lite/gopls/pkg/protocol"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// sortElements offers to reorder the fields of the message or the values of
+// the enum whose name is at the cursor by number, or to reorder the top-level
+// declarations of the file when the cursor is on its syntax, package, import,
+// or option declarations. The order is the same one format.SortElements
+// defines for printed descriptors. Declarations are moved along with their
+// attached comments and options; blank lines and detached comments stay where
+// they are.
+func sortElements(ctx context.Context, request *protocol.CodeActionParams, linkRes linker.Result, mapper *protocol.Mapper, results chan<- protocol.CodeAction) {
+	if request.Range == (protocol.Range{}) || request.Range.Start != request.Range.End {
+		return
+	}
+	fileNode := linkRes.AST()
+	offset, err := mapper.PositionOffset(request.Range.Start)
+	if err != nil {
+		return
+	}
+	enqueue := func(title string, rewrite func(w *sourceRewriter) bool) {
+		if action, ok := enqueueCheckedRewrite(title, protocol.RefactorRewrite, linkRes, mapper, rewrite); ok {
+			results <- action
+		}
+	}
+
+	within := func(node ast.Node) bool {
+		info := fileNode.NodeInfo(node)
+		return offset >= info.Start().Offset && offset <= info.End().Offset+1
+	}
+	header := []ast.Node{}
+	if fileNode.Syntax != nil {
+		header = append(header, fileNode.Syntax)
+	} else if fileNode.Edition != nil {
+		header = append(header, fileNode.Edition)
+	}
+	for _, decl := range fileNode.Decls {
+		switch decl := decl.Unwrap().(type) {
+		case *ast.PackageNode, *ast.ImportNode, *ast.OptionNode:
+			header = append(header, decl)
+		}
+	}
+	if slices.ContainsFunc(header, within) {
+		enqueue("Sort top-level declarations", func(w *sourceRewriter) bool {
+			return w.sortFileDecls()
+		})
+		return
+	}
+
+	rangeTypeDescriptors(linkRes, func(d protoreflect.Descriptor) bool {
+		switch node := declNodeForDescriptor(linkRes, d).(type) {
+		case *ast.MessageNode:
+			if node.Name == nil || !within(node.Name) {
+				return true
+			}
+			enqueue("Sort fields by number", func(w *sourceRewriter) bool {
+				return w.sortMessageFields(node)
+			})
+			return false
+		case *ast.EnumNode:
+			if node.Name == nil || !within(node.Name) {
+				return true
+			}
+			enqueue("Sort enum values by number", func(w *sourceRewriter) bool {
+				return w.sortEnumValues(node)
+			})
+			return false
+		}
+		return true
+	})
+}
+
+// declElement adapts a declaration to the protoprint.Element interface, so
+// that declarations can be ordered with format.SortElements.
+type declElement struct {
+	node   ast.Node
+	kind   protoprint.ElementKind
+	name   string
+	number int32
+	custom bool
+}
+
+var _ protoprint.Element = declElement{}
+
+func (e declElement) Kind() protoprint.ElementKind { return e.kind }
+func (e declElement) Name() string                 { return e.name }
+func (e declElement) Number() int32                { return e.number }
+func (e declElement) NumberRange() (int32, int32)  { return 0, 0 }
+func (e declElement) Extendee() string             { return "" }
+func (e declElement) IsCustomOption() bool         { return e.custom }
+
+// sortedDecls returns the elements in the order defined by
+// format.SortElements, keeping the source order of elements it does not
+// order relative to each other.
+func sortedDecls(elems []declElement) []declElement {
+	sourceOrder := func() bool { return false }
+	sorted := slices.Clone(elems)
+	slices.SortStableFunc(sorted, func(a, b declElement) int {
+		switch {
+		case format.SortElements(a, b, sourceOrder):
+			return -1
+		case format.SortElements(b, a, sourceOrder):
+			return 1
+		}
+		return 0
+	})
+	return sorted
+}
+
+// permuteDecls moves each declaration in sorted into the position held by the
+// declaration at the same index in elems. Reports whether the order changed.
+func (w *sourceRewriter) permuteDecls(elems, sorted []declElement) bool {
+	if slices.EqualFunc(elems, sorted, func(a, b declElement) bool { return a.node == b.node }) {
+		return false
+	}
+	for i := range elems {
+		start, end := w.movableSpan(elems[i].node)
+		sortedStart, sortedEnd := w.movableSpan(sorted[i].node)
+		if start != sortedStart {
+			w.edit(start, end, w.text(sortedStart, sortedEnd))
+		}
+	}
+	return true
+}
+
+// movableSpan returns the span of a declaration along with its attached
+// leading comments and, if it is on a line of its own, its trailing comment.
+func (w *sourceRewriter) movableSpan(node ast.Node) (int, int) {
+	start, end := w.attachedDeclSpan(node)
+	return start, start + len(w.movableText(start, end))
+}
+
+// sortFileDecls reorders the top-level declarations of the file: the package
+// comes first, followed by the imports sorted by path and then the options,
+// with the rest of the declarations kept in their original order.
+func (w *sourceRewriter) sortFileDecls() bool {
+	var elems []declElement
+	for _, decl := range w.file.Decls {
+		elem := declElement{node: decl.Unwrap()}
+		switch node := elem.node.(type) {
+		case *ast.PackageNode:
+			elem.kind = protoprint.KindPackage
+		case *ast.ImportNode:
+			elem.kind = protoprint.KindImport
+			elem.name = node.Name.AsString()
+		case *ast.OptionNode:
+			elem.kind = protoprint.KindOption
+			start, end := w.span(node.Name)
+			elem.custom = strings.HasPrefix(w.text(start, end), "(")
+		case *ast.MessageNode:
+			elem.kind = protoprint.KindMessage
+		case *ast.EnumNode:
+			elem.kind = protoprint.KindEnum
+		case *ast.ServiceNode:
+			elem.kind = protoprint.KindService
+		case *ast.ExtendNode:
+			elem.kind = protoprint.KindExtension
+		default:
+			return false
+		}
+		elems = append(elems, elem)
+	}
+	return w.permuteDecls(elems, sortedDecls(elems))
+}
+
+// sortMessageFields reorders the fields of the message by number, among the
+// positions held by fields. Fields within each oneof are sorted separately.
+func (w *sourceRewriter) sortMessageFields(node *ast.MessageNode) bool {
+	var fields []declElement
+	var oneofs []*ast.OneofNode
+	for _, decl := range node.Decls {
+		if oneof := decl.GetOneof(); oneof != nil {
+			oneofs = append(oneofs, oneof)
+			continue
+		}
+		if n := decl.Unwrap(); isFieldDecl(n) {
+			elem, ok := fieldElement(n)
+			if !ok {
+				return false
+			}
+			fields = append(fields, elem)
+		}
+	}
+	changed := w.permuteDecls(fields, sortedDecls(fields))
+	for _, oneof := range oneofs {
+		fields = nil
+		for _, decl := range oneof.Decls {
+			if n := decl.Unwrap(); isFieldDecl(n) {
+				elem, ok := fieldElement(n)
+				if !ok {
+					return false
+				}
+				fields = append(fields, elem)
+			}
+		}
+		if w.permuteDecls(fields, sortedDecls(fields)) {
+			changed = true
+		}
+	}
+	return changed
+}
+
+func isFieldDecl(n ast.Node) bool {
+	switch n.(type) {
+	case *ast.FieldNode, *ast.MapFieldNode, *ast.GroupNode:
+		return true
+	}
+	return false
+}
+
+func fieldElement(n ast.Node) (declElement, bool) {
+	var tag *ast.UintLiteralNode
+	switch n := n.(type) {
+	case *ast.FieldNode:
+		tag = n.Tag
+	case *ast.MapFieldNode:
+		tag = n.Tag
+	case *ast.GroupNode:
+		tag = n.Tag
+	}
+	if tag == nil {
+		return declElement{}, false
+	}
+	return declElement{node: n, kind: protoprint.KindField, number: int32(tag.Val)}, true
+}
+
+// sortEnumValues reorders the values of the enum by number, among the
+// positions held by values. The first value stays first, since it is the
+// default: open enums require it to be zero, and closed enums would otherwise
+// change their default value.
+func (w *sourceRewriter) sortEnumValues(node *ast.EnumNode) bool {
+	var values []declElement
+	for _, decl := range node.Decls {
+		val := decl.GetEnumValue()
+		if val == nil {
+			continue
+		}
+		if val.Number == nil {
+			return false
+		}
+		number, ok := val.Number.AsInt64()
+		if !ok {
+			return false
+		}
+		values = append(values, declElement{node: val, kind: protoprint.KindEnumValue, number: int32(number)})
+	}
+	if len(values) < 2 {
+		return false
+	}
+	sorted := append([]declElement{values[0]}, sortedDecls(values[1:])...)
+	return w.permuteDecls(values, sorted)
+}
@@ -0,0 +1,126 @@
+package lsp
+
+import (
+	"testing"
+
+	"github.com/kralicky/protocompile/ast"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+func TestSortElements(t *testing.T) {
+	const src = `syntax = "proto3";
+
+import "google/protobuf/timestamp.proto";
+option (custom) = 1;
+option go_package = "example.com/test";
+import "google/protobuf/descriptor.proto";
+package test;
+
+extend google.protobuf.FileOptions {
+  int32 custom = 50000;
+}
+
+message Foo {
+  // the third field
+  int32 c = 3 [deprecated = true];
+  message Nested {}
+  string a = 1; // the first field
+
+  oneof value {
+    string y = 5;
+    string x = 4;
+  }
+  google.protobuf.Timestamp b = 2;
+}
+
+enum Kind {
+  KIND_UNSPECIFIED = 0;
+  KIND_B = 2;
+  KIND_NEGATIVE = -1;
+  KIND_A = 1;
+}
+`
+	const want = `syntax = "proto3";
+
+package test;
+import "google/protobuf/descriptor.proto";
+import "google/protobuf/timestamp.proto";
+option go_package = "example.com/test";
+option (custom) = 1;
+
+extend google.protobuf.FileOptions {
+  int32 custom = 50000;
+}
+
+message Foo {
+  string a = 1; // the first field
+  message Nested {}
+  google.protobuf.Timestamp b = 2;
+
+  oneof value {
+    string x = 4;
+    string y = 5;
+  }
+  // the third field
+  int32 c = 3 [deprecated = true];
+}
+
+enum Kind {
+  KIND_UNSPECIFIED = 0;
+  KIND_NEGATIVE = -1;
+  KIND_A = 1;
+  KIND_B = 2;
+}
+`
+	res := compileFile(t, src)
+	w := &sourceRewriter{res: res, file: res.AST(), content: []byte(src)}
+	require.True(t, w.sortFileDecls())
+	msg := res.Messages().ByName("Foo")
+	require.True(t, w.sortMessageFields(declNodeForDescriptor(res, msg).(*ast.MessageNode)))
+	enum := res.Enums().ByName("Kind")
+	require.True(t, w.sortEnumValues(declNodeForDescriptor(res, enum).(*ast.EnumNode)))
+	out, err := applySourceEdits([]byte(src), w.edits)
+	require.NoError(t, err)
+	require.Equal(t, want, string(out))
+
+	res = compileFile(t, string(out))
+	w = &sourceRewriter{res: res, file: res.AST(), content: out}
+	msg = res.Messages().ByName("Foo")
+	require.False(t, w.sortMessageFields(declNodeForDescriptor(res, msg).(*ast.MessageNode)))
+}
+
+func TestSortClosedEnumValues(t *testing.T) {
+	const src = `syntax = "proto2";
+
+package test;
+
+enum E {
+  B = 2;
+  C = 3;
+  A = 1;
+}
+`
+	const want = `syntax = "proto2";
+
+package test;
+
+enum E {
+  B = 2;
+  A = 1;
+  C = 3;
+}
+`
+	res := compileFile(t, src)
+	w := &sourceRewriter{res: res, file: res.AST(), content: []byte(src)}
+	enum := res.Enums().ByName("E")
+	require.True(t, w.sortEnumValues(declNodeForDescriptor(res, enum).(*ast.EnumNode)))
+	out, err := applySourceEdits([]byte(src), w.edits)
+	require.NoError(t, err)
+	require.Equal(t, want, string(out))
+
+	res = compileFile(t, string(out))
+	require.Equal(t, protoreflect.EnumNumber(2), res.Enums().ByName("E").Values().Get(0).Number())
+	w = &sourceRewriter{res: res, file: res.AST(), content: out}
+	require.False(t, w.sortEnumValues(declNodeForDescriptor(res, res.Enums().ByName("E")).(*ast.EnumNode)))
+}
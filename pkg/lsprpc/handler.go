@@ -33,6 +33,7 @@ type streamServer struct{}
 func (s *streamServer) ServeStream(ctx context.Context, conn jsonrpc2.Conn) error {
 	client := protocol.ClientDispatcher(conn)
 	server := lsp.NewServer(client,
+		lsp.WithClientConn(conn),
 		lsp.WithUnknownCommandHandler(
 			&unknownHandler{
 				Generators: []codegen.Generator{
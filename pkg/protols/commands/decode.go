@@ -1,11 +1,8 @@
 package commands
 
 import (
-	"bytes"
 	"context"
-	"encoding/base64"
 	"fmt"
-	"io"
 	"os"
 	"strings"
 
@@ -19,14 +16,20 @@ import (
 	"google.golang.org/protobuf/encoding/prototext"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
 	"google.golang.org/protobuf/testing/protopack"
 	"google.golang.org/protobuf/types/dynamicpb"
 )
 
+const maxInferenceCandidates = 10
+
 // DecodeCmd represents the decode command
 func BuildDecodeCmd() *cobra.Command {
 	var output string
 	var msgType string
+	var inputEncoding string
+	var framing string
+	var infer, useBest bool
 	cmd := &cobra.Command{
 		Use:   "decode [--type=pkg.Message]",
 		Short: "Decodes a protobuf message from stdin and prints it in text format",
@@ -34,29 +37,85 @@ func BuildDecodeCmd() *cobra.Command {
 If a message type is given with --type, protols will attempt to look up the message
 and use it to provide type information when decoding. If the message could not be
 found or if no message name is given, a textual representation of the wire format
-will be printed instead.
+will be printed instead. Extension fields and google.protobuf.Any values are
+resolved using the message types found in the workspace.
+
+Input may be raw binary, base64, plain hex, or an xxd hex dump. The encoding is
+detected automatically unless --input is given.
+
+By default, the input is treated as a single message. To decode a stream of
+messages, set --framing to one of:
+  grpc:      gRPC length-prefixed frames (gzip-compressed frames are supported)
+  delimited: varint length-delimited messages (as written by protodelim)
+Each message in the stream is printed in sequence.
+
+If the message type is not known, --infer will score every message type in the
+workspace against the input and print the most likely candidates. With
+--use-best, the input is then decoded using the top candidate.
 `[1:],
 		RunE: func(cmd *cobra.Command, args []string) error {
-			in := cmd.InOrStdin()
-			if len(msgType) == 0 {
-				text, err := decodeWithNoType(cmd.Context(), in)
-				if err != nil {
-					return err
+			if useBest && !infer {
+				return fmt.Errorf("--use-best can only be used with --infer")
+			}
+			input, err := readInput(cmd.InOrStdin(), inputEncoding)
+			if err != nil {
+				return err
+			}
+			frames, err := splitFrames(input, framing)
+			if err != nil {
+				return err
+			}
+			if len(msgType) == 0 && !infer {
+				for i, frame := range frames {
+					text, err := decodeWithNoType(frame)
+					if err != nil {
+						return fmt.Errorf("message %d: %w", i, err)
+					}
+					if len(frames) > 1 {
+						cmd.Printf("# message %d (%d bytes)\n", i, len(frame))
+					}
+					cmd.Println(text)
 				}
-				cmd.Println(text)
 				return nil
+			}
+			cache, err := loadWorkspaceCache()
+			if err != nil {
+				return err
+			}
+			var desc protoreflect.MessageDescriptor
+			if infer {
+				candidates := inferMessageTypes(frames, allMessagesRecursive(cache.XGetAllMessages()), cache)
+				if !useBest {
+					cmd.Println(formatCandidates(candidates, maxInferenceCandidates))
+					return nil
+				}
+				if len(candidates) == 0 {
+					return fmt.Errorf("could not infer a message type for the input")
+				}
+				desc = candidates[0].Desc
+				cmd.PrintErrf("# inferred type: %s (%.1f%% confidence)\n", desc.FullName(), candidates[0].Confidence*100)
 			} else {
-				msg, err := decodeWithType(cmd.Context(), in, msgType)
+				desc, err = findMessageType(cmd.Context(), cache, msgType)
 				if err != nil {
 					return err
 				}
+			}
+			for i, frame := range frames {
+				msg, err := decodeWithDescriptor(frame, desc, cache)
+				if err != nil {
+					return fmt.Errorf("message %d: %w", i, err)
+				}
 				switch output {
 				case "text":
+					if len(frames) > 1 {
+						cmd.Printf("# message %d (%d bytes)\n", i, len(frame))
+					}
 					cmd.Println(prototext.MarshalOptions{
 						Multiline:    true,
 						Indent:       "  ",
 						AllowPartial: true,
 						EmitUnknown:  true,
+						Resolver:     cache,
 					}.Format(msg))
 				case "json":
 					cmd.Println(protojson.MarshalOptions{
@@ -64,22 +123,24 @@ will be printed instead.
 						Indent:        "  ",
 						AllowPartial:  true,
 						UseProtoNames: true,
+						Resolver:      cache,
 					}.Format(msg))
 				}
-				return nil
 			}
+			return nil
 		},
 	}
 	cmd.Flags().StringVarP(&msgType, "type", "t", "", "The message type to use when decoding")
 	cmd.Flags().StringVarP(&output, "output", "o", "text", "Output format (text|json)")
+	cmd.Flags().StringVarP(&inputEncoding, "input", "i", inputAuto, "Input encoding (auto|raw|base64|hex)")
+	cmd.Flags().StringVarP(&framing, "framing", "f", framingNone, "Message framing (none|grpc|delimited)")
+	cmd.Flags().BoolVar(&infer, "infer", false, "Infer the message type by scoring all workspace messages against the input")
+	cmd.Flags().BoolVar(&useBest, "use-best", false, "With --infer, decode using the best matching message type")
+	cmd.MarkFlagsMutuallyExclusive("type", "infer")
 	return cmd
 }
 
-func decodeWithNoType(ctx context.Context, in io.Reader) (string, error) {
-	input, err := readMaybeBase64AndTrim(in)
-	if err != nil {
-		return "", err
-	}
+func decodeWithNoType(input []byte) (string, error) {
 	msg := protopack.Message{}
 	msg.UnmarshalAbductive(input, nil)
 	if len(msg) == 0 {
@@ -88,7 +149,9 @@ func decodeWithNoType(ctx context.Context, in io.Reader) (string, error) {
 	return strings.ReplaceAll(fmt.Sprintf("%+v\n", msg), "\t", "  "), nil
 }
 
-func decodeWithType(ctx context.Context, in io.Reader, msgType string) (proto.Message, error) {
+// loadWorkspaceCache creates a new cache for the current working directory
+// and loads all proto sources found within it.
+func loadWorkspaceCache() (*lsp.Cache, error) {
 	cwd, err := os.Getwd()
 	if err != nil {
 		return nil, err
@@ -97,6 +160,10 @@ func decodeWithType(ctx context.Context, in io.Reader, msgType string) (proto.Me
 		URI: string(protocol.URIFromPath(cwd)),
 	})
 	cache.LoadFiles(sources.SearchDirs(cwd))
+	return cache, nil
+}
+
+func findMessageType(ctx context.Context, cache *lsp.Cache, msgType string) (protoreflect.MessageDescriptor, error) {
 	allMsgs := cache.XGetAllMessages()
 	var exact protoreflect.MessageDescriptor
 	var exactNameOnly []protoreflect.MessageDescriptor
@@ -116,27 +183,27 @@ func decodeWithType(ctx context.Context, in io.Reader, msgType string) (proto.Me
 	}
 	if exact != nil {
 		// found an exact match, use it
-		return decodeWithDescriptor(ctx, in, exact)
+		return exact, nil
 	}
 	if len(exactNameOnly) == 1 {
 		// found a single name match, use it
-		return decodeWithDescriptor(ctx, in, exactNameOnly[0])
+		return exactNameOnly[0], nil
 	} else if len(exactNameOnly) > 1 {
 		// found multiple name matches, prompt the user to choose one
-		return chooseAndDecode(ctx, in, exactNameOnly)
+		return chooseMessageType(ctx, exactNameOnly)
 	}
 	if len(partialMatch) == 1 {
 		// found a single partial match, use it
-		return decodeWithDescriptor(ctx, in, partialMatch[0])
+		return partialMatch[0], nil
 	} else if len(partialMatch) > 1 {
 		// found multiple partial matches, prompt the user to choose one
-		return chooseAndDecode(ctx, in, partialMatch)
+		return chooseMessageType(ctx, partialMatch)
 	}
 
 	return nil, fmt.Errorf("could not find a matching type for %q", msgType)
 }
 
-func chooseAndDecode(ctx context.Context, in io.Reader, choices []protoreflect.MessageDescriptor) (proto.Message, error) {
+func chooseMessageType(ctx context.Context, choices []protoreflect.MessageDescriptor) (protoreflect.MessageDescriptor, error) {
 	var selected string
 	tty, err := tty.Open()
 	if err != nil {
@@ -158,59 +225,29 @@ func chooseAndDecode(ctx context.Context, in io.Reader, choices []protoreflect.M
 	}
 	for _, d := range choices {
 		if string(d.FullName()) == selected {
-			return decodeWithDescriptor(ctx, in, d)
+			return d, nil
 		}
 	}
 	return nil, fmt.Errorf("no type selected")
 }
 
-func decodeWithDescriptor(ctx context.Context, in io.Reader, desc protoreflect.MessageDescriptor) (proto.Message, error) {
-	input, err := readMaybeBase64AndTrim(in)
-	if err != nil {
-		return nil, err
-	}
+// typeResolver is used to resolve extensions and google.protobuf.Any message
+// types. *lsp.Cache implements this interface using the workspace descriptors.
+type typeResolver interface {
+	protoregistry.MessageTypeResolver
+	protoregistry.ExtensionTypeResolver
+}
+
+// decodeWithDescriptor decodes the input as a message of the given type.
+// Extension fields are resolved using the given resolver; google.protobuf.Any
+// values are left as-is, and are expanded by the resolver when the message is
+// formatted.
+func decodeWithDescriptor(input []byte, desc protoreflect.MessageDescriptor, resolver typeResolver) (proto.Message, error) {
 	// try to decode as wire format
 	newMsg := dynamicpb.NewMessage(desc)
-	if err := proto.Unmarshal(input, newMsg); err != nil {
+	if err := (proto.UnmarshalOptions{Resolver: resolver}).Unmarshal(input, newMsg); err != nil {
 		return nil, fmt.Errorf("could not decode input (wrong type?): %w", err)
 	}
 
 	return newMsg, nil
 }
-
-func looksLikeBase64(input []byte) bool {
-	return len(bytes.Trim(input, "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/-_=")) == 0
-}
-
-func readMaybeBase64AndTrim(in io.Reader) ([]byte, error) {
-	input, err := io.ReadAll(in)
-	if err != nil {
-		return nil, err
-	}
-	input = bytes.TrimSpace(input)
-	if len(input) == 0 {
-		return nil, fmt.Errorf("no input")
-	}
-
-	// figure out what kind of input we have
-	// 1. check if it's base64 encoded
-	if looksLikeBase64(input) {
-		encodings := []*base64.Encoding{
-			base64.StdEncoding,
-			base64.URLEncoding,
-			base64.RawStdEncoding,
-			base64.RawURLEncoding,
-		}
-		if bytes.HasSuffix(input, []byte{'='}) {
-			encodings = encodings[0:2]
-		}
-		for _, codec := range encodings {
-			decoded, err := codec.DecodeString(string(input))
-			if err == nil {
-				input = decoded
-				break
-			}
-		}
-	}
-	return input, nil
-}
@@ -0,0 +1,84 @@
+package commands
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/testing/protopack"
+)
+
+func TestDecodeWorkspaceTypes(t *testing.T) {
+	newTestWorkspace(t, map[string]string{
+		"a.proto": `syntax = "proto2";
+
+package test;
+
+import "google/protobuf/any.proto";
+
+message Envelope {
+  optional google.protobuf.Any payload = 1;
+  extensions 100 to 199;
+}
+
+message Payload {
+  optional string name = 1;
+}
+
+extend Envelope {
+  optional int32 priority = 100;
+}
+`,
+	})
+	input := protopack.Message{
+		protopack.Tag{Number: 1, Type: protowire.BytesType}, protopack.LengthPrefix{
+			protopack.Tag{Number: 1, Type: protowire.BytesType}, protopack.String("type.googleapis.com/test.Payload"),
+			protopack.Tag{Number: 2, Type: protowire.BytesType}, protopack.LengthPrefix{
+				protopack.Tag{Number: 1, Type: protowire.BytesType}, protopack.String("foo"),
+			},
+		},
+		protopack.Tag{Number: 100, Type: protowire.VarintType}, protopack.Varint(7),
+	}.Marshal()
+
+	for _, c := range []struct {
+		output string
+		want   string
+	}{
+		{
+			output: "text",
+			want: `payload: {
+  [type.googleapis.com/test.Payload]: {
+    name: "foo"
+  }
+}
+[test.priority]: 7
+
+`,
+		},
+		{
+			output: "json",
+			want: `{
+  "payload": {
+    "@type": "type.googleapis.com/test.Payload",
+    "name": "foo"
+  },
+  "[test.priority]": 7
+}
+`,
+		},
+	} {
+		t.Run(c.output, func(t *testing.T) {
+			var out bytes.Buffer
+			cmd := BuildDecodeCmd()
+			cmd.SetIn(bytes.NewReader(input))
+			cmd.SetOut(&out)
+			cmd.SetArgs([]string{"--type=test.Envelope", "--input=raw", "--output=" + c.output})
+			require.NoError(t, cmd.Execute())
+			// the protobuf encoders randomly add a space after separators to
+			// discourage depending on their exact output
+			require.Equal(t, c.want, strings.ReplaceAll(out.String(), ":  ", ": "))
+		})
+	}
+}
@@ -0,0 +1,242 @@
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Input encodings accepted by --input
+const (
+	inputAuto   = "auto"
+	inputRaw    = "raw"
+	inputBase64 = "base64"
+	inputHex    = "hex"
+)
+
+// Message framings accepted by --framing
+const (
+	framingNone      = "none"
+	framingGRPC      = "grpc"
+	framingDelimited = "delimited"
+)
+
+func readInput(in io.Reader, encoding string) ([]byte, error) {
+	input, err := io.ReadAll(in)
+	if err != nil {
+		return nil, err
+	}
+	if len(input) == 0 {
+		return nil, fmt.Errorf("no input")
+	}
+	// binary input must not be trimmed, since it may start or end with bytes
+	// that look like whitespace (e.g. 0x0a, the tag for field 1).
+	trimmed := bytes.TrimSpace(input)
+
+	switch encoding {
+	case inputRaw:
+		return input, nil
+	case inputBase64:
+		return decodeBase64(trimmed)
+	case inputHex:
+		return decodeHex(trimmed)
+	case inputAuto:
+	default:
+		return nil, fmt.Errorf("unknown input encoding %q (expected one of: auto, raw, base64, hex)", encoding)
+	}
+
+	// figure out what kind of input we have
+	// 1. check if it's a hex dump (xxd or plain hex)
+	if len(trimmed) > 0 && looksLikeXxd(trimmed) {
+		if decoded, err := decodeHex(trimmed); err == nil {
+			return decoded, nil
+		}
+	}
+	if len(trimmed) > 0 && looksLikeHex(trimmed) {
+		if decoded, err := decodeHex(trimmed); err == nil {
+			// base64 input can consist entirely of hex digits as well. If so,
+			// prefer whichever decoding is a valid wire-format message.
+			if _, ok := consumeWireFields(decoded); !ok && looksLikeBase64(trimmed) {
+				if alt, err := decodeBase64(trimmed); err == nil {
+					if _, ok := consumeWireFields(alt); ok {
+						return alt, nil
+					}
+				}
+			}
+			return decoded, nil
+		}
+	}
+	// 2. check if it's base64 encoded
+	if len(trimmed) > 0 && looksLikeBase64(trimmed) {
+		if decoded, err := decodeBase64(trimmed); err == nil {
+			return decoded, nil
+		}
+	}
+	return input, nil
+}
+
+func looksLikeBase64(input []byte) bool {
+	return len(bytes.Trim(input, "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/-_=")) == 0
+}
+
+func decodeBase64(input []byte) ([]byte, error) {
+	encodings := []*base64.Encoding{
+		base64.StdEncoding,
+		base64.URLEncoding,
+		base64.RawStdEncoding,
+		base64.RawURLEncoding,
+	}
+	if bytes.HasSuffix(input, []byte{'='}) {
+		encodings = encodings[0:2]
+	}
+	var lastErr error
+	for _, codec := range encodings {
+		decoded, err := codec.DecodeString(string(input))
+		if err == nil {
+			return decoded, nil
+		}
+		lastErr = err
+	}
+	return nil, fmt.Errorf("invalid base64 input: %w", lastErr)
+}
+
+// looksLikeHex reports whether the input consists only of hex digits and
+// whitespace, with an even number of digits (e.g. the output of 'xxd -p').
+// Inputs containing only decimal digits are ambiguous, and are left to be
+// treated as base64 instead.
+func looksLikeHex(input []byte) bool {
+	var digits, letters int
+	for _, c := range input {
+		switch {
+		case c >= '0' && c <= '9':
+			digits++
+		case c >= 'a' && c <= 'f', c >= 'A' && c <= 'F':
+			letters++
+		case c == ' ', c == '\t', c == '\n', c == '\r':
+		default:
+			return false
+		}
+	}
+	return (digits+letters)%2 == 0 && letters > 0
+}
+
+var xxdLine = regexp.MustCompile(`^[0-9a-fA-F]+: `)
+
+// looksLikeXxd reports whether every line of the input starts with an
+// 'xxd'-style offset column.
+func looksLikeXxd(input []byte) bool {
+	for _, line := range bytes.Split(input, []byte{'\n'}) {
+		if !xxdLine.Match(bytes.TrimSpace(line)) {
+			return false
+		}
+	}
+	return true
+}
+
+func decodeHex(input []byte) ([]byte, error) {
+	var digits strings.Builder
+	scanner := bufio.NewScanner(bytes.NewReader(input))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if loc := xxdLine.FindStringIndex(line); loc != nil {
+			// xxd format: "00000000: 0a03 666f  ..fo"; the hex column ends at the
+			// first double space, followed by the ascii column.
+			line = line[loc[1]:]
+			if i := strings.Index(line, "  "); i >= 0 {
+				line = line[:i]
+			}
+		}
+		for _, c := range line {
+			if c != ' ' && c != '\t' {
+				digits.WriteRune(c)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	decoded, err := hex.DecodeString(digits.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid hex input: %w", err)
+	}
+	return decoded, nil
+}
+
+// splitFrames splits the input into individual messages according to the
+// given framing.
+func splitFrames(input []byte, framing string) ([][]byte, error) {
+	switch framing {
+	case framingNone:
+		return [][]byte{input}, nil
+	case framingGRPC:
+		return splitGRPCFrames(input)
+	case framingDelimited:
+		return splitDelimitedFrames(input)
+	default:
+		return nil, fmt.Errorf("unknown framing %q (expected one of: none, grpc, delimited)", framing)
+	}
+}
+
+// splitGRPCFrames splits a gRPC length-prefixed message stream. Each frame
+// consists of a 1-byte compressed flag, a 4-byte big-endian length, and the
+// message bytes. Compressed frames are assumed to use gzip.
+func splitGRPCFrames(input []byte) ([][]byte, error) {
+	var frames [][]byte
+	for offset := 0; offset < len(input); {
+		if len(input)-offset < 5 {
+			return nil, fmt.Errorf("truncated gRPC frame header at offset %d", offset)
+		}
+		flag := input[offset]
+		length := int(binary.BigEndian.Uint32(input[offset+1 : offset+5]))
+		offset += 5
+		if len(input)-offset < length {
+			return nil, fmt.Errorf("truncated gRPC frame at offset %d: expected %d bytes, have %d", offset-5, length, len(input)-offset)
+		}
+		data := input[offset : offset+length]
+		offset += length
+		switch flag {
+		case 0:
+		case 1:
+			r, err := gzip.NewReader(bytes.NewReader(data))
+			if err != nil {
+				return nil, fmt.Errorf("could not decompress gRPC frame %d (only gzip is supported): %w", len(frames), err)
+			}
+			data, err = io.ReadAll(r)
+			if err != nil {
+				return nil, fmt.Errorf("could not decompress gRPC frame %d: %w", len(frames), err)
+			}
+		default:
+			return nil, fmt.Errorf("invalid gRPC frame %d: unknown compressed flag %#x", len(frames), flag)
+		}
+		frames = append(frames, data)
+	}
+	return frames, nil
+}
+
+// splitDelimitedFrames splits a stream of varint length-delimited messages,
+// as written by protodelim.MarshalTo.
+func splitDelimitedFrames(input []byte) ([][]byte, error) {
+	var frames [][]byte
+	for offset := 0; offset < len(input); {
+		length, n := protowire.ConsumeVarint(input[offset:])
+		if n < 0 {
+			return nil, fmt.Errorf("invalid length prefix at offset %d: %w", offset, protowire.ParseError(n))
+		}
+		offset += n
+		if uint64(len(input)-offset) < length {
+			return nil, fmt.Errorf("truncated message at offset %d: expected %d bytes, have %d", offset-n, length, len(input)-offset)
+		}
+		frames = append(frames, input[offset:offset+int(length)])
+		offset += int(length)
+	}
+	return frames, nil
+}
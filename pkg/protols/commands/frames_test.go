@@ -0,0 +1,125 @@
+package commands
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func grpcFrame(flag byte, data []byte) []byte {
+	frame := []byte{flag, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(data)))
+	return append(frame, data...)
+}
+
+func gzipped(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestReadInput(t *testing.T) {
+	// field 1 (string) = "foo"
+	msg := []byte{0x0a, 0x03, 'f', 'o', 'o'}
+	cases := []struct {
+		name     string
+		input    string
+		encoding string
+		want     []byte
+		wantErr  string
+	}{
+		{name: "plain hex", input: "0a03666f6f\n", encoding: inputAuto, want: msg},
+		{name: "spaced hex", input: "0a 03 66 6f 6f", encoding: inputAuto, want: msg},
+		{name: "xxd", input: "00000000: 0a03 666f 6f                             ..foo\n", encoding: inputAuto, want: msg},
+		{name: "multi-line xxd", input: "00000000: 0a03 666f  ..fo\n00000004: 6f  o\n", encoding: inputAuto, want: msg},
+		{name: "base64", input: "CgNmb28=\n", encoding: inputAuto, want: msg},
+		{name: "unpadded base64", input: "CgNmb28", encoding: inputAuto, want: msg},
+		// "0A01" is valid hex and valid base64, but only decodes to a valid
+		// message as base64
+		{name: "base64 with only hex digits", input: "0A01", encoding: inputAuto, want: []byte{0xd0, 0x0d, 0x35}},
+		{name: "hex preferred when both are valid", input: "0a00", encoding: inputAuto, want: []byte{0x0a, 0x00}},
+		{name: "decimal digits are not hex", input: "1234", encoding: inputAuto, want: []byte{0xd7, 0x6d, 0xf8}},
+		{name: "raw", input: "\n\x03foo", encoding: inputAuto, want: []byte("\n\x03foo")},
+		{name: "raw is not trimmed", input: "\n\x03foo\n", encoding: inputRaw, want: []byte("\n\x03foo\n")},
+		{name: "forced hex", input: "0A01", encoding: inputHex, want: []byte{0x0a, 0x01}},
+		{name: "forced base64", input: "0801", encoding: inputBase64, want: []byte{0xd3, 0xcd, 0x35}},
+		{name: "invalid forced hex", input: "0g", encoding: inputHex, wantErr: "invalid hex input"},
+		{name: "empty", input: "", encoding: inputAuto, wantErr: "no input"},
+		{name: "unknown encoding", input: "00", encoding: "binary", wantErr: `unknown input encoding "binary"`},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			out, err := readInput(strings.NewReader(c.input), c.encoding)
+			if c.wantErr != "" {
+				require.ErrorContains(t, err, c.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.want, out)
+		})
+	}
+}
+
+func TestLooksLikeHex(t *testing.T) {
+	cases := []struct {
+		input string
+		want  bool
+	}{
+		{"0a03666f6f", true},
+		{"0A 03\n66\r\n6F", true},
+		{"0a0", false},     // odd number of digits
+		{"1234", false},    // decimal digits only
+		{"0a0g", false},    // not a hex digit
+		{"CgNmb28", false}, // base64
+		{"", false},
+	}
+	for _, c := range cases {
+		require.Equal(t, c.want, looksLikeHex([]byte(c.input)), c.input)
+	}
+}
+
+func TestSplitFrames(t *testing.T) {
+	a := []byte{0x08, 0x01}
+	b := []byte{0x0a, 0x03, 'f', 'o', 'o'}
+	cases := []struct {
+		name    string
+		input   []byte
+		framing string
+		want    [][]byte
+		wantErr string
+	}{
+		{name: "none", input: a, framing: framingNone, want: [][]byte{a}},
+		{name: "grpc", input: slices.Concat(grpcFrame(0, a), grpcFrame(0, b)), framing: framingGRPC, want: [][]byte{a, b}},
+		{name: "grpc empty frame", input: grpcFrame(0, nil), framing: framingGRPC, want: [][]byte{{}}},
+		{name: "grpc gzip", input: slices.Concat(grpcFrame(1, gzipped(t, a)), grpcFrame(0, b)), framing: framingGRPC, want: [][]byte{a, b}},
+		{name: "grpc compressed but not gzip", input: grpcFrame(1, a), framing: framingGRPC, wantErr: "could not decompress gRPC frame 0 (only gzip is supported)"},
+		{name: "grpc unknown flag", input: slices.Concat(grpcFrame(0, a), grpcFrame(2, b)), framing: framingGRPC, wantErr: "invalid gRPC frame 1: unknown compressed flag 0x2"},
+		{name: "grpc truncated header", input: append(grpcFrame(0, a), 0, 0, 0), framing: framingGRPC, wantErr: "truncated gRPC frame header at offset 7"},
+		{name: "grpc truncated frame", input: grpcFrame(0, b)[:8], framing: framingGRPC, wantErr: "truncated gRPC frame at offset 0: expected 5 bytes, have 3"},
+		{name: "delimited", input: slices.Concat([]byte{2}, a, []byte{5}, b), framing: framingDelimited, want: [][]byte{a, b}},
+		{name: "delimited empty message", input: []byte{0, 2, 0x08, 0x01}, framing: framingDelimited, want: [][]byte{{}, a}},
+		{name: "delimited truncated", input: append([]byte{2}, a...)[:2], framing: framingDelimited, wantErr: "truncated message at offset 0: expected 2 bytes, have 1"},
+		{name: "delimited invalid length", input: []byte{0x80}, framing: framingDelimited, wantErr: "invalid length prefix at offset 0"},
+		{name: "unknown framing", input: a, framing: "http", wantErr: `unknown framing "http"`},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			frames, err := splitFrames(c.input, c.framing)
+			if c.wantErr != "" {
+				require.ErrorContains(t, err, c.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.want, frames)
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package commands
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+type inferenceCandidate struct {
+	Desc       protoreflect.MessageDescriptor
+	Confidence float64
+}
+
+// inferMessageTypes scores each of the given message types against the wire
+// data in each frame, and returns the types that could have produced all of
+// the frames, ordered by decreasing confidence.
+//
+// A type is only considered a candidate if every frame unmarshals into it
+// without error. Each frame is then scored by:
+//   - the fraction of top-level field numbers that correspond to a declared
+//     field with a compatible wire type
+//   - the number of unknown fields (at any depth) in the decoded message
+//   - the fraction of declared fields that are present in the data, which
+//     acts as a tie-breaker in favor of smaller messages
+func inferMessageTypes(frames [][]byte, msgs []protoreflect.MessageDescriptor, resolver typeResolver) []inferenceCandidate {
+	wireFields := make([][]wireField, len(frames))
+	for i, frame := range frames {
+		fields, ok := consumeWireFields(frame)
+		if !ok {
+			return nil
+		}
+		wireFields[i] = fields
+	}
+
+	var candidates []inferenceCandidate
+	for _, desc := range msgs {
+		if desc.IsMapEntry() {
+			continue
+		}
+		var total float64
+		for i, frame := range frames {
+			score, ok := scoreFrame(frame, wireFields[i], desc, resolver)
+			if !ok {
+				total = 0
+				break
+			}
+			total += score
+		}
+		if total <= 0 {
+			continue
+		}
+		candidates = append(candidates, inferenceCandidate{
+			Desc:       desc,
+			Confidence: total / float64(len(frames)),
+		})
+	}
+	slices.SortStableFunc(candidates, func(a, b inferenceCandidate) int {
+		if c := cmp.Compare(b.Confidence, a.Confidence); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Desc.FullName(), b.Desc.FullName())
+	})
+	return candidates
+}
+
+type wireField struct {
+	Number protowire.Number
+	Type   protowire.Type
+}
+
+// consumeWireFields returns the top-level fields in the given wire data, or
+// false if the data is not a valid wire-format message.
+func consumeWireFields(b []byte) ([]wireField, bool) {
+	var fields []wireField
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, false
+		}
+		b = b[n:]
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return nil, false
+		}
+		b = b[n:]
+		fields = append(fields, wireField{Number: num, Type: typ})
+	}
+	return fields, true
+}
+
+func scoreFrame(frame []byte, fields []wireField, desc protoreflect.MessageDescriptor, resolver typeResolver) (float64, bool) {
+	msg := dynamicpb.NewMessage(desc)
+	if err := (proto.UnmarshalOptions{Resolver: resolver}).Unmarshal(frame, msg); err != nil {
+		return 0, false
+	}
+	if len(fields) == 0 {
+		// any message type can decode an empty message, but prefer empty ones
+		if desc.Fields().Len() == 0 {
+			return 1, true
+		}
+		return 0.5, true
+	}
+
+	seen := map[protowire.Number]struct{}{}
+	var matched int
+	for _, f := range fields {
+		fd := desc.Fields().ByNumber(f.Number)
+		if fd == nil {
+			if xt, err := resolver.FindExtensionByNumber(desc.FullName(), f.Number); err == nil {
+				fd = xt.TypeDescriptor()
+			}
+		}
+		if fd != nil && wireTypeCompatible(fd, f.Type) {
+			matched++
+		}
+		seen[f.Number] = struct{}{}
+	}
+	score := float64(matched) / float64(len(fields))
+	score /= float64(1 + countUnknownFields(msg.ProtoReflect()))
+	if declared := desc.Fields().Len(); declared > 0 {
+		score *= 0.8 + 0.2*min(1, float64(len(seen))/float64(declared))
+	}
+	return score, true
+}
+
+func wireTypeCompatible(fd protoreflect.FieldDescriptor, typ protowire.Type) bool {
+	var expected protowire.Type
+	switch fd.Kind() {
+	case protoreflect.BoolKind, protoreflect.EnumKind,
+		protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Uint32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Uint64Kind:
+		expected = protowire.VarintType
+	case protoreflect.Fixed32Kind, protoreflect.Sfixed32Kind, protoreflect.FloatKind:
+		expected = protowire.Fixed32Type
+	case protoreflect.Fixed64Kind, protoreflect.Sfixed64Kind, protoreflect.DoubleKind:
+		expected = protowire.Fixed64Type
+	case protoreflect.StringKind, protoreflect.BytesKind, protoreflect.MessageKind:
+		expected = protowire.BytesType
+	case protoreflect.GroupKind:
+		expected = protowire.StartGroupType
+	}
+	if typ == expected {
+		return true
+	}
+	// repeated scalars may be packed regardless of the declared encoding
+	return fd.IsList() && expected != protowire.BytesType && expected != protowire.StartGroupType &&
+		typ == protowire.BytesType
+}
+
+func countUnknownFields(msg protoreflect.Message) int {
+	count := 0
+	for b := msg.GetUnknown(); len(b) > 0; {
+		_, _, n := protowire.ConsumeField(b)
+		if n < 0 {
+			break
+		}
+		b = b[n:]
+		count++
+	}
+	msg.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case fd.IsMap():
+			if fd.MapValue().Message() != nil {
+				v.Map().Range(func(_ protoreflect.MapKey, mv protoreflect.Value) bool {
+					count += countUnknownFields(mv.Message())
+					return true
+				})
+			}
+		case fd.Message() != nil && fd.IsList():
+			for i, l := 0, v.List().Len(); i < l; i++ {
+				count += countUnknownFields(v.List().Get(i).Message())
+			}
+		case fd.Message() != nil:
+			count += countUnknownFields(v.Message())
+		}
+		return true
+	})
+	return count
+}
+
+// allMessagesRecursive returns the given messages along with all of their
+// nested messages.
+func allMessagesRecursive(msgs []protoreflect.MessageDescriptor) []protoreflect.MessageDescriptor {
+	var all []protoreflect.MessageDescriptor
+	var walk func(protoreflect.MessageDescriptor)
+	walk = func(md protoreflect.MessageDescriptor) {
+		all = append(all, md)
+		nested := md.Messages()
+		for i, l := 0, nested.Len(); i < l; i++ {
+			walk(nested.Get(i))
+		}
+	}
+	for _, md := range msgs {
+		walk(md)
+	}
+	return all
+}
+
+func formatCandidates(candidates []inferenceCandidate, limit int) string {
+	if len(candidates) == 0 {
+		return "no matching message types found"
+	}
+	var out strings.Builder
+	for i, c := range candidates {
+		if i == limit {
+			fmt.Fprintf(&out, "... and %d more\n", len(candidates)-limit)
+			break
+		}
+		fmt.Fprintf(&out, "%5.1f%%  %s\n", c.Confidence*100, c.Desc.FullName())
+	}
+	return strings.TrimSuffix(out.String(), "\n")
+}
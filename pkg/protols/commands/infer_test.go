@@ -0,0 +1,127 @@
+package commands
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func inferTestMessages(t *testing.T) protoreflect.MessageDescriptors {
+	t.Helper()
+	field := func(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type, label descriptorpb.FieldDescriptorProto_Label) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{
+			Name:   proto.String(name),
+			Number: proto.Int32(number),
+			Type:   typ.Enum(),
+			Label:  label.Enum(),
+		}
+	}
+	optional := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+	repeated := descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+	fd, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:    proto.String("infer.proto"),
+		Package: proto.String("test"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{Name: proto.String("Empty")},
+			{
+				Name: proto.String("Named"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("name", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, optional),
+				},
+			},
+			{
+				Name: proto.String("NamedWithCount"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("name", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, optional),
+					field("count", 2, descriptorpb.FieldDescriptorProto_TYPE_INT32, optional),
+				},
+			},
+			{
+				Name: proto.String("Counts"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("counts", 1, descriptorpb.FieldDescriptorProto_TYPE_INT32, repeated),
+				},
+			},
+		},
+	}, nil)
+	require.NoError(t, err)
+	return fd.Messages()
+}
+
+func TestScoreFrame(t *testing.T) {
+	msgs := inferTestMessages(t)
+	name := protowire.AppendString(protowire.AppendTag(nil, 1, protowire.BytesType), "foo")
+	count := protowire.AppendVarint(protowire.AppendTag(nil, 2, protowire.VarintType), 3)
+	unknown := protowire.AppendFixed32(protowire.AppendTag(nil, 9, protowire.Fixed32Type), 1)
+	counts := protowire.AppendVarint(protowire.AppendTag(nil, 1, protowire.VarintType), 1)
+
+	score := func(frame []byte, msg string) (float64, bool) {
+		fields, ok := consumeWireFields(frame)
+		require.True(t, ok)
+		return scoreFrame(frame, fields, msgs.ByName(protoreflect.Name(msg)), protoregistry.GlobalTypes)
+	}
+	cases := []struct {
+		name  string
+		frame []byte
+		msg   string
+		want  float64
+		ok    bool
+	}{
+		{name: "all fields matched", frame: slices.Concat(name, count), msg: "NamedWithCount", want: 1, ok: true},
+		{name: "declared fields missing", frame: name, msg: "NamedWithCount", want: 0.9, ok: true},
+		{name: "unknown field", frame: slices.Concat(name, unknown), msg: "Named", want: 0.25, ok: true},
+		{name: "empty frame, empty message", frame: nil, msg: "Empty", want: 1, ok: true},
+		{name: "empty frame, non-empty message", frame: nil, msg: "Named", want: 0.5, ok: true},
+		{name: "unpacked repeated scalar", frame: counts, msg: "Counts", want: 1, ok: true},
+		{name: "packed repeated scalar", frame: protowire.AppendBytes(protowire.AppendTag(nil, 1, protowire.BytesType), []byte{1, 2}), msg: "Counts", want: 1, ok: true},
+		{name: "invalid utf-8", frame: protowire.AppendString(protowire.AppendTag(nil, 1, protowire.BytesType), "\xff"), msg: "Named", ok: false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, ok := score(c.frame, c.msg)
+			require.Equal(t, c.ok, ok)
+			require.InDelta(t, c.want, got, 1e-9)
+		})
+	}
+}
+
+func TestInferMessageTypes(t *testing.T) {
+	msgs := inferTestMessages(t)
+	var all []protoreflect.MessageDescriptor
+	for i := 0; i < msgs.Len(); i++ {
+		all = append(all, msgs.Get(i))
+	}
+	name := protowire.AppendString(protowire.AppendTag(nil, 1, protowire.BytesType), "foo")
+	count := protowire.AppendVarint(protowire.AppendTag(nil, 2, protowire.VarintType), 3)
+
+	candidates := inferMessageTypes([][]byte{slices.Concat(name, count), name}, all, protoregistry.GlobalTypes)
+	var names []protoreflect.FullName
+	for _, c := range candidates {
+		names = append(names, c.Desc.FullName())
+	}
+	// Empty matches none of the fields, and is not a candidate. "foo" is also
+	// a valid packed list of varints, so Counts ties with Named.
+	require.Equal(t, []protoreflect.FullName{"test.NamedWithCount", "test.Counts", "test.Named"}, names)
+	require.InDelta(t, 0.95, candidates[0].Confidence, 1e-9)
+
+	require.Empty(t, inferMessageTypes([][]byte{{0x0a}}, all, protoregistry.GlobalTypes))
+}
+
+func TestDecodeUseBestRequiresInfer(t *testing.T) {
+	cmd := BuildDecodeCmd()
+	cmd.SetArgs([]string{"--use-best"})
+	cmd.SetIn(strings.NewReader("0a00"))
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetErr(&bytes.Buffer{})
+	require.EqualError(t, cmd.Execute(), "--use-best can only be used with --infer")
+}
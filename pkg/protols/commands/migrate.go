@@ -0,0 +1,103 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/kralicky/protocompile/editions"
+	"github.com/kralicky/protols/pkg/lsp"
+	"github.com/kralicky/protols/pkg/util"
+	"github.com/kralicky/tools-lite/gopls/pkg/protocol"
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// MigrateCmd represents the migrate command
+func BuildMigrateCmd() *cobra.Command {
+	var toEdition string
+	var dryRun bool
+	cmd := &cobra.Command{
+		Use:   "migrate --to-edition 2023 [filenames...]",
+		Short: "Migrates proto2 and proto3 files to editions",
+		Long: `
+Rewrites proto2 and proto3 source files to use the given edition. Labels, packed
+options, groups, and the behaviors implied by each file's syntax are replaced
+with equivalent features, set at the file level where possible. Each migrated
+file is checked for semantic equivalence with the original before it is written.
+
+If no filenames are given, all proto2 and proto3 files in the workspace are
+migrated.
+`[1:],
+		RunE: func(cmd *cobra.Command, args []string) error {
+			edition, ok := descriptorpb.Edition_value["EDITION_"+toEdition]
+			if !ok {
+				return fmt.Errorf("unknown edition %q", toEdition)
+			}
+			cache, err := loadWorkspaceCache()
+			if err != nil {
+				return err
+			}
+			var uris []protocol.DocumentURI
+			for _, filename := range args {
+				abs, err := filepath.Abs(filename)
+				if err != nil {
+					return err
+				}
+				uris = append(uris, protocol.URIFromPath(abs))
+			}
+			if len(args) == 0 {
+				uris = cache.XListWorkspaceLocalURIs()
+				slices.Sort(uris)
+			}
+			var errs []error
+			for _, uri := range uris {
+				res, err := cache.FindResultByURI(uri)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("%s: %w", uri.Path(), err))
+					continue
+				}
+				if res.Syntax() == protoreflect.Editions {
+					if len(args) > 0 {
+						errs = append(errs, fmt.Errorf("%s: already uses editions", uri.Path()))
+					}
+					continue
+				}
+				filename := uri.Path()
+				info, err := os.Stat(filename)
+				if err != nil {
+					errs = append(errs, err)
+					continue
+				}
+				orig, err := os.ReadFile(filename)
+				if err != nil {
+					errs = append(errs, err)
+					continue
+				}
+				migrated, err := lsp.MigrateToEdition(res, orig, descriptorpb.Edition(edition))
+				if err != nil {
+					errs = append(errs, fmt.Errorf("%s: %w", filename, err))
+					continue
+				}
+				if dryRun {
+					cmd.Printf("// %s\n%s\n", filename, migrated)
+					continue
+				}
+				if err := util.OverwriteFile(filename, orig, migrated, info.Mode().Perm(), info.Size()); err != nil {
+					errs = append(errs, err)
+					continue
+				}
+				cmd.Println(filename)
+			}
+			return errors.Join(errs...)
+		},
+	}
+	cmd.Flags().StringVar(&toEdition, "to-edition", "", fmt.Sprintf("The edition to migrate to (e.g. %s)", strings.TrimPrefix(editions.MaxSupportedEdition.String(), "EDITION_")))
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "print the migrated files instead of writing them")
+	cmd.MarkFlagRequired("to-edition")
+	return cmd
+}
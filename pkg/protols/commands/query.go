@@ -0,0 +1,254 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"github.com/kralicky/protols/pkg/lsp"
+	"github.com/kralicky/tools-lite/gopls/pkg/protocol"
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+var filePositionPattern = regexp.MustCompile(`^(.+\.proto):(\d+):(\d+)$`)
+
+// QueryCmd represents the query command
+func BuildQueryCmd() *cobra.Command {
+	var jsonOutput bool
+	cmd := &cobra.Command{
+		Use:   "query",
+		Short: "Queries definitions, references, and symbols in the workspace",
+		Long: `
+Answers the same questions an editor would ask the language server, without
+an editor. Symbols are identified either by their fully qualified name, or by
+a position within a file in the form file.proto:line:col (with 1-based line
+and column numbers).
+`[1:],
+	}
+	// results are meant to be consumed by other tools
+	cmd.SetOut(os.Stdout)
+	cmd.PersistentFlags().BoolVar(&jsonOutput, "json", false, "print results as json, using the language server protocol types")
+
+	var includeDeclaration bool
+	refsCmd := &cobra.Command{
+		Use:   "refs <pkg.Symbol | file.proto:line:col>",
+		Short: "Lists the references to a symbol or package",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cache, err := loadWorkspaceCache()
+			if err != nil {
+				return err
+			}
+			pos, err := findPosition(cache, args[0])
+			if err != nil {
+				return err
+			}
+			locations, err := cache.FindReferences(cmd.Context(), pos, protocol.ReferenceContext{
+				IncludeDeclaration: includeDeclaration,
+			})
+			if err != nil {
+				return err
+			}
+			return printLocations(cmd, cache, locations, jsonOutput)
+		},
+	}
+	refsCmd.Flags().BoolVar(&includeDeclaration, "include-declaration", false, "include the declaration in the results")
+
+	defCmd := &cobra.Command{
+		Use:   "def <pkg.Symbol | file.proto:line:col>",
+		Short: "Prints the location of a symbol's definition",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cache, err := loadWorkspaceCache()
+			if err != nil {
+				return err
+			}
+			pos, err := findPosition(cache, args[0])
+			if err != nil {
+				return err
+			}
+			desc, _, err := cache.FindTypeDescriptorAtLocation(pos)
+			if err != nil {
+				return err
+			}
+			if desc == nil {
+				return printLocations(cmd, cache, cache.TryFindPackageReferences(pos), jsonOutput)
+			}
+			loc, err := cache.FindDefinitionForTypeDescriptor(desc)
+			if err != nil {
+				return err
+			}
+			return printLocations(cmd, cache, []protocol.Location{loc}, jsonOutput)
+		},
+	}
+
+	symbolsCmd := &cobra.Command{
+		Use:   "symbols <query>",
+		Short: "Searches for symbols in the workspace",
+		Long: `
+Searches for messages, enums, services, methods, fields, and enum values whose
+fully qualified names fuzzy-match the query.
+`[1:],
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cache, err := loadWorkspaceCache()
+			if err != nil {
+				return err
+			}
+			symbols := cache.QueryWorkspaceSymbols(cmd.Context(), args[0])
+			if jsonOutput {
+				return printJSON(cmd, symbols)
+			}
+			for _, sym := range symbols {
+				cmd.Printf("%s\t%s\t%s\n", formatLocation(cache, sym.Location), symbolKindName(sym.Kind), sym.Name)
+			}
+			return nil
+		},
+	}
+
+	hoverCmd := &cobra.Command{
+		Use:   "hover <pkg.Symbol | file.proto:line:col>",
+		Short: "Prints the hover documentation for a symbol",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cache, err := loadWorkspaceCache()
+			if err != nil {
+				return err
+			}
+			pos, err := findPosition(cache, args[0])
+			if err != nil {
+				return err
+			}
+			hover, err := cache.ComputeHover(pos)
+			if err != nil {
+				return err
+			}
+			if jsonOutput {
+				return printJSON(cmd, hover)
+			}
+			if hover != nil {
+				cmd.Println(hover.Contents.Value)
+			}
+			return nil
+		},
+	}
+
+	cmd.AddCommand(refsCmd, defCmd, symbolsCmd, hoverCmd)
+	return cmd
+}
+
+// findPosition returns the position in the workspace identified by
+// the given argument, which is either a file position in the form
+// file.proto:line:col, or the fully qualified name of a symbol or package.
+func findPosition(cache *lsp.Cache, arg string) (protocol.TextDocumentPositionParams, error) {
+	if m := filePositionPattern.FindStringSubmatch(arg); m != nil {
+		abs, err := filepath.Abs(m[1])
+		if err != nil {
+			return protocol.TextDocumentPositionParams{}, err
+		}
+		uri := protocol.URIFromPath(abs)
+		mapper, err := cache.XGetMapper(uri)
+		if err != nil {
+			return protocol.TextDocumentPositionParams{}, err
+		}
+		line, _ := strconv.Atoi(m[2])
+		col, _ := strconv.Atoi(m[3])
+		pos, err := mapper.LineCol8Position(line, col)
+		if err != nil {
+			return protocol.TextDocumentPositionParams{}, err
+		}
+		return protocol.TextDocumentPositionParams{
+			TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+			Position:     pos,
+		}, nil
+	}
+
+	name := protoreflect.FullName(arg)
+	if !name.IsValid() {
+		return protocol.TextDocumentPositionParams{}, fmt.Errorf("invalid name %q", arg)
+	}
+	if desc, err := cache.FindDescriptorByName(name); err == nil {
+		loc, err := cache.FindDefinitionForTypeDescriptor(desc)
+		if err != nil {
+			return protocol.TextDocumentPositionParams{}, err
+		}
+		return protocol.TextDocumentPositionParams{
+			TextDocument: protocol.TextDocumentIdentifier{URI: loc.URI},
+			Position:     loc.Range.Start,
+		}, nil
+	}
+	// the name of a package, or a prefix of one; the position must be within
+	// its last component
+	for _, prefix := range []bool{false, true} {
+		if locs := cache.FindPackageNameRefs(name, prefix); len(locs) > 0 {
+			pos := locs[0].Range.End
+			pos.Character--
+			return protocol.TextDocumentPositionParams{
+				TextDocument: protocol.TextDocumentIdentifier{URI: locs[0].URI},
+				Position:     pos,
+			}, nil
+		}
+	}
+	return protocol.TextDocumentPositionParams{}, fmt.Errorf("no symbol or package named %q found", arg)
+}
+
+func printLocations(cmd *cobra.Command, cache *lsp.Cache, locations []protocol.Location, jsonOutput bool) error {
+	if jsonOutput {
+		if locations == nil {
+			locations = []protocol.Location{}
+		}
+		return printJSON(cmd, locations)
+	}
+	for _, loc := range locations {
+		cmd.Println(formatLocation(cache, loc))
+	}
+	return nil
+}
+
+func printJSON(cmd *cobra.Command, v any) error {
+	enc := json.NewEncoder(cmd.OutOrStdout())
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// formatLocation formats the start of the location as file:line:col, with
+// the path relative to the working directory and 1-based line and column
+// numbers.
+func formatLocation(cache *lsp.Cache, loc protocol.Location) string {
+	filename := loc.URI.Path()
+	if cwd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(cwd, filename); err == nil {
+			filename = rel
+		}
+	}
+	line, col := int(loc.Range.Start.Line)+1, int(loc.Range.Start.Character)+1
+	if mapper, err := cache.XGetMapper(loc.URI); err == nil {
+		if offset, err := mapper.PositionOffset(loc.Range.Start); err == nil {
+			line, col = mapper.OffsetLineCol8(offset)
+		}
+	}
+	return fmt.Sprintf("%s:%d:%d", filename, line, col)
+}
+
+func symbolKindName(kind protocol.SymbolKind) string {
+	switch kind {
+	case protocol.Class:
+		return "message"
+	case protocol.Enum:
+		return "enum"
+	case protocol.Interface:
+		return "service"
+	case protocol.Function:
+		return "rpc"
+	case protocol.Field:
+		return "field"
+	case protocol.EnumMember:
+		return "enum value"
+	default:
+		return "symbol"
+	}
+}
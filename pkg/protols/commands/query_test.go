@@ -0,0 +1,81 @@
+package commands
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/kralicky/tools-lite/gopls/pkg/protocol"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindPosition(t *testing.T) {
+	dir := newTestWorkspace(t, map[string]string{
+		"foo/bar/a.proto": `syntax = "proto3";
+
+package foo.bar;
+
+message A {
+  string name = 1;
+}
+`,
+	})
+	cache, err := loadWorkspaceCache()
+	require.NoError(t, err)
+	uri := protocol.URIFromPath(filepath.Join(dir, "foo/bar/a.proto"))
+
+	cases := []struct {
+		arg     string
+		want    protocol.Position
+		rename  protocol.Range // the range PrepareRename finds at the position
+		wantErr string
+	}{
+		{
+			arg:  "foo.bar.A",
+			want: protocol.Position{Line: 4, Character: 8},
+		},
+		{
+			arg:  "foo.bar.A.name",
+			want: protocol.Position{Line: 5, Character: 9},
+		},
+		{
+			arg:  "foo/bar/a.proto:5:9",
+			want: protocol.Position{Line: 4, Character: 8},
+		},
+		{
+			arg:    "foo.bar",
+			want:   protocol.Position{Line: 2, Character: 14},
+			rename: protocol.Range{Start: protocol.Position{Line: 2, Character: 8}, End: protocol.Position{Line: 2, Character: 15}},
+		},
+		{
+			// a package prefix resolves to its last component
+			arg:    "foo",
+			want:   protocol.Position{Line: 2, Character: 10},
+			rename: protocol.Range{Start: protocol.Position{Line: 2, Character: 8}, End: protocol.Position{Line: 2, Character: 11}},
+		},
+		{
+			arg:     "foo.baz",
+			wantErr: `no symbol or package named "foo.baz" found`,
+		},
+		{
+			arg:     "b.proto:1:1",
+			wantErr: "no such file or directory",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.arg, func(t *testing.T) {
+			pos, err := findPosition(cache, c.arg)
+			if c.wantErr != "" {
+				require.ErrorContains(t, err, c.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, uri, pos.TextDocument.URI)
+			require.Equal(t, c.want, pos.Position)
+			if c.rename != (protocol.Range{}) {
+				rng, err := cache.PrepareRename(pos)
+				require.NoError(t, err)
+				require.Equal(t, c.rename, rng.Range)
+			}
+		})
+	}
+}
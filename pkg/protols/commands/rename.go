@@ -0,0 +1,147 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/kralicky/protols/pkg/lsp"
+	"github.com/kralicky/protols/pkg/util"
+	"github.com/kralicky/tools-lite/gopls/pkg/protocol"
+	"github.com/kralicky/tools-lite/pkg/diff"
+	"github.com/spf13/cobra"
+)
+
+// RenameCmd represents the rename command
+func BuildRenameCmd() *cobra.Command {
+	var dryRun, showDiff, movePackageFiles bool
+	cmd := &cobra.Command{
+		Use:   "rename <pkg.Symbol | file.proto:line:col> <new name>",
+		Short: "Renames a symbol or package across the workspace",
+		Long: `
+Renames a message, enum, service, field, or other symbol, along with all of its
+references in the workspace. The symbol is identified either by its fully
+qualified name, or by a position within a file (with 1-based line and column
+numbers). The new name replaces only the last component of the symbol's name.
+
+If the fully qualified name is that of a package, or a prefix of one, the
+package is renamed instead, and the new name must be a complete package name.
+`[1:],
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cache, err := loadWorkspaceCache()
+			if err != nil {
+				return err
+			}
+			if movePackageFiles {
+				cache.DidChangeConfiguration(cmd.Context(), lsp.Settings{
+					Rename: lsp.RenameSettings{MovePackageFiles: &movePackageFiles},
+				})
+			}
+			pos, err := findPosition(cache, args[0])
+			if err != nil {
+				return err
+			}
+			if _, err := cache.PrepareRename(pos); err != nil {
+				return err
+			}
+			edit, err := cache.Rename(cmd.Context(), &protocol.RenameParams{
+				TextDocument: pos.TextDocument,
+				Position:     pos.Position,
+				NewName:      args[1],
+			})
+			if err != nil {
+				return err
+			}
+			return applyWorkspaceEdit(cmd, edit, dryRun, showDiff)
+		},
+	}
+	cmd.SetOut(os.Stdout)
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "do not write any changes to disk")
+	cmd.Flags().BoolVarP(&showDiff, "diff", "d", false, "print a diff of the changes")
+	cmd.Flags().BoolVar(&movePackageFiles, "move-package-files", false, "when renaming a package, move files whose directory matches the package into the directory matching the new name")
+	return cmd
+}
+
+// applyWorkspaceEdit writes the changes in the edit to disk, printing the
+// name of each modified or renamed file, or a diff of the changes if showDiff
+// is set. If dryRun is set, the same output is printed, but nothing is
+// written.
+func applyWorkspaceEdit(cmd *cobra.Command, edit *protocol.WorkspaceEdit, dryRun, showDiff bool) error {
+	var changes []protocol.DocumentChanges
+	for _, uri := range slices.Sorted(maps.Keys(edit.Changes)) {
+		changes = append(changes, protocol.TextEditsToDocumentChanges(uri, 0, edit.Changes[uri])...)
+	}
+	changes = append(changes, edit.DocumentChanges...)
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	relPath := func(uri protocol.DocumentURI) string {
+		if rel, err := filepath.Rel(cwd, uri.Path()); err == nil {
+			return rel
+		}
+		return uri.Path()
+	}
+
+	var errs []error
+	for _, change := range changes {
+		switch {
+		case change.TextDocumentEdit != nil:
+			uri := change.TextDocumentEdit.TextDocument.URI
+			filename := uri.Path()
+			info, err := os.Stat(filename)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			orig, err := os.ReadFile(filename)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			updated, _, err := protocol.ApplyEdits(protocol.NewMapper(uri, orig), protocol.AsTextEdits(change.TextDocumentEdit.Edits))
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", filename, err))
+				continue
+			}
+			if !dryRun {
+				if err := util.OverwriteFile(filename, orig, updated, info.Mode().Perm(), info.Size()); err != nil {
+					errs = append(errs, err)
+					continue
+				}
+			}
+			if showDiff {
+				cmd.Print(diff.Unified("a/"+relPath(uri), "b/"+relPath(uri), string(orig), string(updated)))
+			} else {
+				cmd.Println(relPath(uri))
+			}
+		case change.RenameFile != nil:
+			oldURI, newURI := change.RenameFile.OldURI, change.RenameFile.NewURI
+			if _, err := os.Stat(newURI.Path()); err == nil {
+				errs = append(errs, fmt.Errorf("cannot rename %s: %s already exists", relPath(oldURI), relPath(newURI)))
+				continue
+			}
+			if !dryRun {
+				if err := os.MkdirAll(filepath.Dir(newURI.Path()), 0o755); err != nil {
+					errs = append(errs, err)
+					continue
+				}
+				if err := os.Rename(oldURI.Path(), newURI.Path()); err != nil {
+					errs = append(errs, err)
+					continue
+				}
+			}
+			if showDiff {
+				cmd.Printf("rename from %s\nrename to %s\n", relPath(oldURI), relPath(newURI))
+			} else {
+				cmd.Printf("%s -> %s\n", relPath(oldURI), relPath(newURI))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
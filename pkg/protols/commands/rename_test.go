@@ -0,0 +1,99 @@
+package commands
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kralicky/tools-lite/gopls/pkg/protocol"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyWorkspaceEdit(t *testing.T) {
+	const src = `syntax = "proto3";
+
+package foo;
+
+message A {}
+`
+	cases := []struct {
+		name       string
+		dryRun     bool
+		showDiff   bool
+		wantOutput string
+	}{
+		{
+			name:   "write",
+			dryRun: false,
+			wantOutput: `a.proto
+b.proto -> bar/b.proto
+`,
+		},
+		{
+			name:   "dry run",
+			dryRun: true,
+			wantOutput: `a.proto
+b.proto -> bar/b.proto
+`,
+		},
+		{
+			name:     "dry run with diff",
+			dryRun:   true,
+			showDiff: true,
+			wantOutput: `--- a/a.proto
++++ b/a.proto
+@@ -2,4 +2,4 @@
+ 
+ package foo;
+ 
+-message A {}
++message B {}
+rename from b.proto
+rename to bar/b.proto
+`,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dir := newTestWorkspace(t, map[string]string{
+				"a.proto": src,
+				"b.proto": src,
+			})
+			aURI := protocol.URIFromPath(filepath.Join(dir, "a.proto"))
+			edit := &protocol.WorkspaceEdit{
+				DocumentChanges: append(
+					protocol.TextEditsToDocumentChanges(aURI, 0, []protocol.TextEdit{{
+						Range:   protocol.Range{Start: protocol.Position{Line: 4, Character: 8}, End: protocol.Position{Line: 4, Character: 9}},
+						NewText: "B",
+					}}),
+					protocol.DocumentChanges{RenameFile: &protocol.RenameFile{
+						Kind:   "rename",
+						OldURI: protocol.URIFromPath(filepath.Join(dir, "b.proto")),
+						NewURI: protocol.URIFromPath(filepath.Join(dir, "bar/b.proto")),
+					}},
+				),
+			}
+			var out bytes.Buffer
+			cmd := &cobra.Command{}
+			cmd.SetOut(&out)
+			require.NoError(t, applyWorkspaceEdit(cmd, edit, c.dryRun, c.showDiff))
+			require.Equal(t, c.wantOutput, out.String())
+
+			a, err := os.ReadFile(filepath.Join(dir, "a.proto"))
+			require.NoError(t, err)
+			_, bErr := os.Stat(filepath.Join(dir, "b.proto"))
+			_, movedErr := os.Stat(filepath.Join(dir, "bar/b.proto"))
+			if c.dryRun {
+				require.Equal(t, src, string(a))
+				require.NoError(t, bErr)
+				require.ErrorIs(t, movedErr, os.ErrNotExist)
+			} else {
+				require.Contains(t, string(a), "message B {}")
+				require.ErrorIs(t, bErr, os.ErrNotExist)
+				require.NoError(t, movedErr)
+			}
+		})
+	}
+}
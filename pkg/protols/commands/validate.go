@@ -0,0 +1,298 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"buf.build/gen/go/bufbuild/protovalidate/protocolbuffers/go/buf/validate"
+	"github.com/bufbuild/protovalidate-go"
+	"github.com/bufbuild/protovalidate-go/resolver"
+	"github.com/kralicky/protols/pkg/x/protogen/genid"
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// ValidateCmd represents the validate command
+func BuildValidateCmd() *cobra.Command {
+	var msgType string
+	var format string
+	cmd := &cobra.Command{
+		Use:   "validate --type=pkg.Message",
+		Short: "Validates a message read from stdin against its protovalidate constraints",
+		Long: `
+Reads a message of the given type from stdin and evaluates the protovalidate
+(buf.validate) constraints defined on it in the workspace. Each violation is
+printed along with the source location of the constraint that was violated.
+
+The message can be given in JSON (default), text, or binary wire format. Binary
+input may be base64 or hex encoded.
+`[1:],
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cache, err := loadWorkspaceCache()
+			if err != nil {
+				return err
+			}
+			desc, err := findMessageType(cmd.Context(), cache, msgType)
+			if err != nil {
+				return err
+			}
+			msg, err := readMessage(cmd.InOrStdin(), desc, format, cache)
+			if err != nil {
+				return err
+			}
+			validator, err := protovalidate.New(
+				protovalidate.WithExtensionTypeResolver(cache),
+				protovalidate.WithDescriptors(desc),
+			)
+			if err != nil {
+				return err
+			}
+			err = validator.Validate(msg)
+			var valErr *protovalidate.ValidationError
+			if !errors.As(err, &valErr) {
+				return err
+			}
+			cwd, _ := os.Getwd()
+			mappings := cache.XGetURIPathMappings()
+			for _, v := range valErr.Violations {
+				loc := findViolationSource(desc, v)
+				var prefix string
+				if loc.desc != nil {
+					filename := loc.desc.ParentFile().Path()
+					if uri, ok := mappings.FileURIsByPath[filename]; ok {
+						filename = uri.Path()
+						if rel, err := filepath.Rel(cwd, filename); err == nil && !strings.HasPrefix(rel, "..") {
+							filename = rel
+						}
+					}
+					prefix = fmt.Sprintf("%s:%d:%d: ", filename, loc.source.StartLine+1, loc.source.StartColumn+1)
+				}
+				if fieldPath := v.GetFieldPath(); fieldPath != "" {
+					prefix += fieldPath + ": "
+				}
+				cmd.Printf("%s%s [%s]\n", prefix, v.GetMessage(), v.GetConstraintId())
+			}
+			cmd.SilenceUsage = true
+			return fmt.Errorf("%d constraint violation(s)", len(valErr.Violations))
+		},
+	}
+	cmd.Flags().StringVarP(&msgType, "type", "t", "", "The message type to validate")
+	cmd.Flags().StringVarP(&format, "format", "f", "json", "Input format (json|text|binary)")
+	cmd.MarkFlagRequired("type")
+	return cmd
+}
+
+func readMessage(in io.Reader, desc protoreflect.MessageDescriptor, format string, resolver typeResolver) (proto.Message, error) {
+	msg := dynamicpb.NewMessage(desc)
+	switch format {
+	case "binary":
+		input, err := readInput(in, inputAuto)
+		if err != nil {
+			return nil, err
+		}
+		if err := (proto.UnmarshalOptions{Resolver: resolver}).Unmarshal(input, msg); err != nil {
+			return nil, fmt.Errorf("could not decode input: %w", err)
+		}
+	case "json":
+		input, err := io.ReadAll(in)
+		if err != nil {
+			return nil, err
+		}
+		if err := (protojson.UnmarshalOptions{Resolver: resolver}).Unmarshal(input, msg); err != nil {
+			return nil, fmt.Errorf("could not decode input: %w", err)
+		}
+	case "text":
+		input, err := io.ReadAll(in)
+		if err != nil {
+			return nil, err
+		}
+		if err := (prototext.UnmarshalOptions{Resolver: resolver}).Unmarshal(input, msg); err != nil {
+			return nil, fmt.Errorf("could not decode input: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown input format %q (expected one of: json, text, binary)", format)
+	}
+	return msg, nil
+}
+
+type violationSource struct {
+	desc   protoreflect.Descriptor
+	source protoreflect.SourceLocation
+}
+
+// findViolationSource locates the constraint that produced the violation.
+// The violation's field path is resolved starting from the root message to
+// find the field, oneof, or message on which the constraint is declared, then
+// the constraint id is matched against the standard rules or the ids of any
+// custom CEL constraints to find the most specific source location within
+// the element's (buf.validate.*) option.
+func findViolationSource(root protoreflect.MessageDescriptor, v *validate.Violation) violationSource {
+	var target protoreflect.Descriptor = root
+	msg := root
+	for _, name := range splitFieldPath(v.GetFieldPath()) {
+		if msg == nil {
+			break
+		}
+		if fd := msg.Fields().ByName(protoreflect.Name(name)); fd != nil {
+			target = fd
+			switch {
+			case fd.IsMap():
+				msg = fd.MapValue().Message()
+			default:
+				msg = fd.Message()
+			}
+			continue
+		}
+		if od := msg.Oneofs().ByName(protoreflect.Name(name)); od != nil {
+			target = od
+		}
+		break
+	}
+
+	if fd, ok := target.(protoreflect.FieldDescriptor); ok {
+		if path, ok := fieldConstraintPath(fd, v.GetConstraintId()); ok {
+			return lookupSource(fd, path)
+		}
+		// the constraint may instead belong to the field's message type
+		if fd.Message() != nil && !fd.IsMap() {
+			target = fd.Message()
+		}
+	}
+	switch target := target.(type) {
+	case protoreflect.MessageDescriptor:
+		return lookupSource(target, messageConstraintPath(target, v.GetConstraintId()))
+	case protoreflect.OneofDescriptor:
+		return lookupSource(target, []int32{
+			int32(genid.OneofDescriptorProto_Options_field_number),
+			int32(validate.E_Oneof.TypeDescriptor().Number()),
+		})
+	}
+	return lookupSource(target, nil)
+}
+
+// splitFieldPath splits a violation field path such as 'foo.bar[0].baz' or
+// 'foo["a.b"].bar' into field names, discarding list indexes and map keys.
+func splitFieldPath(fieldPath string) []string {
+	var names []string
+	var current strings.Builder
+	for i := 0; i < len(fieldPath); i++ {
+		switch c := fieldPath[i]; c {
+		case '.':
+			if current.Len() > 0 {
+				names = append(names, current.String())
+				current.Reset()
+			}
+		case '[':
+			if current.Len() > 0 {
+				names = append(names, current.String())
+				current.Reset()
+			}
+			// skip to the matching ']', ignoring any inside quoted map keys
+		key:
+			for quote := byte(0); i < len(fieldPath); i++ {
+				switch c := fieldPath[i]; {
+				case quote != 0 && c == '\\':
+					i++
+				case quote != 0 && c == quote:
+					quote = 0
+				case quote == 0 && (c == '"' || c == '\''):
+					quote = c
+				case quote == 0 && c == ']':
+					break key
+				}
+			}
+		default:
+			current.WriteByte(c)
+		}
+	}
+	if current.Len() > 0 {
+		names = append(names, current.String())
+	}
+	return names
+}
+
+// fieldConstraintPath returns the source path (relative to the field) of the
+// constraint with the given id, if the field declares such a constraint.
+func fieldConstraintPath(fd protoreflect.FieldDescriptor, constraintId string) ([]int32, bool) {
+	path := []int32{
+		int32(genid.FieldDescriptorProto_Options_field_number),
+		int32(validate.E_Field.TypeDescriptor().Number()),
+	}
+	constraints := resolver.DefaultResolver{}.ResolveFieldConstraints(fd)
+	if constraints == nil {
+		return nil, false
+	}
+	for i, c := range constraints.GetCel() {
+		if c.GetId() == constraintId {
+			return append(path, int32(fieldNumber(constraints, "cel")), int32(i)), true
+		}
+	}
+	// standard constraint ids are of the form '<type>.<rule>[.<sub-rule>]',
+	// e.g. 'string.min_len' or 'repeated.items', or a top-level rule such as
+	// 'required'
+	var current protoreflect.MessageDescriptor = constraints.ProtoReflect().Descriptor()
+	var matched int
+	for _, part := range strings.Split(constraintId, ".") {
+		if current == nil {
+			break
+		}
+		fd := current.Fields().ByName(protoreflect.Name(part))
+		if fd == nil {
+			break
+		}
+		path = append(path, int32(fd.Number()))
+		current = fd.Message()
+		matched++
+	}
+	if matched == 0 {
+		return nil, false
+	}
+	return path, true
+}
+
+// messageConstraintPath returns the source path (relative to the message) of
+// the constraint with the given id, or the path to the message's constraints
+// if there is no such constraint.
+func messageConstraintPath(md protoreflect.MessageDescriptor, constraintId string) []int32 {
+	path := []int32{
+		int32(genid.DescriptorProto_Options_field_number),
+		int32(validate.E_Message.TypeDescriptor().Number()),
+	}
+	constraints := resolver.DefaultResolver{}.ResolveMessageConstraints(md)
+	for i, c := range constraints.GetCel() {
+		if c.GetId() == constraintId {
+			return append(path, int32(fieldNumber(constraints, "cel")), int32(i))
+		}
+	}
+	return path
+}
+
+func fieldNumber(msg proto.Message, name protoreflect.Name) protoreflect.FieldNumber {
+	return msg.ProtoReflect().Descriptor().Fields().ByName(name).Number()
+}
+
+// lookupSource returns the source location of the given descriptor, or of the
+// longest prefix of the given path (relative to the descriptor) for which
+// source info is available.
+func lookupSource(desc protoreflect.Descriptor, relPath []int32) violationSource {
+	if desc == nil {
+		return violationSource{}
+	}
+	locations := desc.ParentFile().SourceLocations()
+	base := locations.ByDescriptor(desc)
+	for i := len(relPath); i > 0; i-- {
+		path := append(append(protoreflect.SourcePath{}, base.Path...), relPath[:i]...)
+		if loc := locations.ByPath(path); loc.Path != nil {
+			return violationSource{desc: desc, source: loc}
+		}
+	}
+	return violationSource{desc: desc, source: base}
+}
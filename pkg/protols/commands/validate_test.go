@@ -0,0 +1,118 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/bufbuild/protovalidate-go"
+	"github.com/kralicky/protocompile"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+func TestSplitFieldPath(t *testing.T) {
+	cases := []struct {
+		path string
+		want []string
+	}{
+		{"", nil},
+		{"foo", []string{"foo"}},
+		{"foo.bar.baz", []string{"foo", "bar", "baz"}},
+		{"foo[0].bar", []string{"foo", "bar"}},
+		{"foo[0][1]", []string{"foo"}},
+		{`foo["a.b"].bar`, []string{"foo", "bar"}},
+		{`foo['a]b'].bar`, []string{"foo", "bar"}},
+		{`foo["a\"].b"].bar`, []string{"foo", "bar"}},
+		{"foo[true].bar", []string{"foo", "bar"}},
+		{"foo[-1]", []string{"foo"}},
+	}
+	for _, c := range cases {
+		require.Equal(t, c.want, splitFieldPath(c.path), c.path)
+	}
+}
+
+func TestFindViolationSource(t *testing.T) {
+	const src = `syntax = "proto3";
+
+package test;
+
+import "buf/validate/validate.proto";
+
+message Order {
+  repeated Item items = 1 [(buf.validate.field).repeated.max_items = 1];
+  Address address = 2;
+}
+
+message Item {
+  string sku = 1 [(buf.validate.field).string.min_len = 3];
+  int32 quantity = 2 [
+    (buf.validate.field).cel = {
+      id: "quantity.positive"
+      message: "quantity must be positive"
+      expression: "this > 0"
+    },
+    (buf.validate.field).cel = {
+      id: "quantity.even"
+      message: "quantity must be even"
+      expression: "this % 2 == 0"
+    }
+  ];
+}
+
+message Address {
+  string city = 1 [(buf.validate.field).required = true];
+}
+`
+	compiler := protocompile.Compiler{
+		Resolver: protocompile.CompositeResolver{
+			&protocompile.SourceResolver{
+				Accessor: protocompile.SourceAccessorFromMap(map[string]string{"test.proto": src}),
+			},
+			protocompile.ResolverFunc(func(path protocompile.UnresolvedPath, _ protocompile.ImportContext) (protocompile.SearchResult, error) {
+				fd, err := protoregistry.GlobalFiles.FindFileByPath(string(path))
+				if err != nil {
+					return protocompile.SearchResult{}, err
+				}
+				return protocompile.SearchResult{ResolvedPath: protocompile.ResolvedPath(path), Proto: protodesc.ToFileDescriptorProto(fd)}, nil
+			}),
+		},
+		SourceInfoMode: protocompile.SourceInfoExtraOptionLocations,
+	}
+	res, err := compiler.Compile(context.Background(), "test.proto")
+	require.NoError(t, err)
+	desc := res.Files[0].Messages().ByName("Order")
+
+	msg, err := readMessage(strings.NewReader(`{
+  "items": [{"sku": "ab", "quantity": 1}, {"sku": "abcd", "quantity": 0}],
+  "address": {}
+}`), desc, "json", protoregistry.GlobalTypes)
+	require.NoError(t, err)
+	validator, err := protovalidate.New(protovalidate.WithDescriptors(desc))
+	require.NoError(t, err)
+	var valErr *protovalidate.ValidationError
+	require.True(t, errors.As(validator.Validate(msg), &valErr))
+
+	// each violation maps to the text of the constraint that produced it
+	lines := strings.Split(src, "\n")
+	got := map[string]string{}
+	for _, v := range valErr.Violations {
+		loc := findViolationSource(desc, v)
+		require.NotNil(t, loc.desc, v.GetFieldPath())
+		text := lines[loc.source.StartLine][loc.source.StartColumn:]
+		if loc.source.EndLine == loc.source.StartLine {
+			text = lines[loc.source.StartLine][loc.source.StartColumn:loc.source.EndColumn]
+		}
+		got[v.GetFieldPath()+" "+v.GetConstraintId()] = fmt.Sprintf("%s %d:%d: %s", loc.desc.FullName(), loc.source.StartLine+1, loc.source.EndLine+1, text)
+	}
+	require.Equal(t, map[string]string{
+		"items repeated.max_items":            "test.Order.items 8:8: (buf.validate.field).repeated.max_items = 1",
+		"items[0].sku string.min_len":         "test.Item.sku 13:13: (buf.validate.field).string.min_len = 3",
+		"items[0].quantity quantity.even":     "test.Item.quantity 20:24: (buf.validate.field).cel = {",
+		"items[1].quantity quantity.positive": "test.Item.quantity 15:19: (buf.validate.field).cel = {",
+		"address.city required":               "test.Address.city 29:29: (buf.validate.field).required = true",
+	}, got)
+}
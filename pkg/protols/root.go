@@ -20,6 +20,10 @@ func BuildRootCmd() *cobra.Command {
 	rootCmd.AddCommand(commands.BuildServeCmd())
 	rootCmd.AddCommand(commands.BuildVetCmd())
 	rootCmd.AddCommand(commands.BuildDecodeCmd())
+	rootCmd.AddCommand(commands.BuildValidateCmd())
+	rootCmd.AddCommand(commands.BuildMigrateCmd())
+	rootCmd.AddCommand(commands.BuildRenameCmd())
+	rootCmd.AddCommand(commands.BuildQueryCmd())
 	//+cobra:subcommands
 
 	return rootCmd
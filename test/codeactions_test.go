@@ -1,6 +1,10 @@
 package test
 
 import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/kralicky/tools-lite/gopls/pkg/protocol"
@@ -117,6 +121,9 @@ message Foo {
 			require.Equal(t, diag.Diagnostics[0].Message, "no syntax specified; defaulting to proto2 syntax")
 			actions, err := env.Editor.CodeActions(env.Ctx, env.RegexpSearch("options.proto", location), nil, protocol.RefactorRewrite)
 			require.NoError(t, err)
+			actions = slices.DeleteFunc(actions, func(action protocol.CodeAction) bool {
+				return action.Title != "Simplify repeated options"
+			})
 			require.Len(t, actions, 1)
 			env.ApplyCodeAction(actions[0])
 			env.SaveBuffer("options.proto")
@@ -124,3 +131,654 @@ message Foo {
 		})
 	}
 }
+
+func TestMoveToFile(t *testing.T) {
+	const src = `
+-- a.proto --
+syntax = "proto3";
+
+package test;
+
+import "google/protobuf/timestamp.proto";
+
+message Bar {
+  Foo foo = 1;
+}
+
+// Foo is a foo.
+message Foo {
+  google.protobuf.Timestamp time = 1;
+}
+-- b.proto --
+syntax = "proto3";
+
+package test;
+
+import "a.proto";
+
+message Baz {
+  Foo foo = 1;
+}
+`
+	Run(t, src, func(t *testing.T, env *integration.Env) {
+		env.OpenFile("a.proto")
+		env.OpenFile("b.proto")
+		env.Await(integration.NoDiagnostics(integration.ForFile("a.proto")))
+
+		actions, err := env.Editor.CodeActions(env.Ctx, env.RegexpSearch("a.proto", `message ()Foo`), nil, protocol.RefactorMove)
+		require.NoError(t, err)
+		titles := []string{}
+		for _, action := range actions {
+			titles = append(titles, action.Title)
+		}
+		require.Equal(t, []string{"Move Foo to new file foo.proto", "Move Foo to b.proto"}, titles)
+
+		env.ApplyCodeAction(actions[0])
+		require.Equal(t, `syntax = "proto3";
+
+package test;
+
+import "foo.proto";
+
+message Bar {
+  Foo foo = 1;
+}
+`, env.BufferText("a.proto"))
+		require.Equal(t, `syntax = "proto3";
+
+package test;
+
+import "foo.proto";
+
+message Baz {
+  Foo foo = 1;
+}
+`, env.BufferText("b.proto"))
+		require.Equal(t, `syntax = "proto3";
+
+package test;
+
+import "google/protobuf/timestamp.proto";
+
+// Foo is a foo.
+message Foo {
+  google.protobuf.Timestamp time = 1;
+}
+`, env.BufferText("foo.proto"))
+	})
+}
+
+func TestChangeNesting(t *testing.T) {
+	const src = `
+-- a.proto --
+syntax = "proto3";
+
+package test;
+
+message Foo {
+  message Bar {
+    string name = 1;
+  }
+  Bar bar = 1;
+}
+-- b.proto --
+syntax = "proto3";
+
+package test;
+
+import "a.proto";
+
+message Baz {
+  Foo.Bar bar = 1;
+}
+`
+	Run(t, src, func(t *testing.T, env *integration.Env) {
+		env.OpenFile("a.proto")
+		env.OpenFile("b.proto")
+		env.Await(integration.NoDiagnostics(integration.ForFile("a.proto")))
+
+		actions, err := env.Editor.CodeActions(env.Ctx, env.RegexpSearch("a.proto", `message ()Bar`), nil, protocol.RefactorMove)
+		require.NoError(t, err)
+		require.Len(t, actions, 1)
+		require.Equal(t, "Move Bar to top level", actions[0].Title)
+		env.ApplyCodeAction(actions[0])
+		require.Equal(t, `syntax = "proto3";
+
+package test;
+
+message Foo {
+  Bar bar = 1;
+}
+
+message Bar {
+  string name = 1;
+}
+`, env.BufferText("a.proto"))
+		require.Equal(t, `syntax = "proto3";
+
+package test;
+
+import "a.proto";
+
+message Baz {
+  Bar bar = 1;
+}
+`, env.BufferText("b.proto"))
+	})
+}
+
+func TestChangeNestingIntoSoleUser(t *testing.T) {
+	const src = `
+-- a.proto --
+syntax = "proto3";
+
+package test;
+
+message Foo {
+  Bar bar = 1;
+}
+
+message Bar {
+  string name = 1;
+}
+
+message Baz {
+  Qux qux = 1;
+}
+
+message Quux {
+  Qux qux = 1;
+}
+
+message Qux {}
+`
+	Run(t, src, func(t *testing.T, env *integration.Env) {
+		env.OpenFile("a.proto")
+		env.Await(integration.NoDiagnostics(integration.ForFile("a.proto")))
+
+		actions, err := env.Editor.CodeActions(env.Ctx, env.RegexpSearch("a.proto", `message ()Qux`), nil, protocol.RefactorMove)
+		require.NoError(t, err)
+		require.False(t, slices.ContainsFunc(actions, func(a protocol.CodeAction) bool { return strings.HasPrefix(a.Title, "Move Qux into") }))
+		_, err = env.Editor.Server.ExecuteCommand(env.Ctx, &protocol.ExecuteCommandParams{
+			Command: "protols/changeNesting",
+			Arguments: []json.RawMessage{
+				json.RawMessage(fmt.Sprintf(`{"uri":%q,"symbol":"test.Qux"}`, env.Sandbox.Workdir.URI("a.proto"))),
+			},
+		})
+		require.ErrorContains(t, err, "cannot move test.Qux: it must be used by exactly one message in the same file")
+
+		actions, err = env.Editor.CodeActions(env.Ctx, env.RegexpSearch("a.proto", `message ()Bar`), nil, protocol.RefactorMove)
+		require.NoError(t, err)
+		i := slices.IndexFunc(actions, func(a protocol.CodeAction) bool { return a.Title == "Move Bar into Foo" })
+		require.GreaterOrEqual(t, i, 0)
+		env.ApplyCodeAction(actions[i])
+		require.Equal(t, `syntax = "proto3";
+
+package test;
+
+message Foo {
+  Bar bar = 1;
+  message Bar {
+    string name = 1;
+  }
+}
+
+message Baz {
+  Qux qux = 1;
+}
+
+message Quux {
+  Qux qux = 1;
+}
+
+message Qux {}
+`, env.BufferText("a.proto"))
+	})
+}
+
+func TestReserveDeletedFields(t *testing.T) {
+	const src = `
+-- a.proto --
+syntax = "proto3";
+
+package test;
+
+message Foo {
+  string a = 1;
+  string b = 2;
+}
+`
+	Run(t, src, func(t *testing.T, env *integration.Env) {
+		env.OpenFile("a.proto")
+		env.Await(integration.NoDiagnostics(integration.ForFile("a.proto")))
+		env.RegexpReplace("a.proto", `\n  string b = 2;`, "")
+		env.Await(integration.NoDiagnostics(integration.ForFile("a.proto")))
+
+		actions, err := env.Editor.CodeActions(env.Ctx, env.RegexpSearch("a.proto", `message ()Foo`), nil, protocol.SourceFixAll)
+		require.NoError(t, err)
+		require.Len(t, actions, 1)
+		env.ApplyCodeAction(actions[0])
+		require.Equal(t, `syntax = "proto3";
+
+package test;
+
+message Foo {
+  reserved 2;
+  reserved "b";
+  string a = 1;
+}
+`, env.BufferText("a.proto"))
+	})
+}
+
+func TestWrapFieldsInOneof(t *testing.T) {
+	const src = `
+-- a.proto --
+syntax = "proto3";
+
+package test;
+
+message Foo {
+  string id = 1;
+  // the name
+  optional string name = 2;
+  Foo parent = 3; // the parent
+  int32 count = 4;
+}
+`
+	const wrapped = `syntax = "proto3";
+
+package test;
+
+message Foo {
+  string id = 1;
+  oneof new_oneof {
+    // the name
+    string name = 2;
+    Foo parent = 3; // the parent
+    int32 count = 4;
+  }
+}
+`
+	const dissolved = `syntax = "proto3";
+
+package test;
+
+message Foo {
+  string id = 1;
+  // the name
+  optional string name = 2;
+  Foo parent = 3; // the parent
+  optional int32 count = 4;
+}
+`
+	Run(t, src, func(t *testing.T, env *integration.Env) {
+		env.OpenFile("a.proto")
+		env.Await(integration.NoDiagnostics(integration.ForFile("a.proto")))
+
+		actions, err := env.Editor.CodeActions(env.Ctx, env.RegexpSearch("a.proto", `// the name\n(?s:.*)int32 count = 4;`), nil, protocol.RefactorRewrite)
+		require.NoError(t, err)
+		var titles []string
+		for _, action := range actions {
+			titles = append(titles, action.Title)
+		}
+		require.Contains(t, titles, "Wrap fields in new oneof (adds presence to count)")
+		for _, action := range actions {
+			if action.Title == "Wrap fields in new oneof (adds presence to count)" {
+				env.ApplyCodeAction(action)
+			}
+		}
+		require.Equal(t, wrapped, env.BufferText("a.proto"))
+		env.Await(integration.NoDiagnostics(integration.ForFile("a.proto")))
+
+		actions, err = env.Editor.CodeActions(env.Ctx, env.RegexpSearch("a.proto", `oneof ()new_oneof`), nil, protocol.RefactorRewrite)
+		require.NoError(t, err)
+		require.Len(t, actions, 1)
+		require.Equal(t, "Dissolve oneof new_oneof into separate fields", actions[0].Title)
+		env.ApplyCodeAction(actions[0])
+		require.Equal(t, dissolved, env.BufferText("a.proto"))
+	})
+}
+
+func TestWrapFieldsInOneofFieldPresence(t *testing.T) {
+	const src = `
+-- a.proto --
+edition = "2023";
+
+package test;
+
+message Foo {
+  string name = 1 [features.field_presence = IMPLICIT];
+  int32 count = 2;
+}
+`
+	Run(t, src, func(t *testing.T, env *integration.Env) {
+		env.OpenFile("a.proto")
+		env.Await(integration.NoDiagnostics(integration.ForFile("a.proto")))
+
+		actions, err := env.Editor.CodeActions(env.Ctx, env.RegexpSearch("a.proto", `string name(?s:.*)int32 count = 2;`), nil, protocol.RefactorRewrite)
+		require.NoError(t, err)
+		var wrap *protocol.CodeAction
+		for i, action := range actions {
+			if strings.HasPrefix(action.Title, "Wrap fields in new oneof") {
+				wrap = &actions[i]
+			}
+		}
+		require.NotNil(t, wrap)
+		require.NotNil(t, wrap.Disabled)
+		require.Equal(t, "name sets features.field_presence, which oneof fields cannot specify", wrap.Disabled.Reason)
+	})
+}
+
+func TestSimplifyMapLiteralFields(t *testing.T) {
+	const src = `
+-- options.proto --
+syntax = "proto3";
+
+import "google/protobuf/descriptor.proto";
+
+message Labels {
+  map<string, string> labels = 1;
+  string name = 2;
+}
+
+extend google.protobuf.MessageOptions {
+  Labels labels = 50000;
+}
+
+message Foo {
+  option (labels) = {
+    labels: {
+      key:   "a"
+      value: "1"
+    }
+    name: "foo"
+    labels {key: "b", value: "2"}
+    labels: [{key: "c", value: "3"}]
+  };
+}
+`
+	const simplified = `syntax = "proto3";
+
+import "google/protobuf/descriptor.proto";
+
+message Labels {
+  map<string, string> labels = 1;
+  string name = 2;
+}
+
+extend google.protobuf.MessageOptions {
+  Labels labels = 50000;
+}
+
+message Foo {
+  option (labels) = {
+    labels: [
+      {key: "a", value: "1"},
+      {key: "b", value: "2"},
+      {key: "c", value: "3"}
+    ]
+    name: "foo"
+  };
+}
+`
+	const expanded = `syntax = "proto3";
+
+import "google/protobuf/descriptor.proto";
+
+message Labels {
+  map<string, string> labels = 1;
+  string name = 2;
+}
+
+extend google.protobuf.MessageOptions {
+  Labels labels = 50000;
+}
+
+message Foo {
+  option (labels) = {
+    labels: {key: "a", value: "1"}
+    labels: {key: "b", value: "2"}
+    labels: {key: "c", value: "3"}
+    name: "foo"
+  };
+}
+`
+	Run(t, src, func(t *testing.T, env *integration.Env) {
+		env.OpenFile("options.proto")
+		env.Await(integration.NoDiagnostics(integration.ForFile("options.proto")))
+
+		findAction := func(location, title string) protocol.CodeAction {
+			actions, err := env.Editor.CodeActions(env.Ctx, env.RegexpSearch("options.proto", location), nil, protocol.RefactorRewrite)
+			require.NoError(t, err)
+			for _, action := range actions {
+				if action.Title == title {
+					return action
+				}
+			}
+			t.Fatalf("no action %q at %q", title, location)
+			return protocol.CodeAction{}
+		}
+		env.ApplyCodeAction(findAction(`()labels \{key: "b"`, "Simplify map literal fields"))
+		require.Equal(t, simplified, env.BufferText("options.proto"))
+		env.Await(integration.NoDiagnostics(integration.ForFile("options.proto")))
+
+		env.ApplyCodeAction(findAction(`()labels: \[`, "Expand map literal entries"))
+		require.Equal(t, expanded, env.BufferText("options.proto"))
+	})
+}
+
+func TestChangeFieldType(t *testing.T) {
+	const src = `
+-- options.proto --
+syntax = "proto3";
+
+package test;
+
+import "google/protobuf/descriptor.proto";
+
+message Limits {
+  int32 count = 1;
+}
+
+extend google.protobuf.FileOptions {
+  Limits limits = 50000;
+}
+
+option (limits) = {count: 3};
+`
+	Run(t, src, func(t *testing.T, env *integration.Env) {
+		env.OpenFile("options.proto")
+		env.Await(integration.NoDiagnostics(integration.ForFile("options.proto")))
+
+		actions, err := env.Editor.CodeActions(env.Ctx, env.RegexpSearch("options.proto", `()int32 count`), nil, protocol.RefactorRewrite)
+		require.NoError(t, err)
+		i := slices.IndexFunc(actions, func(a protocol.CodeAction) bool {
+			return a.Title == "Change type of count to google.protobuf.Int32Value (wire-incompatible)"
+		})
+		require.GreaterOrEqual(t, i, 0)
+		env.ApplyCodeAction(actions[i])
+		require.Equal(t, `syntax = "proto3";
+
+package test;
+
+import "google/protobuf/descriptor.proto";
+import "google/protobuf/wrappers.proto";
+
+message Limits {
+  google.protobuf.Int32Value count = 1;
+}
+
+extend google.protobuf.FileOptions {
+  Limits limits = 50000;
+}
+
+option (limits) = {count: {value: 3}};
+`, env.BufferText("options.proto"))
+	})
+}
+
+func TestChangeFieldTypeUnconvertedValues(t *testing.T) {
+	const src = `
+-- options.proto --
+syntax = "proto3";
+
+package test;
+
+import "google/protobuf/descriptor.proto";
+
+message Limits {
+  int32 count = 1;
+}
+
+extend google.protobuf.FileOptions {
+  Limits limits = 50000;
+}
+
+option (limits) = {count: -1};
+`
+	Run(t, src, func(t *testing.T, env *integration.Env) {
+		env.OpenFile("options.proto")
+		env.Await(integration.NoDiagnostics(integration.ForFile("options.proto")))
+
+		actions, err := env.Editor.CodeActions(env.Ctx, env.RegexpSearch("options.proto", `()int32 count`), nil, protocol.RefactorRewrite)
+		require.NoError(t, err)
+		i := slices.IndexFunc(actions, func(a protocol.CodeAction) bool {
+			return a.Title == "Change type of count to uint32"
+		})
+		require.GreaterOrEqual(t, i, 0)
+		env.ApplyCodeAction(actions[i])
+		require.Contains(t, env.BufferText("options.proto"), "  uint32 count = 1;\n")
+		env.Await(integration.ShownMessage("Changed type of count to uint32: 1 option value needs to be updated by hand"))
+	})
+}
+
+func TestChangeFieldTypeToEnum(t *testing.T) {
+	const src = `
+-- a.proto --
+syntax = "proto3";
+
+package test;
+
+message Foo {
+  int32 kind = 1;
+}
+
+enum Kind {
+  KIND_UNSPECIFIED = 0;
+}
+`
+	Run(t, src, func(t *testing.T, env *integration.Env) {
+		env.OpenFile("a.proto")
+		env.Await(integration.NoDiagnostics(integration.ForFile("a.proto")))
+
+		actions, err := env.Editor.CodeActions(env.Ctx, env.RegexpSearch("a.proto", `()int32 kind`), nil, protocol.RefactorRewrite)
+		require.NoError(t, err)
+		require.False(t, slices.ContainsFunc(actions, func(a protocol.CodeAction) bool {
+			return strings.HasPrefix(a.Title, "Change type of kind to test.")
+		}))
+
+		_, err = env.Editor.Server.ExecuteCommand(env.Ctx, &protocol.ExecuteCommandParams{
+			Command: "protols/changeFieldType",
+			Arguments: []json.RawMessage{
+				json.RawMessage(fmt.Sprintf(`{"uri":%q,"field":"test.Foo.kind","type":"test.Kind"}`, env.Sandbox.Workdir.URI("a.proto"))),
+			},
+		})
+		require.NoError(t, err)
+		env.Await(integration.NoDiagnostics(integration.ForFile("a.proto")))
+		require.Contains(t, env.BufferText("a.proto"), "  Kind kind = 1;\n")
+	})
+}
+
+func TestQuickFixes(t *testing.T) {
+	const src = `
+-- a.proto --
+syntax = "proto3";
+
+package test;
+
+message Foo {
+  string a = 1;
+  string b = 2;
+  string c = 2;
+}
+`
+	Run(t, src, func(t *testing.T, env *integration.Env) {
+		env.OpenFile("a.proto")
+		var diag protocol.PublishDiagnosticsParams
+		env.OnceMet(
+			integration.Diagnostics(integration.ForFile("a.proto")),
+			integration.ReadDiagnostics("a.proto", &diag),
+		)
+		require.Len(t, diag.Diagnostics, 1)
+
+		actions, err := env.Editor.CodeActions(env.Ctx, env.RegexpSearch("a.proto", `c = ()2`), diag.Diagnostics, protocol.QuickFix)
+		require.NoError(t, err)
+		require.Len(t, actions, 1)
+		require.Equal(t, "Change number to 3", actions[0].Title)
+		env.ApplyCodeAction(actions[0])
+		env.SaveBuffer("a.proto")
+		env.Await(integration.NoDiagnostics(integration.ForFile("a.proto")))
+		require.Equal(t, `syntax = "proto3";
+
+package test;
+
+message Foo {
+  string a = 1;
+  string b = 2;
+  string c = 3;
+}
+`, env.BufferText("a.proto"))
+	})
+}
+
+func TestGenerateResourceService(t *testing.T) {
+	const src = `
+-- a.proto --
+syntax = "proto3";
+
+package test.v1;
+
+import "google/api/resource.proto";
+
+message Book {
+  option (google.api.resource) = {
+    type: "test.example.com/Book"
+    pattern: "books/{book}"
+  };
+
+  string name = 1;
+}
+`
+	Run(t, src, func(t *testing.T, env *integration.Env) {
+		env.OpenFile("a.proto")
+		env.Await(integration.NoDiagnostics(integration.ForFile("a.proto")))
+
+		actions, err := env.Editor.CodeActions(env.Ctx, env.RegexpSearch("a.proto", `message ()Book`), nil, protocol.RefactorRewrite)
+		require.NoError(t, err)
+		var generate *protocol.CodeAction
+		for _, action := range actions {
+			if action.Title == "Generate BookService for resource Book" {
+				generate = &action
+			}
+		}
+		require.NotNil(t, generate)
+		env.ApplyCodeAction(*generate)
+		env.SaveBuffer("a.proto")
+		env.Await(integration.NoDiagnostics(integration.ForFile("a.proto")))
+		require.Contains(t, env.BufferText("a.proto"), `rpc ListBooks(ListBooksRequest) returns (ListBooksResponse) {
+    option (google.api.http) = {get: "/v1/books"};
+  }`)
+
+		actions, err = env.Editor.CodeActions(env.Ctx, env.RegexpSearch("a.proto", `message ()Book \{`), nil, protocol.RefactorRewrite)
+		require.NoError(t, err)
+		var disabled *protocol.CodeActionDisabled
+		for _, action := range actions {
+			if action.Title == "Generate BookService for resource Book" {
+				disabled = action.Disabled
+			}
+		}
+		require.NotNil(t, disabled)
+		require.Equal(t, "BookService is already declared", disabled.Reason)
+	})
+}
@@ -0,0 +1,278 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/kralicky/tools-lite/gopls/pkg/protocol"
+	"github.com/kralicky/tools-lite/gopls/pkg/test/integration"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWillRenameFiles(t *testing.T) {
+	const src = `
+-- foo/bar.proto --
+syntax = "proto3";
+
+package foo;
+
+option go_package = "example.com/api/foo";
+
+message Bar {}
+-- baz.proto --
+syntax = "proto3";
+
+package baz;
+
+import "foo/bar.proto";
+
+message Baz {
+  foo.Bar bar = 1;
+}
+`
+	Run(t, src, func(t *testing.T, env *integration.Env) {
+		env.OpenFile("baz.proto")
+		env.OpenFile("foo/bar.proto")
+		env.Await(integration.NoDiagnostics(integration.ForFile("baz.proto")))
+
+		edit, err := env.Editor.Server.WillRenameFiles(env.Ctx, &protocol.RenameFilesParams{
+			Files: []protocol.FileRename{
+				{
+					OldURI: string(env.Sandbox.Workdir.URI("foo")),
+					NewURI: string(env.Sandbox.Workdir.URI("qux")),
+				},
+			},
+		})
+		require.NoError(t, err)
+		require.NotNil(t, edit)
+		require.Len(t, edit.DocumentChanges, 2)
+		env.ApplyCodeAction(protocol.CodeAction{Title: "rename", Edit: edit})
+		require.Equal(t, `syntax = "proto3";
+
+package baz;
+
+import "qux/bar.proto";
+
+message Baz {
+  foo.Bar bar = 1;
+}
+`, env.BufferText("baz.proto"))
+		require.Equal(t, `syntax = "proto3";
+
+package foo;
+
+option go_package = "example.com/api/qux";
+
+message Bar {}
+`, env.BufferText("foo/bar.proto"))
+	})
+}
+
+func TestWillRenameFilesGoModule(t *testing.T) {
+	const src = `
+-- go.mod --
+module example.com/ws
+
+go 1.22
+-- foo/a.proto --
+syntax = "proto3";
+
+package foo;
+
+option go_package = "example.com/ws/foo";
+
+message A {}
+-- b.proto --
+syntax = "proto3";
+
+package b;
+
+import "example.com/ws/foo/a.proto";
+
+message B {
+  foo.A a = 1;
+}
+-- c.proto --
+syntax = "proto3";
+
+package c;
+
+import "foo/a.proto";
+
+message C {
+  foo.A a = 1;
+}
+`
+	Run(t, src, func(t *testing.T, env *integration.Env) {
+		env.OpenFile("b.proto")
+		env.OpenFile("c.proto")
+		env.OpenFile("foo/a.proto")
+		env.Await(
+			integration.NoDiagnostics(integration.ForFile("b.proto")),
+			integration.NoDiagnostics(integration.ForFile("c.proto")),
+		)
+
+		edit, err := env.Editor.Server.WillRenameFiles(env.Ctx, &protocol.RenameFilesParams{
+			Files: []protocol.FileRename{
+				{
+					OldURI: string(env.Sandbox.Workdir.URI("foo")),
+					NewURI: string(env.Sandbox.Workdir.URI("qux")),
+				},
+			},
+		})
+		require.NoError(t, err)
+		require.NotNil(t, edit)
+		require.Len(t, edit.DocumentChanges, 3)
+		env.ApplyCodeAction(protocol.CodeAction{Title: "rename", Edit: edit})
+		require.Equal(t, `syntax = "proto3";
+
+package b;
+
+import "example.com/ws/qux/a.proto";
+
+message B {
+  foo.A a = 1;
+}
+`, env.BufferText("b.proto"))
+		require.Equal(t, `syntax = "proto3";
+
+package c;
+
+import "qux/a.proto";
+
+message C {
+  foo.A a = 1;
+}
+`, env.BufferText("c.proto"))
+		require.Equal(t, `syntax = "proto3";
+
+package foo;
+
+option go_package = "example.com/ws/qux";
+
+message A {}
+`, env.BufferText("foo/a.proto"))
+	})
+}
+
+func TestWillDeleteFiles(t *testing.T) {
+	const src = `
+-- foo.proto --
+syntax = "proto3";
+
+package test;
+
+message Foo {}
+-- unused.proto --
+syntax = "proto3";
+
+package test;
+
+import "foo.proto";
+
+message Bar {}
+-- used.proto --
+syntax = "proto3";
+
+package test;
+
+import "foo.proto";
+
+message Baz {
+  Foo foo = 1;
+}
+`
+	Run(t, src, func(t *testing.T, env *integration.Env) {
+		env.OpenFile("unused.proto")
+		env.OpenFile("used.proto")
+		env.Await(integration.NoDiagnostics(integration.ForFile("used.proto")))
+
+		edit, err := env.Editor.Server.WillDeleteFiles(env.Ctx, &protocol.DeleteFilesParams{
+			Files: []protocol.FileDelete{
+				{URI: string(env.Sandbox.Workdir.URI("foo.proto"))},
+			},
+		})
+		require.NoError(t, err)
+		require.NotNil(t, edit)
+		require.Len(t, edit.DocumentChanges, 1)
+		env.ApplyCodeAction(protocol.CodeAction{Title: "delete", Edit: edit})
+		require.Equal(t, `syntax = "proto3";
+
+package test;
+
+message Bar {}
+`, env.BufferText("unused.proto"))
+		env.Await(
+			integration.ShownMessage("foo.proto is still imported by used.proto"),
+			// the file has not been deleted yet, so its importers are still valid
+			integration.NoDiagnostics(integration.ForFile("used.proto")),
+		)
+
+		env.RemoveWorkspaceFile("foo.proto")
+		require.NoError(t, env.Editor.Server.DidDeleteFiles(env.Ctx, &protocol.DeleteFilesParams{
+			Files: []protocol.FileDelete{
+				{URI: string(env.Sandbox.Workdir.URI("foo.proto"))},
+			},
+		}))
+		env.Await(
+			integration.Diagnostics(
+				integration.ForFile("used.proto"),
+				integration.WithMessage("foo.proto was deleted, but is still in use"),
+			),
+		)
+	})
+}
+
+func TestWillDeleteFilesGoModule(t *testing.T) {
+	const src = `
+-- go.mod --
+module example.com/ws
+
+go 1.22
+-- foo/a.proto --
+syntax = "proto3";
+
+package foo;
+
+message A {}
+-- unused.proto --
+syntax = "proto3";
+
+package unused;
+
+import "foo/a.proto";
+
+message Unused {}
+-- used.proto --
+syntax = "proto3";
+
+package used;
+
+import "foo/a.proto";
+
+message Used {
+  foo.A a = 1;
+}
+`
+	Run(t, src, func(t *testing.T, env *integration.Env) {
+		env.OpenFile("unused.proto")
+		env.OpenFile("used.proto")
+		env.Await(integration.NoDiagnostics(integration.ForFile("used.proto")))
+
+		edit, err := env.Editor.Server.WillDeleteFiles(env.Ctx, &protocol.DeleteFilesParams{
+			Files: []protocol.FileDelete{
+				{URI: string(env.Sandbox.Workdir.URI("foo/a.proto"))},
+			},
+		})
+		require.NoError(t, err)
+		require.NotNil(t, edit)
+		require.Len(t, edit.DocumentChanges, 1)
+		env.ApplyCodeAction(protocol.CodeAction{Title: "delete", Edit: edit})
+		require.Equal(t, `syntax = "proto3";
+
+package unused;
+
+message Unused {}
+`, env.BufferText("unused.proto"))
+		env.Await(integration.ShownMessage("example.com/ws/foo/a.proto is still imported by example.com/ws/used.proto"))
+	})
+}
@@ -0,0 +1,53 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/kralicky/tools-lite/gopls/pkg/test/integration"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenamePackage(t *testing.T) {
+	const src = `
+-- foo/bar/a.proto --
+syntax = "proto3";
+
+package foo.bar;
+
+message A {}
+-- b.proto --
+syntax = "proto3";
+
+package foo.baz;
+
+import "foo/bar/a.proto";
+
+message B {
+  bar.A a = 1;
+}
+`
+	Run(t, src, func(t *testing.T, env *integration.Env) {
+		env.OpenFile("foo/bar/a.proto")
+		env.OpenFile("b.proto")
+		env.Await(integration.NoDiagnostics(integration.ForFile("b.proto")))
+
+		env.Rename(env.RegexpSearch("foo/bar/a.proto", `package foo\.(bar)`), "foo.qux")
+		require.Equal(t, `syntax = "proto3";
+
+package foo.qux;
+
+message A {}
+`, env.BufferText("foo/bar/a.proto"))
+		require.Equal(t, `syntax = "proto3";
+
+package foo.baz;
+
+import "foo/bar/a.proto";
+
+message B {
+  qux.A a = 1;
+}
+`, env.BufferText("b.proto"))
+		env.Await(integration.NoDiagnostics(integration.ForFile("b.proto")))
+	})
+}